@@ -4,10 +4,12 @@ import (
 	"examples/shared"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/xenitab/go-oidc-middleware/oidcechojwt"
 	"github.com/xenitab/go-oidc-middleware/oidcfiber"
 	"github.com/xenitab/go-oidc-middleware/oidcgin"
+	"github.com/xenitab/go-oidc-middleware/oidcgrpc"
 	"github.com/xenitab/go-oidc-middleware/oidchttp"
 	"github.com/xenitab/go-oidc-middleware/options"
 )
@@ -85,11 +87,46 @@ func run(cfg shared.RuntimeConfig) error {
 		}
 
 		opts = []options.Option{
-			options.WithIssuer(cfg.Issuer),
+			options.ProfileCognito(cfg.Issuer, cfg.RequiredCognitoClientId),
 			options.WithFallbackSignatureAlgorithm(cfg.FallbackSignatureAlgorithm),
 		}
-		claimsValidationFn := shared.GetCognitoClaimsValidationFn(cfg.RequiredCognitoClientId)
+		if cfg.RequiredCognitoGroups != "" {
+			opts = append(opts, options.WithRequiredGroupsAny(strings.Split(cfg.RequiredCognitoGroups, ",")))
+		}
+		return getHandler[shared.CognitoClaims](cfg, nil, opts...)
+	case shared.GoogleProvider:
+		inputs := map[string]string{
+			"clientId": cfg.ClientID,
+		}
+
+		err := stringNotEmpty(inputs)
+		if err != nil {
+			return err
+		}
+
+		opts = []options.Option{
+			options.ProfileGoogle(cfg.ClientID),
+		}
+		claimsValidationFn := shared.GetGoogleClaimsValidationFn(cfg.RequiredGoogleHostedDomain)
 		return getHandler(cfg, claimsValidationFn, opts...)
+	case shared.KeycloakProvider:
+		inputs := map[string]string{
+			"issuer":   cfg.Issuer,
+			"clientId": cfg.ClientID,
+		}
+
+		err := stringNotEmpty(inputs)
+		if err != nil {
+			return err
+		}
+
+		opts = []options.Option{
+			options.ProfileKeycloak(cfg.Issuer, cfg.ClientID),
+		}
+		if cfg.RequiredKeycloakRealmRoles != "" {
+			opts = append(opts, options.WithRequiredRealmRoles(strings.Split(cfg.RequiredKeycloakRealmRoles, ",")))
+		}
+		return getHandler[shared.KeycloakClaims](cfg, nil, opts...)
 	case shared.OktaProvider:
 		inputs := map[string]string{
 			"issuer":                     cfg.Issuer,
@@ -150,6 +187,11 @@ func getHandler[T any](cfg shared.RuntimeConfig, claimsValidationFn options.Clai
 		oidcHandler := oidcfiber.New(claimsValidationFn, opts...)
 
 		return shared.RunFiber[T](oidcHandler, cfg.Address, cfg.Port)
+	case shared.GrpcServer:
+		unaryInterceptor := oidcgrpc.UnaryServerInterceptor(claimsValidationFn, opts...)
+		streamInterceptor := oidcgrpc.StreamServerInterceptor(claimsValidationFn, opts...)
+
+		return shared.RunGrpc[T](unaryInterceptor, streamInterceptor, cfg.Address, cfg.Port)
 	default:
 		return fmt.Errorf("unknown server: %s", cfg.Server)
 	}
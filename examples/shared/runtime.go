@@ -15,33 +15,36 @@ const (
 	GinServer     Server = "gin"
 	EchoJwtServer Server = "echojwt"
 	FiberServer   Server = "fiber"
+	GrpcServer    Server = "grpc"
 )
 
 func (s Server) Validate() error {
 	switch s {
-	case HttpServer, GinServer, EchoJwtServer, FiberServer:
+	case HttpServer, GinServer, EchoJwtServer, FiberServer, GrpcServer:
 		return nil
 	default:
-		return fmt.Errorf("not a supported server (%s), use one of: http, gin, echojwt, fiber", s)
+		return fmt.Errorf("not a supported server (%s), use one of: http, gin, echojwt, fiber, grpc", s)
 	}
 }
 
 type Provider string
 
 const (
-	Auth0Provider   Provider = "auth0"
-	AzureADProvider Provider = "azuread"
-	CognitoProvider Provider = "cognito"
-	OktaProvider    Provider = "okta"
-	OPTestProvider  Provider = "optest"
+	Auth0Provider    Provider = "auth0"
+	AzureADProvider  Provider = "azuread"
+	CognitoProvider  Provider = "cognito"
+	GoogleProvider   Provider = "google"
+	KeycloakProvider Provider = "keycloak"
+	OktaProvider     Provider = "okta"
+	OPTestProvider   Provider = "optest"
 )
 
 func (p Provider) Validate() error {
 	switch p {
-	case Auth0Provider, AzureADProvider, CognitoProvider, OktaProvider, OPTestProvider:
+	case Auth0Provider, AzureADProvider, CognitoProvider, GoogleProvider, KeycloakProvider, OktaProvider, OPTestProvider:
 		return nil
 	default:
-		return fmt.Errorf("not a supported provider (%s), use one of: auth0, azuread, cognito, okta, optest", p)
+		return fmt.Errorf("not a supported provider (%s), use one of: auth0, azuread, cognito, google, keycloak, okta, optest", p)
 	}
 }
 
@@ -57,6 +60,9 @@ type RuntimeConfig struct {
 	RequiredAuth0ClientId      string   `flag:"required-auth0-client-id" env:"REQUIRED_AUTH0_CLIENT_ID" usage:"the required Auth0 Client ID"`
 	RequiredAzureADTenantId    string   `flag:"required-azure-ad-tenant-id" env:"REQUIRED_AZURE_AD_TENANT_ID" usage:"the required Azure AD Tenant ID"`
 	RequiredCognitoClientId    string   `flag:"required-cognito-client-id" env:"REQUIRED_COGNITO_CLIENT_ID" usage:"the required Cognito Client ID"`
+	RequiredCognitoGroups      string   `flag:"required-cognito-groups" env:"REQUIRED_COGNITO_GROUPS" usage:"comma separated Cognito groups, tokens need to contain at least one of them, optional"`
+	RequiredGoogleHostedDomain string   `flag:"required-google-hosted-domain" env:"REQUIRED_GOOGLE_HOSTED_DOMAIN" usage:"the required Google Workspace domain (hd claim), optional"`
+	RequiredKeycloakRealmRoles string   `flag:"required-keycloak-realm-roles" env:"REQUIRED_KEYCLOAK_REALM_ROLES" usage:"comma separated Keycloak realm roles that tokens need to contain, optional"`
 	RequiredOktaClientId       string   `flag:"required-okta-client-id" env:"REQUIRED_OKTA_CLIENT_ID" usage:"the required Okta Client ID"`
 	RequiredOPTestClientId     string   `flag:"required-optest-client-id" env:"REQUIRED_OPTEST_CLIENT_ID" usage:"the required OPTest Client ID"`
 }
@@ -147,6 +153,7 @@ type CognitoClaims struct {
 	ClientId  string    `json:"client_id"`
 	EventId   string    `json:"event_id"`
 	ExpiresAt time.Time `json:"exp"`
+	Groups    []string  `json:"cognito:groups"`
 	IssuedAt  time.Time `json:"iat"`
 	Issuer    string    `json:"iss"`
 	Jti       string    `json:"jti"`
@@ -158,16 +165,47 @@ type CognitoClaims struct {
 	Version   int       `json:"version"`
 }
 
-func GetCognitoClaimsValidationFn(requiredClientId string) options.ClaimsValidationFn[CognitoClaims] {
-	return func(claims *CognitoClaims) error {
-		if requiredClientId != "" && claims.ClientId != requiredClientId {
-			return fmt.Errorf("client_id claim is required to be %q but was: %s", requiredClientId, claims.ClientId)
+type GoogleClaims struct {
+	Audience      []string  `json:"aud"`
+	Azp           string    `json:"azp"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	ExpiresAt     time.Time `json:"exp"`
+	HostedDomain  string    `json:"hd"`
+	IssuedAt      time.Time `json:"iat"`
+	Issuer        string    `json:"iss"`
+	Name          string    `json:"name"`
+	Subject       string    `json:"sub"`
+}
+
+func GetGoogleClaimsValidationFn(requiredHostedDomain string) options.ClaimsValidationFn[GoogleClaims] {
+	return func(claims *GoogleClaims) error {
+		if requiredHostedDomain != "" && claims.HostedDomain != requiredHostedDomain {
+			return fmt.Errorf("hd claim is required to be %q but was: %s", requiredHostedDomain, claims.HostedDomain)
 		}
 
 		return nil
 	}
 }
 
+type KeycloakClaims struct {
+	Audience          []string                 `json:"aud"`
+	Azp               string                   `json:"azp"`
+	ExpiresAt         time.Time                `json:"exp"`
+	IssuedAt          time.Time                `json:"iat"`
+	Issuer            string                   `json:"iss"`
+	PreferredUsername string                   `json:"preferred_username"`
+	RealmAccess       KeycloakRoles            `json:"realm_access"`
+	ResourceAccess    map[string]KeycloakRoles `json:"resource_access"`
+	Scope             string                   `json:"scope"`
+	SessionState      string                   `json:"session_state"`
+	Subject           string                   `json:"sub"`
+}
+
+type KeycloakRoles struct {
+	Roles []string `json:"roles"`
+}
+
 type OktaClaims struct {
 	Audience  []string  `json:"aud"`
 	AuthTime  int64     `json:"auth_time"`
@@ -0,0 +1,82 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/xenitab/go-oidc-middleware/oidcgrpc"
+	"github.com/xenitab/go-oidc-middleware/options"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// grpcClaimsHealthServer implements the gRPC health service and prints the claims of the caller.
+type grpcClaimsHealthServer[T any] struct {
+	healthpb.UnimplementedHealthServer
+}
+
+func (s *grpcClaimsHealthServer[T]) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	claims, err := oidcgrpc.ClaimsFromContext[T](ctx, options.DefaultClaimsContextKeyName)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "claims not found")
+	}
+
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "unable to marshal claims")
+	}
+
+	fmt.Printf("claims: %s\n", b)
+
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func RunGrpc[T any](unaryInterceptor grpc.UnaryServerInterceptor, streamInterceptor grpc.StreamServerInterceptor, address string, port int) error {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+
+	g, ctx := errgroup.WithContext(ctx)
+	stopChan := make(chan os.Signal, 2)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGPIPE)
+
+	addr := net.JoinHostPort(address, fmt.Sprintf("%d", port))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(unaryInterceptor),
+		grpc.StreamInterceptor(streamInterceptor),
+	)
+	healthpb.RegisterHealthServer(srv, &grpcClaimsHealthServer[T]{})
+	reflection.Register(srv)
+
+	g.Go(func() error {
+		fmt.Printf("listening on: %s\n", addr)
+		return srv.Serve(listener)
+	})
+
+	select {
+	case <-stopChan:
+	case <-ctx.Done():
+	}
+
+	cancel()
+
+	srv.GracefulStop()
+	fmt.Println("succeeded to shutdown gracefully")
+
+	return g.Wait()
+}
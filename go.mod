@@ -3,6 +3,7 @@ module github.com/xenitab/go-oidc-middleware
 go 1.19
 
 require (
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.1.0
 	github.com/lestrrat-go/jwx v1.2.25
 	github.com/stretchr/testify v1.8.1
 	github.com/xenitab/dispans v0.0.10
@@ -13,7 +14,6 @@ require (
 require (
 	github.com/andres-erbsen/clock v0.0.0-20160526145045-9e14626cd129 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.1.0 // indirect
 	github.com/go-oauth2/oauth2/v4 v4.4.2 // indirect
 	github.com/go-session/session v3.1.2+incompatible // indirect
 	github.com/goccy/go-json v0.9.11 // indirect
@@ -3,9 +3,12 @@ package coverage
 import (
 	"net/http"
 
+	"github.com/xenitab/go-oidc-middleware/oidcconnect"
 	"github.com/xenitab/go-oidc-middleware/oidcechojwt"
+	"github.com/xenitab/go-oidc-middleware/oidcextauthz"
 	"github.com/xenitab/go-oidc-middleware/oidcfiber"
 	"github.com/xenitab/go-oidc-middleware/oidcgin"
+	"github.com/xenitab/go-oidc-middleware/oidcgrpc"
 	"github.com/xenitab/go-oidc-middleware/oidchttp"
 	"github.com/xenitab/go-oidc-middleware/options"
 )
@@ -14,9 +17,13 @@ type testClaims map[string]interface{}
 
 func main() {
 	f := &foo{}
+	_ = oidcconnect.NewInterceptor[testClaims](nil)
 	_ = oidcechojwt.New[testClaims](nil)
+	_ = oidcextauthz.New[testClaims](nil)
 	_ = oidcfiber.New[testClaims](nil)
 	_ = oidcgin.New[testClaims](nil)
+	_ = oidcgrpc.UnaryServerInterceptor[testClaims](nil)
+	_ = oidcgrpc.StreamServerInterceptor[testClaims](nil)
 	_ = oidchttp.New[testClaims](f, nil)
 	_ = options.New()
 }
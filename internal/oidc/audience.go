@@ -0,0 +1,174 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+type requestAudienceContextKey struct{}
+
+// WithRequestAudience returns a context making ParseToken require the audience instead of
+// the configured audiences, as an example the TLS server name of the request.
+func WithRequestAudience(ctx context.Context, audience string) context.Context {
+	return context.WithValue(ctx, requestAudienceContextKey{}, audience)
+}
+
+func getRequestAudience(ctx context.Context) string {
+	audience, _ := ctx.Value(requestAudienceContextKey{}).(string)
+
+	return audience
+}
+
+type requestPathContextKey struct{}
+
+// WithRequestPath returns a context containing the path of the request, used by
+// RequireAudienceForRequestPath.
+func WithRequestPath(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, requestPathContextKey{}, path)
+}
+
+func getRequestPath(ctx context.Context) string {
+	path, _ := ctx.Value(requestPathContextKey{}).(string)
+
+	return path
+}
+
+// validateAudienceForRequestPath requires one of the audiences to be one of the required audiences
+// followed by the request path or a parent of it. The request path is cleaned first, making sure
+// `..` can't be used to reach a path outside of the scope of the audience.
+func validateAudienceForRequestPath(requiredAudiences []string, audiences []string, requestPath string) error {
+	if requestPath == "" {
+		return fmt.Errorf("unable to validate the audience for the request path: request path is missing")
+	}
+
+	requestPath = path.Clean("/" + requestPath)
+
+	if len(requiredAudiences) == 0 {
+		return fmt.Errorf("unable to validate the audience for the request path: no required audience is configured")
+	}
+
+	for _, requiredAudience := range requiredAudiences {
+		base := strings.TrimSuffix(requiredAudience, "/")
+		for _, audience := range audiences {
+			if !strings.HasPrefix(audience, base+"/") {
+				continue
+			}
+
+			if isRequestPathInScope(strings.TrimPrefix(audience, base), requestPath) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("none of the audiences authorize the request path %q, received: %v", requestPath, audiences)
+}
+
+// isRequestPathInScope returns true if the request path is the scope or below it, comparing whole path segments.
+func isRequestPathInScope(scope string, requestPath string) bool {
+	if requestPath == scope {
+		return true
+	}
+
+	return strings.HasPrefix(requestPath, strings.TrimSuffix(scope, "/")+"/")
+}
+
+// GetTLSServerNameAudience returns the TLS server name (SNI) of the request, to be used as the required audience.
+func GetTLSServerNameAudience(r *http.Request) (string, error) {
+	if r.TLS == nil || r.TLS.ServerName == "" {
+		err := fmt.Errorf("unable to get the required audience: request doesn't contain a TLS server name")
+		return "", &validationFailureError{options.AudienceValidationFailureReason, err}
+	}
+
+	return r.TLS.ServerName, nil
+}
+
+// parseTokenPayload parses the verified payload of a token. If the payload can't be parsed,
+// it is parsed again after normalizing a non-string audience claim, making tokens from
+// providers emitting the audience as a number or as a mixed-type array usable.
+func parseTokenPayload(payload []byte) (jwt.Token, error) {
+	token, err := jwt.Parse(payload)
+	if err == nil {
+		return token, nil
+	}
+
+	normalizedPayload, ok := normalizeAudienceClaim(payload)
+	if !ok {
+		return nil, err
+	}
+
+	return jwt.Parse(normalizedPayload)
+}
+
+// normalizeAudienceClaim returns the payload with the audience claim converted to a list of strings.
+// Numbers are converted to strings and any other non-string values are skipped.
+func normalizeAudienceClaim(payload []byte) ([]byte, bool) {
+	claims := make(map[string]json.RawMessage)
+	err := json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, false
+	}
+
+	rawAudience, ok := claims[jwt.AudienceKey]
+	if !ok {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(rawAudience))
+	decoder.UseNumber()
+
+	var audienceValue interface{}
+	err = decoder.Decode(&audienceValue)
+	if err != nil {
+		return nil, false
+	}
+
+	var audiences []string
+	switch audience := audienceValue.(type) {
+	case json.Number:
+		audiences = append(audiences, audience.String())
+	case []interface{}:
+		audiences = getAudienceStrings(audience)
+	default:
+		return nil, false
+	}
+
+	rawAudience, err = json.Marshal(audiences)
+	if err != nil {
+		return nil, false
+	}
+
+	claims[jwt.AudienceKey] = rawAudience
+
+	normalizedPayload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, false
+	}
+
+	return normalizedPayload, true
+}
+
+// getAudienceStrings returns the string and number values of an audience list, skipping any other values.
+func getAudienceStrings(audienceList []interface{}) []string {
+	audiences := []string{}
+	for _, v := range audienceList {
+		switch audience := v.(type) {
+		case string:
+			audiences = append(audiences, audience)
+		case json.Number:
+			audiences = append(audiences, audience.String())
+		case float64:
+			audiences = append(audiences, strconv.FormatFloat(audience, 'f', -1, 64))
+		}
+	}
+
+	return audiences
+}
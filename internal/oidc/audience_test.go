@@ -0,0 +1,351 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestNormalizeAudienceClaim(t *testing.T) {
+	cases := []struct {
+		testDescription string
+		payload         string
+		expectedPayload string
+		expectedOk      bool
+	}{
+		{
+			testDescription: "mixed-type array",
+			payload:         `{"aud":[123,"my-api",null,true,{"foo":"bar"}],"iss":"http://foo.bar"}`,
+			expectedPayload: `{"aud":["123","my-api"],"iss":"http://foo.bar"}`,
+			expectedOk:      true,
+		},
+		{
+			testDescription: "number",
+			payload:         `{"aud":123,"iss":"http://foo.bar"}`,
+			expectedPayload: `{"aud":["123"],"iss":"http://foo.bar"}`,
+			expectedOk:      true,
+		},
+		{
+			testDescription: "large number isn't rounded",
+			payload:         `{"aud":[12345678901234567890]}`,
+			expectedPayload: `{"aud":["12345678901234567890"]}`,
+			expectedOk:      true,
+		},
+		{
+			testDescription: "array without strings",
+			payload:         `{"aud":[true,null]}`,
+			expectedPayload: `{"aud":[]}`,
+			expectedOk:      true,
+		},
+		{
+			testDescription: "object",
+			payload:         `{"aud":{"foo":"bar"}}`,
+			expectedOk:      false,
+		},
+		{
+			testDescription: "no audience",
+			payload:         `{"iss":"http://foo.bar"}`,
+			expectedOk:      false,
+		},
+		{
+			testDescription: "invalid json",
+			payload:         `foobar`,
+			expectedOk:      false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		payload, ok := normalizeAudienceClaim([]byte(c.payload))
+		require.Equal(t, c.expectedOk, ok)
+
+		if !c.expectedOk {
+			continue
+		}
+
+		require.JSONEq(t, c.expectedPayload, string(payload))
+	}
+}
+
+func TestGetAudienceFromTokenWithMixedTypes(t *testing.T) {
+	token, err := parseTokenPayload([]byte(`{"aud":"foo","custom_aud":[123,"my-api",false],"number_aud":456}`))
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"123", "my-api"}, getAudienceFromToken(token, "custom_aud"))
+	require.Equal(t, []string{"456"}, getAudienceFromToken(token, "number_aud"))
+}
+
+func TestParseTokenWithMixedTypeAudience(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	cases := []struct {
+		testDescription       string
+		audience              interface{}
+		requiredAudience      string
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "string audience among junk",
+			audience:              []interface{}{123, "my-api", nil},
+			requiredAudience:      "my-api",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "number audience in array",
+			audience:              []interface{}{123, "my-api"},
+			requiredAudience:      "123",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "number audience",
+			audience:              123,
+			requiredAudience:      "123",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "missing required audience among junk",
+			audience:              []interface{}{123, "my-api"},
+			requiredAudience:      "other-api",
+			expectedErrorContains: "required audience \"other-api\" was not found",
+		},
+		{
+			testDescription:       "no valid audience",
+			audience:              []interface{}{true, nil},
+			requiredAudience:      "my-api",
+			expectedErrorContains: "required audience \"my-api\" was not found",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](nil,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithRequiredAudience(c.requiredAudience),
+		)
+		require.NoError(t, err)
+
+		tokenString := testNewTokenStringWithAudience(t, privKey, c.audience)
+
+		_, err = h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorContains(t, err, c.expectedErrorContains)
+	}
+}
+
+func testNewTokenStringWithAudience(t *testing.T, privKey jwk.Key, audience interface{}) string {
+	t.Helper()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"iss": "http://foo.bar",
+		"exp": time.Now().Add(1 * time.Minute).Unix(),
+		"aud": audience,
+	})
+	require.NoError(t, err)
+
+	headers := jws.NewHeaders()
+	err = headers.Set(jws.TypeKey, "JWT")
+	require.NoError(t, err)
+
+	tokenBytes, err := jws.Sign(payload, jwa.ES384, privKey, jws.WithHeaders(headers))
+	require.NoError(t, err)
+
+	return string(tokenBytes)
+}
+
+func TestValidateAudienceForRequestPath(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		requiredAudiences     []string
+		audiences             []string
+		requestPath           string
+		expectedErrorContains string
+	}{
+		{
+			testDescription:   "audience for the request path",
+			requiredAudiences: []string{"https://api.example.com"},
+			audiences:         []string{"https://api.example.com", "https://api.example.com/orders"},
+			requestPath:       "/orders",
+		},
+		{
+			testDescription:   "audience for a parent of the request path",
+			requiredAudiences: []string{"https://api.example.com"},
+			audiences:         []string{"https://api.example.com/orders"},
+			requestPath:       "/orders/123",
+		},
+		{
+			testDescription:   "audience with a trailing slash",
+			requiredAudiences: []string{"https://api.example.com/"},
+			audiences:         []string{"https://api.example.com/orders/"},
+			requestPath:       "/orders/123",
+		},
+		{
+			testDescription:   "audience for all paths",
+			requiredAudiences: []string{"https://api.example.com"},
+			audiences:         []string{"https://api.example.com/"},
+			requestPath:       "/orders/123",
+		},
+		{
+			testDescription:   "second required audience",
+			requiredAudiences: []string{"https://foo.example.com", "https://api.example.com"},
+			audiences:         []string{"https://api.example.com/orders"},
+			requestPath:       "/orders/123",
+		},
+		{
+			testDescription:       "audience without path",
+			requiredAudiences:     []string{"https://api.example.com"},
+			audiences:             []string{"https://api.example.com"},
+			requestPath:           "/orders",
+			expectedErrorContains: "none of the audiences authorize the request path \"/orders\"",
+		},
+		{
+			testDescription:       "audience for another path",
+			requiredAudiences:     []string{"https://api.example.com"},
+			audiences:             []string{"https://api.example.com/orders"},
+			requestPath:           "/users",
+			expectedErrorContains: "none of the audiences authorize the request path \"/users\"",
+		},
+		{
+			testDescription:       "audience for a path with the same prefix",
+			requiredAudiences:     []string{"https://api.example.com"},
+			audiences:             []string{"https://api.example.com/orders"},
+			requestPath:           "/orders-admin",
+			expectedErrorContains: "none of the audiences authorize the request path \"/orders-admin\"",
+		},
+		{
+			testDescription:       "request path leaving the scope of the audience",
+			requiredAudiences:     []string{"https://api.example.com"},
+			audiences:             []string{"https://api.example.com/orders"},
+			requestPath:           "/orders/../users",
+			expectedErrorContains: "none of the audiences authorize the request path \"/users\"",
+		},
+		{
+			testDescription:       "audience for the path of another resource",
+			requiredAudiences:     []string{"https://api.example.com"},
+			audiences:             []string{"https://api.example.com.evil/orders", "https://foo.example.com/orders"},
+			requestPath:           "/orders",
+			expectedErrorContains: "none of the audiences authorize the request path \"/orders\"",
+		},
+		{
+			testDescription:       "missing request path",
+			requiredAudiences:     []string{"https://api.example.com"},
+			audiences:             []string{"https://api.example.com/"},
+			expectedErrorContains: "request path is missing",
+		},
+		{
+			testDescription:       "no required audience",
+			audiences:             []string{"https://api.example.com/"},
+			requestPath:           "/orders",
+			expectedErrorContains: "no required audience is configured",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		err := validateAudienceForRequestPath(c.requiredAudiences, c.audiences, c.requestPath)
+		if c.expectedErrorContains != "" {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+			continue
+		}
+
+		require.NoError(t, err)
+	}
+}
+
+func TestParseTokenWithRequireAudienceForRequestPath(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	decisionCache := options.NewMemoryDecisionCache()
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithRequiredAudience("https://api.example.com"),
+		options.WithRequireAudienceForRequestPath(true),
+		options.WithDecisionCache(decisionCache),
+	)
+	require.NoError(t, err)
+	require.True(t, h.Config().RequireAudienceForRequestPath)
+
+	token := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{
+		"aud": []string{"https://api.example.com", "https://api.example.com/orders"},
+	})
+
+	cases := []struct {
+		testDescription string
+		requestPath     string
+		expectedReason  options.ValidationFailureReason
+	}{
+		{
+			testDescription: "authorized path",
+			requestPath:     "/orders/123",
+		},
+		{
+			testDescription: "path that isn't authorized",
+			requestPath:     "/users",
+			expectedReason:  options.AudienceValidationFailureReason,
+		},
+		{
+			testDescription: "authorized path after a path that isn't authorized",
+			requestPath:     "/orders",
+		},
+		{
+			testDescription: "missing path",
+			expectedReason:  options.AudienceValidationFailureReason,
+		},
+	}
+
+	// the decisions are cached per request path
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		ctx := context.Background()
+		if c.requestPath != "" {
+			ctx = WithRequestPath(ctx, c.requestPath)
+		}
+
+		for j := 0; j < 2; j++ {
+			_, err := h.ParseToken(ctx, token)
+			if c.expectedReason == "" {
+				require.NoError(t, err)
+				continue
+			}
+
+			require.Error(t, err)
+			if j == 0 {
+				require.Equal(t, c.expectedReason, GetValidationFailureReason(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package oidc
+
+import (
+	"errors"
+	"time"
+)
+
+// authTooOldError is returned when the auth_time of the token isn't within the max auth age,
+// it keeps the max auth age so that the middlewares can ask the client to re-authenticate.
+type authTooOldError struct {
+	maxAuthAge time.Duration
+	err        error
+}
+
+func (e *authTooOldError) Error() string {
+	return e.err.Error()
+}
+
+func (e *authTooOldError) Unwrap() error {
+	return e.err
+}
+
+// GetMaxAuthAgeFromError returns the max auth age that the auth_time of the token wasn't within,
+// and false if err isn't caused by a stale auth_time.
+func GetMaxAuthAgeFromError(err error) (time.Duration, bool) {
+	var authErr *authTooOldError
+	if !errors.As(err, &authErr) {
+		return 0, false
+	}
+
+	return authErr.maxAuthAge, true
+}
@@ -0,0 +1,78 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestGetMaxAuthAgeFromError(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithMaxAuthAge(5*time.Minute),
+	)
+	require.NoError(t, err)
+
+	cases := []struct {
+		testDescription    string
+		customClaims       map[string]interface{}
+		expectedAuthTooOld bool
+	}{
+		{
+			testDescription: "fresh auth_time",
+			customClaims: map[string]interface{}{
+				"auth_time": time.Now().Add(-1 * time.Minute).Unix(),
+			},
+			expectedAuthTooOld: false,
+		},
+		{
+			testDescription: "stale auth_time",
+			customClaims: map[string]interface{}{
+				"auth_time": time.Now().Add(-10 * time.Minute).Unix(),
+			},
+			expectedAuthTooOld: true,
+		},
+		{
+			testDescription:    "missing auth_time",
+			customClaims:       nil,
+			expectedAuthTooOld: false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims)
+
+		_, err := h.ParseToken(context.Background(), tokenString)
+		maxAuthAge, ok := GetMaxAuthAgeFromError(err)
+		require.Equal(t, c.expectedAuthTooOld, ok)
+		require.Equal(t, c.expectedAuthTooOld, errors.Is(err, options.ErrAuthTooOld))
+
+		if c.expectedAuthTooOld {
+			require.Equal(t, 5*time.Minute, maxAuthAge)
+
+			_, ok = GetMaxAuthAgeFromError(fmt.Errorf("wrapped: %w", err))
+			require.True(t, ok)
+		}
+	}
+}
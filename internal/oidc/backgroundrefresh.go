@@ -0,0 +1,83 @@
+package oidc
+
+import (
+	"context"
+	"time"
+)
+
+// startBackgroundRefresh starts the goroutine downloading the jwks every backgroundRefreshInterval,
+// it's stopped by Close.
+func (h *handler[T]) startBackgroundRefresh() {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.backgroundRefreshCancel = cancel
+	h.backgroundRefreshDone = make(chan struct{})
+
+	go h.refreshJwksInBackground(ctx, h.backgroundRefreshInterval)
+}
+
+func (h *handler[T]) refreshJwksInBackground(ctx context.Context, interval time.Duration) {
+	defer close(h.backgroundRefreshDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// a cached jwks (JwksJSON) is revalidated right away instead of after the first interval
+	if keyHandler := h.getKeyHandler(); keyHandler != nil && keyHandler.cached {
+		err := h.refreshJwks(ctx)
+		if err != nil && ctx.Err() == nil {
+			h.logger.Debug("background jwks refresh failed", "error", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := h.refreshJwks(ctx)
+			if err != nil && ctx.Err() == nil {
+				h.logger.Debug("background jwks refresh failed", "error", err)
+			}
+		}
+	}
+}
+
+// refreshJwks downloads the jwks using the current keyHandler, or loads it if it isn't loaded yet
+// (as an example when LazyLoadJwks is used). The keyHandler of a cached jwks (JwksJSON) is kept
+// until the jwks has been loaded from the jwks uri or the discovery document.
+func (h *handler[T]) refreshJwks(ctx context.Context) error {
+	keyHandler := h.getKeyHandler()
+	if keyHandler == nil {
+		_, err := h.lazyLoadJwks(ctx)
+		return err
+	}
+
+	if keyHandler.cached {
+		_, err := h.loadJwks(ctx)
+		return err
+	}
+
+	return keyHandler.refreshKeySet(ctx)
+}
+
+// Close stops the background refresh of the jwks and empties the token cache, for the handler
+// and the handlers of the additional issuers. Tokens can still be parsed after Close, the jwks is
+// then only downloaded on demand. It's safe to call Close more than once. The DecisionCache isn't
+// emptied, since it's provided by the application and may be shared.
+func (h *handler[T]) Close() error {
+	var err error
+	h.closeOnce.Do(func() {
+		if h.backgroundRefreshCancel != nil {
+			h.backgroundRefreshCancel()
+			<-h.backgroundRefreshDone
+		}
+
+		if h.tokenCache != nil {
+			h.tokenCache.clear()
+		}
+
+		err = closeIssuerHandlers(h.issuerHandlers)
+	})
+
+	return err
+}
@@ -0,0 +1,200 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithBackgroundRefreshInterval(t *testing.T) {
+	var publicKeySet atomic.Value
+	var fetches int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(publicKeySet.Load())
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	publicKeySet.Store(pubKeySet)
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+		options.WithBackgroundRefreshInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, h.Close())
+	}()
+
+	require.Equal(t, 10*time.Millisecond, h.Config().BackgroundRefreshInterval)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, nil))
+	require.NoError(t, err)
+
+	// the rotated key is downloaded without a request using it
+	rotatedPrivKeySet, rotatedPubKeySet := testNewKeySet(t, 1, false)
+	publicKeySet.Store(rotatedPubKeySet)
+
+	rotatedPrivKey, ok := rotatedPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	require.Eventually(t, func() bool {
+		_, found := h.getKeyHandler().getKeySet().LookupKeyID(rotatedPrivKey.KeyID())
+		return found
+	}, 5*time.Second, 10*time.Millisecond)
+
+	fetchesBeforeToken := atomic.LoadInt32(&fetches)
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, rotatedPrivKey, jwa.ES384, nil))
+	require.NoError(t, err)
+	require.LessOrEqual(t, atomic.LoadInt32(&fetches)-fetchesBeforeToken, int32(1))
+
+	// the jwks isn't downloaded after Close
+	require.NoError(t, h.Close())
+	require.NoError(t, h.Close())
+
+	finalPrivKeySet, finalPubKeySet := testNewKeySet(t, 1, false)
+	publicKeySet.Store(finalPubKeySet)
+
+	// a request canceled by Close may still reach the server
+	time.Sleep(50 * time.Millisecond)
+	fetchesAfterClose := atomic.LoadInt32(&fetches)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, fetchesAfterClose, atomic.LoadInt32(&fetches))
+
+	finalPrivKey, ok := finalPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	_, found := h.getKeyHandler().getKeySet().LookupKeyID(finalPrivKey.KeyID())
+	require.False(t, found)
+}
+
+func TestBackgroundRefreshIntervalWithLazyLoadJwks(t *testing.T) {
+	var publicKeySet atomic.Value
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keySet := publicKeySet.Load()
+		if keySet == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(keySet)
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+		options.WithLazyLoadJwks(true),
+		options.WithBackgroundRefreshInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, h.Close())
+	}()
+
+	require.Nil(t, h.getKeyHandler())
+
+	_, pubKeySet := testNewKeySet(t, 1, false)
+	publicKeySet.Store(pubKeySet)
+
+	// the jwks is loaded by the background refresh once it's available
+	require.Eventually(t, func() bool {
+		return h.Config().JwksLoaded
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestBackgroundRefreshIntervalWithJwksJSON(t *testing.T) {
+	var publicKeySet atomic.Value
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keySet := publicKeySet.Load()
+		if keySet == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(keySet)
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	cachedPrivKeySet, cachedPubKeySet := testNewKeySet(t, 1, false)
+	jwksJSON, err := json.Marshal(cachedPubKeySet)
+	require.NoError(t, err)
+
+	// the jwks uri is unavailable, the handler is created using the cached jwks
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+		options.WithJwksJSON(jwksJSON),
+		options.WithBackgroundRefreshInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, h.Close())
+	}()
+
+	cachedPrivKey, ok := cachedPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	remotePrivKeySet, remotePubKeySet := testNewKeySet(t, 1, false)
+	remotePrivKey, ok := remotePrivKeySet.Get(0)
+	require.True(t, ok)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, cachedPrivKey, jwa.ES384, nil))
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, remotePrivKey, jwa.ES384, nil))
+	require.ErrorIs(t, err, options.ErrUnknownKeyID)
+
+	// the cached jwks is replaced once the jwks uri is available
+	publicKeySet.Store(remotePubKeySet)
+
+	require.Eventually(t, func() bool {
+		return !h.getKeyHandler().cached
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, remotePrivKey, jwa.ES384, nil))
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, cachedPrivKey, jwa.ES384, nil))
+	require.ErrorIs(t, err, options.ErrUnknownKeyID)
+}
+
+func TestCloseWithoutBackgroundRefreshInterval(t *testing.T) {
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithKeySourceFunc(func(ctx context.Context) (jwk.Set, error) {
+			return jwk.NewSet(), nil
+		}),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, h.Close())
+	require.Nil(t, h.backgroundRefreshCancel)
+}
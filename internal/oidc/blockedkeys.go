@@ -0,0 +1,27 @@
+package oidc
+
+import (
+	"fmt"
+
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// validateKeyIDNotBlocked returns an error wrapping options.ErrBlockedKeyID if the key id is in BlockedKeyIDs.
+func (h *handler[T]) validateKeyIDNotBlocked(keyID string) error {
+	if !h.isKeyIDBlocked(keyID) {
+		return nil
+	}
+
+	err := fmt.Errorf("%w: token key id %q is blocked", options.ErrBlockedKeyID, keyID)
+
+	return &validationFailureError{options.SignatureValidationFailureReason, err}
+}
+
+func (h *handler[T]) isKeyIDBlocked(keyID string) bool {
+	if keyID == "" {
+		return false
+	}
+
+	_, ok := h.blockedKeyIDs[keyID]
+	return ok
+}
@@ -0,0 +1,106 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithBlockedKeyIDs(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 2, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	blockedPrivKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	privKey, ok := privKeySet.Get(1)
+	require.True(t, ok)
+
+	cases := []struct {
+		testDescription string
+		tokenString     string
+		expectedErr     error
+	}{
+		{
+			testDescription: "token signed with a key that isn't blocked",
+			tokenString:     testNewTokenStringWithKey(t, privKey, jwa.ES384, nil),
+		},
+		{
+			testDescription: "token signed with a blocked key",
+			tokenString:     testNewTokenStringWithKey(t, blockedPrivKey, jwa.ES384, nil),
+			expectedErr:     options.ErrBlockedKeyID,
+		},
+		{
+			testDescription: "token signed with a blocked key using the key id of another key",
+			tokenString:     testNewTokenStringWithKey(t, testWithKeyID(t, blockedPrivKey, privKey.KeyID()), jwa.ES384, nil),
+			expectedErr:     options.ErrSignatureVerification,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		// blocked keys aren't used as fallback keys
+		h, err := NewHandler[testClaims](nil,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithBlockedKeyIDs([]string{blockedPrivKey.KeyID()}),
+			options.WithMaxFallbackKeys(10),
+		)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(context.Background(), c.tokenString)
+		if c.expectedErr == nil {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorIs(t, err, c.expectedErr)
+		require.Equal(t, options.SignatureValidationFailureReason, GetValidationFailureReason(err))
+	}
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithBlockedKeyIDs([]string{blockedPrivKey.KeyID()}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{blockedPrivKey.KeyID()}, h.Config().BlockedKeyIDs)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, blockedPrivKey, jwa.ES384, nil))
+	require.ErrorContains(t, err, "blocked key id: token key id")
+}
+
+func TestParseTokenWithBlockedKeyIDsAndDisableKeyID(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithDisableKeyID(true),
+		options.WithBlockedKeyIDs([]string{privKey.KeyID()}),
+	)
+	require.NoError(t, err)
+
+	// the token doesn't contain the key id, but the key used to verify it is blocked
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, testWithKeyID(t, privKey, ""), jwa.ES384, nil))
+	require.ErrorIs(t, err, options.ErrBlockedKeyID)
+}
@@ -0,0 +1,224 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// CopyClaims returns a deep copy of the claims, by marshalling them to json and back.
+// The claims passed by the middlewares are shared by everyone reading them and should be
+// treated as read-only. Use the copy if the claims need to be modified, as an example
+// by concurrent readers in a gateway that fans out the request to multiple backends.
+func CopyClaims[T any](claims T) (T, error) {
+	return ConvertClaims[T](claims)
+}
+
+// ClaimsFromContext returns a copy of the claims stored in the context using keyName as T. If the
+// claims are stored using another type, they are converted to T using json.
+func ClaimsFromContext[T any](ctx context.Context, keyName options.ClaimsContextKeyName) (T, error) {
+	claims := ctx.Value(keyName)
+	if claims == nil {
+		return *new(T), fmt.Errorf("claims not found in context using key %q", keyName)
+	}
+
+	return ConvertClaims[T](claims)
+}
+
+// ConvertClaims returns a copy of the claims as T, by marshalling them to json and unmarshalling
+// them into T. Can be used to get the claims as a struct with json tags.
+func ConvertClaims[T any](claims interface{}) (T, error) {
+	claimsBytes, err := json.Marshal(claims)
+	if err != nil {
+		return *new(T), fmt.Errorf("unable to marshal claims to json: %w", err)
+	}
+
+	typedClaims := *new(T)
+	err = json.Unmarshal(claimsBytes, &typedClaims)
+	if err != nil {
+		return *new(T), fmt.Errorf("unable to unmarshal claims from json into %T: %w", typedClaims, err)
+	}
+
+	return typedClaims, nil
+}
+
+// GetSubjectFromClaims returns the `sub` claim, or an empty string if the claims don't contain
+// a string `sub` claim.
+func GetSubjectFromClaims[T any](claims T) string {
+	sub, _ := getStringClaimFromClaims(claims, "sub")
+
+	return sub
+}
+
+// getStringClaimFromClaims returns the claim claimName, or false if the claims don't contain
+// a string claimName claim. Claims that aren't a map are converted to one using json.
+func getStringClaimFromClaims(claims interface{}, claimName string) (string, bool) {
+	rawClaims, ok := claims.(map[string]interface{})
+	if !ok {
+		claimsBytes, err := json.Marshal(claims)
+		if err != nil {
+			return "", false
+		}
+
+		err = json.Unmarshal(claimsBytes, &rawClaims)
+		if err != nil {
+			return "", false
+		}
+	}
+
+	value, ok := rawClaims[claimName].(string)
+	if !ok {
+		return "", false
+	}
+
+	return value, true
+}
+
+// GetTokenExpiresIn returns the number of whole seconds left until the `exp` claim, or false if the
+// claims don't contain an `exp` claim. Expired tokens return 0.
+func GetTokenExpiresIn[T any](claims T, now time.Time) (int64, bool) {
+	expiration, ok := getExpirationFromClaims(claims)
+	if !ok {
+		return 0, false
+	}
+
+	expiresIn := int64(expiration.Sub(now) / time.Second)
+	if expiresIn < 0 {
+		return 0, true
+	}
+
+	return expiresIn, true
+}
+
+// GetTokenExpiresInHeaderValue returns the value of the TokenExpiresInHeader response header, or
+// false if the header isn't configured or the claims don't contain an `exp` claim.
+func GetTokenExpiresInHeaderValue[T any](claims T, opts *options.Options) (string, bool) {
+	if opts.TokenExpiresInHeader == "" {
+		return "", false
+	}
+
+	nowFn := opts.NowFn
+	if nowFn == nil {
+		nowFn = time.Now
+	}
+
+	expiresIn, ok := GetTokenExpiresIn(claims, nowFn())
+	if !ok {
+		return "", false
+	}
+
+	return strconv.FormatInt(expiresIn, 10), true
+}
+
+// getExpirationFromClaims returns the `exp` claim, which is a RFC 3339 string in the claims created
+// by the handler and a NumericDate if the claims type stores it as a number.
+func getExpirationFromClaims[T any](claims T) (time.Time, bool) {
+	claimsBytes, err := json.Marshal(claims)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(claimsBytes))
+	decoder.UseNumber()
+
+	var claimsMap map[string]interface{}
+	err = decoder.Decode(&claimsMap)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	switch exp := claimsMap["exp"].(type) {
+	case string:
+		expiration, err := time.Parse(time.RFC3339, exp)
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		return expiration, true
+	case json.Number:
+		seconds, err := exp.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		return time.Unix(0, int64(seconds*float64(time.Second))), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// GetClaimHeaders returns the header values for the claims in claimHeaders, which maps claim names
+// to header names. Strings, numbers and booleans are used as is, lists are joined with a comma and
+// objects are encoded as json. Claims missing from the token are skipped.
+func GetClaimHeaders[T any](claims T, claimHeaders map[string]string) (map[string]string, error) {
+	if len(claimHeaders) == 0 {
+		return nil, nil
+	}
+
+	claimsBytes, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal claims to json: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(claimsBytes))
+	decoder.UseNumber()
+
+	var claimsMap map[string]interface{}
+	err = decoder.Decode(&claimsMap)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal claims from json: %w", err)
+	}
+
+	headers := make(map[string]string, len(claimHeaders))
+	for claimName, headerName := range claimHeaders {
+		claimValue, ok := claimsMap[claimName]
+		if !ok || claimValue == nil {
+			continue
+		}
+
+		headerValue, err := getClaimHeaderValue(claimValue)
+		if err != nil {
+			return nil, fmt.Errorf("unable to convert claim %q to header %q: %w", claimName, headerName, err)
+		}
+
+		headers[headerName] = headerValue
+	}
+
+	return headers, nil
+}
+
+func getClaimHeaderValue(claimValue interface{}) (string, error) {
+	switch value := claimValue.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return fmt.Sprintf("%t", value), nil
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			s, err := getClaimHeaderValue(v)
+			if err != nil {
+				return "", err
+			}
+
+			values = append(values, s)
+		}
+
+		return strings.Join(values, ","), nil
+	default:
+		valueBytes, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+
+		return string(valueBytes), nil
+	}
+}
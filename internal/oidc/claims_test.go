@@ -0,0 +1,204 @@
+package oidc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestCopyClaims(t *testing.T) {
+	claims := testClaims{
+		"sub":    "foo",
+		"roles":  []interface{}{"bar"},
+		"nested": map[string]interface{}{"baz": "qux"},
+	}
+
+	claimsCopy, err := CopyClaims(claims)
+	require.NoError(t, err)
+	require.Equal(t, claims, claimsCopy)
+
+	claimsCopy["sub"] = "bar"
+	claimsCopy["roles"].([]interface{})[0] = "baz"
+	claimsCopy["nested"].(map[string]interface{})["baz"] = "quux"
+
+	require.Equal(t, "foo", claims["sub"])
+	require.Equal(t, []interface{}{"bar"}, claims["roles"])
+	require.Equal(t, map[string]interface{}{"baz": "qux"}, claims["nested"])
+
+	type typedClaims struct {
+		Subject string   `json:"sub"`
+		Roles   []string `json:"roles"`
+	}
+
+	typed := typedClaims{Subject: "foo", Roles: []string{"bar"}}
+	typedCopy, err := CopyClaims(typed)
+	require.NoError(t, err)
+	require.Equal(t, typed, typedCopy)
+
+	typedCopy.Roles[0] = "baz"
+	require.Equal(t, []string{"bar"}, typed.Roles)
+
+	_, err = CopyClaims(map[string]interface{}{"foo": func() {}})
+	require.ErrorContains(t, err, "unable to marshal claims to json")
+}
+
+func TestConvertClaims(t *testing.T) {
+	type typedClaims struct {
+		Subject string `json:"sub"`
+		Nested  struct {
+			Foo string `json:"foo"`
+		} `json:"nested"`
+	}
+
+	typed, err := ConvertClaims[typedClaims](testClaims{"sub": "foo", "nested": map[string]interface{}{"foo": "bar"}})
+	require.NoError(t, err)
+	require.Equal(t, "foo", typed.Subject)
+	require.Equal(t, "bar", typed.Nested.Foo)
+
+	_, err = ConvertClaims[typedClaims](testClaims{"sub": 123})
+	require.ErrorContains(t, err, "unable to unmarshal claims from json into oidc.typedClaims")
+
+	_, err = ConvertClaims[typedClaims](map[string]interface{}{"foo": func() {}})
+	require.ErrorContains(t, err, "unable to marshal claims to json")
+}
+
+func TestGetSubjectFromClaims(t *testing.T) {
+	require.Equal(t, "foo", GetSubjectFromClaims(map[string]interface{}{"sub": "foo"}))
+	require.Equal(t, "foo", GetSubjectFromClaims(testClaims{"sub": "foo"}))
+	require.Equal(t, "", GetSubjectFromClaims(testClaims{"sub": 123}))
+	require.Equal(t, "", GetSubjectFromClaims(testClaims{}))
+
+	type typedClaims struct {
+		Subject string `json:"sub"`
+	}
+
+	require.Equal(t, "foo", GetSubjectFromClaims(typedClaims{Subject: "foo"}))
+	require.Equal(t, "", GetSubjectFromClaims(map[string]interface{}{"foo": func() {}}))
+}
+
+func TestGetTokenExpiresIn(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	cases := []struct {
+		testDescription   string
+		claims            interface{}
+		expectedExpiresIn int64
+		expectedOk        bool
+	}{
+		{
+			testDescription:   "exp as rfc 3339 string",
+			claims:            testClaims{"exp": now.Add(time.Hour).UTC().Format(time.RFC3339)},
+			expectedExpiresIn: 3600,
+			expectedOk:        true,
+		},
+		{
+			testDescription:   "exp as numeric date",
+			claims:            testClaims{"exp": 1234567890 + 90.5},
+			expectedExpiresIn: 90,
+			expectedOk:        true,
+		},
+		{
+			testDescription: "exp as time in typed claims",
+			claims: struct {
+				ExpiresAt time.Time `json:"exp"`
+			}{ExpiresAt: now.Add(time.Minute)},
+			expectedExpiresIn: 60,
+			expectedOk:        true,
+		},
+		{
+			testDescription:   "expired token",
+			claims:            testClaims{"exp": 1234567890 - 60},
+			expectedExpiresIn: 0,
+			expectedOk:        true,
+		},
+		{
+			testDescription: "missing exp",
+			claims:          testClaims{"sub": "foo"},
+			expectedOk:      false,
+		},
+		{
+			testDescription: "exp of other type",
+			claims:          testClaims{"exp": true},
+			expectedOk:      false,
+		},
+		{
+			testDescription: "exp as invalid string",
+			claims:          testClaims{"exp": "foo"},
+			expectedOk:      false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		expiresIn, ok := GetTokenExpiresIn(c.claims, now)
+		require.Equal(t, c.expectedOk, ok)
+		require.Equal(t, c.expectedExpiresIn, expiresIn)
+	}
+
+	nowFn := func() time.Time {
+		return now
+	}
+
+	claims := testClaims{"exp": 1234567890 + 300}
+
+	_, ok := GetTokenExpiresInHeaderValue(claims, options.New(options.WithNowFn(nowFn)))
+	require.False(t, ok)
+
+	headerValue, ok := GetTokenExpiresInHeaderValue(claims, options.New(options.WithNowFn(nowFn), options.WithTokenExpiresInHeader("X-Token-Expires-In")))
+	require.True(t, ok)
+	require.Equal(t, "300", headerValue)
+}
+
+func TestGetClaimHeaders(t *testing.T) {
+	claims := testClaims{
+		"sub":    "foo",
+		"exp":    1234567890,
+		"admin":  true,
+		"roles":  []interface{}{"bar", "baz"},
+		"nested": map[string]interface{}{"foo": "bar"},
+		"empty":  nil,
+	}
+
+	headers, err := GetClaimHeaders(claims, map[string]string{
+		"sub":     "X-Auth-Subject",
+		"exp":     "X-Auth-Expiration",
+		"admin":   "X-Auth-Admin",
+		"roles":   "X-Auth-Roles",
+		"nested":  "X-Auth-Nested",
+		"empty":   "X-Auth-Empty",
+		"missing": "X-Auth-Missing",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"X-Auth-Subject":    "foo",
+		"X-Auth-Expiration": "1234567890",
+		"X-Auth-Admin":      "true",
+		"X-Auth-Roles":      "bar,baz",
+		"X-Auth-Nested":     `{"foo":"bar"}`,
+	}, headers)
+
+	type typedClaims struct {
+		Subject string   `json:"sub"`
+		Roles   []string `json:"roles"`
+	}
+
+	headers, err = GetClaimHeaders(typedClaims{Subject: "foo", Roles: []string{"bar"}}, map[string]string{
+		"sub":   "X-Auth-Subject",
+		"roles": "X-Auth-Roles",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"X-Auth-Subject": "foo",
+		"X-Auth-Roles":   "bar",
+	}, headers)
+
+	headers, err = GetClaimHeaders(claims, nil)
+	require.NoError(t, err)
+	require.Nil(t, headers)
+
+	_, err = GetClaimHeaders(map[string]interface{}{"foo": func() {}}, map[string]string{"foo": "X-Foo"})
+	require.ErrorContains(t, err, "unable to marshal claims to json")
+}
@@ -0,0 +1,58 @@
+package oidc
+
+import (
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// validateClaimsPresent validates that the token contains each of the required claims. If strict
+// is true, claims with an empty value are handled as missing.
+func validateClaimsPresent(requiredClaims []string, strict bool, claimNamespace string, token jwt.Token) error {
+	for _, claimName := range requiredClaims {
+		_, ok := getNamespacedClaim(token, claimName, claimNamespace)
+		if !ok {
+			return fmt.Errorf("required claim %q was not found", claimName)
+		}
+
+		_, ok = getPresentClaim(token, claimName, strict, claimNamespace)
+		if !ok {
+			return fmt.Errorf("required claim %q is empty", claimName)
+		}
+	}
+
+	return nil
+}
+
+// getPresentClaim returns the value of the claim, or false if the token doesn't contain the claim.
+// If strict is true, false is also returned if the claim is null, an empty string, an empty array
+// or an empty object.
+func getPresentClaim(token jwt.Token, claimName string, strict bool, claimNamespace string) (interface{}, bool) {
+	claimValue, ok := getNamespacedClaim(token, claimName, claimNamespace)
+	if !ok {
+		return nil, false
+	}
+
+	if strict && isEmptyClaimValue(claimValue) {
+		return nil, false
+	}
+
+	return claimValue, true
+}
+
+func isEmptyClaimValue(claimValue interface{}) bool {
+	switch v := claimValue.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []string:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,140 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithRequiredClaimsPresent(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	cases := []struct {
+		testDescription       string
+		requiredClaims        []string
+		requiredClaimsRegex   map[string]string
+		strict                bool
+		claimNamespace        string
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "claims present",
+			requiredClaims:  []string{"email", "groups"},
+			customClaims:    map[string]interface{}{"email": "foo@example.com", "groups": []string{"foo"}},
+		},
+		{
+			testDescription:       "claim missing",
+			requiredClaims:        []string{"email", "groups"},
+			customClaims:          map[string]interface{}{"email": "foo@example.com"},
+			expectedErrorContains: "required claim \"groups\" was not found",
+		},
+		{
+			testDescription: "empty claims without strict presence",
+			requiredClaims:  []string{"email", "groups", "address"},
+			customClaims:    map[string]interface{}{"email": "", "groups": []string{}, "address": map[string]interface{}{}},
+		},
+		{
+			testDescription: "claims present with strict presence",
+			requiredClaims:  []string{"email", "groups", "address", "email_verified", "age"},
+			strict:          true,
+			customClaims: map[string]interface{}{
+				"email":          "foo@example.com",
+				"groups":         []string{"foo"},
+				"address":        map[string]interface{}{"country": "SE"},
+				"email_verified": false,
+				"age":            0,
+			},
+		},
+		{
+			testDescription:       "empty string with strict presence",
+			requiredClaims:        []string{"email"},
+			strict:                true,
+			customClaims:          map[string]interface{}{"email": ""},
+			expectedErrorContains: "required claim \"email\" is empty",
+		},
+		{
+			testDescription:       "empty array with strict presence",
+			requiredClaims:        []string{"groups"},
+			strict:                true,
+			customClaims:          map[string]interface{}{"groups": []string{}},
+			expectedErrorContains: "required claim \"groups\" is empty",
+		},
+		{
+			testDescription:       "empty object with strict presence",
+			requiredClaims:        []string{"address"},
+			strict:                true,
+			customClaims:          map[string]interface{}{"address": map[string]interface{}{}},
+			expectedErrorContains: "required claim \"address\" is empty",
+		},
+		{
+			testDescription:       "null with strict presence",
+			requiredClaims:        []string{"email"},
+			strict:                true,
+			customClaims:          map[string]interface{}{"email": nil},
+			expectedErrorContains: "required claim \"email\" is empty",
+		},
+		{
+			testDescription:     "empty string matching regex without strict presence",
+			requiredClaimsRegex: map[string]string{"email": ".*"},
+			customClaims:        map[string]interface{}{"email": ""},
+		},
+		{
+			testDescription:       "empty string matching regex with strict presence",
+			requiredClaimsRegex:   map[string]string{"email": ".*"},
+			strict:                true,
+			customClaims:          map[string]interface{}{"email": ""},
+			expectedErrorContains: "required claim \"email\" matching \".*\" was not found",
+		},
+		{
+			testDescription:     "claims present with claim namespace",
+			requiredClaims:      []string{"email", "groups"},
+			requiredClaimsRegex: map[string]string{"email": `@example\.com$`},
+			strict:              true,
+			claimNamespace:      "https://myapp.com/",
+			customClaims:        map[string]interface{}{"email": "foo@example.com", "https://myapp.com/groups": []string{"foo"}},
+		},
+		{
+			testDescription:       "claim matching regex with claim namespace",
+			requiredClaimsRegex:   map[string]string{"email": `@example\.com$`},
+			claimNamespace:        "https://myapp.com/",
+			customClaims:          map[string]interface{}{"https://myapp.com/email": "foo@example.org"},
+			expectedErrorContains: `claim "email" does not match`,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](nil,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithRequiredClaimsPresent(c.requiredClaims),
+			options.WithRequiredClaimsRegex(c.requiredClaimsRegex),
+			options.WithStrictClaimsPresence(c.strict),
+			options.WithClaimNamespace(c.claimNamespace),
+		)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims))
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorContains(t, err, c.expectedErrorContains)
+		require.Equal(t, options.ClaimsValidationFailureReason, GetValidationFailureReason(err))
+	}
+}
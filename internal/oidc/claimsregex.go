@@ -0,0 +1,75 @@
+package oidc
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// validateClaimsRegex validates that each claim in requiredClaimsRegex matches the regular expression.
+// If the claim is an array, at least one of the values needs to match. If strict is true, claims with
+// an empty value are handled as missing.
+func validateClaimsRegex(requiredClaimsRegex map[string]*regexp.Regexp, strict bool, claimNamespace string, token jwt.Token) error {
+	claimNames := make([]string, 0, len(requiredClaimsRegex))
+	for claimName := range requiredClaimsRegex {
+		claimNames = append(claimNames, claimName)
+	}
+
+	// validated in a stable order to always return the same error for the same token
+	sort.Strings(claimNames)
+
+	for _, claimName := range claimNames {
+		re := requiredClaimsRegex[claimName]
+
+		claimValue, ok := getPresentClaim(token, claimName, strict, claimNamespace)
+		if !ok {
+			return fmt.Errorf("required claim %q matching %q was not found", claimName, re.String())
+		}
+
+		values, err := getStringsFromClaimValue(claimValue)
+		if err != nil {
+			return fmt.Errorf("unable to match claim %q: %w", claimName, err)
+		}
+
+		if !isAnyMatching(re, values) {
+			return fmt.Errorf("claim %q does not match %q, received: %v", claimName, re.String(), values)
+		}
+	}
+
+	return nil
+}
+
+func getStringsFromClaimValue(claimValue interface{}) ([]string, error) {
+	switch v := claimValue.(type) {
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string in array, received type: %T", value)
+			}
+
+			values = append(values, s)
+		}
+
+		return values, nil
+	default:
+		return nil, fmt.Errorf("expected string or array, received type: %T", claimValue)
+	}
+}
+
+func isAnyMatching(re *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
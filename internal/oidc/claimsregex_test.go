@@ -0,0 +1,118 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithRequiredClaimsRegex(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	cases := []struct {
+		testDescription       string
+		requiredClaimsRegex   map[string]string
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription:     "string claim matching",
+			requiredClaimsRegex: map[string]string{"email": `.*@example\.com$`},
+			customClaims:        map[string]interface{}{"email": "foo@example.com"},
+		},
+		{
+			testDescription:       "string claim not matching",
+			requiredClaimsRegex:   map[string]string{"email": `.*@example\.com$`},
+			customClaims:          map[string]interface{}{"email": "foo@example.com.evil.org"},
+			expectedErrorContains: `claim "email" does not match ".*@example\\.com$", received: [foo@example.com.evil.org]`,
+		},
+		{
+			testDescription:     "array claim with a matching value",
+			requiredClaimsRegex: map[string]string{"emails": `.*@example\.com$`},
+			customClaims:        map[string]interface{}{"emails": []string{"foo@gmail.com", "foo@example.com"}},
+		},
+		{
+			testDescription:       "array claim without a matching value",
+			requiredClaimsRegex:   map[string]string{"emails": `.*@example\.com$`},
+			customClaims:          map[string]interface{}{"emails": []string{"foo@gmail.com", "foo@outlook.com"}},
+			expectedErrorContains: `claim "emails" does not match`,
+		},
+		{
+			testDescription:       "empty array claim",
+			requiredClaimsRegex:   map[string]string{"emails": `.*`},
+			customClaims:          map[string]interface{}{"emails": []string{}},
+			expectedErrorContains: `claim "emails" does not match`,
+		},
+		{
+			testDescription:       "missing claim",
+			requiredClaimsRegex:   map[string]string{"email": `.*@example\.com$`},
+			customClaims:          map[string]interface{}{"sub": "foo"},
+			expectedErrorContains: `required claim "email" matching ".*@example\\.com$" was not found`,
+		},
+		{
+			testDescription:       "claim of other type",
+			requiredClaimsRegex:   map[string]string{"email_verified": `^true$`},
+			customClaims:          map[string]interface{}{"email_verified": true},
+			expectedErrorContains: `unable to match claim "email_verified": expected string or array, received type: bool`,
+		},
+		{
+			testDescription:       "array claim with values of other type",
+			requiredClaimsRegex:   map[string]string{"emails": `.*`},
+			customClaims:          map[string]interface{}{"emails": []interface{}{"foo@example.com", 1}},
+			expectedErrorContains: "expected string in array, received type: float64",
+		},
+		{
+			testDescription:     "multiple claims matching",
+			requiredClaimsRegex: map[string]string{"email": `@example\.com$`, "sub": `^[0-9]+$`},
+			customClaims:        map[string]interface{}{"email": "foo@example.com", "sub": "1234"},
+		},
+		{
+			testDescription:       "multiple claims with one not matching",
+			requiredClaimsRegex:   map[string]string{"email": `@example\.com$`, "sub": `^[0-9]+$`},
+			customClaims:          map[string]interface{}{"email": "foo@example.com", "sub": "foo"},
+			expectedErrorContains: `claim "sub" does not match`,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](nil,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithRequiredClaimsRegex(c.requiredClaimsRegex),
+		)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims))
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorContains(t, err, c.expectedErrorContains)
+		require.Equal(t, options.ClaimsValidationFailureReason, GetValidationFailureReason(err))
+	}
+}
+
+func TestNewHandlerWithInvalidRequiredClaimsRegex(t *testing.T) {
+	_, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithLazyLoadJwks(true),
+		options.WithRequiredClaimsRegex(map[string]string{"email": `(`}),
+	)
+	require.ErrorContains(t, err, `RequiredClaimsRegex not accepted for claim "email"`)
+}
@@ -0,0 +1,30 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// runClaimsValidator calls the ClaimsValidator with the claims of the token. It isn't part of
+// validatePolicy, since its outcome can depend on the request context and can't be stored in
+// the decision cache.
+func (h *handler[T]) runClaimsValidator(ctx context.Context, token jwt.Token) error {
+	if h.claimsValidator == nil {
+		return nil
+	}
+
+	claims, err := jwtTokenToClaims[map[string]interface{}](ctx, token, h.claimNamespace)
+	if err != nil {
+		return fmt.Errorf("unable to convert jwt.Token to claims: %w", err)
+	}
+
+	err = h.claimsValidator(ctx, claims)
+	if err != nil {
+		return &validationFailureError{options.ClaimsValidationFailureReason, fmt.Errorf("claims validator returned an error: %w", err)}
+	}
+
+	return nil
+}
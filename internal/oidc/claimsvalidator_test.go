@@ -0,0 +1,125 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+type testTenantContextKey struct{}
+
+func TestParseTokenWithClaimsValidator(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	errNotAllowed := errors.New("admins are only allowed for tenant foo")
+
+	calls := 0
+	claimsValidator := func(ctx context.Context, claims map[string]interface{}) error {
+		calls++
+
+		if claims["role"] == "admin" && claims["tenant"] != "foo" {
+			return errNotAllowed
+		}
+
+		tenant, ok := ctx.Value(testTenantContextKey{}).(string)
+		if ok && claims["tenant"] != tenant {
+			return fmt.Errorf("tenant %q is required, received: %v", tenant, claims["tenant"])
+		}
+
+		return nil
+	}
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithRequiredAudience("baz"),
+		options.WithClaimsValidator(claimsValidator),
+		options.WithDecisionCache(options.NewMemoryDecisionCache()),
+	)
+	require.NoError(t, err)
+
+	cases := []struct {
+		testDescription       string
+		ctx                   context.Context
+		customClaims          map[string]interface{}
+		expectedCalls         int
+		expectedErrorContains string
+		expectedError         error
+	}{
+		{
+			testDescription: "admin of the allowed tenant",
+			ctx:             context.Background(),
+			customClaims:    map[string]interface{}{"aud": "baz", "role": "admin", "tenant": "foo"},
+			expectedCalls:   1,
+		},
+		{
+			testDescription: "user of another tenant",
+			ctx:             context.Background(),
+			customClaims:    map[string]interface{}{"aud": "baz", "role": "user", "tenant": "bar"},
+			expectedCalls:   1,
+		},
+		{
+			testDescription:       "admin of another tenant",
+			ctx:                   context.Background(),
+			customClaims:          map[string]interface{}{"aud": "baz", "role": "admin", "tenant": "bar"},
+			expectedCalls:         1,
+			expectedErrorContains: "claims validator returned an error: admins are only allowed for tenant foo",
+			expectedError:         errNotAllowed,
+		},
+		{
+			testDescription:       "tenant from the request context not matching",
+			ctx:                   context.WithValue(context.Background(), testTenantContextKey{}, "foo"),
+			customClaims:          map[string]interface{}{"aud": "baz", "role": "user", "tenant": "bar"},
+			expectedCalls:         1,
+			expectedErrorContains: "tenant \"foo\" is required, received: bar",
+		},
+		{
+			testDescription:       "not called if the audience is invalid",
+			ctx:                   context.Background(),
+			customClaims:          map[string]interface{}{"aud": "qux", "role": "admin", "tenant": "foo"},
+			expectedCalls:         0,
+			expectedErrorContains: "required audience \"baz\" was not found",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		calls = 0
+		token := testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims)
+
+		// the outcome of the claims validator isn't stored in the decision cache, unlike the audience
+		for j := 0; j < 2; j++ {
+			_, err := h.ParseToken(c.ctx, token)
+			if c.expectedErrorContains == "" {
+				require.NoError(t, err)
+				continue
+			}
+
+			require.Error(t, err)
+			if c.expectedCalls > 0 || j == 0 {
+				require.ErrorContains(t, err, c.expectedErrorContains)
+			}
+			if c.expectedError != nil {
+				require.ErrorIs(t, err, c.expectedError)
+				require.Equal(t, options.ClaimsValidationFailureReason, GetValidationFailureReason(err))
+			}
+		}
+
+		require.Equal(t, 2*c.expectedCalls, calls)
+	}
+}
@@ -0,0 +1,195 @@
+package oidc
+
+import (
+	"sort"
+	"time"
+)
+
+// Config contains the effective configuration used by the handler, after defaults
+// have been applied and the discovery has been resolved.
+type Config struct {
+	Issuer                        string
+	IssuerAliases                 []string
+	UseDiscoveryIssuer            bool
+	DiscoveryIssuer               string
+	DiscoveryUri                  string
+	DiscoveryFetchTimeout         time.Duration
+	JwksUri                       string
+	JwksFetchTimeout              time.Duration
+	JwksFetchRetries              int
+	JwksFetchRetryDelay           time.Duration
+	JwksRateLimit                 uint
+	JwksLoaded                    bool
+	LazyLoadJwksBackoff           time.Duration
+	BackgroundRefreshInterval     time.Duration
+	RequireJwksSameHostAsIssuer   bool
+	IntrospectionUri              string
+	IntrospectionClientID         string
+	IntrospectionFetchTimeout     time.Duration
+	FallbackSignatureAlgorithm    string
+	AllowES256K                   bool
+	AllowedTokenDrift             time.Duration
+	AbsoluteMaxExpiryAge          time.Duration
+	MaxAuthAge                    time.Duration
+	MaxTokenAge                   time.Duration
+	RequiredTokenType             string
+	RequiredTokenTypes            []string
+	AllowMissingTokenType         bool
+	RequiredTokenUse              string
+	MaxTokenLength                int
+	RequiredAudience              string
+	RequiredAudiences             []string
+	AudienceIsIssuer              bool
+	AllowMissingAudience          bool
+	AudienceClaimName             string
+	RequireAudienceForRequestPath bool
+	ClaimNamespace                string
+	RequiredRoles                 []string
+	RequiredScopes                []string
+	RolesClaimName                string
+	RolesDelimiter                string
+	RoleHierarchy                 map[string][]string
+	RequiredRealmRoles            []string
+	RequiredClientRoles           map[string][]string
+	RequiredGroupsAny             []string
+	RequiredGroupsAll             []string
+	RequiredClaimsRegex           map[string]string
+	RequiredClaimsPresent         []string
+	StrictClaimsPresence          bool
+	GroupsClaimName               string
+	DisableKeyID                  bool
+	MaxFallbackKeys               int
+	AllowedKeyTypes               []string
+	MinRSAKeyBits                 int
+	AllowedSignatureAlgorithms    []string
+	DeprecatedKeyIDs              []string
+	BlockedKeyIDs                 []string
+	RevokedTokenIDs               []string
+	NonceMaxAge                   time.Duration
+	PolicyID                      string
+	TokenCacheTTL                 time.Duration
+	TokenCacheSize                int
+}
+
+// Config returns a copy of the effective configuration. JwksUri is the one resolved
+// using discovery if the jwks has been loaded and JwksUri wasn't configured.
+// Changing the returned Config doesn't affect the handler.
+func (h *handler[T]) Config() Config {
+	h.RLock()
+	defer h.RUnlock()
+
+	cfg := Config{
+		Issuer:                        h.issuer,
+		IssuerAliases:                 append([]string(nil), h.issuerAliases...),
+		UseDiscoveryIssuer:            h.useDiscoveryIssuer,
+		DiscoveryIssuer:               h.discoveryIssuer,
+		DiscoveryUri:                  h.discoveryUri,
+		DiscoveryFetchTimeout:         h.discoveryFetchTimeout,
+		JwksUri:                       h.jwksUri,
+		JwksFetchTimeout:              h.jwksFetchTimeout,
+		JwksFetchRetries:              h.jwksFetchRetries,
+		JwksFetchRetryDelay:           h.jwksFetchRetryDelay,
+		JwksRateLimit:                 h.jwksRateLimit,
+		JwksLoaded:                    h.keyHandler != nil,
+		LazyLoadJwksBackoff:           h.lazyLoadJwksBackoff,
+		BackgroundRefreshInterval:     h.backgroundRefreshInterval,
+		RequireJwksSameHostAsIssuer:   h.requireJwksSameHostAsIssuer,
+		IntrospectionUri:              h.introspectionUri,
+		IntrospectionClientID:         h.introspectionClientID,
+		IntrospectionFetchTimeout:     h.introspectionFetchTimeout,
+		FallbackSignatureAlgorithm:    h.fallbackSignatureAlgorithm.String(),
+		AllowES256K:                   h.allowES256K,
+		AllowedTokenDrift:             h.allowedTokenDrift,
+		AbsoluteMaxExpiryAge:          h.absoluteMaxExpiryAge,
+		MaxAuthAge:                    h.maxAuthAge,
+		MaxTokenAge:                   h.maxTokenAge,
+		RequiredTokenType:             h.requiredTokenType,
+		RequiredTokenTypes:            append([]string(nil), h.requiredTokenTypes...),
+		AllowMissingTokenType:         h.allowMissingTokenType,
+		RequiredTokenUse:              h.requiredTokenUse,
+		MaxTokenLength:                h.maxTokenLength,
+		RequiredAudience:              h.requiredAudience,
+		RequiredAudiences:             append([]string(nil), h.requiredAudiences...),
+		AudienceIsIssuer:              h.audienceIsIssuer,
+		AllowMissingAudience:          h.allowMissingAudience,
+		AudienceClaimName:             h.audienceClaimName,
+		RequireAudienceForRequestPath: h.requireAudienceForRequestPath,
+		ClaimNamespace:                h.claimNamespace,
+		RequiredRoles:                 append([]string(nil), h.requiredRoles...),
+		RequiredScopes:                append([]string(nil), h.requiredScopes...),
+		RolesClaimName:                h.rolesClaimName,
+		RolesDelimiter:                h.rolesDelimiter,
+		RequiredRealmRoles:            append([]string(nil), h.requiredRealmRoles...),
+		RequiredGroupsAny:             append([]string(nil), h.requiredGroupsAny...),
+		RequiredGroupsAll:             append([]string(nil), h.requiredGroupsAll...),
+		RequiredClaimsPresent:         append([]string(nil), h.requiredClaimsPresent...),
+		StrictClaimsPresence:          h.strictClaimsPresence,
+		GroupsClaimName:               h.groupsClaimName,
+		DisableKeyID:                  h.disableKeyID,
+		MaxFallbackKeys:               h.maxFallbackKeys,
+		MinRSAKeyBits:                 h.minRSAKeyBits,
+		NonceMaxAge:                   h.nonceMaxAge,
+		PolicyID:                      h.policyID,
+	}
+
+	if h.tokenCache != nil {
+		cfg.TokenCacheTTL = h.tokenCache.ttl
+		cfg.TokenCacheSize = h.tokenCache.size
+	}
+
+	if h.keyHandler != nil {
+		cfg.JwksUri = h.keyHandler.jwksURI
+	}
+
+	for client, roles := range h.requiredClientRoles {
+		if cfg.RequiredClientRoles == nil {
+			cfg.RequiredClientRoles = make(map[string][]string)
+		}
+
+		cfg.RequiredClientRoles[client] = append([]string(nil), roles...)
+	}
+
+	for role, impliedRoles := range h.roleHierarchy {
+		if cfg.RoleHierarchy == nil {
+			cfg.RoleHierarchy = make(map[string][]string)
+		}
+
+		cfg.RoleHierarchy[role] = append([]string(nil), impliedRoles...)
+	}
+
+	for claimName, re := range h.requiredClaimsRegex {
+		if cfg.RequiredClaimsRegex == nil {
+			cfg.RequiredClaimsRegex = make(map[string]string)
+		}
+
+		cfg.RequiredClaimsRegex[claimName] = re.String()
+	}
+
+	for _, kty := range h.allowedKeyTypes {
+		cfg.AllowedKeyTypes = append(cfg.AllowedKeyTypes, kty.String())
+	}
+
+	for _, alg := range h.allowedSignatureAlgorithms {
+		cfg.AllowedSignatureAlgorithms = append(cfg.AllowedSignatureAlgorithms, alg.String())
+	}
+
+	for kid := range h.deprecatedKeyIDs {
+		cfg.DeprecatedKeyIDs = append(cfg.DeprecatedKeyIDs, kid)
+	}
+
+	sort.Strings(cfg.DeprecatedKeyIDs)
+
+	for kid := range h.blockedKeyIDs {
+		cfg.BlockedKeyIDs = append(cfg.BlockedKeyIDs, kid)
+	}
+
+	sort.Strings(cfg.BlockedKeyIDs)
+
+	for jti := range h.revokedTokenIDs {
+		cfg.RevokedTokenIDs = append(cfg.RevokedTokenIDs, jti)
+	}
+
+	sort.Strings(cfg.RevokedTokenIDs)
+
+	return cfg
+}
@@ -0,0 +1,69 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/optest"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestConfig(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	issuer := op.GetURL(t)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer(issuer),
+		options.WithAllowedKeyTypes([]string{"EC"}),
+	)
+	require.NoError(t, err)
+
+	cfg := h.Config()
+	require.Equal(t, issuer, cfg.Issuer)
+	require.Equal(t, GetDiscoveryUriFromIssuer(issuer), cfg.DiscoveryUri)
+	require.Equal(t, issuer+"/jwks", cfg.JwksUri)
+	require.True(t, cfg.JwksLoaded)
+	require.Equal(t, 5*time.Second, cfg.DiscoveryFetchTimeout)
+	require.Equal(t, 5*time.Second, cfg.JwksFetchTimeout)
+	require.Equal(t, uint(1), cfg.JwksRateLimit)
+	require.Equal(t, 10*time.Second, cfg.AllowedTokenDrift)
+	require.Equal(t, "aud", cfg.AudienceClaimName)
+	require.Equal(t, []string{"EC"}, cfg.AllowedKeyTypes)
+
+	// the returned config is a copy
+	cfg.AllowedKeyTypes[0] = "RSA"
+	cfg.Issuer = "http://foo.bar"
+	require.Equal(t, []string{"EC"}, h.Config().AllowedKeyTypes)
+	require.Equal(t, issuer, h.Config().Issuer)
+}
+
+func TestConfigWithLazyLoad(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	issuer := op.GetURL(t)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer(issuer),
+		options.WithLazyLoadJwks(true),
+	)
+	require.NoError(t, err)
+
+	cfg := h.Config()
+	require.Equal(t, GetDiscoveryUriFromIssuer(issuer), cfg.DiscoveryUri)
+	require.Equal(t, "", cfg.JwksUri)
+	require.False(t, cfg.JwksLoaded)
+
+	_, err = h.ParseToken(context.Background(), op.GetToken(t).AccessToken)
+	require.NoError(t, err)
+
+	cfg = h.Config()
+	require.Equal(t, issuer+"/jwks", cfg.JwksUri)
+	require.True(t, cfg.JwksLoaded)
+}
@@ -0,0 +1,169 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Diagnostics contains the outcome of each step run by Validate.
+type Diagnostics struct {
+	DiscoveryUri string
+	JwksUri      string
+	KeyCount     int
+	Steps        []DiagnosticsStep
+}
+
+// DiagnosticsStep contains the outcome of a single step run by Validate.
+// Err is nil if the step succeeded.
+type DiagnosticsStep struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// Failed returns the first failed step or nil if all steps succeeded.
+func (d *Diagnostics) Failed() *DiagnosticsStep {
+	for i := range d.Steps {
+		if d.Steps[i].Err != nil {
+			return &d.Steps[i]
+		}
+	}
+
+	return nil
+}
+
+func (d *Diagnostics) run(name string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	d.Steps = append(d.Steps, DiagnosticsStep{
+		Name:     name,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	return nil
+}
+
+// Validate verifies the configuration end-to-end: the options are validated the same way as by
+// NewHandler, the discovery document is fetched and parsed (unless JwksUri or KeySourceFunc is
+// configured), the jwks is downloaded (or loaded using KeySourceFunc), the discovery and jwks of
+// each of the Issuers are validated and, if sampleToken isn't empty, the sample token is parsed
+// and validated. The discovery and jwks steps are skipped when IntrospectionUri is used.
+// Diagnostics is always returned and error is the first step that failed.
+func (h *handler[T]) Validate(ctx context.Context, sampleToken string) (*Diagnostics, error) {
+	diag := &Diagnostics{
+		DiscoveryUri: h.getDiscoveryUri(),
+		JwksUri:      h.jwksUri,
+	}
+
+	if h.keySourceFunc != nil {
+		diag.JwksUri = ""
+	}
+
+	err := diag.run("options", func() error {
+		return validateOptions(h.opts)
+	})
+	if err != nil {
+		return diag, err
+	}
+
+	if h.introspectionUri == "" {
+		err := h.validateKeys(ctx, diag)
+		if err != nil {
+			return diag, err
+		}
+	}
+
+	for _, issuer := range h.issuerHandlerOrder {
+		err := diag.run(fmt.Sprintf("issuer %s", issuer), func() error {
+			_, err := h.issuerHandlers[issuer].Validate(ctx, "")
+			return err
+		})
+		if err != nil {
+			return diag, err
+		}
+	}
+
+	if sampleToken == "" {
+		return diag, nil
+	}
+
+	err = diag.run("token", func() error {
+		_, err := h.ParseToken(ctx, sampleToken)
+		return err
+	})
+	if err != nil {
+		return diag, err
+	}
+
+	return diag, nil
+}
+
+// validateKeys runs the discovery and jwks steps of Validate.
+func (h *handler[T]) validateKeys(ctx context.Context, diag *Diagnostics) error {
+	if diag.JwksUri == "" && h.keySourceFunc == nil {
+		err := diag.run("discovery", func() error {
+			err := h.validateSameHostAsIssuer("discoveryUri", diag.DiscoveryUri)
+			if err != nil {
+				return err
+			}
+
+			data, err := h.getDiscoveryData(ctx, diag.DiscoveryUri)
+			if err != nil {
+				return fmt.Errorf("unable to fetch jwksUri from discoveryUri (%s): %w", diag.DiscoveryUri, err)
+			}
+
+			err = h.validateDiscoveryIssuer(data.Issuer)
+			if err != nil {
+				return err
+			}
+
+			diag.JwksUri = data.JwksUri
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return diag.run("jwks", func() error {
+		fetchCtx, cancel := context.WithTimeout(ctx, h.jwksFetchTimeout)
+		defer cancel()
+
+		if h.keySourceFunc != nil {
+			keySet, err := h.keySourceFunc(fetchCtx)
+			if err != nil {
+				return fmt.Errorf("unable to get keys from KeySourceFunc: %w", err)
+			}
+
+			if keySet == nil || keySet.Len() == 0 {
+				return fmt.Errorf("KeySourceFunc did not return any keys")
+			}
+
+			diag.KeyCount = keySet.Len()
+			return nil
+		}
+
+		err := h.validateSameHostAsIssuer("jwksUri", diag.JwksUri)
+		if err != nil {
+			return err
+		}
+
+		keySet, err := fetchKeySet(fetchCtx, h.jwksHttpClient, diag.JwksUri, h.jwksResponseExtractor)
+		if err != nil {
+			return fmt.Errorf("unable to fetch jwks (%s): %w", diag.JwksUri, err)
+		}
+
+		if keySet.Len() == 0 {
+			return fmt.Errorf("jwks (%s) does not contain any keys", diag.JwksUri)
+		}
+
+		diag.KeyCount = keySet.Len()
+		return nil
+	})
+}
@@ -0,0 +1,206 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/optest"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestValidate(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	unreachableServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableIssuer := unreachableServer.URL
+	unreachableServer.Close()
+
+	cases := []struct {
+		testDescription       string
+		issuer                string
+		sampleToken           string
+		expectedSteps         []string
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "reachable issuer without sample token",
+			issuer:          op.GetURL(t),
+			sampleToken:     "",
+			expectedSteps:   []string{"options", "discovery", "jwks"},
+		},
+		{
+			testDescription: "reachable issuer with valid sample token",
+			issuer:          op.GetURL(t),
+			sampleToken:     op.GetToken(t).AccessToken,
+			expectedSteps:   []string{"options", "discovery", "jwks", "token"},
+		},
+		{
+			testDescription:       "reachable issuer with invalid sample token",
+			issuer:                op.GetURL(t),
+			sampleToken:           "foobar",
+			expectedSteps:         []string{"options", "discovery", "jwks", "token"},
+			expectedErrorContains: "token: unable to parse tokenString",
+		},
+		{
+			testDescription:       "unreachable issuer",
+			issuer:                unreachableIssuer,
+			sampleToken:           op.GetToken(t).AccessToken,
+			expectedSteps:         []string{"options", "discovery"},
+			expectedErrorContains: "discovery: unable to fetch jwksUri from discoveryUri",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](nil, options.WithIssuer(c.issuer), options.WithLazyLoadJwks(true))
+		require.NoError(t, err)
+
+		diag, err := h.Validate(context.Background(), c.sampleToken)
+		require.NotNil(t, diag)
+		require.Equal(t, GetDiscoveryUriFromIssuer(c.issuer), diag.DiscoveryUri)
+
+		var steps []string
+		for _, step := range diag.Steps {
+			steps = append(steps, step.Name)
+		}
+		require.Equal(t, c.expectedSteps, steps)
+
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			require.Nil(t, diag.Failed())
+			require.NotEmpty(t, diag.JwksUri)
+			require.Equal(t, 1, diag.KeyCount)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+			require.NotNil(t, diag.Failed())
+			require.Equal(t, c.expectedSteps[len(c.expectedSteps)-1], diag.Failed().Name)
+		}
+	}
+}
+
+func TestValidateWithJwksUri(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+	)
+	require.NoError(t, err)
+
+	diag, err := h.Validate(context.Background(), testNewTokenString(t, privKeySet))
+	require.NoError(t, err)
+	require.Equal(t, testServer.URL, diag.JwksUri)
+	require.Len(t, diag.Steps, 3)
+	require.Equal(t, "options", diag.Steps[0].Name)
+	require.Equal(t, "jwks", diag.Steps[1].Name)
+	require.Equal(t, "token", diag.Steps[2].Name)
+}
+
+func TestValidateWithKeySourceFunc(t *testing.T) {
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri("http://foo.bar/jwks"),
+		options.WithKeySourceFunc(func(ctx context.Context) (jwk.Set, error) {
+			return pubKeySet, nil
+		}),
+	)
+	require.NoError(t, err)
+
+	diag, err := h.Validate(context.Background(), testNewTokenString(t, privKeySet))
+	require.NoError(t, err)
+	require.Equal(t, "", diag.JwksUri)
+	require.Equal(t, 1, diag.KeyCount)
+	require.Len(t, diag.Steps, 3)
+	require.Equal(t, "options", diag.Steps[0].Name)
+	require.Equal(t, "jwks", diag.Steps[1].Name)
+	require.Equal(t, "token", diag.Steps[2].Name)
+}
+
+func TestValidateWithInvalidOptions(t *testing.T) {
+	opts := options.New(
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithIntrospectionUri("http://foo.bar/introspect"),
+		options.WithIssuers(options.IssuerConfig{Issuer: "http://bar.baz"}),
+	)
+	h := newHandler[testClaims](nil, opts)
+
+	diag, err := h.Validate(context.Background(), "")
+	require.ErrorContains(t, err, "options: Issuers can't be used together with IntrospectionUri")
+	require.Len(t, diag.Steps, 1)
+	require.Equal(t, "options", diag.Failed().Name)
+}
+
+func TestValidateWithIntrospection(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := fmt.Fprintf(w, `{"active":true,"iss":"http://foo.bar","sub":"foo","exp":%d}`, time.Now().Add(time.Minute).Unix())
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithIntrospectionUri(testServer.URL),
+		options.WithRequiredAudience(""),
+	)
+	require.NoError(t, err)
+
+	diag, err := h.Validate(context.Background(), "foo")
+	require.NoError(t, err)
+	require.Len(t, diag.Steps, 2)
+	require.Equal(t, "options", diag.Steps[0].Name)
+	require.Equal(t, "token", diag.Steps[1].Name)
+}
+
+func TestValidateWithIssuers(t *testing.T) {
+	opFoo := optest.NewTesting(t)
+	defer opFoo.Close(t)
+
+	opBar := optest.NewTesting(t)
+	defer opBar.Close(t)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer(opFoo.GetURL(t)),
+		options.WithIssuers(options.IssuerConfig{Issuer: opBar.GetURL(t)}),
+		options.WithLazyLoadJwks(true),
+	)
+	require.NoError(t, err)
+
+	diag, err := h.Validate(context.Background(), opBar.GetToken(t).AccessToken)
+	require.NoError(t, err)
+
+	var steps []string
+	for _, step := range diag.Steps {
+		steps = append(steps, step.Name)
+	}
+	require.Equal(t, []string{"options", "discovery", "jwks", fmt.Sprintf("issuer %s", opBar.GetURL(t)), "token"}, steps)
+
+	opBar.Close(t)
+
+	diag, err = h.Validate(context.Background(), "")
+	require.ErrorContains(t, err, fmt.Sprintf("issuer %s: discovery: unable to fetch jwksUri from discoveryUri", opBar.GetURL(t)))
+	require.Equal(t, fmt.Sprintf("issuer %s", opBar.GetURL(t)), diag.Failed().Name)
+}
@@ -0,0 +1,55 @@
+package oidc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// setDiscoveryIssuer validates the issuer of the discovery document and requires it as the
+// issuer of the tokens, if UseDiscoveryIssuer is used.
+func (h *handler[T]) setDiscoveryIssuer(discoveryIssuer string) error {
+	err := h.validateDiscoveryIssuer(discoveryIssuer)
+	if err != nil || !h.useDiscoveryIssuer {
+		return err
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	h.discoveryIssuer = discoveryIssuer
+
+	return nil
+}
+
+// validateDiscoveryIssuer returns an error if UseDiscoveryIssuer is used and the issuer of the
+// discovery document isn't the configured issuer, ignoring a trailing slash.
+func (h *handler[T]) validateDiscoveryIssuer(discoveryIssuer string) error {
+	if !h.useDiscoveryIssuer {
+		return nil
+	}
+
+	issuer := h.getIssuer()
+	if discoveryIssuer == "" {
+		return fmt.Errorf("%w: the discovery document doesn't contain an issuer, required issuer %q", options.ErrDiscoveryIssuerMismatch, issuer)
+	}
+
+	if strings.TrimSuffix(discoveryIssuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return fmt.Errorf("%w: discovery issuer %q doesn't match the configured issuer %q", options.ErrDiscoveryIssuerMismatch, discoveryIssuer, issuer)
+	}
+
+	return nil
+}
+
+// getRequiredIssuer returns the issuer required in the tokens, which is the issuer of the
+// discovery document if UseDiscoveryIssuer is used and the discovery document has been fetched.
+func (h *handler[T]) getRequiredIssuer() string {
+	h.RLock()
+	defer h.RUnlock()
+
+	if h.discoveryIssuer != "" {
+		return h.discoveryIssuer
+	}
+
+	return h.issuer
+}
@@ -0,0 +1,171 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithUseDiscoveryIssuer(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	jwksServer := testNewJwksServer(t, keySets)
+	defer jwksServer.Close()
+
+	var mu sync.Mutex
+	discoveryIssuer := ""
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		data := map[string]string{
+			"jwks_uri": jwksServer.URL,
+		}
+		if discoveryIssuer != "" {
+			data["issuer"] = discoveryIssuer
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(data)
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	cases := []struct {
+		testDescription         string
+		issuer                  string
+		discoveryIssuer         string
+		useDiscoveryIssuer      bool
+		expectedNewErrorIs      error
+		expectedValidIssuers    []string
+		expectedRejectedIssuers []string
+	}{
+		{
+			testDescription:         "discovery issuer with trailing slash",
+			issuer:                  "http://foo.bar",
+			discoveryIssuer:         "http://foo.bar/",
+			useDiscoveryIssuer:      true,
+			expectedValidIssuers:    []string{"http://foo.bar/"},
+			expectedRejectedIssuers: []string{"http://foo.bar"},
+		},
+		{
+			testDescription:         "configured issuer with trailing slash",
+			issuer:                  "http://foo.bar/",
+			discoveryIssuer:         "http://foo.bar",
+			useDiscoveryIssuer:      true,
+			expectedValidIssuers:    []string{"http://foo.bar"},
+			expectedRejectedIssuers: []string{"http://foo.bar/"},
+		},
+		{
+			testDescription:         "identical issuers",
+			issuer:                  "http://foo.bar",
+			discoveryIssuer:         "http://foo.bar",
+			useDiscoveryIssuer:      true,
+			expectedValidIssuers:    []string{"http://foo.bar"},
+			expectedRejectedIssuers: []string{"http://foo.bar/"},
+		},
+		{
+			testDescription:    "discovery issuer mismatch",
+			issuer:             "http://foo.bar",
+			discoveryIssuer:    "http://bar.baz",
+			useDiscoveryIssuer: true,
+			expectedNewErrorIs: options.ErrDiscoveryIssuerMismatch,
+		},
+		{
+			testDescription:    "discovery issuer with another path",
+			issuer:             "http://foo.bar",
+			discoveryIssuer:    "http://foo.bar/baz",
+			useDiscoveryIssuer: true,
+			expectedNewErrorIs: options.ErrDiscoveryIssuerMismatch,
+		},
+		{
+			testDescription:    "discovery without issuer",
+			issuer:             "http://foo.bar",
+			discoveryIssuer:    "",
+			useDiscoveryIssuer: true,
+			expectedNewErrorIs: options.ErrDiscoveryIssuerMismatch,
+		},
+		{
+			testDescription:         "discovery issuer ignored without UseDiscoveryIssuer",
+			issuer:                  "http://foo.bar",
+			discoveryIssuer:         "http://bar.baz",
+			useDiscoveryIssuer:      false,
+			expectedValidIssuers:    []string{"http://foo.bar"},
+			expectedRejectedIssuers: []string{"http://foo.bar/", "http://bar.baz"},
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		mu.Lock()
+		discoveryIssuer = c.discoveryIssuer
+		mu.Unlock()
+
+		h, err := NewHandler[testClaims](
+			nil,
+			options.WithIssuer(c.issuer),
+			options.WithAllowInsecureIssuer(true),
+			options.WithDiscoveryUri(testServer.URL),
+			options.WithUseDiscoveryIssuer(c.useDiscoveryIssuer),
+		)
+		if c.expectedNewErrorIs != nil {
+			require.ErrorIs(t, err, c.expectedNewErrorIs)
+			require.NotErrorIs(t, err, options.ErrJwksUnavailable)
+			continue
+		}
+
+		require.NoError(t, err)
+
+		if c.useDiscoveryIssuer {
+			require.Equal(t, c.discoveryIssuer, h.Config().DiscoveryIssuer)
+		}
+
+		for _, issuer := range c.expectedValidIssuers {
+			_, err := h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"iss": issuer}))
+			require.NoError(t, err)
+		}
+
+		for _, issuer := range c.expectedRejectedIssuers {
+			_, err := h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"iss": issuer}))
+			require.ErrorContains(t, err, "required issuer")
+		}
+	}
+}
+
+func TestValidateWithUseDiscoveryIssuer(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"issuer":"http://bar.baz","jwks_uri":"http://bar.baz/jwks"}`))
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithDiscoveryUri(testServer.URL),
+		options.WithUseDiscoveryIssuer(true),
+		options.WithLazyLoadJwks(true),
+	)
+	require.NoError(t, err)
+
+	diag, err := h.Validate(context.Background(), "")
+	require.ErrorIs(t, err, options.ErrDiscoveryIssuerMismatch)
+	require.ErrorContains(t, err, "discovery issuer \"http://bar.baz\" doesn't match the configured issuer \"http://foo.bar\"")
+	require.Len(t, diag.Steps, 2)
+	require.Equal(t, "discovery", diag.Steps[1].Name)
+}
@@ -0,0 +1,42 @@
+//go:build jwx_es256k
+// +build jwx_es256k
+
+package oidc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/optest"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithES256K(t *testing.T) {
+	ctx := context.Background()
+
+	op := optest.NewTesting(t, optest.WithSigningAlgorithm(jwa.ES256K))
+	defer op.Close(t)
+
+	tokenString := op.GetToken(t).AccessToken
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithAllowES256K(true),
+	)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, tokenString)
+	require.NoError(t, err)
+
+	h, err = NewHandler[testClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+	)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, tokenString)
+	require.ErrorContains(t, err, "signature algorithm ES256K requires AllowES256K")
+}
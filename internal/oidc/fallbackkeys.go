@@ -0,0 +1,72 @@
+package oidc
+
+import (
+	"context"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// verifyWithFallbackKeys verifies the token using up to maxFallbackKeys other keys from the keySet,
+// after the signature couldn't be verified using the key matching the key id. Only keys with an
+// allowed key type and size and the same signature algorithm as the token are tried. verifyErr is
+// returned if none of the keys can verify the signature.
+func (h *handler[T]) verifyWithFallbackKeys(ctx context.Context, tokenString string, keySet jwk.Set, failedKey jwk.Key,
+	tokenAlgorithm jwa.SignatureAlgorithm, verifyErr error) (jwt.Token, jwk.Key, error) {
+	tried := 0
+	for i := 0; i < keySet.Len() && tried < h.maxFallbackKeys; i++ {
+		key, ok := keySet.Get(i)
+		if !ok || key == failedKey || h.isKeyIDBlocked(key.KeyID()) {
+			continue
+		}
+
+		if !isKeyTypeValid(h.allowedKeyTypes, key.KeyType()) || h.validateKeySize(key) != nil {
+			continue
+		}
+
+		alg, err := getSignatureAlgorithm(key.KeyType(), getKeyCurve(key), key.Algorithm(), h.fallbackSignatureAlgorithm, h.allowES256K)
+		if err != nil || alg != tokenAlgorithm || !isSignatureAlgorithmValid(h.allowedSignatureAlgorithms, alg) {
+			continue
+		}
+
+		tried++
+
+		verifyKey, err := h.getFallbackVerifyKey(key, failedKey.KeyID())
+		if err != nil {
+			continue
+		}
+
+		token, err := h.getAndVerifyTokenFromString(ctx, tokenString, verifyKey, alg)
+		if err != nil {
+			continue
+		}
+
+		h.logger.Debug("token signature verified using a fallback key", "kid", failedKey.KeyID(), "fallback_kid", key.KeyID())
+
+		return token, key, nil
+	}
+
+	return nil, nil, verifyErr
+}
+
+// getFallbackVerifyKey returns a copy of the key using the key id from the token header if the signature
+// is verified locally, since jws.Verify rejects keys with another key id. Verifiers get the key as is.
+func (h *handler[T]) getFallbackVerifyKey(key jwk.Key, keyID string) (jwk.Key, error) {
+	verifier, ok := h.verifiers[key.KeyType()]
+	if ok && verifier != nil {
+		return key, nil
+	}
+
+	verifyKey, err := key.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifyKey.Set(jwk.KeyIDKey, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyKey, nil
+}
@@ -0,0 +1,115 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithMaxFallbackKeys(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 3, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	oldPrivKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	newPrivKey, ok := privKeySet.Get(2)
+	require.True(t, ok)
+
+	unknownPrivKeySet, _ := testNewKeySet(t, 1, false)
+	unknownPrivKey, ok := unknownPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	// signed using the new key, but with the key id of the old key in the header
+	mismatchedToken := testNewTokenStringWithKey(t, testWithKeyID(t, newPrivKey, oldPrivKey.KeyID()), jwa.ES384, nil)
+	unknownToken := testNewTokenStringWithKey(t, testWithKeyID(t, unknownPrivKey, oldPrivKey.KeyID()), jwa.ES384, nil)
+
+	cases := []struct {
+		testDescription string
+		maxFallbackKeys int
+		tokenString     string
+		expectedErr     error
+	}{
+		{
+			testDescription: "fallback disabled",
+			maxFallbackKeys: 0,
+			tokenString:     mismatchedToken,
+			expectedErr:     options.ErrSignatureVerification,
+		},
+		{
+			testDescription: "signing key not reached",
+			maxFallbackKeys: 1,
+			tokenString:     mismatchedToken,
+			expectedErr:     options.ErrSignatureVerification,
+		},
+		{
+			testDescription: "signing key reached",
+			maxFallbackKeys: 2,
+			tokenString:     mismatchedToken,
+		},
+		{
+			testDescription: "signed by a key missing from the jwks",
+			maxFallbackKeys: 10,
+			tokenString:     unknownToken,
+			expectedErr:     options.ErrSignatureVerification,
+		},
+		{
+			testDescription: "key id matching the signing key",
+			maxFallbackKeys: 2,
+			tokenString:     testNewTokenStringWithKey(t, oldPrivKey, jwa.ES384, nil),
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		logger := &testLogger{}
+		h, err := NewHandler[testClaims](nil,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithMaxFallbackKeys(c.maxFallbackKeys),
+			options.WithLogger(logger),
+		)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(context.Background(), c.tokenString)
+		if c.expectedErr != nil {
+			require.ErrorIs(t, err, c.expectedErr)
+			require.Empty(t, logger.getEntries("token signature verified using a fallback key"))
+			continue
+		}
+
+		require.NoError(t, err)
+		if c.tokenString == mismatchedToken {
+			entries := logger.getEntries("token signature verified using a fallback key")
+			require.Len(t, entries, 1)
+			require.Equal(t, oldPrivKey.KeyID(), entries[0].keysAndValues["kid"])
+			require.Equal(t, newPrivKey.KeyID(), entries[0].keysAndValues["fallback_kid"])
+		}
+	}
+}
+
+func testWithKeyID(t *testing.T, key jwk.Key, keyID string) jwk.Key {
+	t.Helper()
+
+	var rawKey interface{}
+	err := key.Raw(&rawKey)
+	require.NoError(t, err)
+
+	keyWithKeyID, err := jwk.New(rawKey)
+	require.NoError(t, err)
+
+	err = keyWithKeyID.Set(jwk.KeyIDKey, keyID)
+	require.NoError(t, err)
+
+	return keyWithKeyID
+}
@@ -0,0 +1,35 @@
+package oidc
+
+import (
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// validateGroups validates that at least one of requiredGroupsAny and all of requiredGroupsAll
+// are present in the groups claim. An empty list isn't validated.
+func validateGroups(requiredGroupsAny []string, requiredGroupsAll []string, claimName string, claimNamespace string, token jwt.Token) error {
+	claimValue, ok := getNamespacedClaim(token, claimName, claimNamespace)
+	if !ok {
+		return fmt.Errorf("required groups were not found, token does not contain claim %q", claimName)
+	}
+
+	groups, err := getRolesFromClaimValue(claimValue, "")
+	if err != nil {
+		return fmt.Errorf("unable to get groups from claim %q: %w", claimName, err)
+	}
+
+	if len(requiredGroupsAny) > 0 {
+		missingGroups := GetMissingScopes(requiredGroupsAny, groups)
+		if len(missingGroups) == len(requiredGroupsAny) {
+			return fmt.Errorf("none of the required groups %v were found, received: %v", requiredGroupsAny, groups)
+		}
+	}
+
+	missingGroups := GetMissingScopes(requiredGroupsAll, groups)
+	if len(missingGroups) > 0 {
+		return fmt.Errorf("required groups %v were not found, received: %v", missingGroups, groups)
+	}
+
+	return nil
+}
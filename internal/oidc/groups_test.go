@@ -0,0 +1,180 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithRequiredGroups(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "any with intersection",
+			options: []options.Option{
+				options.WithRequiredGroupsAny([]string{"admins", "developers"}),
+			},
+			customClaims: map[string]interface{}{
+				"groups": []string{"users", "developers"},
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "any with full match",
+			options: []options.Option{
+				options.WithRequiredGroupsAny([]string{"admins", "developers"}),
+			},
+			customClaims: map[string]interface{}{
+				"groups": []string{"admins", "developers"},
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "any without overlap",
+			options: []options.Option{
+				options.WithRequiredGroupsAny([]string{"admins", "developers"}),
+			},
+			customClaims: map[string]interface{}{
+				"groups": []string{"users"},
+			},
+			expectedErrorContains: "none of the required groups [admins developers] were found, received: [users]",
+		},
+		{
+			testDescription: "all with full match",
+			options: []options.Option{
+				options.WithRequiredGroupsAll([]string{"admins", "developers"}),
+			},
+			customClaims: map[string]interface{}{
+				"groups": []string{"users", "admins", "developers"},
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "all with intersection",
+			options: []options.Option{
+				options.WithRequiredGroupsAll([]string{"admins", "developers"}),
+			},
+			customClaims: map[string]interface{}{
+				"groups": []string{"users", "developers"},
+			},
+			expectedErrorContains: "required groups [admins] were not found, received: [users developers]",
+		},
+		{
+			testDescription: "all without overlap",
+			options: []options.Option{
+				options.WithRequiredGroupsAll([]string{"admins", "developers"}),
+			},
+			customClaims: map[string]interface{}{
+				"groups": []string{"users"},
+			},
+			expectedErrorContains: "required groups [admins developers] were not found, received: [users]",
+		},
+		{
+			testDescription: "any and all both satisfied",
+			options: []options.Option{
+				options.WithRequiredGroupsAny([]string{"admins", "developers"}),
+				options.WithRequiredGroupsAll([]string{"users"}),
+			},
+			customClaims: map[string]interface{}{
+				"groups": []string{"users", "developers"},
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "any satisfied but all not",
+			options: []options.Option{
+				options.WithRequiredGroupsAny([]string{"admins", "developers"}),
+				options.WithRequiredGroupsAll([]string{"users"}),
+			},
+			customClaims: map[string]interface{}{
+				"groups": []string{"developers"},
+			},
+			expectedErrorContains: "required groups [users] were not found, received: [developers]",
+		},
+		{
+			testDescription: "custom claim name",
+			options: []options.Option{
+				options.WithRequiredGroupsAny([]string{"admins"}),
+				options.WithGroupsClaimName("cognito:groups"),
+			},
+			customClaims: map[string]interface{}{
+				"cognito:groups": []string{"admins"},
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "groups with claim namespace",
+			options: []options.Option{
+				options.WithRequiredGroupsAny([]string{"admins"}),
+				options.WithClaimNamespace("https://myapp.com/"),
+			},
+			customClaims: map[string]interface{}{
+				"https://myapp.com/groups": []string{"admins"},
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "missing groups claim",
+			options: []options.Option{
+				options.WithRequiredGroupsAny([]string{"admins"}),
+			},
+			customClaims:          nil,
+			expectedErrorContains: "token does not contain claim \"groups\"",
+		},
+		{
+			testDescription: "invalid groups claim",
+			options: []options.Option{
+				options.WithRequiredGroupsAny([]string{"admins"}),
+			},
+			customClaims: map[string]interface{}{
+				"groups": 1234,
+			},
+			expectedErrorContains: "unable to get groups from claim \"groups\"",
+		},
+		{
+			testDescription:       "no groups required",
+			options:               nil,
+			customClaims:          nil,
+			expectedErrorContains: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		opts := []options.Option{
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+		}
+
+		h, err := NewHandler[testClaims](nil, append(opts, c.options...)...)
+		require.NoError(t, err)
+
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims)
+
+		_, err = h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
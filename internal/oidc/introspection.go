@@ -0,0 +1,107 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xenitab/go-oidc-middleware/options"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// parseTokenWithIntrospection validates the token using the introspection endpoint (RFC 7662)
+// instead of the jwks, and validates the claims from the introspection response.
+func (h *handler[T]) parseTokenWithIntrospection(ctx context.Context, tokenString string, timings *options.Timings) (T, error) {
+	stepStart := time.Now()
+	token, err := h.introspectToken(ctx, tokenString)
+	timings.Introspection = time.Since(stepStart)
+	if err != nil {
+		return *new(T), err
+	}
+
+	stepStart = time.Now()
+	defer func() {
+		timings.ClaimsValidation = time.Since(stepStart)
+	}()
+
+	return h.validateToken(ctx, getTokenHash(tokenString), token)
+}
+
+type introspectionResponse struct {
+	Active bool `json:"active"`
+}
+
+// introspectToken posts the token to the introspection endpoint and returns the response as a jwt.Token
+// if the token is active. Errors calling the introspection endpoint wrap options.ErrJwksUnavailable.
+func (h *handler[T]) introspectToken(ctx context.Context, tokenString string) (jwt.Token, error) {
+	bodyBytes, err := h.getIntrospectionResponse(ctx, tokenString)
+	if err != nil {
+		return nil, fmt.Errorf("unable to introspect token using %q: %w", h.introspectionUri, &jwksUnavailableError{err})
+	}
+
+	var response introspectionResponse
+	err = json.Unmarshal(bodyBytes, &response)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal introspection response: %w", err)
+	}
+
+	if !response.Active {
+		return nil, options.ErrInactiveToken
+	}
+
+	token, err := parseTokenPayload(bodyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse introspection response: %w", err)
+	}
+
+	return token, nil
+}
+
+func (h *handler[T]) getIntrospectionResponse(ctx context.Context, tokenString string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.introspectionFetchTimeout)
+	defer cancel()
+
+	form := url.Values{}
+	form.Set("token", tokenString)
+	form.Set("token_type_hint", "access_token")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.introspectionUri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if h.introspectionClientID != "" {
+		// the client credentials are form encoded before being used for basic auth, see RFC 6749 section 2.3.1
+		req.SetBasicAuth(url.QueryEscape(h.introspectionClientID), url.QueryEscape(h.introspectionClientSecret))
+	}
+
+	res, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	return bodyBytes, nil
+}
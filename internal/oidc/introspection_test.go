@@ -0,0 +1,227 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithIntrospection(t *testing.T) {
+	activeResponse := map[string]interface{}{
+		"active":    true,
+		"iss":       "http://foo.bar",
+		"aud":       "https://api.foo.bar",
+		"sub":       "foo",
+		"scope":     "read write",
+		"client_id": "baz",
+		"exp":       time.Now().Add(time.Minute).Unix(),
+	}
+
+	responses := map[string]interface{}{
+		"active-token":   activeResponse,
+		"inactive-token": map[string]interface{}{"active": false},
+		"expired-token": testCopyMap(activeResponse, map[string]interface{}{
+			"exp": time.Now().Add(-time.Minute).Unix(),
+		}),
+		"foreign-issuer-token": testCopyMap(activeResponse, map[string]interface{}{
+			"iss": "http://bar.baz",
+		}),
+		"foreign-audience-token": testCopyMap(activeResponse, map[string]interface{}{
+			"aud": "https://api.bar.baz",
+		}),
+		"missing-scope-token": testCopyMap(activeResponse, map[string]interface{}{
+			"scope": "read",
+		}),
+		"mixed-audience-token": testCopyMap(activeResponse, map[string]interface{}{
+			"aud": []interface{}{123, "https://api.foo.bar"},
+		}),
+	}
+
+	var mu sync.Mutex
+	var requests []*http.Request
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		require.NoError(t, err)
+
+		mu.Lock()
+		requests = append(requests, r)
+		mu.Unlock()
+
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "client%3Aid" || clientSecret != "client+secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		response, ok := responses[r.PostForm.Get("token")]
+		if !ok {
+			response = map[string]interface{}{"active": false}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(response)
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	var timings options.Timings
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithIntrospectionUri(testServer.URL),
+		options.WithIntrospectionClientID("client:id"),
+		options.WithIntrospectionClientSecret("client secret"),
+		options.WithRequiredAudience("https://api.foo.bar"),
+		options.WithRequiredScopes([]string{"write"}),
+		options.WithTimingsFn(func(ctx context.Context, t options.Timings, err error) {
+			timings = t
+		}),
+	)
+	require.NoError(t, err)
+	require.False(t, h.Config().JwksLoaded)
+
+	cases := []struct {
+		testDescription       string
+		tokenString           string
+		expectedErrorIs       error
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "active token",
+			tokenString:     "active-token",
+		},
+		{
+			testDescription: "active token with mixed-type audience",
+			tokenString:     "mixed-audience-token",
+		},
+		{
+			testDescription: "inactive token",
+			tokenString:     "inactive-token",
+			expectedErrorIs: options.ErrInactiveToken,
+		},
+		{
+			testDescription: "unknown token",
+			tokenString:     "unknown-token",
+			expectedErrorIs: options.ErrInactiveToken,
+		},
+		{
+			testDescription:       "expired token",
+			tokenString:           "expired-token",
+			expectedErrorContains: "token has expired",
+		},
+		{
+			testDescription:       "foreign issuer",
+			tokenString:           "foreign-issuer-token",
+			expectedErrorContains: "required issuer \"http://foo.bar\" was not found",
+		},
+		{
+			testDescription:       "foreign audience",
+			tokenString:           "foreign-audience-token",
+			expectedErrorContains: "required audience \"https://api.foo.bar\" was not found",
+		},
+		{
+			testDescription:       "missing scope",
+			tokenString:           "missing-scope-token",
+			expectedErrorContains: "required scopes [write] were not found",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		claims, err := h.ParseToken(context.Background(), c.tokenString)
+		require.NotZero(t, timings.Introspection)
+		require.Zero(t, timings.SignatureVerification)
+
+		if c.expectedErrorIs == nil && c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			require.Equal(t, "foo", claims["sub"])
+			require.Equal(t, "baz", claims["client_id"])
+			continue
+		}
+
+		require.Error(t, err)
+		require.NotErrorIs(t, err, options.ErrJwksUnavailable)
+
+		if c.expectedErrorIs != nil {
+			require.ErrorIs(t, err, c.expectedErrorIs)
+		}
+
+		if c.expectedErrorContains != "" {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, requests, len(cases))
+	for _, r := range requests {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "access_token", r.PostForm.Get("token_type_hint"))
+		require.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+	}
+}
+
+func TestParseTokenWithIntrospectionUnavailable(t *testing.T) {
+	errorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer errorServer.Close()
+
+	unreachableServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableUrl := unreachableServer.URL
+	unreachableServer.Close()
+
+	cases := []struct {
+		testDescription       string
+		introspectionUri      string
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "error status code",
+			introspectionUri:      errorServer.URL,
+			expectedErrorContains: "unexpected status code: 500",
+		},
+		{
+			testDescription:       "unreachable",
+			introspectionUri:      unreachableUrl,
+			expectedErrorContains: "unable to introspect token",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](nil,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithIntrospectionUri(c.introspectionUri),
+		)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(context.Background(), "opaque-token")
+		require.ErrorIs(t, err, options.ErrJwksUnavailable)
+		require.ErrorContains(t, err, c.expectedErrorContains)
+	}
+}
+
+func testCopyMap(m map[string]interface{}, overrides map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+
+	for k, v := range overrides {
+		result[k] = v
+	}
+
+	return result
+}
@@ -0,0 +1,102 @@
+package oidc
+
+import (
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// setIssuerHandlers creates a handler for each of the additional issuers, using the same options
+// except for the issuer, discovery uri, jwks uri and pending jwks. Each handler loads its own jwks,
+// which makes the keys selected by (issuer, kid): two issuers can use the same key id, a token is
+// only verified using the keys of the issuer in its `iss` claim. The issuers have been validated
+// by validateOptions.
+func (h *handler[T]) setIssuerHandlers(setters []options.Option, issuers []options.IssuerConfig) error {
+	issuerHandlers := make(map[string]*handler[T])
+	for _, issuerConfig := range issuers {
+		issuerSetters := append(append([]options.Option(nil), setters...),
+			options.WithIssuer(issuerConfig.Issuer),
+			options.WithIssuerAliases(nil),
+			options.WithDiscoveryUri(issuerConfig.DiscoveryUri),
+			options.WithJwksUri(issuerConfig.JwksUri),
+			options.WithPendingJwks(nil),
+			options.WithIssuers(),
+		)
+
+		issuerHandler, err := NewHandler(h.claimsValidationFn, issuerSetters...)
+		if err != nil {
+			_ = closeIssuerHandlers(issuerHandlers)
+			return fmt.Errorf("unable to create handler for issuer %q: %w", issuerConfig.Issuer, err)
+		}
+
+		issuerHandlers[issuerConfig.Issuer] = issuerHandler
+		h.issuerHandlerOrder = append(h.issuerHandlerOrder, issuerConfig.Issuer)
+	}
+
+	h.issuerHandlers = issuerHandlers
+
+	return nil
+}
+
+// closeIssuerHandlers closes all the issuer handlers and returns the first error.
+func closeIssuerHandlers[T any](issuerHandlers map[string]*handler[T]) error {
+	var closeErr error
+	for issuer, issuerHandler := range issuerHandlers {
+		err := issuerHandler.Close()
+		if err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("unable to close handler for issuer %q: %w", issuer, err)
+		}
+	}
+
+	return closeErr
+}
+
+// getIssuerHandler returns the handler for the issuer of the token, which hasn't been verified yet,
+// together with the token string (decrypted if it was encrypted). Tokens from issuers that aren't
+// trusted are rejected before any key is looked up.
+func (h *handler[T]) getIssuerHandler(tokenString string) (*handler[T], string, error) {
+	if len(h.issuerHandlers) == 0 {
+		return h, tokenString, nil
+	}
+
+	if isEncryptedTokenString(tokenString) {
+		if h.decryptionKeys == nil {
+			return nil, "", fmt.Errorf("token is encrypted and no decryption keys are configured")
+		}
+
+		var err error
+		tokenString, err = decryptTokenString(tokenString, h.decryptionKeys)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	token, err := jwt.ParseString(tokenString)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to get issuer from token: %w", err)
+	}
+
+	issuerHandler, ok := h.issuerHandlers[token.Issuer()]
+	if ok {
+		return issuerHandler, tokenString, nil
+	}
+
+	if isTokenIssuerValid(h.getRequiredIssuer(), h.issuerAliases, token.Issuer()) {
+		return h, tokenString, nil
+	}
+
+	// with UseDiscoveryIssuer, the tokens can use the issuer of the discovery document, which is
+	// only known after the jwks of the issuer has been loaded. The issuers are tried in the
+	// configured order to always use the same handler if more than one of them matches.
+	for _, issuer := range h.issuerHandlerOrder {
+		issuerHandler := h.issuerHandlers[issuer]
+		if issuerHandler.getRequiredIssuer() == token.Issuer() {
+			return issuerHandler, tokenString, nil
+		}
+	}
+
+	err = fmt.Errorf("token issuer %q isn't one of the trusted issuers", token.Issuer())
+
+	return nil, "", &validationFailureError{options.IssuerValidationFailureReason, err}
+}
@@ -0,0 +1,279 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/optest"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithIssuers(t *testing.T) {
+	opFoo := optest.NewTesting(t)
+	defer opFoo.Close(t)
+
+	opBar := optest.NewTesting(t)
+	defer opBar.Close(t)
+
+	opUntrusted := optest.NewTesting(t)
+	defer opUntrusted.Close(t)
+
+	// signs tokens using its own keys, but with the issuer of opFoo
+	opImpersonating := optest.NewTesting(t, optest.WithIssuer(opFoo.GetURL(t)))
+	defer opImpersonating.Close(t)
+
+	handlerOptions := []struct {
+		testDescription string
+		options         []options.Option
+	}{
+		{
+			testDescription: "only Issuers",
+			options: []options.Option{
+				options.WithIssuers(
+					options.IssuerConfig{Issuer: opFoo.GetURL(t)},
+					options.IssuerConfig{Issuer: opBar.GetURL(t)},
+				),
+			},
+		},
+		{
+			testDescription: "Issuer and Issuers with JwksUri",
+			options: []options.Option{
+				options.WithIssuer(opFoo.GetURL(t)),
+				options.WithIssuers(options.IssuerConfig{Issuer: opBar.GetURL(t), JwksUri: fmt.Sprintf("%s/jwks", opBar.GetURL(t))}),
+			},
+		},
+	}
+
+	cases := []struct {
+		testDescription       string
+		tokenString           string
+		expectedIssuer        string
+		expectedErrorContains string
+		expectedReason        options.ValidationFailureReason
+	}{
+		{
+			testDescription: "token from the first issuer",
+			tokenString:     opFoo.GetToken(t).AccessToken,
+			expectedIssuer:  opFoo.GetURL(t),
+		},
+		{
+			testDescription: "token from the second issuer",
+			tokenString:     opBar.GetToken(t).AccessToken,
+			expectedIssuer:  opBar.GetURL(t),
+		},
+		{
+			testDescription:       "token from an untrusted issuer",
+			tokenString:           opUntrusted.GetToken(t).AccessToken,
+			expectedErrorContains: fmt.Sprintf("token issuer %q isn't one of the trusted issuers", opUntrusted.GetURL(t)),
+			expectedReason:        options.IssuerValidationFailureReason,
+		},
+		{
+			testDescription:       "token signed by a key from another issuer",
+			tokenString:           opImpersonating.GetToken(t).AccessToken,
+			expectedErrorContains: "unable to get public key",
+			expectedReason:        options.OtherValidationFailureReason,
+		},
+		{
+			testDescription:       "malformed token",
+			tokenString:           "foo",
+			expectedErrorContains: "unable to get issuer from token",
+			expectedReason:        options.OtherValidationFailureReason,
+		},
+	}
+
+	for _, ho := range handlerOptions {
+		h, err := NewHandler[testClaims](nil, ho.options...)
+		require.NoError(t, err)
+
+		for i, c := range cases {
+			t.Logf("Test iteration %d: %s - %s", i, ho.testDescription, c.testDescription)
+
+			claims, err := h.ParseToken(context.Background(), c.tokenString)
+			if c.expectedErrorContains != "" {
+				require.ErrorContains(t, err, c.expectedErrorContains)
+				require.Equal(t, c.expectedReason, GetValidationFailureReason(err))
+				continue
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, c.expectedIssuer, claims["iss"])
+		}
+
+		// the validation of the claims is updated for all issuers
+		h.SetClaimsValidationFn(func(claims *testClaims) error {
+			return fmt.Errorf("foobar")
+		})
+
+		_, err = h.ParseToken(context.Background(), opBar.GetToken(t).AccessToken)
+		require.ErrorContains(t, err, "foobar")
+	}
+}
+
+func TestParseTokenWithIssuersSharingKeyID(t *testing.T) {
+	privKeyA, pubKeyA := testNewKey(t)
+	privKeyB, pubKeyB := testNewKey(t)
+	pendingPrivKeyA, pendingPubKeyA := testNewKey(t)
+	for _, key := range []jwk.Key{privKeyA, pubKeyA, privKeyB, pubKeyB} {
+		require.NoError(t, key.Set(jwk.KeyIDKey, "shared"))
+	}
+	for _, key := range []jwk.Key{pendingPrivKeyA, pendingPubKeyA} {
+		require.NoError(t, key.Set(jwk.KeyIDKey, "pending"))
+	}
+
+	keySetsA := testNewTestKeySet(t)
+	keySetsA.setKeys(jwk.NewSet(), jwk.NewSet())
+	keySetsA.publicKeySet.Add(pubKeyA)
+	testServerA := testNewJwksServer(t, keySetsA)
+	defer testServerA.Close()
+
+	keySetsB := testNewTestKeySet(t)
+	keySetsB.setKeys(jwk.NewSet(), jwk.NewSet())
+	keySetsB.publicKeySet.Add(pubKeyB)
+	testServerB := testNewJwksServer(t, keySetsB)
+	defer testServerB.Close()
+
+	pendingJwksA := jwk.NewSet()
+	pendingJwksA.Add(pendingPubKeyA)
+
+	issuerA := "http://a.example.com"
+	issuerB := "http://b.example.com"
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer(issuerA),
+		options.WithJwksUri(testServerA.URL),
+		options.WithPendingJwks(pendingJwksA),
+		options.WithAllowInsecureIssuer(true),
+		options.WithIssuers(options.IssuerConfig{Issuer: issuerB, JwksUri: testServerB.URL}),
+	)
+	require.NoError(t, err)
+	defer h.Close()
+
+	cases := []struct {
+		testDescription string
+		issuer          string
+		privKey         jwk.Key
+		expectedErr     bool
+	}{
+		{
+			testDescription: "token from issuer a signed with the key of issuer a",
+			issuer:          issuerA,
+			privKey:         privKeyA,
+		},
+		{
+			testDescription: "token from issuer b signed with the key of issuer b",
+			issuer:          issuerB,
+			privKey:         privKeyB,
+		},
+		{
+			testDescription: "token from issuer a signed with the key of issuer b using the same key id",
+			issuer:          issuerA,
+			privKey:         privKeyB,
+			expectedErr:     true,
+		},
+		{
+			testDescription: "token from issuer b signed with the key of issuer a using the same key id",
+			issuer:          issuerB,
+			privKey:         privKeyA,
+			expectedErr:     true,
+		},
+		{
+			testDescription: "token from issuer a signed with the pending key of issuer a",
+			issuer:          issuerA,
+			privKey:         pendingPrivKeyA,
+		},
+		{
+			testDescription: "token from issuer b signed with the pending key of issuer a",
+			issuer:          issuerB,
+			privKey:         pendingPrivKeyA,
+			expectedErr:     true,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		tokenString := testNewTokenStringWithKey(t, c.privKey, jwa.ES384, map[string]interface{}{"iss": c.issuer})
+		claims, err := h.ParseToken(context.Background(), tokenString)
+		if c.expectedErr {
+			require.Error(t, err)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, c.issuer, claims["iss"])
+	}
+}
+
+func TestNewHandlerWithIssuers(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "two issuers",
+			options: []options.Option{
+				options.WithIssuers(options.IssuerConfig{Issuer: "https://foo.bar"}, options.IssuerConfig{Issuer: "https://bar.baz"}),
+			},
+		},
+		{
+			testDescription: "empty issuer",
+			options: []options.Option{
+				options.WithIssuer("https://foo.bar"),
+				options.WithIssuers(options.IssuerConfig{}),
+			},
+			expectedErrorContains: "Issuers[0]: issuer is empty",
+		},
+		{
+			testDescription: "duplicate issuer",
+			options: []options.Option{
+				options.WithIssuers(options.IssuerConfig{Issuer: "https://foo.bar"}, options.IssuerConfig{Issuer: "https://foo.bar"}),
+			},
+			expectedErrorContains: "Issuers[0]: issuer \"https://foo.bar\" is configured more than once",
+		},
+		{
+			testDescription: "insecure issuer",
+			options: []options.Option{
+				options.WithIssuers(options.IssuerConfig{Issuer: "https://foo.bar"}, options.IssuerConfig{Issuer: "http://bar.baz"}),
+			},
+			expectedErrorContains: "unable to create handler for issuer \"http://bar.baz\"",
+		},
+		{
+			testDescription: "introspection",
+			options: []options.Option{
+				options.WithIssuers(options.IssuerConfig{Issuer: "https://foo.bar"}, options.IssuerConfig{Issuer: "https://bar.baz"}),
+				options.WithIntrospectionUri("https://foo.bar/introspect"),
+			},
+			expectedErrorContains: "Issuers can't be used together with IntrospectionUri",
+		},
+		{
+			testDescription: "key source",
+			options: []options.Option{
+				options.WithIssuers(options.IssuerConfig{Issuer: "https://foo.bar"}, options.IssuerConfig{Issuer: "https://bar.baz"}),
+				options.WithKeySourceFunc(func(ctx context.Context) (jwk.Set, error) {
+					return jwk.NewSet(), nil
+				}),
+			},
+			expectedErrorContains: "Issuers can't be used together with KeySourceFunc",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](nil, append(c.options, options.WithLazyLoadJwks(true))...)
+		if c.expectedErrorContains != "" {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, "https://foo.bar", h.Config().Issuer)
+		require.Len(t, h.issuerHandlers, 1)
+		require.Contains(t, h.issuerHandlers, "https://bar.baz")
+	}
+}
@@ -0,0 +1,58 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwe"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// isEncryptedTokenString returns true if the token string is a JWE using the
+// compact serialization, which contains five segments instead of three.
+func isEncryptedTokenString(tokenString string) bool {
+	return strings.Count(tokenString, ".") == 4
+}
+
+// decryptTokenString decrypts a JWE and returns the inner token string. The key matching the
+// key id from the JWE header is used, or every key in the set if the header doesn't contain a key id.
+func decryptTokenString(tokenString string, keys jwk.Set) (string, error) {
+	msg, err := jwe.ParseString(tokenString)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse encrypted token: %w", err)
+	}
+
+	headers := msg.ProtectedHeaders()
+	alg := headers.Algorithm()
+	keyID := headers.KeyID()
+
+	if keyID != "" {
+		key, ok := keys.LookupKeyID(keyID)
+		if !ok {
+			return "", fmt.Errorf("unable to find decryption key with key id %q", keyID)
+		}
+
+		payload, err := jwe.Decrypt([]byte(tokenString), alg, key)
+		if err != nil {
+			return "", fmt.Errorf("unable to decrypt token: %w", err)
+		}
+
+		return string(payload), nil
+	}
+
+	iter := keys.Iterate(context.Background())
+	for iter.Next(context.Background()) {
+		key, ok := iter.Pair().Value.(jwk.Key)
+		if !ok {
+			continue
+		}
+
+		payload, err := jwe.Decrypt([]byte(tokenString), alg, key)
+		if err == nil {
+			return string(payload), nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to decrypt token with any of the decryption keys")
+}
@@ -0,0 +1,140 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwe"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestIsEncryptedTokenString(t *testing.T) {
+	require.False(t, isEncryptedTokenString("foo.bar.baz"))
+	require.True(t, isEncryptedTokenString("foo.bar.baz.qux.quux"))
+	require.True(t, isEncryptedTokenString("foo..baz.qux.quux"))
+	require.False(t, isEncryptedTokenString("foo"))
+}
+
+func TestParseTokenWithDecryptionKeys(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	decryptionKey, rawDecryptionKey := testNewDecryptionKey(t, "enc-1")
+	otherDecryptionKey, _ := testNewDecryptionKey(t, "enc-2")
+
+	decryptionKeys := jwk.NewSet()
+	decryptionKeys.Add(otherDecryptionKey)
+	decryptionKeys.Add(decryptionKey)
+
+	signedToken := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"iss": "http://foo.bar", "sub": "foo"})
+
+	encryptWithKey := func(key interface{}) string {
+		t.Helper()
+
+		pubKey := key
+		if jwkKey, ok := key.(jwk.Key); ok {
+			var err error
+			pubKey, err = jwkKey.PublicKey()
+			require.NoError(t, err)
+		}
+
+		encrypted, err := jwe.Encrypt([]byte(signedToken), jwa.RSA_OAEP_256, pubKey, jwa.A256GCM, jwa.NoCompress)
+		require.NoError(t, err)
+
+		return string(encrypted)
+	}
+
+	unknownKey, _ := testNewDecryptionKey(t, "enc-3")
+	_, rawUnknownKey := testNewDecryptionKey(t, "")
+
+	cases := []struct {
+		testDescription       string
+		decryptionKeys        jwk.Set
+		tokenString           string
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "signed token",
+			decryptionKeys:  decryptionKeys,
+			tokenString:     signedToken,
+		},
+		{
+			testDescription: "encrypted token with key id",
+			decryptionKeys:  decryptionKeys,
+			tokenString:     encryptWithKey(decryptionKey),
+		},
+		{
+			testDescription: "encrypted token without key id",
+			decryptionKeys:  decryptionKeys,
+			tokenString:     encryptWithKey(&rawDecryptionKey.PublicKey),
+		},
+		{
+			testDescription:       "encrypted token with unknown key id",
+			decryptionKeys:        decryptionKeys,
+			tokenString:           encryptWithKey(unknownKey),
+			expectedErrorContains: "unable to find decryption key with key id \"enc-3\"",
+		},
+		{
+			testDescription:       "encrypted token with unknown key without key id",
+			decryptionKeys:        decryptionKeys,
+			tokenString:           encryptWithKey(&rawUnknownKey.PublicKey),
+			expectedErrorContains: "unable to decrypt token with any of the decryption keys",
+		},
+		{
+			testDescription:       "encrypted token without decryption keys",
+			decryptionKeys:        nil,
+			tokenString:           encryptWithKey(decryptionKey),
+			expectedErrorContains: "token is encrypted and no decryption keys are configured",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](nil,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithDecryptionKeys(c.decryptionKeys),
+		)
+		require.NoError(t, err)
+
+		claims, err := h.ParseToken(context.Background(), c.tokenString)
+		if c.expectedErrorContains != "" {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, "foo", claims["sub"])
+	}
+}
+
+func testNewDecryptionKey(tb testing.TB, keyID string) (jwk.Key, *rsa.PrivateKey) {
+	tb.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(tb, err)
+
+	key, err := jwk.New(rsaKey)
+	require.NoError(tb, err)
+
+	if keyID != "" {
+		err = key.Set(jwk.KeyIDKey, keyID)
+		require.NoError(tb, err)
+	}
+
+	return key, rsaKey
+}
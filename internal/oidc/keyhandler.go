@@ -3,10 +3,13 @@ package oidc
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/xenitab/go-oidc-middleware/options"
+
 	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jwk"
 	"go.uber.org/ratelimit"
@@ -24,6 +27,13 @@ type keyHandler struct {
 	keyUpdateCount     int
 	keyUpdateLimiter   ratelimit.Limiter
 	httpClient         *http.Client
+	responseExtractor  options.JwksResponseExtractor
+	keySourceFunc      options.KeySourceFunc
+	pendingKeySet      jwk.Set
+	disableKeyUpdates  bool
+	cached             bool
+	metrics            options.Metrics
+	logger             options.Logger
 }
 
 type keyUpdate struct {
@@ -31,15 +41,33 @@ type keyUpdate struct {
 	err    error
 }
 
-func newKeyHandler(httpClient *http.Client, jwksUri string, fetchTimeout time.Duration, keyUpdateRPS uint, disableKeyID bool) (*keyHandler, error) {
+// keyHandlerConfig contains the options used by a keyHandler. The jwks is downloaded from jwksUri,
+// unless keySourceFunc is set.
+type keyHandlerConfig struct {
+	httpClient        *http.Client
+	jwksUri           string
+	fetchTimeout      time.Duration
+	keyUpdateRPS      uint
+	disableKeyID      bool
+	responseExtractor options.JwksResponseExtractor
+	keySourceFunc     options.KeySourceFunc
+	metrics           options.Metrics
+	logger            options.Logger
+}
+
+func newKeyHandler(cfg keyHandlerConfig) (*keyHandler, error) {
 	h := &keyHandler{
-		jwksURI:            jwksUri,
-		disableKeyID:       disableKeyID,
-		fetchTimeout:       fetchTimeout,
+		jwksURI:            cfg.jwksUri,
+		disableKeyID:       cfg.disableKeyID,
+		fetchTimeout:       cfg.fetchTimeout,
 		keyUpdateSemaphore: semaphore.NewWeighted(int64(1)),
 		keyUpdateChannel:   make(chan keyUpdate),
-		keyUpdateLimiter:   ratelimit.New(int(keyUpdateRPS)),
-		httpClient:         httpClient,
+		keyUpdateLimiter:   ratelimit.New(int(cfg.keyUpdateRPS)),
+		httpClient:         cfg.httpClient,
+		responseExtractor:  cfg.responseExtractor,
+		keySourceFunc:      cfg.keySourceFunc,
+		metrics:            cfg.metrics,
+		logger:             getLogger(cfg.logger),
 	}
 
 	ctx := context.Background()
@@ -55,9 +83,15 @@ func newKeyHandler(httpClient *http.Client, jwksUri string, fetchTimeout time.Du
 func (h *keyHandler) updateKeySet(ctx context.Context) (jwk.Set, error) {
 	ctx, cancel := context.WithTimeout(ctx, h.fetchTimeout)
 	defer cancel()
-	keySet, err := jwk.Fetch(ctx, h.jwksURI, jwk.WithHTTPClient(h.httpClient))
+	start := time.Now()
+	keySet, err := h.fetchKeySet(ctx)
+	if h.metrics != nil {
+		h.metrics.ObserveJwksFetch(time.Since(start), err)
+	}
+
 	if err != nil {
-		return nil, fmt.Errorf("unable to fetch keys from %q: %w", h.jwksURI, err)
+		h.logger.Debug("unable to fetch jwks", "jwks_uri", h.jwksURI, "error", err)
+		return nil, fmt.Errorf("unable to fetch keys from %q: %w", h.getKeySource(), &jwksUnavailableError{err})
 	}
 
 	if h.disableKeyID && keySet.Len() != 1 {
@@ -69,43 +103,148 @@ func (h *keyHandler) updateKeySet(ctx context.Context) (jwk.Set, error) {
 	h.keyUpdateCount++
 	h.Unlock()
 
+	h.logger.Debug("fetched jwks", "jwks_uri", h.jwksURI, "keys", keySet.Len(), "duration", time.Since(start))
+
 	return keySet, nil
 }
 
+// fetchKeySet returns the keys from the KeySourceFunc if it's configured, otherwise the jwks
+// is downloaded from the jwks uri.
+func (h *keyHandler) fetchKeySet(ctx context.Context) (jwk.Set, error) {
+	if h.keySourceFunc == nil {
+		return fetchKeySet(ctx, h.httpClient, h.jwksURI, h.responseExtractor)
+	}
+
+	keySet, err := h.keySourceFunc(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if keySet == nil {
+		return nil, fmt.Errorf("KeySourceFunc returned a nil key set")
+	}
+
+	return keySet, nil
+}
+
+// getKeySource returns a description of where the keys are fetched from, used in errors.
+func (h *keyHandler) getKeySource() string {
+	if h.keySourceFunc != nil {
+		return "KeySourceFunc"
+	}
+
+	return h.jwksURI
+}
+
+// fetchKeySet downloads and parses the jwks. If responseExtractor isn't nil, it is
+// used to unwrap the response body before it is parsed.
+func fetchKeySet(ctx context.Context, httpClient *http.Client, jwksUri string, responseExtractor options.JwksResponseExtractor) (jwk.Set, error) {
+	if responseExtractor == nil {
+		return jwk.Fetch(ctx, jwksUri, jwk.WithHTTPClient(httpClient))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksUri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks: status code %d", res.StatusCode)
+	}
+
+	keysBytes, err := responseExtractor(bodyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("jwks response extractor returned an error: %w", err)
+	}
+
+	return jwk.Parse(keysBytes)
+}
+
 // waitForUpdateKeySetSet handles concurrent requests to update the jwks as well as rate limiting.
 func (h *keyHandler) waitForUpdateKeySetAndGetKeySet(ctx context.Context) (jwk.Set, error) {
+	defer recordKeyRefresh(ctx, time.Now())
+
+	// a static jwks (JwksJSON) is never refreshed, a cached one only by the background refresh
+	if h.disableKeyUpdates {
+		return h.getKeySet(), nil
+	}
+
 	// ok will be false if there's already an update in progress.
 	ok := h.keyUpdateSemaphore.TryAcquire(1)
 	if ok {
 		defer h.keyUpdateSemaphore.Release(1)
-		_ = h.keyUpdateLimiter.Take()
-		keySet, err := h.updateKeySet(ctx)
-
-		result := keyUpdate{
-			keySet,
-			err,
-		}
-
-		// start go routine to handle all requests waiting for result.
-		go func(res keyUpdate) {
-			// for each request waiting for update, send result to them.
-			for {
-				select {
-				case h.keyUpdateChannel <- res:
-				default:
-					return
-				}
-			}
-		}(result)
-
-		return keySet, err
+		return h.rateLimitedUpdateKeySet(ctx)
 	}
 
 	// wait for the request that is updating keys and return the result from it
+	h.logger.Debug("waiting for jwks refresh in progress", "jwks_uri", h.jwksURI)
 	result := <-h.keyUpdateChannel
 	return result.keySet, result.err
 }
 
+// refreshKeySet updates the jwks, used by the background refresh. Nothing is done if an update
+// is already in progress, since the keys are about to be updated anyway.
+func (h *keyHandler) refreshKeySet(ctx context.Context) error {
+	if h.disableKeyUpdates {
+		return nil
+	}
+
+	ok := h.keyUpdateSemaphore.TryAcquire(1)
+	if !ok {
+		return nil
+	}
+
+	defer h.keyUpdateSemaphore.Release(1)
+	_, err := h.rateLimitedUpdateKeySet(ctx)
+
+	return err
+}
+
+// rateLimitedUpdateKeySet updates the jwks when the rate limit allows it and sends the result to
+// the requests waiting for the update. The keyUpdateSemaphore needs to be held by the caller.
+func (h *keyHandler) rateLimitedUpdateKeySet(ctx context.Context) (jwk.Set, error) {
+	waitStart := time.Now()
+	_ = h.keyUpdateLimiter.Take()
+	if wait := time.Since(waitStart); wait > time.Millisecond {
+		h.logger.Debug("jwks refresh was rate limited", "jwks_uri", h.jwksURI, "wait", wait)
+	}
+	keySet, err := h.updateKeySet(ctx)
+
+	result := keyUpdate{
+		keySet,
+		err,
+	}
+
+	// start go routine to handle all requests waiting for result.
+	go func(res keyUpdate) {
+		// for each request waiting for update, send result to them.
+		for {
+			select {
+			case h.keyUpdateChannel <- res:
+			default:
+				return
+			}
+		}
+	}(result)
+
+	return keySet, err
+}
+
 func (h *keyHandler) waitForUpdateKeySetAndGetKey(ctx context.Context) (jwk.Key, error) {
 	keySet, err := h.waitForUpdateKeySetAndGetKeySet(ctx)
 	if err != nil {
@@ -141,6 +280,16 @@ func (h *keyHandler) getKeyFromID(ctx context.Context, keyID string, tokenAlgori
 		return key, nil
 	}
 
+	// keys announced ahead of a rotation are used without refreshing the jwks
+	if h.pendingKeySet != nil {
+		key, err := findKey(h.pendingKeySet, keyID, tokenAlgorithm)
+		if err == nil {
+			return key, nil
+		}
+	}
+
+	h.logger.Debug("key id not found in jwks, refreshing jwks", "kid", keyID, "jwks_uri", h.jwksURI)
+
 	updatedKeySet, err := h.waitForUpdateKeySetAndGetKeySet(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("unable to update key set for key %q: %w", keyID, err)
@@ -169,7 +318,7 @@ func findKey(keySet jwk.Set, keyID string, tokenAlgorithm jwa.SignatureAlgorithm
 			return key, nil
 		}
 	}
-	return nil, fmt.Errorf("unable to find key %q", keyID)
+	return nil, fmt.Errorf("%w: unable to find key %q", options.ErrUnknownKeyID, keyID)
 }
 
 func (h *keyHandler) getKeyWithoutKeyID() (jwk.Key, error) {
@@ -3,7 +3,9 @@ package oidc
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/lestrrat-go/jwx/jwa"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"sync"
@@ -13,6 +15,7 @@ import (
 	"github.com/lestrrat-go/jwx/jwk"
 	"github.com/stretchr/testify/require"
 	"github.com/xenitab/go-oidc-middleware/optest"
+	"github.com/xenitab/go-oidc-middleware/options"
 )
 
 func TestNewKeyHandler(t *testing.T) {
@@ -21,10 +24,11 @@ func TestNewKeyHandler(t *testing.T) {
 	op := optest.NewTesting(t)
 	issuer := op.GetURL(t)
 	discoveryUri := GetDiscoveryUriFromIssuer(issuer)
-	jwksUri, err := getJwksUriFromDiscoveryUri(http.DefaultClient, discoveryUri, 10*time.Millisecond)
+	discovery, err := getDiscoveryData(context.Background(), http.DefaultClient, discoveryUri, 10*time.Millisecond)
 	require.NoError(t, err)
+	jwksUri := discovery.JwksUri
 
-	keyHandler, err := newKeyHandler(http.DefaultClient, jwksUri, 10*time.Millisecond, 100, false)
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: jwksUri, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100})
 	require.NoError(t, err)
 
 	keySet1 := keyHandler.getKeySet()
@@ -82,7 +86,7 @@ func TestNewKeyHandler(t *testing.T) {
 	require.NotEqual(t, key1, key2)
 
 	// Validate that error is returned when using fake jwks uri
-	_, err = newKeyHandler(http.DefaultClient, "http://foo.bar/baz", 10*time.Millisecond, 100, false)
+	_, err = newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: "http://foo.bar/baz", fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100})
 	require.Error(t, err)
 
 	// Validate that error is returned when keys are rotated,
@@ -106,11 +110,12 @@ func TestUpdate(t *testing.T) {
 	op := optest.NewTesting(t)
 	issuer := op.GetURL(t)
 	discoveryUri := GetDiscoveryUriFromIssuer(issuer)
-	jwksUri, err := getJwksUriFromDiscoveryUri(http.DefaultClient, discoveryUri, 10*time.Millisecond)
+	discovery, err := getDiscoveryData(context.Background(), http.DefaultClient, discoveryUri, 10*time.Millisecond)
 	require.NoError(t, err)
+	jwksUri := discovery.JwksUri
 
 	rateLimit := uint(10)
-	keyHandler, err := newKeyHandler(http.DefaultClient, jwksUri, 10*time.Millisecond, rateLimit, false)
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: jwksUri, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: rateLimit})
 	require.NoError(t, err)
 
 	require.Equal(t, 1, keyHandler.keyUpdateCount)
@@ -187,12 +192,12 @@ func TestNewKeyHandlerWithKeyIDDisabled(t *testing.T) {
 	testServer := testNewJwksServer(t, keySets)
 	defer testServer.Close()
 
-	_, err := newKeyHandler(http.DefaultClient, testServer.URL, 10*time.Millisecond, 100, disableKeyID)
+	_, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, disableKeyID: disableKeyID})
 	require.NoError(t, err)
 
 	keySets.setKeys(testNewKeySet(t, 2, disableKeyID))
 
-	_, err = newKeyHandler(http.DefaultClient, testServer.URL, 10*time.Millisecond, 100, disableKeyID)
+	_, err = newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, disableKeyID: disableKeyID})
 	require.Error(t, err)
 }
 
@@ -205,15 +210,243 @@ func TestNewKeyHandlerWithKeyIDEnabled(t *testing.T) {
 	testServer := testNewJwksServer(t, keySets)
 	defer testServer.Close()
 
-	_, err := newKeyHandler(http.DefaultClient, testServer.URL, 10*time.Millisecond, 100, disableKeyID)
+	_, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, disableKeyID: disableKeyID})
 	require.NoError(t, err)
 
 	keySets.setKeys(testNewKeySet(t, 2, disableKeyID))
 
-	_, err = newKeyHandler(http.DefaultClient, testServer.URL, 10*time.Millisecond, 100, disableKeyID)
+	_, err = newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, disableKeyID: disableKeyID})
 	require.NoError(t, err)
 }
 
+func TestNewKeyHandlerWithJwksResponseExtractor(t *testing.T) {
+	_, pubKeySet := testNewKeySet(t, 1, false)
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": pubKeySet,
+		})
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	extractor := func(body []byte) ([]byte, error) {
+		var envelope struct {
+			Data json.RawMessage `json:"data"`
+		}
+
+		err := json.Unmarshal(body, &envelope)
+		if err != nil {
+			return nil, err
+		}
+
+		return envelope.Data, nil
+	}
+
+	_, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 100 * time.Millisecond, keyUpdateRPS: 100})
+	require.Error(t, err)
+
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 100 * time.Millisecond, keyUpdateRPS: 100, responseExtractor: extractor})
+	require.NoError(t, err)
+	require.Equal(t, 1, keyHandler.getKeySet().Len())
+
+	expectedKey, ok := pubKeySet.Get(0)
+	require.True(t, ok)
+
+	key, err := keyHandler.getKey(context.Background(), expectedKey.KeyID(), jwa.ES384)
+	require.NoError(t, err)
+	require.Equal(t, expectedKey, key)
+
+	failingExtractor := func(body []byte) ([]byte, error) {
+		return nil, fmt.Errorf("foobar")
+	}
+
+	_, err = newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 100 * time.Millisecond, keyUpdateRPS: 100, responseExtractor: failingExtractor})
+	require.ErrorContains(t, err, "jwks response extractor returned an error: foobar")
+}
+
+func TestNewKeyHandlerWithKeySourceFunc(t *testing.T) {
+	ctx := context.Background()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	rotatedPrivKeySet, rotatedPubKeySet := testNewKeySet(t, 1, false)
+
+	var mu sync.Mutex
+	calls := 0
+	keySource := func(ctx context.Context) (jwk.Set, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		if calls == 1 {
+			return pubKeySet, nil
+		}
+
+		return rotatedPubKeySet, nil
+	}
+
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, keySourceFunc: keySource})
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	_, err = keyHandler.getKey(ctx, privKey.KeyID(), jwa.ES384)
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+
+	// unknown key ids refreshes the keys using the key source
+	rotatedPrivKey, ok := rotatedPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	_, err = keyHandler.getKey(ctx, rotatedPrivKey.KeyID(), jwa.ES384)
+	require.NoError(t, err)
+	require.Equal(t, 2, calls)
+
+	failingKeySource := func(ctx context.Context) (jwk.Set, error) {
+		return nil, fmt.Errorf("foobar")
+	}
+
+	_, err = newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, keySourceFunc: failingKeySource})
+	require.ErrorContains(t, err, "unable to fetch keys from \"KeySourceFunc\": foobar")
+	require.ErrorIs(t, err, options.ErrJwksUnavailable)
+
+	nilKeySource := func(ctx context.Context) (jwk.Set, error) {
+		return nil, nil
+	}
+
+	_, err = newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, keySourceFunc: nilKeySource})
+	require.ErrorContains(t, err, "KeySourceFunc returned a nil key set")
+}
+
+func TestParseTokenWithKeySourceFunc(t *testing.T) {
+	privKeySet, pubKeySet := testNewKeySet(t, 2, false)
+
+	// the keys are served as individual PEM files listed in a manifest, without key ids
+	pemFiles := make(map[string][]byte)
+	for i := 0; i < pubKeySet.Len(); i++ {
+		pubKey, ok := pubKeySet.Get(i)
+		require.True(t, ok)
+
+		pemBytes, err := jwk.Pem(pubKey)
+		require.NoError(t, err)
+
+		pemFiles[pubKey.KeyID()] = pemBytes
+	}
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/manifest.json" {
+			manifest := make(map[string]string)
+			for keyID := range pemFiles {
+				manifest[keyID] = fmt.Sprintf("/keys/%s.pem", keyID)
+			}
+
+			err := json.NewEncoder(w).Encode(manifest)
+			require.NoError(t, err)
+			return
+		}
+
+		for keyID, pemBytes := range pemFiles {
+			if r.URL.Path == fmt.Sprintf("/keys/%s.pem", keyID) {
+				_, err := w.Write(pemBytes)
+				require.NoError(t, err)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer testServer.Close()
+
+	keySource := func(ctx context.Context) (jwk.Set, error) {
+		manifest := make(map[string]string)
+		err := testGetJson(ctx, testServer.URL+"/manifest.json", &manifest)
+		if err != nil {
+			return nil, err
+		}
+
+		keySet := jwk.NewSet()
+		for keyID, path := range manifest {
+			pemBytes, err := testGet(ctx, testServer.URL+path)
+			if err != nil {
+				return nil, err
+			}
+
+			key, err := jwk.ParseKey(pemBytes, jwk.WithPEM(true))
+			if err != nil {
+				return nil, err
+			}
+
+			err = key.Set(jwk.KeyIDKey, keyID)
+			if err != nil {
+				return nil, err
+			}
+
+			err = key.Set(jwk.AlgorithmKey, jwa.ES384)
+			if err != nil {
+				return nil, err
+			}
+
+			keySet.Add(key)
+		}
+
+		return keySet, nil
+	}
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithKeySourceFunc(keySource),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "", h.Config().JwksUri)
+
+	for i := 0; i < privKeySet.Len(); i++ {
+		privKey, ok := privKeySet.Get(i)
+		require.True(t, ok)
+
+		_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, nil))
+		require.NoError(t, err)
+	}
+
+	unknownPrivKeySet, _ := testNewKeySet(t, 1, false)
+	unknownPrivKey, ok := unknownPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, unknownPrivKey, jwa.ES384, nil))
+	require.Error(t, err)
+}
+
+func testGet(ctx context.Context, uri string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %s", res.StatusCode, uri)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+func testGetJson(ctx context.Context, uri string, v interface{}) error {
+	body, err := testGet(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
+
 func TestUpdateKeySetWithKeyIDDisabled(t *testing.T) {
 	ctx := context.Background()
 
@@ -225,7 +458,7 @@ func TestUpdateKeySetWithKeyIDDisabled(t *testing.T) {
 	testServer := testNewJwksServer(t, keySets)
 	defer testServer.Close()
 
-	keyHandler, err := newKeyHandler(http.DefaultClient, testServer.URL, 10*time.Millisecond, 100, disableKeyID)
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, disableKeyID: disableKeyID})
 	require.NoError(t, err)
 
 	_, err = keyHandler.updateKeySet(ctx)
@@ -248,7 +481,7 @@ func TestUpdateKeySetWithKeyIDEnabled(t *testing.T) {
 	testServer := testNewJwksServer(t, keySets)
 	defer testServer.Close()
 
-	keyHandler, err := newKeyHandler(http.DefaultClient, testServer.URL, 100*time.Millisecond, 100, disableKeyID)
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 100 * time.Millisecond, keyUpdateRPS: 100, disableKeyID: disableKeyID})
 	require.NoError(t, err)
 
 	_, err = keyHandler.updateKeySet(ctx)
@@ -271,7 +504,7 @@ func TestWaitForUpdateKeySetWithKeyIDDisabled(t *testing.T) {
 	testServer := testNewJwksServer(t, keySets)
 	defer testServer.Close()
 
-	keyHandler, err := newKeyHandler(http.DefaultClient, testServer.URL, 10*time.Millisecond, 100, disableKeyID)
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, disableKeyID: disableKeyID})
 	require.NoError(t, err)
 
 	_, err = keyHandler.waitForUpdateKeySetAndGetKey(ctx)
@@ -294,7 +527,7 @@ func TestWaitForUpdateKeySetWithKeyIDEnabled(t *testing.T) {
 	testServer := testNewJwksServer(t, keySets)
 	defer testServer.Close()
 
-	keyHandler, err := newKeyHandler(http.DefaultClient, testServer.URL, 10*time.Millisecond, 100, disableKeyID)
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, disableKeyID: disableKeyID})
 	require.NoError(t, err)
 
 	_, err = keyHandler.waitForUpdateKeySetAndGetKey(ctx)
@@ -315,7 +548,7 @@ func TestKeySetWithDuplicateKeyID(t *testing.T) {
 	testServer := testNewJwksServer(t, keySets)
 	defer testServer.Close()
 
-	keyHandler, err := newKeyHandler(http.DefaultClient, testServer.URL, 100*time.Millisecond, 100, false)
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 100 * time.Millisecond, keyUpdateRPS: 100})
 	require.NoError(t, err)
 
 	genKey, _ := keySets.publicKeySet.Get(0)
@@ -0,0 +1,47 @@
+package oidc
+
+import (
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// validateKeySize returns an error wrapping options.ErrRSAKeyTooSmall if the key is an RSA key
+// smaller than MinRSAKeyBits. Keys of other types are always valid.
+func (h *handler[T]) validateKeySize(key jwk.Key) error {
+	if h.minRSAKeyBits <= 0 || key.KeyType() != jwa.RSA {
+		return nil
+	}
+
+	keyBits, err := getRSAKeyBits(key)
+	if err != nil {
+		return err
+	}
+
+	if keyBits < h.minRSAKeyBits {
+		err = fmt.Errorf("%w: key %q is %d bits, at least %d bits are required", options.ErrRSAKeyTooSmall, key.KeyID(), keyBits, h.minRSAKeyBits)
+		return &validationFailureError{options.SignatureValidationFailureReason, err}
+	}
+
+	return nil
+}
+
+func getRSAKeyBits(key jwk.Key) (int, error) {
+	var rawKey interface{}
+	err := key.Raw(&rawKey)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get raw rsa key: %w", err)
+	}
+
+	switch rawKey := rawKey.(type) {
+	case *rsa.PublicKey:
+		return rawKey.N.BitLen(), nil
+	case *rsa.PrivateKey:
+		return rawKey.N.BitLen(), nil
+	default:
+		return 0, fmt.Errorf("unexpected raw rsa key type %T", rawKey)
+	}
+}
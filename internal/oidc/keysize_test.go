@@ -0,0 +1,129 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithMinRSAKeyBits(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	weakPrivKey, weakPubKey := testNewRSAKey(t, "weak", 1024)
+	strongPrivKey, strongPubKey := testNewRSAKey(t, "strong", 2048)
+	ecPrivKeySet, ecPubKeySet := testNewKeySet(t, 1, false)
+
+	ecPrivKey, ok := ecPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	ecPubKey, ok := ecPubKeySet.Get(0)
+	require.True(t, ok)
+
+	privKeySet := jwk.NewSet()
+	privKeySet.Add(weakPrivKey)
+	privKeySet.Add(strongPrivKey)
+	privKeySet.Add(ecPrivKey)
+
+	pubKeySet := jwk.NewSet()
+	pubKeySet.Add(weakPubKey)
+	pubKeySet.Add(strongPubKey)
+	pubKeySet.Add(ecPubKey)
+
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	cases := []struct {
+		testDescription string
+		minRSAKeyBits   int
+		tokenString     string
+		expectedErr     error
+	}{
+		{
+			testDescription: "1024 bit key without MinRSAKeyBits",
+			tokenString:     testNewTokenStringWithKey(t, weakPrivKey, jwa.RS256, nil),
+		},
+		{
+			testDescription: "1024 bit key",
+			minRSAKeyBits:   2048,
+			tokenString:     testNewTokenStringWithKey(t, weakPrivKey, jwa.RS256, nil),
+			expectedErr:     options.ErrRSAKeyTooSmall,
+		},
+		{
+			testDescription: "2048 bit key",
+			minRSAKeyBits:   2048,
+			tokenString:     testNewTokenStringWithKey(t, strongPrivKey, jwa.RS256, nil),
+		},
+		{
+			testDescription: "2048 bit key with a larger MinRSAKeyBits",
+			minRSAKeyBits:   4096,
+			tokenString:     testNewTokenStringWithKey(t, strongPrivKey, jwa.RS256, nil),
+			expectedErr:     options.ErrRSAKeyTooSmall,
+		},
+		{
+			testDescription: "ec key",
+			minRSAKeyBits:   4096,
+			tokenString:     testNewTokenStringWithKey(t, ecPrivKey, jwa.ES384, nil),
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](nil,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithMinRSAKeyBits(c.minRSAKeyBits),
+		)
+		require.NoError(t, err)
+		require.Equal(t, c.minRSAKeyBits, h.Config().MinRSAKeyBits)
+
+		_, err = h.ParseToken(context.Background(), c.tokenString)
+		if c.expectedErr == nil {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorIs(t, err, c.expectedErr)
+		require.Equal(t, options.SignatureValidationFailureReason, GetValidationFailureReason(err))
+	}
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithMinRSAKeyBits(2048),
+	)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, weakPrivKey, jwa.RS256, nil))
+	require.ErrorContains(t, err, "rsa key too small: key \"weak\" is 1024 bits, at least 2048 bits are required")
+}
+
+func testNewRSAKey(t *testing.T, keyID string, bits int) (jwk.Key, jwk.Key) {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+
+	privKey, err := jwk.New(rsaKey)
+	require.NoError(t, err)
+
+	err = privKey.Set(jwk.KeyIDKey, keyID)
+	require.NoError(t, err)
+
+	pubKey, err := jwk.New(rsaKey.PublicKey)
+	require.NoError(t, err)
+
+	err = pubKey.Set(jwk.KeyIDKey, keyID)
+	require.NoError(t, err)
+
+	return privKey, pubKey
+}
@@ -0,0 +1,28 @@
+package oidc
+
+import (
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// noopLogger is used if no Logger is configured.
+type noopLogger struct{}
+
+func (noopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+
+// getLogger returns the logger, or a noopLogger if it's nil.
+func getLogger(logger options.Logger) options.Logger {
+	if logger == nil {
+		return noopLogger{}
+	}
+
+	return logger
+}
+
+// logValidation logs the reason and the error if the token validation failed.
+func logValidation(logger options.Logger, err error) {
+	if err == nil {
+		return
+	}
+
+	logger.Debug("token validation failed", "reason", GetValidationFailureReason(err), "error", err)
+}
@@ -0,0 +1,129 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+type testLogEntry struct {
+	msg           string
+	keysAndValues map[string]interface{}
+}
+
+type testLogger struct {
+	sync.Mutex
+	entries []testLogEntry
+}
+
+func (l *testLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+
+	entry := testLogEntry{
+		msg:           msg,
+		keysAndValues: make(map[string]interface{}),
+	}
+
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		entry.keysAndValues[fmt.Sprint(keysAndValues[i])] = keysAndValues[i+1]
+	}
+
+	l.entries = append(l.entries, entry)
+}
+
+func (l *testLogger) getEntries(msg string) []testLogEntry {
+	l.Lock()
+	defer l.Unlock()
+
+	var entries []testLogEntry
+	for _, entry := range l.entries {
+		if entry.msg == msg {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+func TestParseTokenWithLogger(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	logger := &testLogger{}
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+		options.WithLogger(logger),
+	)
+	require.NoError(t, err)
+
+	fetched := logger.getEntries("fetched jwks")
+	require.Len(t, fetched, 1)
+	require.Equal(t, testServer.URL, fetched[0].keysAndValues["jwks_uri"])
+	require.Equal(t, 1, fetched[0].keysAndValues["keys"])
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, nil))
+	require.NoError(t, err)
+	require.Empty(t, logger.getEntries("token validation failed"))
+
+	// a token signed by a key missing from the jwks makes the handler refresh the jwks
+	rotatedPrivKeySet, _ := testNewKeySet(t, 1, false)
+	rotatedPrivKey, ok := rotatedPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, rotatedPrivKey, jwa.ES384, nil))
+	require.Error(t, err)
+
+	missed := logger.getEntries("key id not found in jwks, refreshing jwks")
+	require.Len(t, missed, 1)
+	require.Equal(t, rotatedPrivKey.KeyID(), missed[0].keysAndValues["kid"])
+	require.Len(t, logger.getEntries("fetched jwks"), 2)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"iss": "http://bar.baz"}))
+	require.Error(t, err)
+
+	failures := logger.getEntries("token validation failed")
+	require.Len(t, failures, 2)
+	require.Equal(t, options.OtherValidationFailureReason, failures[0].keysAndValues["reason"])
+	require.Equal(t, options.IssuerValidationFailureReason, failures[1].keysAndValues["reason"])
+	require.EqualError(t, failures[1].keysAndValues["error"].(error), err.Error())
+
+	// the jwks server is unavailable
+	testServer.Close()
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, rotatedPrivKey, jwa.ES384, nil))
+	require.ErrorIs(t, err, options.ErrJwksUnavailable)
+
+	fetchFailures := logger.getEntries("unable to fetch jwks")
+	require.Len(t, fetchFailures, 1)
+	require.NotNil(t, fetchFailures[0].keysAndValues["error"])
+
+	failures = logger.getEntries("token validation failed")
+	require.Len(t, failures, 3)
+	require.Equal(t, options.JwksUnavailableValidationFailureReason, failures[2].keysAndValues["reason"])
+}
+
+func TestGetLogger(t *testing.T) {
+	require.Equal(t, noopLogger{}, getLogger(nil))
+
+	logger := &testLogger{}
+	require.Equal(t, logger, getLogger(logger))
+
+	// the noopLogger can be called without configuring a logger
+	logValidation(getLogger(nil), fmt.Errorf("foo"))
+}
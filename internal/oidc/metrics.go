@@ -0,0 +1,54 @@
+package oidc
+
+import (
+	"errors"
+
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// validationFailureError wraps errors from the token validation with the reason
+// reported to Metrics, without changing the error message.
+type validationFailureError struct {
+	reason options.ValidationFailureReason
+	err    error
+}
+
+func (e *validationFailureError) Error() string {
+	return e.err.Error()
+}
+
+func (e *validationFailureError) Unwrap() error {
+	return e.err
+}
+
+// GetValidationFailureReason returns the reason reported to Metrics for an error returned by ParseToken.
+func GetValidationFailureReason(err error) options.ValidationFailureReason {
+	var validationErr *validationFailureError
+	if errors.As(err, &validationErr) {
+		return validationErr.reason
+	}
+
+	if errors.Is(err, options.ErrSignatureVerification) {
+		return options.SignatureValidationFailureReason
+	}
+
+	if errors.Is(err, options.ErrJwksUnavailable) {
+		return options.JwksUnavailableValidationFailureReason
+	}
+
+	return options.OtherValidationFailureReason
+}
+
+// observeValidation reports the outcome of a token validation to metrics, if it isn't nil.
+func observeValidation(metrics options.Metrics, err error) {
+	if metrics == nil {
+		return
+	}
+
+	if err == nil {
+		metrics.ObserveValidation(true, "")
+		return
+	}
+
+	metrics.ObserveValidation(false, GetValidationFailureReason(err))
+}
@@ -0,0 +1,171 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+type testMetrics struct {
+	sync.Mutex
+	validations     int
+	failures        map[options.ValidationFailureReason]int
+	jwksFetches     int
+	jwksFetchErrors int
+}
+
+func (m *testMetrics) ObserveValidation(ok bool, reason options.ValidationFailureReason) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.validations++
+	if !ok {
+		m.failures[reason]++
+	}
+}
+
+func (m *testMetrics) ObserveJwksFetch(duration time.Duration, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.jwksFetches++
+	if err != nil {
+		m.jwksFetchErrors++
+	}
+}
+
+func TestParseTokenWithMetrics(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	metrics := &testMetrics{failures: make(map[options.ValidationFailureReason]int)}
+	h, err := NewHandler[testClaims](func(claims *testClaims) error {
+		if (*claims)["foo"] != "bar" {
+			return fmt.Errorf("foo isn't bar")
+		}
+
+		return nil
+	},
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithRequiredAudience("baz"),
+		options.WithMetrics(metrics),
+	)
+	require.NoError(t, err)
+
+	metrics.Lock()
+	require.Equal(t, 1, metrics.jwksFetches)
+	metrics.Unlock()
+
+	validToken := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"aud": "baz", "foo": "bar"})
+	tokenParts := strings.Split(validToken, ".")
+	tamperedPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"http://foo.bar","aud":"baz","foo":"bar"}`))
+
+	cases := []struct {
+		testDescription string
+		tokenString     string
+		expectedReason  options.ValidationFailureReason
+	}{
+		{
+			testDescription: "valid token",
+			tokenString:     validToken,
+			expectedReason:  "",
+		},
+		{
+			testDescription: "expired token",
+			tokenString:     testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"aud": "baz", "foo": "bar", "exp": time.Now().Add(-time.Hour).Unix()}),
+			expectedReason:  options.ExpiredValidationFailureReason,
+		},
+		{
+			testDescription: "bad signature",
+			tokenString:     fmt.Sprintf("%s.%s.%s", tokenParts[0], tamperedPayload, tokenParts[2]),
+			expectedReason:  options.SignatureValidationFailureReason,
+		},
+		{
+			testDescription: "wrong issuer",
+			tokenString:     testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"iss": "http://bar.baz", "aud": "baz", "foo": "bar"}),
+			expectedReason:  options.IssuerValidationFailureReason,
+		},
+		{
+			testDescription: "wrong audience",
+			tokenString:     testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"aud": "qux", "foo": "bar"}),
+			expectedReason:  options.AudienceValidationFailureReason,
+		},
+		{
+			testDescription: "missing claim",
+			tokenString:     testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"aud": "baz"}),
+			expectedReason:  options.ClaimsValidationFailureReason,
+		},
+		{
+			testDescription: "malformed token",
+			tokenString:     "foo",
+			expectedReason:  options.OtherValidationFailureReason,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		_, err := h.ParseToken(context.Background(), c.tokenString)
+		if c.expectedReason == "" {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+		}
+
+		require.Equal(t, c.expectedReason, testGetValidationFailureReason(err))
+	}
+
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	require.Equal(t, len(cases), metrics.validations)
+	for _, c := range cases[1:] {
+		require.Equal(t, 1, metrics.failures[c.expectedReason], c.testDescription)
+	}
+}
+
+func TestGetValidationFailureReason(t *testing.T) {
+	require.Equal(t, options.JwksUnavailableValidationFailureReason, GetValidationFailureReason(fmt.Errorf("foo: %w", &jwksUnavailableError{fmt.Errorf("bar")})))
+	require.Equal(t, options.SignatureValidationFailureReason, GetValidationFailureReason(fmt.Errorf("foo: %w", options.ErrSignatureVerification)))
+	require.Equal(t, options.AudienceValidationFailureReason, GetValidationFailureReason(fmt.Errorf("foo: %w", &validationFailureError{options.AudienceValidationFailureReason, fmt.Errorf("bar")})))
+	require.Equal(t, options.OtherValidationFailureReason, GetValidationFailureReason(fmt.Errorf("foo")))
+}
+
+func TestNewKeyHandlerWithMetrics(t *testing.T) {
+	metrics := &testMetrics{failures: make(map[options.ValidationFailureReason]int)}
+
+	_, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: "http://foo.bar/baz", fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, metrics: metrics})
+	require.Error(t, err)
+
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	require.Equal(t, 1, metrics.jwksFetches)
+	require.Equal(t, 1, metrics.jwksFetchErrors)
+}
+
+func testGetValidationFailureReason(err error) options.ValidationFailureReason {
+	if err == nil {
+		return ""
+	}
+
+	return GetValidationFailureReason(err)
+}
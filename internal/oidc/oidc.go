@@ -2,12 +2,19 @@ package oidc
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/xenitab/go-oidc-middleware/options"
@@ -18,262 +25,1475 @@ import (
 	"github.com/lestrrat-go/jwx/jwt"
 )
 
-var (
-	errSignatureVerification = fmt.Errorf("failed to verify signature")
-)
+// JwksUnavailableRetryAfter is the value (in seconds) of the Retry-After header used by the
+// middlewares when responding to errors wrapping options.ErrJwksUnavailable.
+const JwksUnavailableRetryAfter = "5"
+
+// jwksUnavailableError wraps errors from fetching the discovery document or the jwks,
+// making errors.Is(err, options.ErrJwksUnavailable) true while keeping the original error.
+type jwksUnavailableError struct {
+	err error
+}
+
+func (e *jwksUnavailableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *jwksUnavailableError) Unwrap() error {
+	return e.err
+}
+
+func (e *jwksUnavailableError) Is(target error) bool {
+	return target == options.ErrJwksUnavailable
+}
 
 type handler[T any] struct {
-	issuer                     string
-	discoveryUri               string
-	discoveryFetchTimeout      time.Duration
-	jwksUri                    string
-	jwksFetchTimeout           time.Duration
-	jwksRateLimit              uint
-	fallbackSignatureAlgorithm jwa.SignatureAlgorithm
-	allowedTokenDrift          time.Duration
-	requiredAudience           string
-	requiredTokenType          string
-	disableKeyID               bool
-	httpClient                 *http.Client
-	keyHandler                 *keyHandler
-	claimsValidationFn         options.ClaimsValidationFn[T]
+	sync.RWMutex
+	issuer                        string
+	issuerAliases                 []string
+	discoveryUri                  string
+	discoveryMode                 options.DiscoveryMode
+	useDiscoveryIssuer            bool
+	discoveryIssuer               string
+	discoveryFetchTimeout         time.Duration
+	jwksUri                       string
+	jwksFetchTimeout              time.Duration
+	jwksFetchRetries              int
+	jwksFetchRetryDelay           time.Duration
+	jwksRateLimit                 uint
+	fallbackSignatureAlgorithm    jwa.SignatureAlgorithm
+	allowES256K                   bool
+	allowedTokenDrift             time.Duration
+	absoluteMaxExpiryAge          time.Duration
+	nowFn                         options.NowFn
+	maxAuthAge                    time.Duration
+	maxTokenAge                   time.Duration
+	requiredAudience              string
+	requiredAudiences             []string
+	audienceIsIssuer              bool
+	allowMissingAudience          bool
+	audienceClaimName             string
+	requireAudienceForRequestPath bool
+	claimNamespace                string
+	requiredRoles                 []string
+	requiredScopes                []string
+	rolesClaimName                string
+	rolesDelimiter                string
+	roleHierarchy                 map[string][]string
+	requiredRealmRoles            []string
+	requiredClientRoles           map[string][]string
+	requiredGroupsAny             []string
+	requiredGroupsAll             []string
+	requiredClaimsRegex           map[string]*regexp.Regexp
+	requiredClaimsPresent         []string
+	strictClaimsPresence          bool
+	groupsClaimName               string
+	strictClaimsDecoding          bool
+	attachRejectedToken           bool
+	requiredTokenType             string
+	requiredTokenTypes            []string
+	allowMissingTokenType         bool
+	requiredTokenUse              string
+	tokenTypeValidator            options.TokenTypeValidator
+	maxTokenLength                int
+	disableKeyID                  bool
+	maxFallbackKeys               int
+	allowedKeyTypes               []jwa.KeyType
+	minRSAKeyBits                 int
+	allowedSignatureAlgorithms    []jwa.SignatureAlgorithm
+	deprecatedKeyIDs              map[string]struct{}
+	blockedKeyIDs                 map[string]struct{}
+	onDeprecatedKeyUsed           func(kid string)
+	verifiers                     map[jwa.KeyType]options.Verifier
+	jwksResponseExtractor         options.JwksResponseExtractor
+	keySourceFunc                 options.KeySourceFunc
+	staticJwks                    bool
+	pendingJwks                   jwk.Set
+	decryptionKeys                jwk.Set
+	requireJwksSameHostAsIssuer   bool
+	introspectionUri              string
+	introspectionClientID         string
+	introspectionClientSecret     string
+	introspectionFetchTimeout     time.Duration
+	httpClient                    *http.Client
+	nonceFromContextFn            options.NonceFromContextFn
+	claimsValidator               options.ClaimsValidator
+	nonceMaxAge                   time.Duration
+	revokedTokenIDs               map[string]struct{}
+	revokedTokenIDsFn             options.RevokedTokenIDsFn
+	decisionCache                 options.DecisionCache
+	tokenCache                    *tokenCache
+	shouldCacheFunc               options.ShouldCacheFunc
+	policyID                      string
+	policyGeneration              uint64
+	timingsFn                     options.TimingsFn
+	metrics                       options.Metrics
+	logger                        options.Logger
+	jwksHttpClient                *http.Client
+	lazyLoadJwksBackoff           time.Duration
+	lazyLoadMu                    sync.Mutex
+	lazyLoadErr                   error
+	lazyLoadRetryAt               time.Time
+	backgroundRefreshInterval     time.Duration
+	backgroundRefreshCancel       context.CancelFunc
+	backgroundRefreshDone         chan struct{}
+	closeOnce                     sync.Once
+	keyHandler                    *keyHandler
+	issuerHandlers                map[string]*handler[T]
+	issuerHandlerOrder            []string
+	claimsValidationFn            options.ClaimsValidationFn[T]
+	opts                          *options.Options
 }
 
 func NewHandler[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) (*handler[T], error) {
 	opts := options.New(setters...)
 
-	h := &handler[T]{
-		issuer:                opts.Issuer,
-		discoveryUri:          opts.DiscoveryUri,
-		discoveryFetchTimeout: opts.DiscoveryFetchTimeout,
-		jwksUri:               opts.JwksUri,
-		jwksFetchTimeout:      opts.JwksFetchTimeout,
-		jwksRateLimit:         opts.JwksRateLimit,
-		allowedTokenDrift:     opts.AllowedTokenDrift,
-		requiredTokenType:     opts.RequiredTokenType,
-		requiredAudience:      opts.RequiredAudience,
-		disableKeyID:          opts.DisableKeyID,
-		httpClient:            opts.HttpClient,
-		claimsValidationFn:    claimsValidationFn,
-	}
-
-	if h.issuer == "" {
-		return nil, fmt.Errorf("issuer is empty")
-	}
-	if h.discoveryUri == "" {
-		h.discoveryUri = GetDiscoveryUriFromIssuer(h.issuer)
-	}
-	if opts.FallbackSignatureAlgorithm != "" {
-		alg, err := getSignatureAlgorithmFromString(opts.FallbackSignatureAlgorithm)
+	err := validateOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	h := newHandler(claimsValidationFn, opts)
+
+	err = h.setPolicyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(opts.JwksJSON) > 0 {
+		err := h.setJwksJSON(opts.JwksJSON)
 		if err != nil {
-			return nil, fmt.Errorf("FallbackSignatureAlgorithm not accepted: %w", err)
+			return nil, err
 		}
+	}
 
-		h.fallbackSignatureAlgorithm = alg
+	_, issuers := getIssuerConfigs(opts)
+	if len(issuers) > 0 {
+		err := h.setIssuerHandlers(setters, issuers)
+		if err != nil {
+			return nil, err
+		}
 	}
-	if !opts.LazyLoadJwks {
-		err := h.loadJwks()
+
+	if !opts.LazyLoadJwks && h.introspectionUri == "" && h.getKeyHandler() == nil {
+		_, err := h.loadJwks(context.Background())
 		if err != nil {
+			_ = h.Close()
 			return nil, fmt.Errorf("unable to load jwks: %w", err)
 		}
 	}
 
+	if h.backgroundRefreshInterval > 0 && h.introspectionUri == "" && !h.staticJwks {
+		h.startBackgroundRefresh()
+	}
+
 	return h, nil
 }
 
-func (h *handler[T]) loadJwks() error {
-	if h.jwksUri == "" {
-		jwksUri, err := getJwksUriFromDiscoveryUri(h.httpClient, h.discoveryUri, h.discoveryFetchTimeout)
+// newHandler creates the handler from options validated by validateOptions, without loading the
+// jwks or creating the handlers of the additional issuers.
+func newHandler[T any](claimsValidationFn options.ClaimsValidationFn[T], opts *options.Options) *handler[T] {
+	issuerConfig, _ := getIssuerConfigs(opts)
+
+	h := &handler[T]{
+		opts:                          opts,
+		issuer:                        issuerConfig.Issuer,
+		issuerAliases:                 opts.IssuerAliases,
+		discoveryUri:                  issuerConfig.DiscoveryUri,
+		discoveryMode:                 opts.DiscoveryMode,
+		useDiscoveryIssuer:            opts.UseDiscoveryIssuer,
+		discoveryFetchTimeout:         opts.DiscoveryFetchTimeout,
+		jwksUri:                       issuerConfig.JwksUri,
+		jwksFetchTimeout:              opts.JwksFetchTimeout,
+		jwksFetchRetries:              opts.JwksFetchRetries,
+		jwksFetchRetryDelay:           opts.JwksFetchRetryDelay,
+		jwksRateLimit:                 opts.JwksRateLimit,
+		jwksResponseExtractor:         opts.JwksResponseExtractor,
+		keySourceFunc:                 opts.KeySourceFunc,
+		pendingJwks:                   opts.PendingJwks,
+		decryptionKeys:                opts.DecryptionKeys,
+		lazyLoadJwksBackoff:           opts.LazyLoadJwksBackoff,
+		backgroundRefreshInterval:     opts.BackgroundRefreshInterval,
+		requireJwksSameHostAsIssuer:   opts.RequireJwksSameHostAsIssuer,
+		introspectionUri:              opts.IntrospectionUri,
+		introspectionClientID:         opts.IntrospectionClientID,
+		introspectionClientSecret:     opts.IntrospectionClientSecret,
+		introspectionFetchTimeout:     opts.IntrospectionFetchTimeout,
+		httpClient:                    opts.HttpClient,
+		allowES256K:                   opts.AllowES256K,
+		allowedTokenDrift:             opts.AllowedTokenDrift,
+		absoluteMaxExpiryAge:          opts.AbsoluteMaxExpiryAge,
+		nowFn:                         time.Now,
+		maxAuthAge:                    opts.MaxAuthAge,
+		maxTokenAge:                   opts.MaxTokenAge,
+		requiredTokenType:             opts.RequiredTokenType,
+		requiredTokenTypes:            append([]string(nil), opts.RequiredTokenTypes...),
+		allowMissingTokenType:         opts.AllowMissingTokenType,
+		requiredTokenUse:              opts.RequiredTokenUse,
+		tokenTypeValidator:            opts.TokenTypeValidator,
+		maxTokenLength:                opts.MaxTokenLength,
+		requiredAudience:              opts.RequiredAudience,
+		requiredAudiences:             append([]string(nil), opts.RequiredAudiences...),
+		audienceIsIssuer:              opts.AudienceIsIssuer,
+		allowMissingAudience:          opts.AllowMissingAudience,
+		audienceClaimName:             opts.AudienceClaimName,
+		requireAudienceForRequestPath: opts.RequireAudienceForRequestPath,
+		claimNamespace:                opts.ClaimNamespace,
+		requiredRoles:                 opts.RequiredRoles,
+		requiredScopes:                opts.RequiredScopes,
+		rolesClaimName:                opts.RolesClaimName,
+		rolesDelimiter:                opts.RolesDelimiter,
+		roleHierarchy:                 opts.RoleHierarchy,
+		requiredRealmRoles:            opts.RequiredRealmRoles,
+		requiredClientRoles:           opts.RequiredClientRoles,
+		requiredGroupsAny:             opts.RequiredGroupsAny,
+		requiredGroupsAll:             opts.RequiredGroupsAll,
+		requiredClaimsPresent:         opts.RequiredClaimsPresent,
+		strictClaimsPresence:          opts.StrictClaimsPresence,
+		groupsClaimName:               opts.GroupsClaimName,
+		strictClaimsDecoding:          opts.StrictClaimsDecoding,
+		attachRejectedToken:           opts.AttachRejectedToken,
+		disableKeyID:                  opts.DisableKeyID,
+		maxFallbackKeys:               opts.MaxFallbackKeys,
+		minRSAKeyBits:                 opts.MinRSAKeyBits,
+		deprecatedKeyIDs:              getStringSet(opts.DeprecatedKeyIDs),
+		blockedKeyIDs:                 getStringSet(opts.BlockedKeyIDs),
+		revokedTokenIDs:               getStringSet(opts.RevokedTokenIDs),
+		onDeprecatedKeyUsed:           opts.OnDeprecatedKeyUsed,
+		nonceFromContextFn:            opts.NonceFromContextFn,
+		nonceMaxAge:                   opts.NonceMaxAge,
+		revokedTokenIDsFn:             opts.RevokedTokenIDsFn,
+		claimsValidator:               opts.ClaimsValidator,
+		decisionCache:                 opts.DecisionCache,
+		tokenCache:                    newTokenCache(opts.TokenCacheTTL, opts.TokenCacheSize),
+		shouldCacheFunc:               opts.ShouldCacheFunc,
+		policyID:                      opts.PolicyID,
+		timingsFn:                     opts.TimingsFn,
+		metrics:                       opts.Metrics,
+		logger:                        getLogger(opts.Logger),
+		jwksHttpClient:                opts.HttpClient,
+		claimsValidationFn:            claimsValidationFn,
+	}
+
+	if opts.JwksHttpClient != nil {
+		h.jwksHttpClient = opts.JwksHttpClient
+	}
+	if opts.NowFn != nil {
+		h.nowFn = opts.NowFn
+	}
+
+	return h
+}
+
+// setPolicyOptions sets the signature algorithms, key types and verifiers used to verify tokens
+// and the regular expressions used to validate the claims.
+func (h *handler[T]) setPolicyOptions(opts *options.Options) error {
+	var err error
+	h.fallbackSignatureAlgorithm, err = getFallbackSignatureAlgorithm(opts.FallbackSignatureAlgorithm, h.allowES256K)
+	if err != nil {
+		return err
+	}
+
+	h.allowedKeyTypes, err = getAllowedKeyTypes(opts.AllowedKeyTypes)
+	if err != nil {
+		return err
+	}
+
+	h.allowedSignatureAlgorithms, err = getAllowedSignatureAlgorithms(opts.AllowedSignatureAlgorithms, h.allowES256K)
+	if err != nil {
+		return err
+	}
+
+	h.verifiers, err = getVerifiers(opts.Verifiers)
+	if err != nil {
+		return err
+	}
+
+	h.requiredClaimsRegex, err = getRequiredClaimsRegex(opts.RequiredClaimsRegex)
+
+	return err
+}
+
+// setJwksJSON uses the jwks as is, or only until it has been downloaded by the background refresh
+// if BackgroundRefreshInterval is set.
+func (h *handler[T]) setJwksJSON(jwksJSON []byte) error {
+	keySet, err := jwk.Parse(jwksJSON)
+	if err != nil {
+		return fmt.Errorf("JwksJSON not accepted: %w", err)
+	}
+
+	if h.backgroundRefreshInterval > 0 {
+		return h.initCachedKeyHandler(keySet)
+	}
+
+	h.staticJwks = true
+	h.keySourceFunc = func(_ context.Context) (jwk.Set, error) {
+		return keySet, nil
+	}
+
+	return nil
+}
+
+// loadJwks resolves the jwks uri (using discovery if JwksUri isn't configured), creates a new
+// keyHandler and replaces the current one. The current keyHandler is kept if an error occurs.
+// The jwks uri isn't used if KeySourceFunc is configured.
+func (h *handler[T]) loadJwks(ctx context.Context) (*keyHandler, error) {
+	if h.keySourceFunc != nil {
+		return h.initKeyHandlerWithRetries(ctx, "")
+	}
+
+	jwksUri := h.jwksUri
+	if jwksUri == "" {
+		discoveryUri := h.getDiscoveryUri()
+		err := h.validateSameHostAsIssuer("discoveryUri", discoveryUri)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := retryWithBackoff(ctx, h.jwksFetchRetries, h.jwksFetchRetryDelay, h.logger, "discovery", func() (discoveryData, error) {
+			return h.getDiscoveryData(ctx, discoveryUri)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch jwksUri from discoveryUri (%s): %w", discoveryUri, &jwksUnavailableError{err})
+		}
+
+		err = h.setDiscoveryIssuer(data.Issuer)
+		if err != nil {
+			return nil, err
+		}
+
+		jwksUri = data.JwksUri
+	}
+
+	err := h.validateSameHostAsIssuer("jwksUri", jwksUri)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.initKeyHandlerWithRetries(ctx, jwksUri)
+}
+
+// initKeyHandler creates a new keyHandler using jwksUri and replaces the current one.
+func (h *handler[T]) initKeyHandler(jwksUri string) (*keyHandler, error) {
+	keyHandler, err := newKeyHandler(h.getKeyHandlerConfig(jwksUri))
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize keyHandler: %w", err)
+	}
+
+	keyHandler.pendingKeySet = h.pendingJwks
+	keyHandler.disableKeyUpdates = h.staticJwks
+
+	h.setKeyHandler(keyHandler)
+
+	return keyHandler, nil
+}
+
+// getKeyHandlerConfig returns the config of a keyHandler downloading the jwks from jwksUri, or
+// getting the keys from KeySourceFunc if it's configured.
+func (h *handler[T]) getKeyHandlerConfig(jwksUri string) keyHandlerConfig {
+	return keyHandlerConfig{
+		httpClient:        h.jwksHttpClient,
+		jwksUri:           jwksUri,
+		fetchTimeout:      h.jwksFetchTimeout,
+		keyUpdateRPS:      h.jwksRateLimit,
+		disableKeyID:      h.disableKeyID,
+		responseExtractor: h.jwksResponseExtractor,
+		keySourceFunc:     h.keySourceFunc,
+		metrics:           h.metrics,
+		logger:            h.logger,
+	}
+}
+
+// initCachedKeyHandler creates a keyHandler serving keySet without fetching anything, used when
+// JwksJSON is combined with BackgroundRefreshInterval. It's replaced by the background refresh
+// once the jwks has been downloaded.
+func (h *handler[T]) initCachedKeyHandler(keySet jwk.Set) error {
+	cfg := h.getKeyHandlerConfig("")
+	cfg.keySourceFunc = func(_ context.Context) (jwk.Set, error) {
+		return keySet, nil
+	}
+
+	keyHandler, err := newKeyHandler(cfg)
+	if err != nil {
+		return fmt.Errorf("JwksJSON not accepted: %w", err)
+	}
+
+	keyHandler.pendingKeySet = h.pendingJwks
+	keyHandler.disableKeyUpdates = true
+	keyHandler.cached = true
+
+	h.setKeyHandler(keyHandler)
+
+	return nil
+}
+
+// validateSameHostAsIssuer returns an error if RequireJwksSameHostAsIssuer is used and
+// the host of the uri isn't the same as the host of the issuer.
+func (h *handler[T]) validateSameHostAsIssuer(name string, uri string) error {
+	if !h.requireJwksSameHostAsIssuer {
+		return nil
+	}
+
+	issuer := h.getIssuer()
+	issuerUrl, err := url.Parse(issuer)
+	if err != nil || issuerUrl.Hostname() == "" {
+		return fmt.Errorf("unable to get host from issuer %q", issuer)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil || u.Hostname() == "" {
+		return fmt.Errorf("unable to get host from %s %q", name, uri)
+	}
+
+	if !strings.EqualFold(u.Hostname(), issuerUrl.Hostname()) {
+		return fmt.Errorf("%s host %q isn't the same as the issuer host %q", name, u.Hostname(), issuerUrl.Hostname())
+	}
+
+	return nil
+}
+
+// validateHttpsUri returns an error if the uri doesn't use the https scheme, except for loopback hosts.
+// An empty uri isn't validated.
+func validateHttpsUri(name string, uri string) error {
+	if uri == "" {
+		return nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("unable to parse %s %q: %w", name, uri, err)
+	}
+
+	if u.Scheme == "https" || isLoopbackHost(u.Hostname()) {
+		return nil
+	}
+
+	return fmt.Errorf("%s %q is required to use https, use AllowInsecureIssuer to allow it", name, uri)
+}
+
+// isLoopbackHost returns true for localhost and loopback ip addresses.
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// Reload re-runs the discovery and downloads the jwks, then atomically replaces the keys
+// used to validate tokens. Tokens being parsed during the reload use either the old or the
+// new keys. If an error is returned, the old keys are kept.
+func (h *handler[T]) Reload(ctx context.Context) error {
+	_, err := h.loadJwks(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to reload jwks: %w", err)
+	}
+
+	return nil
+}
+
+func (h *handler[T]) getKeyHandler() *keyHandler {
+	h.RLock()
+	defer h.RUnlock()
+	return h.keyHandler
+}
+
+func (h *handler[T]) setKeyHandler(keyHandler *keyHandler) {
+	h.Lock()
+	defer h.Unlock()
+	h.keyHandler = keyHandler
+}
+
+func (h *handler[T]) getDiscoveryUri() string {
+	h.RLock()
+	defer h.RUnlock()
+	return h.discoveryUri
+}
+
+func (h *handler[T]) getIssuer() string {
+	h.RLock()
+	defer h.RUnlock()
+	return h.issuer
+}
+
+func (h *handler[T]) SetIssuer(issuer string) {
+	h.Lock()
+	defer h.Unlock()
+	h.issuer = issuer
+	h.discoveryIssuer = ""
+	h.policyGeneration++
+	h.lazyLoadErr = nil
+}
+
+func (h *handler[T]) SetDiscoveryUri(discoveryUri string) {
+	h.Lock()
+	defer h.Unlock()
+	h.discoveryUri = discoveryUri
+	h.lazyLoadErr = nil
+}
+
+// lazyLoadJwks loads the jwks if it isn't loaded. If LazyLoadJwksBackoff is used, concurrent loads
+// are deduplicated and a failed load is returned without loading again until the backoff has passed.
+func (h *handler[T]) lazyLoadJwks(ctx context.Context) (*keyHandler, error) {
+	if h.lazyLoadJwksBackoff <= 0 {
+		return h.loadJwks(ctx)
+	}
+
+	h.lazyLoadMu.Lock()
+	defer h.lazyLoadMu.Unlock()
+
+	h.RLock()
+	keyHandler, lazyLoadErr, lazyLoadRetryAt := h.keyHandler, h.lazyLoadErr, h.lazyLoadRetryAt
+	h.RUnlock()
+
+	if keyHandler != nil {
+		return keyHandler, nil
+	}
+
+	if lazyLoadErr != nil && time.Now().Before(lazyLoadRetryAt) {
+		err := fmt.Errorf("%w until %s: %v", options.ErrJwksLoadBackoff, lazyLoadRetryAt.Format(time.RFC3339), lazyLoadErr)
+		return nil, &jwksUnavailableError{err}
+	}
+
+	keyHandler, err := h.loadJwks(ctx)
+
+	h.Lock()
+	defer h.Unlock()
+
+	if err != nil {
+		h.lazyLoadErr = err
+		h.lazyLoadRetryAt = time.Now().Add(h.lazyLoadJwksBackoff)
+		return nil, err
+	}
+
+	h.lazyLoadErr = nil
+
+	return keyHandler, nil
+}
+
+type ParseTokenFunc[T any] func(ctx context.Context, tokenString string) (T, error)
+
+func (h *handler[T]) ParseToken(ctx context.Context, tokenString string) (T, error) {
+	if h.timingsFn == nil {
+		claims, err := h.parseToken(ctx, tokenString, &options.Timings{})
+		observeValidation(h.metrics, err)
+		logValidation(h.logger, err)
+
+		return claims, err
+	}
+
+	start := time.Now()
+	timings := &options.Timings{}
+	claims, err := h.parseToken(withTimings(ctx, timings), tokenString, timings)
+	timings.Total = time.Since(start)
+
+	observeValidation(h.metrics, err)
+	logValidation(h.logger, err)
+	h.timingsFn(ctx, *timings, err)
+
+	return claims, err
+}
+
+func (h *handler[T]) parseToken(ctx context.Context, tokenString string, timings *options.Timings) (T, error) {
+	err := ValidateTokenLength(tokenString, h.maxTokenLength)
+	if err != nil {
+		return *new(T), err
+	}
+
+	if h.introspectionUri != "" {
+		return h.parseTokenWithIntrospection(ctx, tokenString, timings)
+	}
+
+	issuerHandler, tokenString, err := h.getIssuerHandler(tokenString)
+	if err != nil {
+		return *new(T), err
+	}
+
+	if issuerHandler != h {
+		return issuerHandler.parseToken(ctx, tokenString, timings)
+	}
+
+	keyHandler, err := h.getOrLoadKeyHandler(ctx, timings)
+	if err != nil {
+		return *new(T), err
+	}
+
+	stepStart := time.Now()
+	cacheKey := ""
+	if h.tokenCache != nil {
+		cacheKey = getTokenHash(tokenString)
+		claims, found, err := h.parseTokenFromTokenCache(ctx, cacheKey, keyHandler.getKeySet(), timings)
+		if found {
+			return claims, err
+		}
+	}
+
+	unverifiedToken, err := h.getUnverifiedToken(tokenString)
+	if err != nil {
+		return *new(T), err
+	}
+
+	timings.KeyIDExtraction = time.Since(stepStart)
+
+	token, key, err := h.getVerifiedToken(ctx, keyHandler, unverifiedToken, timings)
+	if err != nil {
+		return *new(T), err
+	}
+
+	stepStart = time.Now()
+	defer func() {
+		timings.ClaimsValidation = time.Since(stepStart)
+	}()
+
+	err = h.validateVerifiedToken(unverifiedToken.tokenString, key)
+	if err != nil {
+		return *new(T), err
+	}
+
+	tokenHash := getTokenHash(unverifiedToken.tokenString)
+	claims, err := h.validateToken(ctx, tokenHash, token)
+	if err != nil {
+		return *new(T), err
+	}
+
+	if h.tokenCache != nil && (h.shouldCacheFunc == nil || h.shouldCacheFunc(token)) {
+		h.tokenCache.set(tokenCacheEntry{
+			key:        cacheKey,
+			tokenHash:  tokenHash,
+			token:      token,
+			headers:    unverifiedToken.headers,
+			signingKey: key,
+			keySet:     keyHandler.getKeySet(),
+		}, h.nowFn())
+	}
+
+	h.notifyIfDeprecatedKey(key.KeyID())
+
+	return claims, nil
+}
+
+// getOrLoadKeyHandler returns the current keyHandler, or loads the jwks if it hasn't been loaded yet.
+func (h *handler[T]) getOrLoadKeyHandler(ctx context.Context, timings *options.Timings) (*keyHandler, error) {
+	keyHandler := h.getKeyHandler()
+	if keyHandler != nil {
+		return keyHandler, nil
+	}
+
+	stepStart := time.Now()
+	keyHandler, err := h.lazyLoadJwks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load jwks: %w", err)
+	}
+
+	timings.JwksLoad = time.Since(stepStart)
+
+	return keyHandler, nil
+}
+
+// unverifiedToken is a token which headers have been validated, but which signature hasn't been
+// verified yet. tokenString is the decrypted token string if the token was encrypted.
+type unverifiedToken struct {
+	tokenString string
+	headers     jws.Headers
+	keyID       string
+	algorithm   jwa.SignatureAlgorithm
+}
+
+// getUnverifiedToken decrypts the token if it's encrypted and validates its headers.
+func (h *handler[T]) getUnverifiedToken(tokenString string) (unverifiedToken, error) {
+	if isEncryptedTokenString(tokenString) {
+		if h.decryptionKeys == nil {
+			return unverifiedToken{}, fmt.Errorf("token is encrypted and no decryption keys are configured")
+		}
+
+		var err error
+		tokenString, err = decryptTokenString(tokenString, h.decryptionKeys)
+		if err != nil {
+			return unverifiedToken{}, err
+		}
+	}
+
+	tokenHeaders, err := getHeadersFromTokenString(tokenString)
+	if err != nil {
+		return unverifiedToken{}, err
+	}
+
+	keyID, tokenAlgorithm, err := h.validateTokenHeaders(tokenHeaders)
+	if err != nil {
+		return unverifiedToken{}, err
+	}
+
+	return unverifiedToken{
+		tokenString: tokenString,
+		headers:     tokenHeaders,
+		keyID:       keyID,
+		algorithm:   tokenAlgorithm,
+	}, nil
+}
+
+// getVerifiedToken looks up the key of the token and verifies its signature. If the signature can't
+// be verified, the jwks is refreshed when DisableKeyID is used and the fallback keys are tried when
+// MaxFallbackKeys is used. The key used to verify the signature is returned together with the token.
+func (h *handler[T]) getVerifiedToken(ctx context.Context, keyHandler *keyHandler, t unverifiedToken,
+	timings *options.Timings) (jwt.Token, jwk.Key, error) {
+	stepStart := time.Now()
+	key, err := keyHandler.getKey(ctx, t.keyID, t.algorithm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get public key: %w", err)
+	}
+
+	alg, err := h.validateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	timings.KeyLookup = time.Since(stepStart)
+	stepStart = time.Now()
+
+	token, err := h.getAndVerifyTokenFromString(ctx, t.tokenString, key, alg)
+	timings.SignatureVerification = time.Since(stepStart)
+	if err == nil {
+		return token, key, nil
+	}
+
+	if !errors.Is(err, options.ErrSignatureVerification) {
+		return nil, nil, err
+	}
+
+	switch {
+	case h.disableKeyID:
+		return h.getVerifiedTokenWithUpdatedKey(ctx, keyHandler, t.tokenString, timings)
+	case h.maxFallbackKeys > 0:
+		stepStart = time.Now()
+		token, key, err = h.verifyWithFallbackKeys(ctx, t.tokenString, keyHandler.getKeySet(), key, t.algorithm, err)
+		timings.SignatureVerification += time.Since(stepStart)
+
+		return token, key, err
+	default:
+		return nil, nil, err
+	}
+}
+
+// getVerifiedTokenWithUpdatedKey verifies the token using the key of the refreshed jwks, since the
+// key may have been rotated when DisableKeyID is used.
+func (h *handler[T]) getVerifiedTokenWithUpdatedKey(ctx context.Context, keyHandler *keyHandler, tokenString string,
+	timings *options.Timings) (jwt.Token, jwk.Key, error) {
+	stepStart := time.Now()
+	key, err := keyHandler.waitForUpdateKeySetAndGetKey(ctx)
+	timings.KeyLookup += time.Since(stepStart)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	alg, err := h.validateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stepStart = time.Now()
+	token, err := h.getAndVerifyTokenFromString(ctx, tokenString, key, alg)
+	timings.SignatureVerification += time.Since(stepStart)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, key, nil
+}
+
+// validateVerifiedToken runs the checks needing the verified token: the key used to verify it
+// isn't blocked (it may not match the key id of the token if DisableKeyID is used) and, with
+// StrictClaimsDecoding, the claims don't contain duplicates.
+func (h *handler[T]) validateVerifiedToken(tokenString string, key jwk.Key) error {
+	err := h.validateKeyIDNotBlocked(key.KeyID())
+	if err != nil {
+		return err
+	}
+
+	if h.strictClaimsDecoding {
+		return checkDuplicateClaims(tokenString)
+	}
+
+	return nil
+}
+
+// parseTokenFromTokenCache validates the claims of a token found in the token cache, skipping
+// the signature verification. found is false if the token isn't cached or if the jwks has been
+// updated since the token was verified, since the signing key may have been removed.
+func (h *handler[T]) parseTokenFromTokenCache(ctx context.Context, cacheKey string, keySet jwk.Set,
+	timings *options.Timings) (claims T, found bool, err error) {
+	entry, ok := h.tokenCache.get(cacheKey, h.nowFn())
+	if !ok {
+		return *new(T), false, nil
+	}
+
+	if entry.keySet != keySet {
+		h.tokenCache.remove(cacheKey)
+		return *new(T), false, nil
+	}
+
+	// the checks of the headers and the key don't depend on the signature and are run again,
+	// since the token and key type validators may not accept the token anymore
+	_, _, err = h.validateTokenHeaders(entry.headers)
+	if err != nil {
+		return *new(T), true, err
+	}
+
+	_, err = h.validateKey(entry.signingKey)
+	if err != nil {
+		return *new(T), true, err
+	}
+
+	err = h.validateKeyIDNotBlocked(entry.signingKey.KeyID())
+	if err != nil {
+		return *new(T), true, err
+	}
+
+	stepStart := time.Now()
+	defer func() {
+		timings.ClaimsValidation = time.Since(stepStart)
+	}()
+
+	claims, err = h.validateToken(ctx, entry.tokenHash, entry.token)
+	if err != nil {
+		return *new(T), true, err
+	}
+
+	h.notifyIfDeprecatedKey(entry.signingKey.KeyID())
+
+	return claims, true, nil
+}
+
+// validateToken validates the claims of a token, after it has been verified using
+// the jwks or the introspection endpoint.
+func (h *handler[T]) validateToken(ctx context.Context, tokenHash string, token jwt.Token) (T, error) {
+	now := h.nowFn()
+
+	validExpiration := isTokenExpirationValid(token.Expiration(), h.allowedTokenDrift, now)
+	if !validExpiration {
+		return *new(T), &validationFailureError{options.ExpiredValidationFailureReason, fmt.Errorf("token has expired: %s", token.Expiration())}
+	}
+
+	// a missing exp is handled as expired by isTokenExpirationValid, since no drift can reach the zero time
+	if h.absoluteMaxExpiryAge > 0 && !isTokenExpirationValid(token.Expiration(), h.absoluteMaxExpiryAge, now) {
+		err := fmt.Errorf("token expired more than the absolute max expiry age %s ago: %s", h.absoluteMaxExpiryAge, token.Expiration())
+		return *new(T), &validationFailureError{options.ExpiredValidationFailureReason, err}
+	}
+
+	if h.maxAuthAge > 0 {
+		authTime, err := getTimeClaimFromToken(token, "auth_time")
+		if err != nil {
+			return *new(T), err
+		}
+
+		validAuthTime := isTokenTimeFresh(authTime, h.maxAuthAge, h.allowedTokenDrift, now)
+		if !validAuthTime {
+			err = fmt.Errorf("%w: token auth_time %q is not within the max auth age %s", options.ErrAuthTooOld, authTime, h.maxAuthAge)
+			return *new(T), &authTooOldError{h.maxAuthAge, err}
+		}
+	}
+
+	if h.maxTokenAge > 0 {
+		issuedAt, err := getTimeClaimFromToken(token, "iat")
+		if err != nil {
+			return *new(T), err
+		}
+
+		validIssuedAt := isTokenTimeFresh(issuedAt, h.maxTokenAge, h.allowedTokenDrift, now)
+		if !validIssuedAt {
+			err = fmt.Errorf("token issued at %q is not within the max token age %s", issuedAt, h.maxTokenAge)
+			return *new(T), &validationFailureError{options.ExpiredValidationFailureReason, err}
+		}
+	}
+
+	if h.nonceFromContextFn != nil {
+		requiredNonce, ok := h.nonceFromContextFn(ctx)
+		if ok {
+			validNonce := isTokenNonceValid(requiredNonce, token)
+			if !validNonce {
+				return *new(T), fmt.Errorf("required nonce was not found or does not match")
+			}
+
+			validIssuedAt := isTokenTimeFresh(token.IssuedAt(), h.nonceMaxAge, h.allowedTokenDrift, now)
+			if !validIssuedAt {
+				return *new(T), fmt.Errorf("token issued at %q is not within the nonce max age %s", token.IssuedAt(), h.nonceMaxAge)
+			}
+		}
+	}
+
+	err := h.validateTokenNotRevoked(ctx, token)
+	if err != nil {
+		return *new(T), err
+	}
+
+	claims, err := h.getClaimsWithDecisionCache(ctx, tokenHash, token)
+	if err == nil {
+		err = h.runClaimsValidator(ctx, token)
+	}
+	if err != nil && h.attachRejectedToken && GetValidationFailureReason(err) == options.ClaimsValidationFailureReason {
+		return *new(T), &options.RejectedTokenError{Token: token, Err: err}
+	}
+	if err != nil {
+		return *new(T), err
+	}
+
+	return claims, nil
+}
+
+// policy contains the part of the configuration that can be changed at runtime.
+// A copy is used for each token to make sure it's validated against a single version of the policy.
+type policy[T any] struct {
+	issuer             string
+	requiredAudience   string
+	requestAudience    string
+	requestPath        string
+	requiredScopes     []string
+	policyID           string
+	generation         uint64
+	claimsValidationFn options.ClaimsValidationFn[T]
+}
+
+func (h *handler[T]) getPolicy() policy[T] {
+	h.RLock()
+	defer h.RUnlock()
+
+	issuer := h.issuer
+	if h.discoveryIssuer != "" {
+		issuer = h.discoveryIssuer
+	}
+
+	return policy[T]{
+		issuer:             issuer,
+		requiredAudience:   h.requiredAudience,
+		requiredScopes:     h.requiredScopes,
+		policyID:           h.policyID,
+		generation:         h.policyGeneration,
+		claimsValidationFn: h.claimsValidationFn,
+	}
+}
+
+// SetRequiredAudience replaces the required audience used for the next tokens.
+// Has no effect if AudienceIsIssuer is used.
+func (h *handler[T]) SetRequiredAudience(requiredAudience string) {
+	h.Lock()
+	defer h.Unlock()
+	h.requiredAudience = requiredAudience
+	h.policyGeneration++
+
+	for _, issuerHandler := range h.issuerHandlers {
+		issuerHandler.SetRequiredAudience(requiredAudience)
+	}
+}
+
+// SetRequiredScopes replaces the required scopes used for the next tokens.
+func (h *handler[T]) SetRequiredScopes(requiredScopes []string) {
+	requiredScopes = append([]string(nil), requiredScopes...)
+
+	h.Lock()
+	defer h.Unlock()
+	h.requiredScopes = requiredScopes
+	h.policyGeneration++
+
+	for _, issuerHandler := range h.issuerHandlers {
+		issuerHandler.SetRequiredScopes(requiredScopes)
+	}
+}
+
+// SetClaimsValidationFn replaces the function used to validate the required claims for the next tokens.
+func (h *handler[T]) SetClaimsValidationFn(claimsValidationFn options.ClaimsValidationFn[T]) {
+	h.Lock()
+	defer h.Unlock()
+	h.claimsValidationFn = claimsValidationFn
+	h.policyGeneration++
+
+	for _, issuerHandler := range h.issuerHandlers {
+		issuerHandler.SetClaimsValidationFn(claimsValidationFn)
+	}
+}
+
+// SetPolicyID replaces the policy id used as part of the decision cache key for the next tokens.
+// The other setters already stop the handler from reusing decisions made using the previous policy.
+func (h *handler[T]) SetPolicyID(policyID string) {
+	h.Lock()
+	defer h.Unlock()
+	h.policyID = policyID
+
+	for _, issuerHandler := range h.issuerHandlers {
+		issuerHandler.SetPolicyID(policyID)
+	}
+}
+
+// getClaimsWithDecisionCache runs validatePolicy, using the outcome stored in the decision cache
+// for the token, policy id and policy generation if it exists. Outcomes are stored until the token expires.
+func (h *handler[T]) getClaimsWithDecisionCache(ctx context.Context, tokenHash string, token jwt.Token) (T, error) {
+	p := h.getPolicy()
+	p.requestAudience = getRequestAudience(ctx)
+	if h.requireAudienceForRequestPath {
+		p.requestPath = getRequestPath(ctx)
+	}
+
+	if h.decisionCache == nil || token.Expiration().IsZero() {
+		return h.validatePolicy(ctx, token, p)
+	}
+
+	key := options.DecisionCacheKey{
+		TokenHash:        tokenHash,
+		PolicyID:         p.policyID,
+		PolicyGeneration: p.generation,
+		Audience:         p.requestAudience,
+		Path:             p.requestPath,
+	}
+
+	decision, found := h.decisionCache.Get(key)
+	if found && !decision.Allowed {
+		reason := decision.Reason
+		if reason == "" {
+			reason = options.OtherValidationFailureReason
+		}
+
+		return *new(T), &validationFailureError{reason, fmt.Errorf("token denied by cached decision for policy %q: %s", p.policyID, reason)}
+	}
+
+	if found {
+		claims, err := h.jwtTokenToClaims(ctx, token)
+		if err != nil {
+			return *new(T), fmt.Errorf("unable to convert jwt.Token to claims: %w", err)
+		}
+
+		return claims, nil
+	}
+
+	claims, err := h.validatePolicy(ctx, token, p)
+	decision = options.Decision{Allowed: err == nil}
+	if err != nil {
+		decision.Reason = GetValidationFailureReason(err)
+	}
+
+	h.decisionCache.Set(key, decision, token.Expiration())
+
+	return claims, err
+}
+
+// validatePolicy runs the validations only depending on the token and the configuration,
+// which makes it possible to store the outcome in the decision cache.
+func (h *handler[T]) validatePolicy(ctx context.Context, token jwt.Token, p policy[T]) (T, error) {
+	err := h.validateIssuerAndAudience(token, p)
+	if err != nil {
+		return *new(T), err
+	}
+
+	err = h.validateRequiredClaims(token, p)
+	if err != nil {
+		return *new(T), &validationFailureError{options.ClaimsValidationFailureReason, err}
+	}
+
+	claims, err := h.jwtTokenToClaims(ctx, token)
+	if err != nil {
+		return *new(T), fmt.Errorf("unable to convert jwt.Token to claims: %w", err)
+	}
+
+	err = validateClaims(p.claimsValidationFn, &claims)
+	if err != nil {
+		err = fmt.Errorf("claims validation returned an error: %w", err)
+		return *new(T), &validationFailureError{options.ClaimsValidationFailureReason, err}
+	}
+
+	return claims, nil
+}
+
+// validateIssuerAndAudience validates the issuer and the audience of the token, including the
+// audience scoped to the request path if RequireAudienceForRequestPath is used.
+func (h *handler[T]) validateIssuerAndAudience(token jwt.Token, p policy[T]) error {
+	validIssuer := isTokenIssuerValid(p.issuer, h.issuerAliases, token.Issuer())
+	if !validIssuer {
+		err := fmt.Errorf("required issuer %q was not found, received: %s", p.issuer, token.Issuer())
+		return &validationFailureError{options.IssuerValidationFailureReason, err}
+	}
+
+	requiredAudiences := h.getRequiredAudiences(p)
+	audience := getAudienceFromToken(token, h.audienceClaimName)
+
+	skipAudience := h.allowMissingAudience && !hasAudienceClaim(token, h.audienceClaimName)
+	if !skipAudience {
+		validAudience := isTokenAudienceValid(requiredAudiences, audience)
+		if !validAudience && len(requiredAudiences) == 1 {
+			err := fmt.Errorf("required audience %q was not found, received: %v", requiredAudiences[0], audience)
+			return &validationFailureError{options.AudienceValidationFailureReason, err}
+		}
+		if !validAudience {
+			err := fmt.Errorf("none of the required audiences %q were found, received: %v", requiredAudiences, audience)
+			return &validationFailureError{options.AudienceValidationFailureReason, err}
+		}
+	}
+
+	if h.requireAudienceForRequestPath {
+		err := validateAudienceForRequestPath(requiredAudiences, audience, p.requestPath)
+		if err != nil {
+			return &validationFailureError{options.AudienceValidationFailureReason, err}
+		}
+	}
+
+	return nil
+}
+
+// validateRequiredClaims validates the token use, roles, groups, claims and scopes required by the
+// configuration and the policy.
+func (h *handler[T]) validateRequiredClaims(token jwt.Token, p policy[T]) error {
+	if h.requiredTokenUse != "" {
+		err := validateTokenUse(h.requiredTokenUse, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(h.requiredRoles) > 0 {
+		err := h.validateRoles(token)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(h.requiredRealmRoles) > 0 {
+		err := validateRealmRoles(h.requiredRealmRoles, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(h.requiredClientRoles) > 0 {
+		err := validateClientRoles(h.requiredClientRoles, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(h.requiredGroupsAny) > 0 || len(h.requiredGroupsAll) > 0 {
+		err := validateGroups(h.requiredGroupsAny, h.requiredGroupsAll, h.groupsClaimName, h.claimNamespace, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(h.requiredClaimsPresent) > 0 {
+		err := validateClaimsPresent(h.requiredClaimsPresent, h.strictClaimsPresence, h.claimNamespace, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(h.requiredClaimsRegex) > 0 {
+		err := validateClaimsRegex(h.requiredClaimsRegex, h.strictClaimsPresence, h.claimNamespace, token)
 		if err != nil {
-			return fmt.Errorf("unable to fetch jwksUri from discoveryUri (%s): %w", h.discoveryUri, err)
+			return err
 		}
-		h.jwksUri = jwksUri
 	}
 
-	keyHandler, err := newKeyHandler(h.httpClient, h.jwksUri, h.jwksFetchTimeout, h.jwksRateLimit, h.disableKeyID)
-	if err != nil {
-		return fmt.Errorf("unable to initialize keyHandler: %w", err)
+	if len(p.requiredScopes) > 0 {
+		return validateScopes(p.requiredScopes, token)
 	}
 
-	h.keyHandler = keyHandler
-
 	return nil
 }
 
-func (h *handler[T]) SetIssuer(issuer string) {
-	h.issuer = issuer
-}
-
-func (h *handler[T]) SetDiscoveryUri(discoveryUri string) {
-	h.discoveryUri = discoveryUri
+// getTokenHash returns a hex encoded sha256 hash of the token, used to avoid storing tokens in caches.
+func getTokenHash(tokenString string) string {
+	hash := sha256.Sum256([]byte(tokenString))
+	return hex.EncodeToString(hash[:])
 }
 
-type ParseTokenFunc[T any] func(ctx context.Context, tokenString string) (T, error)
-
-func (h *handler[T]) ParseToken(ctx context.Context, tokenString string) (T, error) {
-	if h.keyHandler == nil {
-		err := h.loadJwks()
-		if err != nil {
-			return *new(T), fmt.Errorf("unable to load jwks: %w", err)
-		}
+// validateTokenHeaders validates the headers of the token before the key is looked up and returns
+// the key id (empty if DisableKeyID is used) and the signature algorithm of the token.
+func (h *handler[T]) validateTokenHeaders(tokenHeaders jws.Headers) (string, jwa.SignatureAlgorithm, error) {
+	if isNoneAlgorithm(tokenHeaders.Algorithm()) {
+		return "", "", options.ErrNoneAlgorithm
 	}
 
-	tokenHeaders, err := getHeadersFromTokenString(tokenString)
+	err := h.validateTokenType(tokenHeaders)
 	if err != nil {
-		return *new(T), err
+		return "", "", err
 	}
 
-	tokenTypeValid := isTokenTypeValid(h.requiredTokenType, tokenHeaders)
-	if !tokenTypeValid {
-		return *new(T), fmt.Errorf("token type %q required", h.requiredTokenType)
+	err = h.validateKeyIDNotBlocked(tokenHeaders.KeyID())
+	if err != nil {
+		return "", "", err
 	}
 
 	keyID := ""
 	if !h.disableKeyID {
-		var err error
 		keyID, err = getKeyIDFromTokenHeader(tokenHeaders)
 		if err != nil {
-			return *new(T), err
+			return "", "", err
 		}
 	}
 
 	tokenAlgorithm, err := getTokenAlgorithmFromTokenHeader(tokenHeaders)
 	if err != nil {
-		return *new(T), fmt.Errorf("tokenAlgorithm required: %w", err)
+		return "", "", fmt.Errorf("tokenAlgorithm required: %w", err)
 	}
 
-	key, err := h.keyHandler.getKey(ctx, keyID, tokenAlgorithm)
-	if err != nil {
-		return *new(T), fmt.Errorf("unable to get public key: %w", err)
+	tokenAlgorithmValid := isSignatureAlgorithmValid(h.allowedSignatureAlgorithms, tokenAlgorithm)
+	if !tokenAlgorithmValid {
+		return "", "", fmt.Errorf("token signature algorithm %q is not allowed", tokenAlgorithm)
+	}
+
+	return keyID, tokenAlgorithm, nil
+}
+
+// validateKey validates the type and size of the key used to verify the token and returns the
+// signature algorithm to verify the token with.
+func (h *handler[T]) validateKey(key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	keyTypeValid := isKeyTypeValid(h.allowedKeyTypes, key.KeyType())
+	if !keyTypeValid {
+		return "", fmt.Errorf("key type %q is not allowed", key.KeyType())
 	}
 
-	alg, err := getSignatureAlgorithm(key.KeyType(), key.Algorithm(), h.fallbackSignatureAlgorithm)
+	err := h.validateKeySize(key)
 	if err != nil {
-		return *new(T), err
+		return "", err
 	}
 
-	token, err := getAndValidateTokenFromString(tokenString, key, alg)
+	alg, err := getSignatureAlgorithm(key.KeyType(), getKeyCurve(key), key.Algorithm(), h.fallbackSignatureAlgorithm, h.allowES256K)
 	if err != nil {
-		if h.disableKeyID && errors.Is(err, errSignatureVerification) {
-			updatedKey, err := h.keyHandler.waitForUpdateKeySetAndGetKey(ctx)
-			if err != nil {
-				return *new(T), err
-			}
+		return "", err
+	}
 
-			alg, err := getSignatureAlgorithm(key.KeyType(), key.Algorithm(), h.fallbackSignatureAlgorithm)
-			if err != nil {
-				return *new(T), err
-			}
+	algValid := isSignatureAlgorithmValid(h.allowedSignatureAlgorithms, alg)
+	if !algValid {
+		return "", fmt.Errorf("key signature algorithm %q is not allowed", alg)
+	}
 
-			token, err = getAndValidateTokenFromString(tokenString, updatedKey, alg)
-			if err != nil {
-				return *new(T), err
-			}
-		} else {
-			return *new(T), err
-		}
+	return alg, nil
+}
+
+func (h *handler[T]) getAndVerifyTokenFromString(ctx context.Context, tokenString string, key jwk.Key,
+	alg jwa.SignatureAlgorithm) (jwt.Token, error) {
+	verifier, ok := h.verifiers[key.KeyType()]
+	if !ok || verifier == nil {
+		return getAndValidateTokenFromString(tokenString, key, alg)
 	}
 
-	validExpiration := isTokenExpirationValid(token.Expiration(), h.allowedTokenDrift)
-	if !validExpiration {
-		return *new(T), fmt.Errorf("token has expired: %s", token.Expiration())
+	return getAndVerifyTokenFromStringWithVerifier(ctx, tokenString, key, alg, verifier)
+}
+
+func validateScopes(requiredScopes []string, token jwt.Token) error {
+	scopes, err := getScopesFromToken(token)
+	if err != nil {
+		return err
 	}
 
-	validIssuer := isTokenIssuerValid(h.issuer, token.Issuer())
-	if !validIssuer {
-		return *new(T), fmt.Errorf("required issuer %q was not found, received: %s", h.issuer, token.Issuer())
+	missingScopes := GetMissingScopes(requiredScopes, scopes)
+	if len(missingScopes) > 0 {
+		return fmt.Errorf("required scopes %v were not found, received: %v", missingScopes, scopes)
 	}
 
-	validAudience := isTokenAudienceValid(h.requiredAudience, token.Audience())
-	if !validAudience {
-		return *new(T), fmt.Errorf("required audience %q was not found, received: %v", h.requiredAudience, token.Audience())
+	return nil
+}
+
+func (h *handler[T]) validateRoles(token jwt.Token) error {
+	claimValue, ok := getNamespacedClaim(token, h.rolesClaimName, h.claimNamespace)
+	if !ok {
+		return fmt.Errorf("required roles %v were not found, token does not contain claim %q", h.requiredRoles, h.rolesClaimName)
 	}
 
-	claims, err := h.jwtTokenToClaims(ctx, token)
+	roles, err := getRolesFromClaimValue(claimValue, h.rolesDelimiter)
 	if err != nil {
-		return *new(T), fmt.Errorf("unable to convert jwt.Token to claims: %w", err)
+		return fmt.Errorf("unable to get roles from claim %q: %w", h.rolesClaimName, err)
 	}
 
-	err = h.validateClaims(&claims)
-	if err != nil {
-		return *new(T), fmt.Errorf("claims validation returned an error: %w", err)
+	missingRoles := GetMissingScopes(h.requiredRoles, expandRoles(roles, h.roleHierarchy))
+	if len(missingRoles) > 0 {
+		return fmt.Errorf("required roles %v were not found, received: %v", missingRoles, roles)
 	}
 
-	return claims, nil
+	return nil
+}
+
+func (h *handler[T]) notifyIfDeprecatedKey(keyID string) {
+	if h.onDeprecatedKeyUsed == nil {
+		return
+	}
+
+	_, ok := h.deprecatedKeyIDs[keyID]
+	if !ok {
+		return
+	}
+
+	h.onDeprecatedKeyUsed(keyID)
 }
 
-func (h *handler[T]) validateClaims(claims *T) error {
-	if h.claimsValidationFn == nil {
+func validateClaims[T any](claimsValidationFn options.ClaimsValidationFn[T], claims *T) error {
+	if claimsValidationFn == nil {
 		return nil
 	}
 
-	return h.claimsValidationFn(claims)
+	return claimsValidationFn(claims)
 }
 
 func (h *handler[T]) jwtTokenToClaims(ctx context.Context, token jwt.Token) (T, error) {
+	return jwtTokenToClaims[T](ctx, token, h.claimNamespace)
+}
+
+func jwtTokenToClaims[C any](ctx context.Context, token jwt.Token, claimNamespace string) (C, error) {
 	rawClaims, err := token.AsMap(ctx)
 	if err != nil {
-		return *new(T), fmt.Errorf("unable to convert token to claims: %w", err)
+		return *new(C), fmt.Errorf("unable to convert token to claims: %w", err)
 	}
 
+	rawClaims = addClaimsWithoutNamespace(rawClaims, claimNamespace)
+
 	claimsBytes, err := json.Marshal(rawClaims)
 	if err != nil {
-		return *new(T), fmt.Errorf("unable to marshal raw claims to json: %w", err)
+		return *new(C), fmt.Errorf("unable to marshal raw claims to json: %w", err)
 	}
 
-	claims := *new(T)
+	claims := *new(C)
 	err = json.Unmarshal(claimsBytes, &claims)
 	if err != nil {
-		return *new(T), fmt.Errorf("unable to unmarshal claims from json: %w", err)
+		return *new(C), fmt.Errorf("unable to unmarshal claims from json: %w", err)
 	}
 
 	return claims, nil
 }
 
+// addClaimsWithoutNamespace adds a copy of the claims with the namespace prefix, without the prefix.
+// Claims already present in rawClaims are not overwritten.
+func addClaimsWithoutNamespace(rawClaims map[string]interface{}, namespace string) map[string]interface{} {
+	if namespace == "" {
+		return rawClaims
+	}
+
+	for key, value := range rawClaims {
+		if !strings.HasPrefix(key, namespace) {
+			continue
+		}
+
+		name := strings.TrimPrefix(key, namespace)
+		if name == "" {
+			continue
+		}
+
+		_, ok := rawClaims[name]
+		if ok {
+			continue
+		}
+
+		rawClaims[name] = value
+	}
+
+	return rawClaims
+}
+
+// getNamespacedClaim returns the claim, or the claim with the namespace prefix if the token doesn't
+// contain it, which matches the claims made available by addClaimsWithoutNamespace.
+func getNamespacedClaim(token jwt.Token, claimName string, namespace string) (interface{}, bool) {
+	claimValue, ok := token.Get(claimName)
+	if ok || namespace == "" {
+		return claimValue, ok
+	}
+
+	return token.Get(namespace + claimName)
+}
+
 func GetDiscoveryUriFromIssuer(issuer string) string {
 	return fmt.Sprintf("%s/.well-known/openid-configuration", strings.TrimSuffix(issuer, "/"))
 }
 
-func getJwksUriFromDiscoveryUri(httpClient *http.Client, discoveryUri string, fetchTimeout time.Duration) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+// GetOAuth2MetadataUriFromIssuer returns the OAuth 2.0 Authorization Server Metadata uri for the issuer.
+// As described in RFC 8414, the well-known path is inserted between the host and the path of the issuer.
+func GetOAuth2MetadataUriFromIssuer(issuer string) string {
+	issuerUrl, err := url.Parse(strings.TrimSuffix(issuer, "/"))
+	if err != nil || issuerUrl.Host == "" {
+		return fmt.Sprintf("%s/.well-known/oauth-authorization-server", strings.TrimSuffix(issuer, "/"))
+	}
+
+	issuerUrl.Path = "/.well-known/oauth-authorization-server" + issuerUrl.Path
+	issuerUrl.RawPath = ""
+
+	return issuerUrl.String()
+}
+
+// getDiscoveryData fetches the discovery document, or the RFC 8414 metadata if OAuth2MetadataDiscoveryMode is used.
+func (h *handler[T]) getDiscoveryData(ctx context.Context, discoveryUri string) (discoveryData, error) {
+	if h.discoveryMode == options.OAuth2MetadataDiscoveryMode {
+		return getOAuth2MetadataData(ctx, h.jwksHttpClient, discoveryUri, h.discoveryFetchTimeout, h.getIssuer())
+	}
+
+	return getDiscoveryData(ctx, h.jwksHttpClient, discoveryUri, h.discoveryFetchTimeout)
+}
+
+type discoveryData struct {
+	Issuer  string `json:"issuer"`
+	JwksUri string `json:"jwks_uri"`
+}
+
+func getDiscoveryData(ctx context.Context, httpClient *http.Client, discoveryUri string, fetchTimeout time.Duration) (discoveryData, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryUri, nil)
 	if err != nil {
-		return "", err
+		return discoveryData{}, err
 	}
 
 	req.Header.Set("Accept", "application/json")
 
 	res, err := httpClient.Do(req)
 	if err != nil {
-		return "", err
+		return discoveryData{}, err
 	}
 
 	bodyBytes, err := io.ReadAll(res.Body)
 	if err != nil {
-		return "", err
+		return discoveryData{}, err
 	}
 
 	err = res.Body.Close()
 	if err != nil {
-		return "", err
+		return discoveryData{}, err
+	}
+
+	var data discoveryData
+	err = json.Unmarshal(bodyBytes, &data)
+	if err != nil {
+		return discoveryData{}, err
+	}
+
+	if data.JwksUri == "" {
+		return discoveryData{}, fmt.Errorf("JwksUri is empty")
+	}
+
+	return data, nil
+}
+
+// getOAuth2MetadataData fetches the RFC 8414 metadata, which is required to contain
+// an `issuer` identical to the issuer used to create the metadata uri.
+func getOAuth2MetadataData(ctx context.Context, httpClient *http.Client, metadataUri string, fetchTimeout time.Duration,
+	issuer string) (discoveryData, error) {
+	data, err := getDiscoveryData(ctx, httpClient, metadataUri, fetchTimeout)
+	if err != nil {
+		return discoveryData{}, err
 	}
 
-	var discoveryData struct {
-		JwksUri string `json:"jwks_uri"`
+	if data.Issuer != issuer {
+		return discoveryData{}, fmt.Errorf("metadata issuer %q doesn't match the required issuer %q", data.Issuer, issuer)
 	}
 
-	err = json.Unmarshal(bodyBytes, &discoveryData)
+	return data, nil
+}
+
+func checkDuplicateClaims(tokenString string) error {
+	msg, err := jws.ParseString(tokenString)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("unable to parse tokenString: %w", err)
 	}
 
-	if discoveryData.JwksUri == "" {
-		return "", fmt.Errorf("JwksUri is empty")
+	err = checkDuplicateJsonKeys(msg.Payload())
+	if err != nil {
+		return fmt.Errorf("token payload rejected by strict claims decoding: %w", err)
 	}
 
-	return discoveryData.JwksUri, nil
+	return nil
 }
 
 func getKeyIDFromTokenHeader(headers jws.Headers) (string, error) {
@@ -295,6 +1515,11 @@ func getTokenAlgorithmFromTokenHeader(headers jws.Headers) (jwa.SignatureAlgorit
 	return algorithm, nil
 }
 
+// isNoneAlgorithm returns true if the algorithm is `none`, regardless of case.
+func isNoneAlgorithm(alg jwa.SignatureAlgorithm) bool {
+	return strings.EqualFold(alg.String(), jwa.NoSignature.String())
+}
+
 func getTokenTypeFromTokenHeader(headers jws.Headers) (string, error) {
 	tokenType := headers.Type()
 	if tokenType == "" {
@@ -320,89 +1545,351 @@ func getHeadersFromTokenString(tokenString string) (jws.Headers, error) {
 	return headers, nil
 }
 
-func isTokenAudienceValid(requiredAudience string, audiences []string) bool {
-	if requiredAudience == "" {
+func getAudienceFromToken(token jwt.Token, audienceClaimName string) []string {
+	if audienceClaimName == "" || audienceClaimName == jwt.AudienceKey {
+		return token.Audience()
+	}
+
+	audienceValue, ok := token.Get(audienceClaimName)
+	if !ok {
+		return nil
+	}
+
+	switch audience := audienceValue.(type) {
+	case string:
+		return []string{audience}
+	case []string:
+		return audience
+	case float64:
+		return getAudienceStrings([]interface{}{audience})
+	case []interface{}:
+		return getAudienceStrings(audience)
+	default:
+		return nil
+	}
+}
+
+func hasAudienceClaim(token jwt.Token, audienceClaimName string) bool {
+	if audienceClaimName == "" {
+		audienceClaimName = jwt.AudienceKey
+	}
+
+	_, ok := token.Get(audienceClaimName)
+
+	return ok
+}
+
+// getRequiredAudiences returns the audiences where at least one is required to be in the token,
+// combining RequiredAudiences with RequiredAudience. The audience required for the request replaces them.
+func (h *handler[T]) getRequiredAudiences(p policy[T]) []string {
+	if p.requestAudience != "" {
+		return []string{p.requestAudience}
+	}
+
+	if h.audienceIsIssuer {
+		return []string{p.issuer}
+	}
+
+	if p.requiredAudience == "" {
+		return h.requiredAudiences
+	}
+
+	if len(h.requiredAudiences) == 0 {
+		return []string{p.requiredAudience}
+	}
+
+	return append(append([]string(nil), h.requiredAudiences...), p.requiredAudience)
+}
+
+func isTokenAudienceValid(requiredAudiences []string, audiences []string) bool {
+	if len(requiredAudiences) == 0 {
 		return true
 	}
 
-	for _, audience := range audiences {
-		if audience == requiredAudience {
-			return true
+	for _, requiredAudience := range requiredAudiences {
+		for _, audience := range audiences {
+			if audience == requiredAudience {
+				return true
+			}
 		}
 	}
 
 	return false
 }
 
-func isTokenExpirationValid(expiration time.Time, allowedDrift time.Duration) bool {
+func isTokenExpirationValid(expiration time.Time, allowedDrift time.Duration, now time.Time) bool {
 	expirationWithAllowedDrift := expiration.Round(0).Add(allowedDrift)
 
-	return expirationWithAllowedDrift.After(time.Now())
+	return expirationWithAllowedDrift.After(now)
 }
 
-func isTokenIssuerValid(requiredIssuer string, tokenIssuer string) bool {
-	if requiredIssuer == "" {
+func isTokenIssuerValid(requiredIssuer string, issuerAliases []string, tokenIssuer string) bool {
+	if requiredIssuer == "" || tokenIssuer == "" {
 		return false
 	}
 
-	return tokenIssuer == requiredIssuer
+	if tokenIssuer == requiredIssuer {
+		return true
+	}
+
+	for _, issuerAlias := range issuerAliases {
+		if tokenIssuer == issuerAlias {
+			return true
+		}
+	}
+
+	return false
 }
 
-func isTokenTypeValid(requiredTokenType string, tokenHeaders jws.Headers) bool {
-	if requiredTokenType == "" {
+func getTimeClaimFromToken(token jwt.Token, claimName string) (time.Time, error) {
+	value, ok := token.Get(claimName)
+	if !ok {
+		return time.Time{}, fmt.Errorf("token does not contain claim %q", claimName)
+	}
+
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("token claim %q is not a valid timestamp: %w", claimName, err)
+		}
+
+		return time.Unix(i, 0), nil
+	default:
+		return time.Time{}, fmt.Errorf("token claim %q is not a valid timestamp, received type: %T", claimName, value)
+	}
+}
+
+func isTokenNonceValid(requiredNonce string, token jwt.Token) bool {
+	if requiredNonce == "" {
+		return false
+	}
+
+	nonceValue, ok := token.Get("nonce")
+	if !ok {
+		return false
+	}
+
+	nonce, ok := nonceValue.(string)
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(nonce), []byte(requiredNonce)) == 1
+}
+
+func isTokenTimeFresh(issuedAt time.Time, maxAge time.Duration, allowedDrift time.Duration, now time.Time) bool {
+	if issuedAt.IsZero() {
+		return false
+	}
+
+	if issuedAt.Round(0).After(now.Add(allowedDrift)) {
+		return false
+	}
+
+	return issuedAt.Round(0).Add(maxAge).Add(allowedDrift).After(now)
+}
+
+// validateTokenType validates the token type using the TokenTypeValidator if configured
+// and the RequiredTokenType otherwise.
+func (h *handler[T]) validateTokenType(tokenHeaders jws.Headers) error {
+	if h.tokenTypeValidator != nil {
+		err := h.tokenTypeValidator(tokenHeaders.Type())
+		if err != nil {
+			return fmt.Errorf("token type %q is not allowed: %w", tokenHeaders.Type(), err)
+		}
+
+		return nil
+	}
+
+	tokenTypeValid := isTokenTypeValid(h.requiredTokenType, h.requiredTokenTypes, h.allowMissingTokenType, tokenHeaders)
+	if !tokenTypeValid && len(h.requiredTokenTypes) == 0 {
+		return fmt.Errorf("token type %q required", h.requiredTokenType)
+	}
+	if !tokenTypeValid {
+		requiredTokenTypes := getRequiredTokenTypes(h.requiredTokenType, h.requiredTokenTypes)
+		return fmt.Errorf("token type %q isn't one of the required token types %q", tokenHeaders.Type(), requiredTokenTypes)
+	}
+
+	return nil
+}
+
+// isTokenTypeValid validates that the token type is requiredTokenType or one of requiredTokenTypes.
+// A missing token type is valid if allowMissingTokenType is true.
+func isTokenTypeValid(requiredTokenType string, requiredTokenTypes []string, allowMissingTokenType bool, tokenHeaders jws.Headers) bool {
+	if requiredTokenType == "" && len(requiredTokenTypes) == 0 {
 		return true
 	}
 
 	tokenType, err := getTokenTypeFromTokenHeader(tokenHeaders)
 	if err != nil {
-		return false
+		return allowMissingTokenType
 	}
 
-	if tokenType != requiredTokenType {
-		return false
+	if tokenType == requiredTokenType {
+		return true
+	}
+
+	for _, t := range requiredTokenTypes {
+		if tokenType == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getRequiredTokenTypes(requiredTokenType string, requiredTokenTypes []string) []string {
+	if requiredTokenType == "" {
+		return requiredTokenTypes
+	}
+
+	return append([]string{requiredTokenType}, requiredTokenTypes...)
+}
+
+func isKeyTypeValid(allowedKeyTypes []jwa.KeyType, keyType jwa.KeyType) bool {
+	if len(allowedKeyTypes) == 0 {
+		return true
+	}
+
+	for _, allowedKeyType := range allowedKeyTypes {
+		if keyType == allowedKeyType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSignatureAlgorithmValid(allowedSignatureAlgorithms []jwa.SignatureAlgorithm, alg jwa.SignatureAlgorithm) bool {
+	if len(allowedSignatureAlgorithms) == 0 {
+		return true
+	}
+
+	for _, allowedSignatureAlgorithm := range allowedSignatureAlgorithms {
+		if alg == allowedSignatureAlgorithm {
+			return true
+		}
 	}
 
-	return true
+	return false
 }
 
 func getAndValidateTokenFromString(tokenString string, key jwk.Key, alg jwa.SignatureAlgorithm) (jwt.Token, error) {
-	token, err := jwt.ParseString(tokenString, jwt.WithVerify(alg, key))
+	payload, err := jws.Verify([]byte(tokenString), alg, key)
 	if err != nil {
-		if strings.Contains(err.Error(), errSignatureVerification.Error()) {
-			return nil, errSignatureVerification
+		// jwx uses the same message as ErrSignatureVerification for invalid signatures
+		if strings.Contains(err.Error(), options.ErrSignatureVerification.Error()) {
+			return nil, options.ErrSignatureVerification
 		}
 
 		return nil, err
 	}
 
-	return token, nil
+	return parseTokenPayload(payload)
 }
 
-func getSignatureAlgorithm(kty jwa.KeyType, keyAlg string, fallbackAlg jwa.SignatureAlgorithm) (jwa.SignatureAlgorithm, error) {
-	if keyAlg != "" {
-		return getSignatureAlgorithmFromString(keyAlg)
+func getAndVerifyTokenFromStringWithVerifier(ctx context.Context, tokenString string, key jwk.Key, alg jwa.SignatureAlgorithm,
+	verifier options.Verifier) (jwt.Token, error) {
+	msg, err := jws.ParseString(tokenString)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse tokenString: %w", err)
+	}
+
+	signatures := msg.Signatures()
+	if len(signatures) != 1 {
+		return nil, fmt.Errorf("more than one signature in token")
+	}
+
+	signingInputEnd := strings.LastIndex(tokenString, ".")
+	if signingInputEnd == -1 {
+		return nil, fmt.Errorf("unable to get signing input from tokenString")
 	}
 
-	if fallbackAlg != "" {
-		return fallbackAlg, nil
+	err = verifier.Verify(ctx, []byte(tokenString[:signingInputEnd]), signatures[0].Signature(), alg.String(), key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", options.ErrSignatureVerification, err)
 	}
 
-	switch kty {
-	case jwa.RSA:
-		return jwa.RS256, nil
-	case jwa.EC:
-		return jwa.ES256, nil
+	return parseTokenPayload(msg.Payload())
+}
+
+// secp256k1Curve is defined here since jwa.Secp256k1 only exists with the jwx_es256k build tag.
+const secp256k1Curve jwa.EllipticCurveAlgorithm = "secp256k1"
+
+func getSignatureAlgorithm(kty jwa.KeyType, crv jwa.EllipticCurveAlgorithm, keyAlg string, fallbackAlg jwa.SignatureAlgorithm,
+	allowES256K bool) (jwa.SignatureAlgorithm, error) {
+	var alg jwa.SignatureAlgorithm
+	switch {
+	case keyAlg != "":
+		var err error
+		alg, err = getSignatureAlgorithmFromString(keyAlg, allowES256K)
+		if err != nil {
+			return "", err
+		}
+	case fallbackAlg != "":
+		alg = fallbackAlg
+	case kty == jwa.RSA:
+		alg = jwa.RS256
+	case kty == jwa.EC && crv == secp256k1Curve && allowES256K:
+		alg = jwa.ES256K
+	case kty == jwa.EC:
+		alg = jwa.ES256
 	default:
 		return "", fmt.Errorf("unable to get signature algorithm with kty=%s, alg=%s, fallbackAlg=%s", kty, keyAlg, fallbackAlg)
 	}
+
+	// ES256K can only be used with secp256k1 keys and secp256k1 keys only with ES256K
+	if kty == jwa.EC && (alg == jwa.ES256K) != (crv == secp256k1Curve) {
+		return "", fmt.Errorf("signature algorithm %s can't be used with curve %q", alg, crv)
+	}
+
+	return alg, nil
 }
 
-func getSignatureAlgorithmFromString(s string) (jwa.SignatureAlgorithm, error) {
+func getSignatureAlgorithmFromString(s string, allowES256K bool) (jwa.SignatureAlgorithm, error) {
 	var alg jwa.SignatureAlgorithm
 	err := alg.Accept(s)
 	if err != nil {
 		return "", err
 	}
 
+	if alg == jwa.ES256K && !allowES256K {
+		return "", fmt.Errorf("signature algorithm %s requires AllowES256K", alg)
+	}
+
 	return alg, nil
 }
+
+// getKeyCurve returns the curve (crv) of EC keys and an empty string for other key types.
+func getKeyCurve(key jwk.Key) jwa.EllipticCurveAlgorithm {
+	ecKey, ok := key.(jwk.ECDSAPublicKey)
+	if !ok {
+		return ""
+	}
+
+	return ecKey.Crv()
+}
+
+// isES256KSupported returns true if jwx has been built with the jwx_es256k build tag,
+// which registers the secp256k1 curve.
+func isES256KSupported() bool {
+	var crv jwa.EllipticCurveAlgorithm
+	return crv.Accept(secp256k1Curve.String()) == nil
+}
+
+func getKeyTypeFromString(s string) (jwa.KeyType, error) {
+	var kty jwa.KeyType
+	err := kty.Accept(s)
+	if err != nil {
+		return "", err
+	}
+
+	return kty, nil
+}
@@ -7,11 +7,18 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/xenitab/go-oidc-middleware/optest"
 	"github.com/xenitab/go-oidc-middleware/options"
 
 	"github.com/lestrrat-go/jwx/jwa"
@@ -186,756 +193,4183 @@ func TestGetTokenTypeFromTokenString(t *testing.T) {
 
 func TestIsTokenAudienceValid(t *testing.T) {
 	cases := []struct {
-		testDescription  string
-		requiredAudience string
-		tokenAudiences   []string
-		expectedResult   bool
+		testDescription   string
+		requiredAudiences []string
+		tokenAudiences    []string
+		expectedResult    bool
 	}{
 		{
-			testDescription:  "empty requiredAudience, empty tokenAudiences",
-			requiredAudience: "",
-			tokenAudiences:   []string{},
-			expectedResult:   true,
-		},
-		{
-			testDescription:  "empty requiredAudience, one tokenAudiences",
-			requiredAudience: "",
-			tokenAudiences:   []string{"foo"},
-			expectedResult:   true,
-		},
-		{
-			testDescription:  "empty requiredAudience, two tokenAudiences",
-			requiredAudience: "",
-			tokenAudiences:   []string{"foo", "bar"},
-			expectedResult:   true,
-		},
-		{
-			testDescription:  "empty requiredAudience, three tokenAudiences",
-			requiredAudience: "",
-			tokenAudiences:   []string{"foo", "bar", "baz"},
-			expectedResult:   true,
+			testDescription:   "empty requiredAudience, empty tokenAudiences",
+			requiredAudiences: nil,
+			tokenAudiences:    []string{},
+			expectedResult:    true,
 		},
 		{
-			testDescription:  "one tokenAudiences, same as requiredAudience",
-			requiredAudience: "foo",
-			tokenAudiences:   []string{"foo"},
-			expectedResult:   true,
+			testDescription:   "empty requiredAudience, one tokenAudiences",
+			requiredAudiences: nil,
+			tokenAudiences:    []string{"foo"},
+			expectedResult:    true,
 		},
 		{
-			testDescription:  "two tokenAudiences, first same as requiredAudience",
-			requiredAudience: "foo",
-			tokenAudiences:   []string{"foo", "bar"},
-			expectedResult:   true,
+			testDescription:   "empty requiredAudience, two tokenAudiences",
+			requiredAudiences: nil,
+			tokenAudiences:    []string{"foo", "bar"},
+			expectedResult:    true,
 		},
 		{
-			testDescription:  "two tokenAudiences, second same as requiredAudience",
-			requiredAudience: "bar",
-			tokenAudiences:   []string{"foo", "bar"},
-			expectedResult:   true,
+			testDescription:   "empty requiredAudience, three tokenAudiences",
+			requiredAudiences: nil,
+			tokenAudiences:    []string{"foo", "bar", "baz"},
+			expectedResult:    true,
 		},
 		{
-			testDescription:  "three tokenAudiences, third same as requiredAudience",
-			requiredAudience: "baz",
-			tokenAudiences:   []string{"foo", "bar", "baz"},
-			expectedResult:   true,
+			testDescription:   "one tokenAudiences, same as requiredAudience",
+			requiredAudiences: []string{"foo"},
+			tokenAudiences:    []string{"foo"},
+			expectedResult:    true,
 		},
 		{
-			testDescription:  "set requiredAudience, empty tokenAudiences",
-			requiredAudience: "foo",
-			tokenAudiences:   []string{},
-			expectedResult:   false,
+			testDescription:   "two tokenAudiences, first same as requiredAudience",
+			requiredAudiences: []string{"foo"},
+			tokenAudiences:    []string{"foo", "bar"},
+			expectedResult:    true,
 		},
 		{
-			testDescription:  "one tokenAudience, not same as requiredAudience",
-			requiredAudience: "foo",
-			tokenAudiences:   []string{"bar"},
-			expectedResult:   false,
+			testDescription:   "two tokenAudiences, second same as requiredAudience",
+			requiredAudiences: []string{"bar"},
+			tokenAudiences:    []string{"foo", "bar"},
+			expectedResult:    true,
 		},
 		{
-			testDescription:  "two tokenAudience, none same as requiredAudience",
-			requiredAudience: "foo",
-			tokenAudiences:   []string{"bar", "baz"},
-			expectedResult:   false,
+			testDescription:   "three tokenAudiences, third same as requiredAudience",
+			requiredAudiences: []string{"baz"},
+			tokenAudiences:    []string{"foo", "bar", "baz"},
+			expectedResult:    true,
 		},
 		{
-			testDescription:  "three tokenAudience, none same as requiredAudience",
-			requiredAudience: "foo",
-			tokenAudiences:   []string{"bar", "baz", "foobar"},
-			expectedResult:   false,
+			testDescription:   "set requiredAudience, empty tokenAudiences",
+			requiredAudiences: []string{"foo"},
+			tokenAudiences:    []string{},
+			expectedResult:    false,
 		},
-	}
-
-	for i, c := range cases {
-		t.Logf("Test iteration %d: %s", i, c.testDescription)
-		result := isTokenAudienceValid(c.requiredAudience, c.tokenAudiences)
-		require.Equal(t, c.expectedResult, result)
-	}
-}
-
-func TestTokenExpirationValid(t *testing.T) {
-	cases := []struct {
-		testDescription string
-		expiration      time.Time
-		allowedDrift    time.Duration
-		expectedResult  bool
-	}{
 		{
-			testDescription: "expires now, 50 millisecond drift allowed",
-			expiration:      time.Now(),
-			allowedDrift:    50 * time.Millisecond,
-			expectedResult:  true,
+			testDescription:   "one tokenAudience, not same as requiredAudience",
+			requiredAudiences: []string{"foo"},
+			tokenAudiences:    []string{"bar"},
+			expectedResult:    false,
 		},
 		{
-			testDescription: "expires now, 10 second drift allowed",
-			expiration:      time.Now(),
-			allowedDrift:    10 * time.Second,
-			expectedResult:  true,
+			testDescription:   "two tokenAudience, none same as requiredAudience",
+			requiredAudiences: []string{"foo"},
+			tokenAudiences:    []string{"bar", "baz"},
+			expectedResult:    false,
 		},
 		{
-			testDescription: "expires in one hour, 10 second drift allowed",
-			expiration:      time.Now().Add(1 * time.Hour),
-			allowedDrift:    10 * time.Second,
-			expectedResult:  true,
+			testDescription:   "three tokenAudience, none same as requiredAudience",
+			requiredAudiences: []string{"foo"},
+			tokenAudiences:    []string{"bar", "baz", "foobar"},
+			expectedResult:    false,
 		},
 		{
-			testDescription: "expired 5 seconds ago, 10 second drift allowed",
-			expiration:      time.Now().Add(-5 * time.Second),
-			allowedDrift:    10 * time.Second,
-			expectedResult:  true,
+			testDescription:   "two requiredAudiences, token has the second",
+			requiredAudiences: []string{"foo", "bar"},
+			tokenAudiences:    []string{"bar"},
+			expectedResult:    true,
 		},
 		{
-			testDescription: "expired 11 seconds ago, 10 second drift allowed",
-			expiration:      time.Now().Add(-11 * time.Second),
-			allowedDrift:    10 * time.Second,
-			expectedResult:  false,
+			testDescription:   "two requiredAudiences, token has both",
+			requiredAudiences: []string{"foo", "bar"},
+			tokenAudiences:    []string{"bar", "foo"},
+			expectedResult:    true,
 		},
 		{
-			testDescription: "expires now, no drift",
-			expiration:      time.Now(),
-			allowedDrift:    0,
-			expectedResult:  false,
+			testDescription:   "two requiredAudiences, one of multiple token audiences matches",
+			requiredAudiences: []string{"foo", "bar"},
+			tokenAudiences:    []string{"baz", "foobar", "bar"},
+			expectedResult:    true,
 		},
 		{
-			testDescription: "expired an hour ago, no drift",
-			expiration:      time.Now().Add(-1 * time.Hour),
-			allowedDrift:    0,
-			expectedResult:  false,
+			testDescription:   "two requiredAudiences, none of multiple token audiences matches",
+			requiredAudiences: []string{"foo", "bar"},
+			tokenAudiences:    []string{"baz", "foobar"},
+			expectedResult:    false,
 		},
 		{
-			testDescription: "expired an hour ago, 10 second drift",
-			expiration:      time.Now().Add(-1 * time.Hour),
-			allowedDrift:    10 * time.Second,
-			expectedResult:  false,
+			testDescription:   "two requiredAudiences, empty tokenAudiences",
+			requiredAudiences: []string{"foo", "bar"},
+			tokenAudiences:    []string{},
+			expectedResult:    false,
 		},
 	}
 
 	for i, c := range cases {
 		t.Logf("Test iteration %d: %s", i, c.testDescription)
-		result := isTokenExpirationValid(c.expiration, c.allowedDrift)
+		result := isTokenAudienceValid(c.requiredAudiences, c.tokenAudiences)
 		require.Equal(t, c.expectedResult, result)
 	}
 }
 
-func TestIsTokenIssuerValid(t *testing.T) {
+func TestParseTokenWithRequiredAudiences(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
 	cases := []struct {
-		testDescription string
-		requiredIssuer  string
-		tokenIssuer     string
-		expectedResult  bool
+		testDescription       string
+		requiredAudience      string
+		requiredAudiences     []string
+		tokenAudiences        []string
+		expectedErrorContains string
 	}{
 		{
-			testDescription: "both requiredIssuer and tokenIssuer are the same",
-			requiredIssuer:  "foo",
-			tokenIssuer:     "foo",
-			expectedResult:  true,
+			testDescription:       "token with one of the required audiences",
+			requiredAudiences:     []string{"https://api.foo.bar", "https://api.bar.baz"},
+			tokenAudiences:        []string{"https://api.bar.baz"},
+			expectedErrorContains: "",
 		},
 		{
-			testDescription: "requiredIssuer and tokenIssuer are not the same",
-			requiredIssuer:  "foo",
-			tokenIssuer:     "bar",
-			expectedResult:  false,
+			testDescription:       "token with multiple audiences, one required",
+			requiredAudiences:     []string{"https://api.foo.bar", "https://api.bar.baz"},
+			tokenAudiences:        []string{"https://other.foo.bar", "https://api.foo.bar"},
+			expectedErrorContains: "",
 		},
 		{
-			testDescription: "both requiredIssuer and tokenIssuer are empty",
-			requiredIssuer:  "",
-			tokenIssuer:     "",
-			expectedResult:  false,
+			testDescription:       "token with multiple audiences, none required",
+			requiredAudiences:     []string{"https://api.foo.bar", "https://api.bar.baz"},
+			tokenAudiences:        []string{"https://other.foo.bar", "https://other.bar.baz"},
+			expectedErrorContains: "none of the required audiences [\"https://api.foo.bar\" \"https://api.bar.baz\"] were found",
 		},
 		{
-			testDescription: "requiredIssuer is empty and tokenIssuer is set",
-			requiredIssuer:  "",
-			tokenIssuer:     "foo",
-			expectedResult:  false,
+			testDescription:       "required audience is added to required audiences",
+			requiredAudience:      "https://legacy.foo.bar",
+			requiredAudiences:     []string{"https://api.foo.bar"},
+			tokenAudiences:        []string{"https://legacy.foo.bar"},
+			expectedErrorContains: "",
 		},
 		{
-			testDescription: "requiredIssuer is set and tokenIssuer is empty",
-			requiredIssuer:  "foo",
-			tokenIssuer:     "",
-			expectedResult:  false,
+			testDescription:       "required audiences still accepted with required audience",
+			requiredAudience:      "https://legacy.foo.bar",
+			requiredAudiences:     []string{"https://api.foo.bar"},
+			tokenAudiences:        []string{"https://api.foo.bar"},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "neither required audience nor required audiences",
+			requiredAudience:      "https://legacy.foo.bar",
+			requiredAudiences:     []string{"https://api.foo.bar"},
+			tokenAudiences:        []string{"https://other.foo.bar"},
+			expectedErrorContains: "none of the required audiences [\"https://api.foo.bar\" \"https://legacy.foo.bar\"] were found",
+		},
+		{
+			testDescription:       "only required audience keeps the previous error",
+			requiredAudience:      "https://legacy.foo.bar",
+			tokenAudiences:        []string{"https://other.foo.bar"},
+			expectedErrorContains: "required audience \"https://legacy.foo.bar\" was not found",
 		},
 	}
 
 	for i, c := range cases {
 		t.Logf("Test iteration %d: %s", i, c.testDescription)
-		result := isTokenIssuerValid(c.requiredIssuer, c.tokenIssuer)
-		require.Equal(t, c.expectedResult, result)
+
+		h, err := NewHandler[testClaims](nil,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithRequiredAudience(c.requiredAudience),
+			options.WithRequiredAudiences(c.requiredAudiences),
+		)
+		require.NoError(t, err)
+
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{
+			"aud": c.tokenAudiences,
+		})
+
+		_, err = h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorContains(t, err, c.expectedErrorContains)
 	}
+
+	_, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithAudienceIsIssuer(true),
+		options.WithRequiredAudiences([]string{"https://api.foo.bar"}),
+	)
+	require.ErrorContains(t, err, "AudienceIsIssuer can't be used together with RequiredAudiences")
 }
 
-func TestIsTokenTypeValid(t *testing.T) {
+func TestParseTokenWithAudienceClaimName(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
 	cases := []struct {
-		testDescription   string
-		requiredTokenType string
-		tokenType         string
-		expectedResult    bool
+		testDescription       string
+		audienceClaimName     string
+		customClaims          map[string]interface{}
+		expectedErrorContains string
 	}{
 		{
-			testDescription:   "both requiredTokenType and tokenType are empty",
-			requiredTokenType: "",
-			tokenType:         "",
-			expectedResult:    true,
+			testDescription:   "default audience claim",
+			audienceClaimName: "",
+			customClaims: map[string]interface{}{
+				"aud": "https://api.foo.bar",
+			},
+			expectedErrorContains: "",
 		},
 		{
-			testDescription:   "requiredTokenType is empty and tokenType is set",
-			requiredTokenType: "",
-			tokenType:         "foo",
-			expectedResult:    true,
+			testDescription:   "custom audience claim as string",
+			audienceClaimName: "resource",
+			customClaims: map[string]interface{}{
+				"resource": "https://api.foo.bar",
+			},
+			expectedErrorContains: "",
 		},
 		{
-			testDescription:   "both requiredTokenType and tokenType are set to the same",
-			requiredTokenType: "foo",
-			tokenType:         "foo",
-			expectedResult:    true,
+			testDescription:   "custom audience claim as array",
+			audienceClaimName: "resource",
+			customClaims: map[string]interface{}{
+				"resource": []string{"https://other.foo.bar", "https://api.foo.bar"},
+			},
+			expectedErrorContains: "",
 		},
 		{
-			testDescription:   "requiredTokenType and tokenType are set to different",
-			requiredTokenType: "foo",
-			tokenType:         "bar",
-			expectedResult:    false,
+			testDescription:   "custom audience claim with wrong value",
+			audienceClaimName: "resource",
+			customClaims: map[string]interface{}{
+				"resource": "https://other.foo.bar",
+			},
+			expectedErrorContains: "required audience \"https://api.foo.bar\" was not found, received: [https://other.foo.bar]",
 		},
 		{
-			testDescription:   "requiredTokenType and tokenType are set to different but tokenType contains requiredTokenType",
-			requiredTokenType: "foo",
-			tokenType:         "foobar",
-			expectedResult:    false,
+			testDescription:   "custom audience claim ignores aud",
+			audienceClaimName: "resource",
+			customClaims: map[string]interface{}{
+				"aud": "https://api.foo.bar",
+			},
+			expectedErrorContains: "required audience \"https://api.foo.bar\" was not found, received: []",
+		},
+		{
+			testDescription:   "custom audience claim with invalid type",
+			audienceClaimName: "resource",
+			customClaims: map[string]interface{}{
+				"resource": 1234,
+			},
+			expectedErrorContains: "required audience \"https://api.foo.bar\" was not found",
 		},
 	}
 
 	for i, c := range cases {
 		t.Logf("Test iteration %d: %s", i, c.testDescription)
 
-		key, _ := testNewKey(t)
-		payload := `{"foo":"bar"}`
+		opts := []options.Option{
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithRequiredAudience("https://api.foo.bar"),
+		}
 
-		signer, err := jws.NewSigner(jwa.ES384)
+		if c.audienceClaimName != "" {
+			opts = append(opts, options.WithAudienceClaimName(c.audienceClaimName))
+		}
+
+		h, err := NewHandler[testClaims](nil, opts...)
 		require.NoError(t, err)
 
-		var signedTokenBytes []byte
-		if c.tokenType == "" {
-			signedTokenBytes, err = jws.SignMulti([]byte(payload), jws.WithSigner(signer, key, nil, nil))
-			require.NoError(t, err)
-		} else {
-			headers := jws.NewHeaders()
-			err = headers.Set(jws.TypeKey, c.tokenType)
-			require.NoError(t, err)
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims)
 
-			signedTokenBytes, err = jws.SignMulti([]byte(payload), jws.WithSigner(signer, key, nil, headers))
+		_, err = h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
 			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
 		}
-
-		token := string(signedTokenBytes)
-
-		parsedHeader, err := getHeadersFromTokenString(token)
-		require.NoError(t, err)
-
-		result := isTokenTypeValid(c.requiredTokenType, parsedHeader)
-		require.Equal(t, c.expectedResult, result)
 	}
 }
 
-func TestGetAndValidateTokenFromString(t *testing.T) {
-	op := server.NewTesting(t)
-	defer op.Close(t)
-
-	issuer := op.GetURL(t)
-	discoveryUri := GetDiscoveryUriFromIssuer(issuer)
-	jwksUri, err := getJwksUriFromDiscoveryUri(http.DefaultClient, discoveryUri, 10*time.Millisecond)
-	require.NoError(t, err)
-
-	keyHandler, err := newKeyHandler(http.DefaultClient, jwksUri, 50*time.Millisecond, 100, false)
-	require.NoError(t, err)
-
-	validKey, ok := keyHandler.getKeySet().Get(0)
-	require.True(t, ok)
+func TestParseTokenWithAudienceIsIssuer(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
 
-	validAccessToken := op.GetToken(t).AccessToken
-	require.NotEmpty(t, validAccessToken)
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
 
-	validIDToken, ok := op.GetToken(t).Extra("id_token").(string)
+	privKey, ok := privKeySet.Get(0)
 	require.True(t, ok)
-	require.NotEmpty(t, validIDToken)
-
-	invalidKey, invalidPubKey := testNewKey(t)
-
-	invalidToken := jwt.New()
-	err = invalidToken.Set("foo", "bar")
-	require.NoError(t, err)
-
-	invalidHeaders := jws.NewHeaders()
-	err = invalidHeaders.Set(jws.TypeKey, "JWT")
-	require.NoError(t, err)
 
-	invalidTokenBytes, err := jwt.Sign(invalidToken, jwa.ES384, invalidKey, jwt.WithHeaders(invalidHeaders))
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithAudienceIsIssuer(true),
+	)
 	require.NoError(t, err)
 
-	invalidSignedToken := string(invalidTokenBytes)
-
 	cases := []struct {
-		testDescription string
-		tokenString     string
-		key             jwk.Key
-		expectedError   bool
+		testDescription       string
+		customClaims          map[string]interface{}
+		expectedErrorContains string
 	}{
 		{
-			testDescription: "valid access token, valid key",
-			tokenString:     validAccessToken,
-			key:             validKey,
-			expectedError:   false,
-		},
-		{
-			testDescription: "valid id token, valid key",
-			tokenString:     validIDToken,
-			key:             validKey,
-			expectedError:   false,
-		},
-		{
-			testDescription: "empty string, valid key",
-			tokenString:     "",
-			key:             validKey,
-			expectedError:   true,
+			testDescription:       "audience is issuer",
+			customClaims:          map[string]interface{}{"aud": "http://foo.bar"},
+			expectedErrorContains: "",
 		},
 		{
-			testDescription: "random string, valid key",
-			tokenString:     "foobar",
-			key:             validKey,
-			expectedError:   true,
+			testDescription:       "issuer is one of the audiences",
+			customClaims:          map[string]interface{}{"aud": []string{"foo", "http://foo.bar"}},
+			expectedErrorContains: "",
 		},
 		{
-			testDescription: "invalid token, valid key",
-			tokenString:     invalidSignedToken,
-			key:             validKey,
-			expectedError:   true,
+			testDescription:       "audience isn't issuer",
+			customClaims:          map[string]interface{}{"aud": "foo"},
+			expectedErrorContains: "required audience \"http://foo.bar\" was not found",
 		},
 		{
-			testDescription: "invalid token, invalid key",
-			tokenString:     invalidSignedToken,
-			key:             invalidPubKey,
-			expectedError:   false,
+			testDescription:       "no audience",
+			customClaims:          nil,
+			expectedErrorContains: "required audience \"http://foo.bar\" was not found",
 		},
 	}
 
 	for i, c := range cases {
 		t.Logf("Test iteration %d: %s", i, c.testDescription)
 
-		alg, err := getSignatureAlgorithm(c.key.KeyType(), c.key.Algorithm(), jwa.ES384)
-		require.NoError(t, err)
-
-		token, err := getAndValidateTokenFromString(c.tokenString, c.key, alg)
-		if c.expectedError {
-			require.Error(t, err)
-		} else {
+		_, err := h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims))
+		if c.expectedErrorContains == "" {
 			require.NoError(t, err)
-			require.NotEmpty(t, token)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
 		}
 	}
+
+	_, err = NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithAudienceIsIssuer(true),
+		options.WithRequiredAudience("foo"),
+	)
+	require.ErrorContains(t, err, "AudienceIsIssuer can't be used together with RequiredAudience")
 }
 
-func TestParseToken(t *testing.T) {
+func TestParseTokenWithAllowMissingAudience(t *testing.T) {
 	keySets := testNewTestKeySet(t)
 	testServer := testNewJwksServer(t, keySets)
 	defer testServer.Close()
 
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
 	cases := []struct {
-		testDescription         string
-		options                 []options.Option
-		numKeys                 int
-		customIssuer            string
-		customExpirationMinutes int
-		customClaims            map[string]string
-		expectedErrorContains   string
+		testDescription       string
+		options               []options.Option
+		customClaims          map[string]interface{}
+		expectedErrorContains string
 	}{
 		{
-			testDescription: "successful parse with keyID, one key",
-			options: []options.Option{
-				options.WithIssuer("http://foo.bar"),
-				options.WithDiscoveryUri("http://foo.bar"),
-				options.WithJwksUri(testServer.URL),
-				options.WithDisableKeyID(false),
-				options.WithJwksRateLimit(100),
-			},
-			numKeys:               1,
+			testDescription:       "missing audience",
+			options:               []options.Option{options.WithRequiredAudience("foo")},
+			customClaims:          nil,
 			expectedErrorContains: "",
 		},
 		{
-			testDescription: "successful parse without keyID, one key",
+			testDescription:       "matching audience",
+			options:               []options.Option{options.WithRequiredAudience("foo")},
+			customClaims:          map[string]interface{}{"aud": "foo"},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "mismatched audience",
+			options:               []options.Option{options.WithRequiredAudience("foo")},
+			customClaims:          map[string]interface{}{"aud": "bar"},
+			expectedErrorContains: "required audience \"foo\" was not found, received: [bar]",
+		},
+		{
+			testDescription:       "missing audience with AudienceIsIssuer",
+			options:               []options.Option{options.WithAudienceIsIssuer(true)},
+			customClaims:          nil,
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "mismatched audience with AudienceIsIssuer",
+			options:               []options.Option{options.WithAudienceIsIssuer(true)},
+			customClaims:          map[string]interface{}{"aud": "bar"},
+			expectedErrorContains: "required audience \"http://foo.bar\" was not found",
+		},
+		{
+			testDescription: "missing custom audience claim",
 			options: []options.Option{
-				options.WithIssuer("http://foo.bar"),
-				options.WithDiscoveryUri("http://foo.bar"),
-				options.WithJwksUri(testServer.URL),
-				options.WithDisableKeyID(true),
-				options.WithJwksRateLimit(100),
+				options.WithRequiredAudience("foo"),
+				options.WithAudienceClaimName("azp"),
 			},
-			numKeys:               1,
+			customClaims:          map[string]interface{}{"aud": "bar"},
 			expectedErrorContains: "",
 		},
 		{
-			testDescription: "successful parse with keyID, two keys",
+			testDescription: "mismatched custom audience claim",
 			options: []options.Option{
-				options.WithIssuer("http://foo.bar"),
-				options.WithDiscoveryUri("http://foo.bar"),
-				options.WithJwksUri(testServer.URL),
-				options.WithDisableKeyID(false),
-				options.WithJwksRateLimit(100),
+				options.WithRequiredAudience("foo"),
+				options.WithAudienceClaimName("azp"),
 			},
-			numKeys:               2,
+			customClaims:          map[string]interface{}{"azp": "bar"},
+			expectedErrorContains: "required audience \"foo\" was not found",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		opts := append([]options.Option{
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithAllowMissingAudience(true),
+		}, c.options...)
+
+		h, err := NewHandler[testClaims](nil, opts...)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims))
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestParseTokenWithProfileGoogle(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	clientID := "1234567890-foo.apps.googleusercontent.com"
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.ProfileGoogle(clientID),
+		options.WithJwksUri(testServer.URL),
+	)
+	require.NoError(t, err)
+
+	googleClaims := func(issuer string, audience string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":            issuer,
+			"aud":            audience,
+			"azp":            audience,
+			"sub":            "110169484474386276334",
+			"email":          "foo@gmail.com",
+			"email_verified": true,
+			"at_hash":        "HK6E_P6Dh8Y93mRNtsDB1Q",
+		}
+	}
+
+	cases := []struct {
+		testDescription       string
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "issuer with scheme",
+			customClaims:          googleClaims("https://accounts.google.com", clientID),
 			expectedErrorContains: "",
 		},
 		{
-			// without lazyLoad, New() panics
-			testDescription: "unsuccessful parse without keyID, two keys with lazyLoad",
+			testDescription:       "issuer without scheme",
+			customClaims:          googleClaims("accounts.google.com", clientID),
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "other issuer",
+			customClaims:          googleClaims("https://foo.bar", clientID),
+			expectedErrorContains: "required issuer \"https://accounts.google.com\" was not found",
+		},
+		{
+			testDescription:       "other client id",
+			customClaims:          googleClaims("https://accounts.google.com", "foo"),
+			expectedErrorContains: "required audience \"1234567890-foo.apps.googleusercontent.com\" was not found",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		claims, err := h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims))
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			require.Equal(t, "foo@gmail.com", claims["email"])
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestParseTokenWithProfileCognito(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	issuer := "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_Foo"
+	clientID := "1example23456789"
+
+	cognitoClaims := func(clientID string, tokenUse string, groups []string) map[string]interface{} {
+		claims := map[string]interface{}{
+			"iss":        issuer,
+			"sub":        "aaaaaaaa-bbbb-cccc-dddd-example",
+			"client_id":  clientID,
+			"token_use":  tokenUse,
+			"scope":      "aws.cognito.signin.user.admin",
+			"auth_time":  time.Now().Unix(),
+			"jti":        "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee",
+			"username":   "foo",
+			"origin_jti": "aaaaaaaa-bbbb-cccc-dddd-ffffffffffff",
+		}
+
+		if groups != nil {
+			claims["cognito:groups"] = groups
+		}
+
+		return claims
+	}
+
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "access token",
+			customClaims:    cognitoClaims(clientID, "access", nil),
+		},
+		{
+			testDescription:       "access token from other client",
+			customClaims:          cognitoClaims("foo", "access", nil),
+			expectedErrorContains: "required audience \"1example23456789\" was not found",
+		},
+		{
+			testDescription:       "id token",
+			customClaims:          cognitoClaims(clientID, "id", nil),
+			expectedErrorContains: "required token_use \"access\" was not found, received: id",
+		},
+		{
+			testDescription:       "token without token_use",
+			customClaims:          map[string]interface{}{"iss": issuer, "client_id": clientID},
+			expectedErrorContains: "token does not contain claim \"token_use\"",
+		},
+		{
+			testDescription: "access token with required group",
 			options: []options.Option{
-				options.WithIssuer("http://foo.bar"),
-				options.WithDiscoveryUri("http://foo.bar"),
-				options.WithJwksUri(testServer.URL),
-				options.WithDisableKeyID(true),
-				options.WithJwksRateLimit(100),
-				options.WithLazyLoadJwks(true),
+				options.WithRequiredGroupsAny([]string{"admins"}),
 			},
-			numKeys:               2,
-			expectedErrorContains: "keyID is disabled, but received a keySet with more than one key",
+			customClaims: cognitoClaims(clientID, "access", []string{"users", "admins"}),
 		},
 		{
-			testDescription: "wrong issuer, with keyID",
+			testDescription: "access token without required group",
 			options: []options.Option{
-				options.WithIssuer("http://foo.bar"),
-				options.WithDiscoveryUri("http://foo.bar"),
-				options.WithJwksUri(testServer.URL),
-				options.WithDisableKeyID(false),
+				options.WithRequiredGroupsAny([]string{"admins"}),
 			},
-			numKeys:               1,
-			customIssuer:          "http://wrong.issuer",
-			expectedErrorContains: "required issuer \"http://foo.bar\" was not found",
+			customClaims:          cognitoClaims(clientID, "access", []string{"users"}),
+			expectedErrorContains: "none of the required groups [admins] were found",
 		},
 		{
-			testDescription: "wrong issuer, without keyID",
+			testDescription: "id token with id token use",
 			options: []options.Option{
-				options.WithIssuer("http://foo.bar"),
-				options.WithDiscoveryUri("http://foo.bar"),
+				options.WithRequiredTokenUse("id"),
+				options.WithAudienceClaimName("aud"),
+			},
+			customClaims: map[string]interface{}{"iss": issuer, "aud": clientID, "token_use": "id"},
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](
+			nil,
+			append([]options.Option{
+				options.ProfileCognito(issuer, clientID),
 				options.WithJwksUri(testServer.URL),
-				options.WithDisableKeyID(true),
+			}, c.options...)...,
+		)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims))
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestParseTokenWithRequiredScopes(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithRequiredScopes([]string{"read", "write"}),
+	)
+	require.NoError(t, err)
+
+	cases := []struct {
+		testDescription       string
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "scope string",
+			customClaims:          map[string]interface{}{"scope": "read write"},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "scp array",
+			customClaims:          map[string]interface{}{"scp": []string{"read", "write"}},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "scp string",
+			customClaims:          map[string]interface{}{"scp": "read write"},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "scopes split between scope and scp",
+			customClaims:          map[string]interface{}{"scope": "read", "scp": []string{"write"}},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "missing scope",
+			customClaims:          map[string]interface{}{"scope": "read"},
+			expectedErrorContains: "required scopes [write] were not found, received: [read]",
+		},
+		{
+			testDescription:       "missing scp",
+			customClaims:          map[string]interface{}{"scp": []string{"write"}},
+			expectedErrorContains: "required scopes [read] were not found, received: [write]",
+		},
+		{
+			testDescription:       "no scopes",
+			customClaims:          nil,
+			expectedErrorContains: "required scopes [read write] were not found",
+		},
+		{
+			testDescription:       "invalid scope claim",
+			customClaims:          map[string]interface{}{"scope": 1234},
+			expectedErrorContains: "unable to get scopes from claim \"scope\"",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		_, err := h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims))
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestParseTokenWithPolicySetters(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+	)
+	require.NoError(t, err)
+
+	tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"aud": "foo", "sub": "test", "scope": "read"})
+
+	cases := []struct {
+		testDescription       string
+		setPolicy             func()
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "no policy",
+			setPolicy:             func() {},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "missing required scope",
+			setPolicy:             func() { h.SetRequiredScopes([]string{"read", "write"}) },
+			expectedErrorContains: "required scopes [write] were not found",
+		},
+		{
+			testDescription:       "required scope",
+			setPolicy:             func() { h.SetRequiredScopes([]string{"read"}) },
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "wrong required audience",
+			setPolicy:             func() { h.SetRequiredAudience("bar") },
+			expectedErrorContains: "required audience \"bar\" was not found",
+		},
+		{
+			testDescription:       "required audience",
+			setPolicy:             func() { h.SetRequiredAudience("foo") },
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "failing claims validation",
+			setPolicy: func() {
+				h.SetClaimsValidationFn(func(claims *testClaims) error {
+					if (*claims)["sub"] != "foo" {
+						return fmt.Errorf("sub %q isn't foo", (*claims)["sub"])
+					}
+
+					return nil
+				})
 			},
-			numKeys:               1,
-			customIssuer:          "http://wrong.issuer",
-			expectedErrorContains: "required issuer \"http://foo.bar\" was not found",
+			expectedErrorContains: "claims validation returned an error",
 		},
 		{
-			testDescription: "expired token, with keyID",
-			options: []options.Option{
-				options.WithIssuer("http://foo.bar"),
-				options.WithDiscoveryUri("http://foo.bar"),
-				options.WithJwksUri(testServer.URL),
-				options.WithDisableKeyID(false),
+			testDescription: "claims validation",
+			setPolicy: func() {
+				h.SetClaimsValidationFn(func(claims *testClaims) error {
+					if (*claims)["sub"] != "test" {
+						return fmt.Errorf("sub %q isn't test", (*claims)["sub"])
+					}
+
+					return nil
+				})
+			},
+			expectedErrorContains: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		c.setPolicy()
+
+		_, err := h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+
+	require.Equal(t, "foo", h.Config().RequiredAudience)
+	require.Equal(t, []string{"read"}, h.Config().RequiredScopes)
+}
+
+func TestParseTokenWithPolicySettersConcurrently(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithRequiredAudience("foo"),
+		options.WithRequiredScopes([]string{"read"}),
+	)
+	require.NoError(t, err)
+
+	tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"aud": "foo", "scope": "read"})
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 100)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := h.ParseToken(context.Background(), tokenString)
+				errCh <- err
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		h.SetRequiredScopes([]string{"read", "write"})
+		h.SetRequiredAudience("bar")
+		h.SetPolicyID(fmt.Sprintf("policy-%d", i))
+		h.SetClaimsValidationFn(func(claims *testClaims) error { return nil })
+		h.SetRequiredScopes([]string{"read"})
+		h.SetRequiredAudience("foo")
+	}
+
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		if err != nil {
+			require.Regexp(t, "required (scopes \\[write\\] were|audience \"bar\" was) not found", err.Error())
+		}
+	}
+
+	_, err = h.ParseToken(context.Background(), tokenString)
+	require.NoError(t, err)
+}
+
+func TestParseTokenWithUnknownKeyIDAndInvalidSignature(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	foreignPrivKeySet, _ := testNewKeySet(t, 1, false)
+	foreignPrivKey, ok := foreignPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+	)
+	require.NoError(t, err)
+
+	tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, nil)
+	tokenParts := strings.Split(tokenString, ".")
+	require.Len(t, tokenParts, 3)
+
+	otherTokenParts := strings.Split(testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"sub": "foo"}), ".")
+	require.Len(t, otherTokenParts, 3)
+
+	cases := []struct {
+		testDescription string
+		tokenString     string
+		expectedErr     error
+		notExpectedErr  error
+	}{
+		{
+			testDescription: "valid token",
+			tokenString:     tokenString,
+			expectedErr:     nil,
+			notExpectedErr:  nil,
+		},
+		{
+			testDescription: "unknown key id",
+			tokenString:     testNewTokenStringWithKey(t, foreignPrivKey, jwa.ES384, nil),
+			expectedErr:     options.ErrUnknownKeyID,
+			notExpectedErr:  options.ErrSignatureVerification,
+		},
+		{
+			testDescription: "tampered payload",
+			tokenString:     strings.Join([]string{tokenParts[0], otherTokenParts[1], tokenParts[2]}, "."),
+			expectedErr:     options.ErrSignatureVerification,
+			notExpectedErr:  options.ErrUnknownKeyID,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		_, err := h.ParseToken(context.Background(), c.tokenString)
+		if c.expectedErr == nil {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorIs(t, err, c.expectedErr)
+		require.NotErrorIs(t, err, c.notExpectedErr)
+	}
+}
+
+func TestParseTokenWithClaimNamespace(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	type namespacedClaims struct {
+		Subject string   `json:"sub"`
+		Roles   []string `json:"roles"`
+	}
+
+	claimsValidationFn := func(claims *namespacedClaims) error {
+		for _, role := range claims.Roles {
+			if role == "admin" {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("role admin is required, received: %v", claims.Roles)
+	}
+
+	h, err := NewHandler(
+		claimsValidationFn,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithClaimNamespace("https://myapp.com/"),
+	)
+	require.NoError(t, err)
+
+	cases := []struct {
+		testDescription       string
+		customClaims          map[string]interface{}
+		expectedClaims        namespacedClaims
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "namespaced roles",
+			customClaims: map[string]interface{}{
+				"sub":                     "foo",
+				"https://myapp.com/roles": []string{"user", "admin"},
+			},
+			expectedClaims: namespacedClaims{
+				Subject: "foo",
+				Roles:   []string{"user", "admin"},
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "namespaced roles without required role",
+			customClaims: map[string]interface{}{
+				"sub":                     "foo",
+				"https://myapp.com/roles": []string{"user"},
+			},
+			expectedErrorContains: "role admin is required, received: [user]",
+		},
+		{
+			testDescription: "roles with other namespace",
+			customClaims: map[string]interface{}{
+				"sub":                        "foo",
+				"https://otherapp.com/roles": []string{"admin"},
+			},
+			expectedErrorContains: "role admin is required, received: []",
+		},
+		{
+			testDescription: "namespaced claim doesn't overwrite existing claim",
+			customClaims: map[string]interface{}{
+				"sub":                     "foo",
+				"https://myapp.com/sub":   "bar",
+				"https://myapp.com/roles": []string{"admin"},
+			},
+			expectedClaims: namespacedClaims{
+				Subject: "foo",
+				Roles:   []string{"admin"},
+			},
+			expectedErrorContains: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims)
+
+		claims, err := h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			require.Equal(t, c.expectedClaims, claims)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestParseTokenWithStrictClaimsDecoding(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	headers := jws.NewHeaders()
+	err := headers.Set(jws.TypeKey, "JWT")
+	require.NoError(t, err)
+
+	exp := time.Now().Add(time.Minute).Unix()
+	payload := fmt.Sprintf(`{"iss":"http://foo.bar","exp":%d,"aud":"evil","sub":"foo","aud":"api"}`, exp)
+	duplicateAudToken, err := jws.Sign([]byte(payload), jwa.ES384, privKey, jws.WithHeaders(headers))
+	require.NoError(t, err)
+
+	validToken := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"aud": "api"})
+
+	ctx := context.Background()
+
+	// without strict claims decoding, the last aud is used
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithRequiredAudience("api"),
+	)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, string(duplicateAudToken))
+	require.NoError(t, err)
+
+	// with strict claims decoding, the token is rejected
+	h, err = NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithRequiredAudience("api"),
+		options.WithStrictClaimsDecoding(true),
+	)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, string(duplicateAudToken))
+	require.EqualError(t, err, "token payload rejected by strict claims decoding: duplicate key \"aud\" in json object")
+
+	_, err = h.ParseToken(ctx, validToken)
+	require.NoError(t, err)
+}
+
+func TestTokenExpirationValid(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	cases := []struct {
+		testDescription string
+		expiration      time.Time
+		allowedDrift    time.Duration
+		expectedResult  bool
+	}{
+		{
+			testDescription: "expires now, 50 millisecond drift allowed",
+			expiration:      now,
+			allowedDrift:    50 * time.Millisecond,
+			expectedResult:  true,
+		},
+		{
+			testDescription: "expires now, 10 second drift allowed",
+			expiration:      now,
+			allowedDrift:    10 * time.Second,
+			expectedResult:  true,
+		},
+		{
+			testDescription: "expires in one hour, 10 second drift allowed",
+			expiration:      now.Add(1 * time.Hour),
+			allowedDrift:    10 * time.Second,
+			expectedResult:  true,
+		},
+		{
+			testDescription: "expired 5 seconds ago, 10 second drift allowed",
+			expiration:      now.Add(-5 * time.Second),
+			allowedDrift:    10 * time.Second,
+			expectedResult:  true,
+		},
+		{
+			testDescription: "expired 11 seconds ago, 10 second drift allowed",
+			expiration:      now.Add(-11 * time.Second),
+			allowedDrift:    10 * time.Second,
+			expectedResult:  false,
+		},
+		{
+			testDescription: "expires now, no drift",
+			expiration:      now,
+			allowedDrift:    0,
+			expectedResult:  false,
+		},
+		{
+			testDescription: "expired an hour ago, no drift",
+			expiration:      now.Add(-1 * time.Hour),
+			allowedDrift:    0,
+			expectedResult:  false,
+		},
+		{
+			testDescription: "expired an hour ago, 10 second drift",
+			expiration:      now.Add(-1 * time.Hour),
+			allowedDrift:    10 * time.Second,
+			expectedResult:  false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+		result := isTokenExpirationValid(c.expiration, c.allowedDrift, now)
+		require.Equal(t, c.expectedResult, result)
+	}
+}
+
+func TestIsTokenIssuerValid(t *testing.T) {
+	cases := []struct {
+		testDescription string
+		requiredIssuer  string
+		issuerAliases   []string
+		tokenIssuer     string
+		expectedResult  bool
+	}{
+		{
+			testDescription: "both requiredIssuer and tokenIssuer are the same",
+			requiredIssuer:  "foo",
+			tokenIssuer:     "foo",
+			expectedResult:  true,
+		},
+		{
+			testDescription: "requiredIssuer and tokenIssuer are not the same",
+			requiredIssuer:  "foo",
+			tokenIssuer:     "bar",
+			expectedResult:  false,
+		},
+		{
+			testDescription: "both requiredIssuer and tokenIssuer are empty",
+			requiredIssuer:  "",
+			tokenIssuer:     "",
+			expectedResult:  false,
+		},
+		{
+			testDescription: "requiredIssuer is empty and tokenIssuer is set",
+			requiredIssuer:  "",
+			tokenIssuer:     "foo",
+			expectedResult:  false,
+		},
+		{
+			testDescription: "requiredIssuer is set and tokenIssuer is empty",
+			requiredIssuer:  "foo",
+			tokenIssuer:     "",
+			expectedResult:  false,
+		},
+		{
+			testDescription: "tokenIssuer is an issuer alias",
+			requiredIssuer:  "https://foo",
+			issuerAliases:   []string{"foo"},
+			tokenIssuer:     "foo",
+			expectedResult:  true,
+		},
+		{
+			testDescription: "tokenIssuer isn't an issuer alias",
+			requiredIssuer:  "https://foo",
+			issuerAliases:   []string{"foo"},
+			tokenIssuer:     "bar",
+			expectedResult:  false,
+		},
+		{
+			testDescription: "tokenIssuer and issuer alias are empty",
+			requiredIssuer:  "foo",
+			issuerAliases:   []string{""},
+			tokenIssuer:     "",
+			expectedResult:  false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+		result := isTokenIssuerValid(c.requiredIssuer, c.issuerAliases, c.tokenIssuer)
+		require.Equal(t, c.expectedResult, result)
+	}
+}
+
+func TestIsTokenTypeValid(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		requiredTokenType     string
+		requiredTokenTypes    []string
+		allowMissingTokenType bool
+		tokenType             string
+		expectedResult        bool
+	}{
+		{
+			testDescription:   "both requiredTokenType and tokenType are empty",
+			requiredTokenType: "",
+			tokenType:         "",
+			expectedResult:    true,
+		},
+		{
+			testDescription:   "requiredTokenType is empty and tokenType is set",
+			requiredTokenType: "",
+			tokenType:         "foo",
+			expectedResult:    true,
+		},
+		{
+			testDescription:   "both requiredTokenType and tokenType are set to the same",
+			requiredTokenType: "foo",
+			tokenType:         "foo",
+			expectedResult:    true,
+		},
+		{
+			testDescription:   "requiredTokenType and tokenType are set to different",
+			requiredTokenType: "foo",
+			tokenType:         "bar",
+			expectedResult:    false,
+		},
+		{
+			testDescription:   "requiredTokenType and tokenType are set to different but tokenType contains requiredTokenType",
+			requiredTokenType: "foo",
+			tokenType:         "foobar",
+			expectedResult:    false,
+		},
+		{
+			testDescription:   "requiredTokenType is set and tokenType is missing",
+			requiredTokenType: "foo",
+			tokenType:         "",
+			expectedResult:    false,
+		},
+		{
+			testDescription:       "requiredTokenType is set and tokenType is missing but allowed to be missing",
+			requiredTokenType:     "foo",
+			allowMissingTokenType: true,
+			tokenType:             "",
+			expectedResult:        true,
+		},
+		{
+			testDescription:       "requiredTokenType and tokenType are set to different and missing tokenType is allowed",
+			requiredTokenType:     "foo",
+			allowMissingTokenType: true,
+			tokenType:             "bar",
+			expectedResult:        false,
+		},
+		{
+			testDescription:    "tokenType is one of requiredTokenTypes",
+			requiredTokenTypes: []string{"foo", "bar"},
+			tokenType:          "bar",
+			expectedResult:     true,
+		},
+		{
+			testDescription:    "tokenType is requiredTokenType when requiredTokenTypes is set",
+			requiredTokenType:  "baz",
+			requiredTokenTypes: []string{"foo", "bar"},
+			tokenType:          "baz",
+			expectedResult:     true,
+		},
+		{
+			testDescription:    "tokenType isn't one of requiredTokenTypes",
+			requiredTokenTypes: []string{"foo", "bar"},
+			tokenType:          "baz",
+			expectedResult:     false,
+		},
+		{
+			testDescription:    "requiredTokenTypes is set and tokenType is missing",
+			requiredTokenTypes: []string{"foo", "bar"},
+			tokenType:          "",
+			expectedResult:     false,
+		},
+		{
+			testDescription:       "requiredTokenTypes is set and tokenType is missing but allowed to be missing",
+			requiredTokenTypes:    []string{"foo", "bar"},
+			allowMissingTokenType: true,
+			tokenType:             "",
+			expectedResult:        true,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		key, _ := testNewKey(t)
+		payload := `{"foo":"bar"}`
+
+		signer, err := jws.NewSigner(jwa.ES384)
+		require.NoError(t, err)
+
+		var signedTokenBytes []byte
+		if c.tokenType == "" {
+			signedTokenBytes, err = jws.SignMulti([]byte(payload), jws.WithSigner(signer, key, nil, nil))
+			require.NoError(t, err)
+		} else {
+			headers := jws.NewHeaders()
+			err = headers.Set(jws.TypeKey, c.tokenType)
+			require.NoError(t, err)
+
+			signedTokenBytes, err = jws.SignMulti([]byte(payload), jws.WithSigner(signer, key, nil, headers))
+			require.NoError(t, err)
+		}
+
+		token := string(signedTokenBytes)
+
+		parsedHeader, err := getHeadersFromTokenString(token)
+		require.NoError(t, err)
+
+		result := isTokenTypeValid(c.requiredTokenType, c.requiredTokenTypes, c.allowMissingTokenType, parsedHeader)
+		require.Equal(t, c.expectedResult, result)
+	}
+}
+
+func TestValidateTokenTypeWithRequiredTokenTypes(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		tokenType             string
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "token type matching RequiredTokenType",
+			options:         []options.Option{options.WithRequiredTokenType("JWT")},
+			tokenType:       "JWT",
+		},
+		{
+			testDescription:       "missing token type with RequiredTokenType",
+			options:               []options.Option{options.WithRequiredTokenType("JWT")},
+			tokenType:             "",
+			expectedErrorContains: "token type \"JWT\" required",
+		},
+		{
+			testDescription: "missing token type with RequiredTokenType and AllowMissingTokenType",
+			options:         []options.Option{options.WithRequiredTokenType("JWT"), options.WithAllowMissingTokenType(true)},
+			tokenType:       "",
+		},
+		{
+			testDescription:       "other token type with RequiredTokenType and AllowMissingTokenType",
+			options:               []options.Option{options.WithRequiredTokenType("JWT"), options.WithAllowMissingTokenType(true)},
+			tokenType:             "id+jwt",
+			expectedErrorContains: "token type \"JWT\" required",
+		},
+		{
+			testDescription: "token type matching one of RequiredTokenTypes",
+			options:         []options.Option{options.WithRequiredTokenType("JWT"), options.WithRequiredTokenTypes([]string{"at+jwt"})},
+			tokenType:       "at+jwt",
+		},
+		{
+			testDescription:       "other token type with RequiredTokenTypes",
+			options:               []options.Option{options.WithRequiredTokenType("JWT"), options.WithRequiredTokenTypes([]string{"at+jwt"})},
+			tokenType:             "id+jwt",
+			expectedErrorContains: "token type \"id+jwt\" isn't one of the required token types [\"JWT\" \"at+jwt\"]",
+		},
+		{
+			testDescription:       "missing token type with RequiredTokenTypes",
+			options:               []options.Option{options.WithRequiredTokenTypes([]string{"JWT", "at+jwt"})},
+			tokenType:             "",
+			expectedErrorContains: "token type \"\" isn't one of the required token types [\"JWT\" \"at+jwt\"]",
+		},
+		{
+			testDescription: "missing token type with RequiredTokenTypes and AllowMissingTokenType",
+			options:         []options.Option{options.WithRequiredTokenTypes([]string{"JWT", "at+jwt"}), options.WithAllowMissingTokenType(true)},
+			tokenType:       "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](nil, append(c.options, options.WithIssuer("http://foo.bar"), options.WithAllowInsecureIssuer(true), options.WithLazyLoadJwks(true))...)
+		require.NoError(t, err)
+
+		headers := jws.NewHeaders()
+		if c.tokenType != "" {
+			err = headers.Set(jws.TypeKey, c.tokenType)
+			require.NoError(t, err)
+		}
+
+		err = h.validateTokenType(headers)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorContains(t, err, c.expectedErrorContains)
+	}
+}
+
+func TestParseTokenWithTokenTypeValidator(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	var mu sync.Mutex
+	allowedTokenTypes := map[string]bool{"at+jwt": true}
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithRequiredTokenType("JWT"),
+		options.WithTokenTypeValidator(func(typ string) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if !allowedTokenTypes[strings.ToLower(typ)] {
+				return fmt.Errorf("token type isn't one of the allowed token types")
+			}
+
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+
+	newTokenStringWithType := func(tokenType string) string {
+		t.Helper()
+
+		jwtToken := jwt.New()
+		err := jwtToken.Set(jwt.IssuerKey, "http://foo.bar")
+		require.NoError(t, err)
+
+		err = jwtToken.Set(jwt.ExpirationKey, time.Now().Add(time.Minute).Unix())
+		require.NoError(t, err)
+
+		if tokenType == "" {
+			// jwt.Sign sets the token type to JWT if it's missing
+			payload, err := json.Marshal(jwtToken)
+			require.NoError(t, err)
+
+			tokenBytes, err := jws.Sign(payload, jwa.ES384, privKey)
+			require.NoError(t, err)
+
+			return string(tokenBytes)
+		}
+
+		headers := jws.NewHeaders()
+		err = headers.Set(jws.TypeKey, tokenType)
+		require.NoError(t, err)
+
+		tokenBytes, err := jwt.Sign(jwtToken, jwa.ES384, privKey, jwt.WithHeaders(headers))
+		require.NoError(t, err)
+
+		return string(tokenBytes)
+	}
+
+	cases := []struct {
+		testDescription       string
+		allowedTokenTypes     []string
+		tokenType             string
+		expectedErrorContains string
+	}{
+		{
+			testDescription:   "allowed token type",
+			allowedTokenTypes: []string{"at+jwt"},
+			tokenType:         "at+jwt",
+		},
+		{
+			testDescription:   "allowed token type is case insensitive in the validator",
+			allowedTokenTypes: []string{"at+jwt"},
+			tokenType:         "AT+JWT",
+		},
+		{
+			testDescription:       "RequiredTokenType is superseded",
+			allowedTokenTypes:     []string{"at+jwt"},
+			tokenType:             "JWT",
+			expectedErrorContains: "token type \"JWT\" is not allowed: token type isn't one of the allowed token types",
+		},
+		{
+			testDescription:       "missing token type",
+			allowedTokenTypes:     []string{"at+jwt"},
+			tokenType:             "",
+			expectedErrorContains: "token type \"\" is not allowed",
+		},
+		{
+			testDescription:   "rotated set allows new token type",
+			allowedTokenTypes: []string{"at+jwt", "logout+jwt", "vnd.foo+jwt"},
+			tokenType:         "logout+jwt",
+		},
+		{
+			testDescription:   "rotated set allows vendor token type",
+			allowedTokenTypes: []string{"at+jwt", "logout+jwt", "vnd.foo+jwt"},
+			tokenType:         "vnd.foo+jwt",
+		},
+		{
+			testDescription:       "rotated set no longer allows token type",
+			allowedTokenTypes:     []string{"logout+jwt"},
+			tokenType:             "at+jwt",
+			expectedErrorContains: "token type \"at+jwt\" is not allowed",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		mu.Lock()
+		allowedTokenTypes = make(map[string]bool, len(c.allowedTokenTypes))
+		for _, tokenType := range c.allowedTokenTypes {
+			allowedTokenTypes[tokenType] = true
+		}
+		mu.Unlock()
+
+		_, err := h.ParseToken(context.Background(), newTokenStringWithType(c.tokenType))
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorContains(t, err, c.expectedErrorContains)
+	}
+}
+
+func TestIsKeyTypeValid(t *testing.T) {
+	cases := []struct {
+		testDescription string
+		allowedKeyTypes []jwa.KeyType
+		keyType         jwa.KeyType
+		expectedResult  bool
+	}{
+		{
+			testDescription: "empty allowedKeyTypes, EC key",
+			allowedKeyTypes: nil,
+			keyType:         jwa.EC,
+			expectedResult:  true,
+		},
+		{
+			testDescription: "empty allowedKeyTypes, RSA key",
+			allowedKeyTypes: nil,
+			keyType:         jwa.RSA,
+			expectedResult:  true,
+		},
+		{
+			testDescription: "EC allowed, EC key",
+			allowedKeyTypes: []jwa.KeyType{jwa.EC},
+			keyType:         jwa.EC,
+			expectedResult:  true,
+		},
+		{
+			testDescription: "EC allowed, RSA key",
+			allowedKeyTypes: []jwa.KeyType{jwa.EC},
+			keyType:         jwa.RSA,
+			expectedResult:  false,
+		},
+		{
+			testDescription: "EC and RSA allowed, RSA key",
+			allowedKeyTypes: []jwa.KeyType{jwa.EC, jwa.RSA},
+			keyType:         jwa.RSA,
+			expectedResult:  true,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+		result := isKeyTypeValid(c.allowedKeyTypes, c.keyType)
+		require.Equal(t, c.expectedResult, result)
+	}
+}
+
+func TestGetAndValidateTokenFromString(t *testing.T) {
+	op := server.NewTesting(t)
+	defer op.Close(t)
+
+	issuer := op.GetURL(t)
+	discoveryUri := GetDiscoveryUriFromIssuer(issuer)
+	discovery, err := getDiscoveryData(context.Background(), http.DefaultClient, discoveryUri, 10*time.Millisecond)
+	require.NoError(t, err)
+	jwksUri := discovery.JwksUri
+
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: jwksUri, fetchTimeout: 50 * time.Millisecond, keyUpdateRPS: 100})
+	require.NoError(t, err)
+
+	validKey, ok := keyHandler.getKeySet().Get(0)
+	require.True(t, ok)
+
+	validAccessToken := op.GetToken(t).AccessToken
+	require.NotEmpty(t, validAccessToken)
+
+	validIDToken, ok := op.GetToken(t).Extra("id_token").(string)
+	require.True(t, ok)
+	require.NotEmpty(t, validIDToken)
+
+	invalidKey, invalidPubKey := testNewKey(t)
+
+	invalidToken := jwt.New()
+	err = invalidToken.Set("foo", "bar")
+	require.NoError(t, err)
+
+	invalidHeaders := jws.NewHeaders()
+	err = invalidHeaders.Set(jws.TypeKey, "JWT")
+	require.NoError(t, err)
+
+	invalidTokenBytes, err := jwt.Sign(invalidToken, jwa.ES384, invalidKey, jwt.WithHeaders(invalidHeaders))
+	require.NoError(t, err)
+
+	invalidSignedToken := string(invalidTokenBytes)
+
+	cases := []struct {
+		testDescription string
+		tokenString     string
+		key             jwk.Key
+		expectedError   bool
+	}{
+		{
+			testDescription: "valid access token, valid key",
+			tokenString:     validAccessToken,
+			key:             validKey,
+			expectedError:   false,
+		},
+		{
+			testDescription: "valid id token, valid key",
+			tokenString:     validIDToken,
+			key:             validKey,
+			expectedError:   false,
+		},
+		{
+			testDescription: "empty string, valid key",
+			tokenString:     "",
+			key:             validKey,
+			expectedError:   true,
+		},
+		{
+			testDescription: "random string, valid key",
+			tokenString:     "foobar",
+			key:             validKey,
+			expectedError:   true,
+		},
+		{
+			testDescription: "invalid token, valid key",
+			tokenString:     invalidSignedToken,
+			key:             validKey,
+			expectedError:   true,
+		},
+		{
+			testDescription: "invalid token, invalid key",
+			tokenString:     invalidSignedToken,
+			key:             invalidPubKey,
+			expectedError:   false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		alg, err := getSignatureAlgorithm(c.key.KeyType(), getKeyCurve(c.key), c.key.Algorithm(), jwa.ES384, false)
+		require.NoError(t, err)
+
+		token, err := getAndValidateTokenFromString(c.tokenString, c.key, alg)
+		if c.expectedError {
+			require.Error(t, err)
+		} else {
+			require.NoError(t, err)
+			require.NotEmpty(t, token)
+		}
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	cases := []struct {
+		testDescription         string
+		options                 []options.Option
+		numKeys                 int
+		customIssuer            string
+		customExpirationMinutes int
+		customClaims            map[string]string
+		expectedErrorContains   string
+	}{
+		{
+			testDescription: "successful parse with keyID, one key",
+			options: []options.Option{
+				options.WithIssuer("http://foo.bar"),
+				options.WithAllowInsecureIssuer(true),
+				options.WithDiscoveryUri("http://foo.bar"),
+				options.WithJwksUri(testServer.URL),
+				options.WithDisableKeyID(false),
+				options.WithJwksRateLimit(100),
+			},
+			numKeys:               1,
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "successful parse without keyID, one key",
+			options: []options.Option{
+				options.WithIssuer("http://foo.bar"),
+				options.WithAllowInsecureIssuer(true),
+				options.WithDiscoveryUri("http://foo.bar"),
+				options.WithJwksUri(testServer.URL),
+				options.WithDisableKeyID(true),
+				options.WithJwksRateLimit(100),
+			},
+			numKeys:               1,
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "successful parse with keyID, two keys",
+			options: []options.Option{
+				options.WithIssuer("http://foo.bar"),
+				options.WithAllowInsecureIssuer(true),
+				options.WithDiscoveryUri("http://foo.bar"),
+				options.WithJwksUri(testServer.URL),
+				options.WithDisableKeyID(false),
+				options.WithJwksRateLimit(100),
+			},
+			numKeys:               2,
+			expectedErrorContains: "",
+		},
+		{
+			// without lazyLoad, New() panics
+			testDescription: "unsuccessful parse without keyID, two keys with lazyLoad",
+			options: []options.Option{
+				options.WithIssuer("http://foo.bar"),
+				options.WithAllowInsecureIssuer(true),
+				options.WithDiscoveryUri("http://foo.bar"),
+				options.WithJwksUri(testServer.URL),
+				options.WithDisableKeyID(true),
+				options.WithJwksRateLimit(100),
+				options.WithLazyLoadJwks(true),
+			},
+			numKeys:               2,
+			expectedErrorContains: "keyID is disabled, but received a keySet with more than one key",
+		},
+		{
+			testDescription: "wrong issuer, with keyID",
+			options: []options.Option{
+				options.WithIssuer("http://foo.bar"),
+				options.WithAllowInsecureIssuer(true),
+				options.WithDiscoveryUri("http://foo.bar"),
+				options.WithJwksUri(testServer.URL),
+				options.WithDisableKeyID(false),
+			},
+			numKeys:               1,
+			customIssuer:          "http://wrong.issuer",
+			expectedErrorContains: "required issuer \"http://foo.bar\" was not found",
+		},
+		{
+			testDescription: "wrong issuer, without keyID",
+			options: []options.Option{
+				options.WithIssuer("http://foo.bar"),
+				options.WithAllowInsecureIssuer(true),
+				options.WithDiscoveryUri("http://foo.bar"),
+				options.WithJwksUri(testServer.URL),
+				options.WithDisableKeyID(true),
+			},
+			numKeys:               1,
+			customIssuer:          "http://wrong.issuer",
+			expectedErrorContains: "required issuer \"http://foo.bar\" was not found",
+		},
+		{
+			testDescription: "expired token, with keyID",
+			options: []options.Option{
+				options.WithIssuer("http://foo.bar"),
+				options.WithAllowInsecureIssuer(true),
+				options.WithDiscoveryUri("http://foo.bar"),
+				options.WithJwksUri(testServer.URL),
+				options.WithDisableKeyID(false),
+			},
+			numKeys:                 1,
+			customExpirationMinutes: -1,
+			expectedErrorContains:   "token has expired",
+		},
+		{
+			testDescription: "expired token, without keyID",
+			options: []options.Option{
+				options.WithIssuer("http://foo.bar"),
+				options.WithAllowInsecureIssuer(true),
+				options.WithDiscoveryUri("http://foo.bar"),
+				options.WithJwksUri(testServer.URL),
+				options.WithDisableKeyID(true),
+			},
+			numKeys:                 1,
+			customExpirationMinutes: -1,
+			expectedErrorContains:   "token has expired",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		opts := &options.Options{}
+
+		for _, setter := range c.options {
+			setter(opts)
+		}
+
+		keySets.setKeys(testNewKeySet(t, c.numKeys, opts.DisableKeyID))
+
+		h, err := NewHandler[testClaims](nil, c.options...)
+		require.NoError(t, err)
+
+		parseTokenFunc := h.ParseToken
+
+		issuer := opts.Issuer
+		if c.customIssuer != "" {
+			issuer = c.customIssuer
+		}
+
+		expirationMinutes := 1
+		if c.customExpirationMinutes != 0 {
+			expirationMinutes = c.customExpirationMinutes
+		}
+
+		customClaims := make(map[string]string)
+		customClaims["foo"] = "bar"
+		if c.customClaims != nil {
+			customClaims = c.customClaims
+		}
+
+		token := testNewCustomTokenString(t, keySets.privateKeySet, issuer, expirationMinutes, customClaims)
+
+		ctx := context.Background()
+
+		_, err = parseTokenFunc(ctx, token)
+
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.Contains(t, err.Error(), c.expectedErrorContains)
+		}
+	}
+}
+
+func TestParseTokenWithKeyID(t *testing.T) {
+	disableKeyID := false
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+
+	opts := []options.Option{
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithDiscoveryUri("http://foo.bar"),
+		options.WithJwksUri(testServer.URL),
+		options.WithDisableKeyID(disableKeyID),
+		options.WithJwksRateLimit(100),
+	}
+
+	h, err := NewHandler[testClaims](nil, opts...)
+	require.NoError(t, err)
+
+	parseTokenFunc := h.ParseToken
+
+	// first token should succeed
+	token1 := testNewTokenString(t, keySets.privateKeySet)
+
+	ctx := context.Background()
+
+	_, err = parseTokenFunc(ctx, token1)
+	require.NoError(t, err)
+
+	// second token should succeed, rotation successful
+	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+
+	token2 := testNewTokenString(t, keySets.privateKeySet)
+
+	_, err = parseTokenFunc(ctx, token2)
+	require.NoError(t, err)
+
+	// after rotation, first token should fail
+	_, err = parseTokenFunc(ctx, token1)
+	require.Error(t, err)
+
+	// third token should succeed with two keys
+	keySets.setKeys(testNewKeySet(t, 2, disableKeyID))
+
+	token3 := testNewTokenString(t, keySets.privateKeySet)
+
+	_, err = parseTokenFunc(ctx, token3)
+	require.NoError(t, err)
+
+	// fourth token should fail since they token doesn't contain keyID
+	keySets.setKeys(testNewKeySet(t, 1, true))
+
+	token4 := testNewTokenString(t, keySets.privateKeySet)
+
+	_, err = parseTokenFunc(ctx, token4)
+	require.Error(t, err)
+
+	// fifth token should fail since it's the wrong key but correct keyID
+	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+	currentPrivateKey, found := keySets.privateKeySet.Get(0)
+	require.True(t, found)
+
+	currentKeyID := currentPrivateKey.KeyID()
+	invalidPrivKey, _ := testNewKey(t)
+
+	err = invalidPrivKey.Set(jwk.KeyIDKey, currentKeyID)
+	require.NoError(t, err)
+
+	invalidKeySet := jwk.NewSet()
+	invalidKeySet.Add(invalidPrivKey)
+
+	token5 := testNewTokenString(t, invalidKeySet)
+
+	_, err = parseTokenFunc(ctx, token5)
+	require.ErrorIs(t, err, options.ErrSignatureVerification)
+
+	// sixth token should fail since the jwks can't be refreshed
+	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+
+	token6 := testNewTokenString(t, keySets.privateKeySet)
+
+	testServer.Close()
+
+	_, err = parseTokenFunc(ctx, token6)
+	require.Error(t, err)
+}
+
+func TestParseTokenWithoutKeyID(t *testing.T) {
+	disableKeyID := true
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+
+	opts := []options.Option{
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithDiscoveryUri("http://foo.bar"),
+		options.WithJwksUri(testServer.URL),
+		options.WithDisableKeyID(disableKeyID),
+		options.WithJwksRateLimit(100),
+	}
+
+	h, err := NewHandler[testClaims](nil, opts...)
+	require.NoError(t, err)
+
+	parseTokenFunc := h.ParseToken
+
+	// first token should succeed
+	token1 := testNewTokenString(t, keySets.privateKeySet)
+
+	ctx := context.Background()
+
+	_, err = parseTokenFunc(ctx, token1)
+	require.NoError(t, err)
+
+	// second token should succeed, with key rotation
+	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+
+	token2 := testNewTokenString(t, keySets.privateKeySet)
+
+	_, err = parseTokenFunc(ctx, token2)
+	require.NoError(t, err)
+
+	// after rotation, first token should fail
+	_, err = parseTokenFunc(ctx, token1)
+	require.Error(t, err)
+
+	// third token should fail since there are two keys present
+	keySets.setKeys(testNewKeySet(t, 2, disableKeyID))
+
+	token3 := testNewTokenString(t, keySets.privateKeySet)
+
+	_, err = parseTokenFunc(ctx, token3)
+	require.Error(t, err)
+
+	// fourth token should fail since the jwks can't be refreshed
+	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+
+	token4 := testNewTokenString(t, keySets.privateKeySet)
+
+	testServer.Close()
+
+	_, err = parseTokenFunc(ctx, token4)
+	require.Error(t, err)
+}
+
+func TestGetAndValidateTokenFromStringWithKeyID(t *testing.T) {
+	disableKeyID := false
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, disableKeyID: disableKeyID})
+	require.NoError(t, err)
+
+	token1 := testNewTokenString(t, keySets.privateKeySet)
+
+	parsedHeaders1, err := getHeadersFromTokenString(token1)
+	require.NoError(t, err)
+
+	keyID, err := getKeyIDFromTokenHeader(parsedHeaders1)
+	require.NoError(t, err)
+
+	tokenAlgorithm, err := getTokenAlgorithmFromTokenHeader(parsedHeaders1)
+	require.NoError(t, err)
+
+	pubKey, err := keyHandler.getKey(context.Background(), keyID, tokenAlgorithm)
+	require.NoError(t, err)
+
+	alg, err := getSignatureAlgorithm(pubKey.KeyType(), getKeyCurve(pubKey), pubKey.Algorithm(), jwa.ES384, false)
+	require.NoError(t, err)
+
+	_, err = getAndValidateTokenFromString(token1, pubKey, alg)
+	require.NoError(t, err)
+
+	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+
+	token2 := testNewTokenString(t, keySets.privateKeySet)
+
+	_, err = getAndValidateTokenFromString(token2, pubKey, alg)
+	require.Error(t, err)
+}
+
+func TestGetAndValidateTokenFromStringWithoutKeyID(t *testing.T) {
+	disableKeyID := true
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+
+	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+
+	keyHandler, err := newKeyHandler(keyHandlerConfig{httpClient: http.DefaultClient, jwksUri: testServer.URL, fetchTimeout: 10 * time.Millisecond, keyUpdateRPS: 100, disableKeyID: disableKeyID})
+	require.NoError(t, err)
+
+	token1 := testNewTokenString(t, keySets.privateKeySet)
+
+	parsedHeaders1, err := getHeadersFromTokenString(token1)
+	require.NoError(t, err)
+
+	tokenAlgorithm, err := getTokenAlgorithmFromTokenHeader(parsedHeaders1)
+	require.NoError(t, err)
+
+	pubKey, err := keyHandler.getKey(context.Background(), "", tokenAlgorithm)
+	require.NoError(t, err)
+
+	alg, err := getSignatureAlgorithm(pubKey.KeyType(), getKeyCurve(pubKey), pubKey.Algorithm(), jwa.ES384, false)
+	require.NoError(t, err)
+
+	_, err = getAndValidateTokenFromString(token1, pubKey, alg)
+	require.NoError(t, err)
+
+	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+
+	token2 := testNewTokenString(t, keySets.privateKeySet)
+
+	_, err = getAndValidateTokenFromString(token2, pubKey, alg)
+	require.ErrorIs(t, err, options.ErrSignatureVerification)
+}
+
+func TestParseTokenWithAllowedKeyTypes(t *testing.T) {
+	ecPrivKey, ecPubKey := testNewKey(t)
+	rsaPrivKey, rsaPubKey, _ := testDuplicateKey(t)
+
+	keySets := testNewTestKeySet(t)
+	privKeySet := jwk.NewSet()
+	privKeySet.Add(ecPrivKey)
+	privKeySet.Add(rsaPrivKey)
+	pubKeySet := jwk.NewSet()
+	pubKeySet.Add(ecPubKey)
+	pubKeySet.Add(rsaPubKey)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	ecToken := testNewTokenStringWithKey(t, ecPrivKey, jwa.ES384, nil)
+	rsaToken := testNewTokenStringWithKey(t, rsaPrivKey, jwa.RS256, nil)
+
+	baseOpts := []options.Option{
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+	}
+
+	ctx := context.Background()
+
+	// without allowlist, both key types are accepted
+	h, err := NewHandler[testClaims](nil, baseOpts...)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, ecToken)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, rsaToken)
+	require.NoError(t, err)
+
+	// with EC only, RSA signed tokens are rejected
+	h, err = NewHandler[testClaims](nil, append(baseOpts, options.WithAllowedKeyTypes([]string{"EC"}))...)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, ecToken)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, rsaToken)
+	require.EqualError(t, err, "key type \"RSA\" is not allowed")
+
+	// invalid key type
+	_, err = NewHandler[testClaims](nil, append(baseOpts, options.WithAllowedKeyTypes([]string{"foo"}))...)
+	require.ErrorContains(t, err, "AllowedKeyTypes not accepted")
+}
+
+func TestParseTokenWithAllowedKeyTypesAndDisableKeyID(t *testing.T) {
+	ecPrivKey, ecPubKey := testNewKey(t)
+	rsaPrivKey, rsaPubKey, _ := testDuplicateKey(t)
+
+	keySets := testNewTestKeySet(t)
+	privKeySet := jwk.NewSet()
+	privKeySet.Add(testWithKeyID(t, ecPrivKey, ""))
+	pubKeySet := jwk.NewSet()
+	pubKeySet.Add(testWithKeyID(t, ecPubKey, ""))
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+		options.WithDisableKeyID(true),
+		options.WithAllowedKeyTypes([]string{"EC"}),
+	)
+	require.NoError(t, err)
+
+	// the jwks is rotated to a key type that isn't allowed, the refreshed key is rejected
+	rotatedPrivKeySet := jwk.NewSet()
+	rotatedPrivKeySet.Add(testWithKeyID(t, rsaPrivKey, ""))
+	rotatedPubKeySet := jwk.NewSet()
+	rotatedPubKeySet.Add(testWithKeyID(t, rsaPubKey, ""))
+	keySets.setKeys(rotatedPrivKeySet, rotatedPubKeySet)
+
+	rsaToken := testNewTokenStringWithKey(t, testWithKeyID(t, rsaPrivKey, ""), jwa.RS256, nil)
+
+	_, err = h.ParseToken(context.Background(), rsaToken)
+	require.EqualError(t, err, "key type \"RSA\" is not allowed")
+
+	// without the allowlist, the algorithm is taken from the refreshed key
+	keySets.setKeys(privKeySet, pubKeySet)
+	h, err = NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+		options.WithDisableKeyID(true),
+	)
+	require.NoError(t, err)
+
+	keySets.setKeys(rotatedPrivKeySet, rotatedPubKeySet)
+
+	_, err = h.ParseToken(context.Background(), rsaToken)
+	require.NoError(t, err)
+}
+
+func TestParseTokenWithNoneAlgorithm(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	var mu sync.Mutex
+	jwksRequests := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		jwksRequests++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(keySets.publicKeySet)
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	pubKey, ok := pubKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+		options.WithFallbackSignatureAlgorithm("ES384"),
+	)
+	require.NoError(t, err)
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"iss":"http://foo.bar","exp":%d}`, time.Now().Add(time.Minute).Unix())))
+
+	cases := []struct {
+		testDescription string
+		header          string
+		signature       string
+	}{
+		{
+			testDescription: "unsigned token",
+			header:          `{"alg":"none","typ":"JWT"}`,
+			signature:       "",
+		},
+		{
+			testDescription: "unsigned token with kid of a valid key",
+			header:          fmt.Sprintf(`{"alg":"none","typ":"JWT","kid":%q}`, pubKey.KeyID()),
+			signature:       "",
+		},
+		{
+			testDescription: "none algorithm with signature",
+			header:          fmt.Sprintf(`{"alg":"none","typ":"JWT","kid":%q}`, pubKey.KeyID()),
+			signature:       base64.RawURLEncoding.EncodeToString([]byte("foobar")),
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		tokenString := fmt.Sprintf("%s.%s.%s", base64.RawURLEncoding.EncodeToString([]byte(c.header)), payload, c.signature)
+
+		_, err := h.ParseToken(context.Background(), tokenString)
+		require.ErrorIs(t, err, options.ErrNoneAlgorithm)
+		require.EqualError(t, err, "token algorithm none is not allowed")
+	}
+
+	// the jwks is only fetched by NewHandler, the tokens are rejected before any key lookup
+	mu.Lock()
+	require.Equal(t, 1, jwksRequests)
+	mu.Unlock()
+}
+
+func TestParseTokenWithAttachRejectedToken(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	claimsValidationFn := func(claims *testClaims) error {
+		if (*claims)["foo"] != "bar" {
+			return fmt.Errorf("foo isn't bar")
+		}
+
+		return nil
+	}
+
+	validToken := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"sub": "baz", "aud": "api", "scope": "read", "roles": []string{"admin"}, "foo": "bar"})
+	tokenParts := strings.Split(validToken, ".")
+	tamperedPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"http://foo.bar","sub":"baz","aud":"api","scope":"read","roles":["admin"],"foo":"bar"}`))
+
+	cases := []struct {
+		testDescription       string
+		attachRejectedToken   bool
+		tokenString           string
+		expectedErrorContains string
+		expectedAttached      bool
+	}{
+		{
+			testDescription:     "valid token",
+			attachRejectedToken: true,
+			tokenString:         validToken,
+		},
+		{
+			testDescription:       "missing scope",
+			attachRejectedToken:   true,
+			tokenString:           testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"sub": "baz", "aud": "api", "roles": []string{"admin"}, "foo": "bar"}),
+			expectedErrorContains: "required scopes [read] were not found",
+			expectedAttached:      true,
+		},
+		{
+			testDescription:       "missing role",
+			attachRejectedToken:   true,
+			tokenString:           testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"sub": "baz", "aud": "api", "scope": "read", "foo": "bar"}),
+			expectedErrorContains: "required roles [admin] were not found",
+			expectedAttached:      true,
+		},
+		{
+			testDescription:       "claims validation failure",
+			attachRejectedToken:   true,
+			tokenString:           testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"sub": "baz", "aud": "api", "scope": "read", "roles": []string{"admin"}, "foo": "qux"}),
+			expectedErrorContains: "claims validation returned an error: foo isn't bar",
+			expectedAttached:      true,
+		},
+		{
+			testDescription:       "missing scope without AttachRejectedToken",
+			attachRejectedToken:   false,
+			tokenString:           testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"sub": "baz", "aud": "api", "roles": []string{"admin"}, "foo": "bar"}),
+			expectedErrorContains: "required scopes [read] were not found",
+			expectedAttached:      false,
+		},
+		{
+			testDescription:       "bad signature",
+			attachRejectedToken:   true,
+			tokenString:           fmt.Sprintf("%s.%s.%s", tokenParts[0], tamperedPayload, tokenParts[2]),
+			expectedErrorContains: "failed to verify signature",
+			expectedAttached:      false,
+		},
+		{
+			testDescription:       "wrong issuer",
+			attachRejectedToken:   true,
+			tokenString:           testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"iss": "http://bar.baz", "sub": "baz", "aud": "api"}),
+			expectedErrorContains: "required issuer \"http://foo.bar\" was not found",
+			expectedAttached:      false,
+		},
+		{
+			testDescription:       "wrong audience",
+			attachRejectedToken:   true,
+			tokenString:           testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"sub": "baz", "aud": "other"}),
+			expectedErrorContains: "required audience \"api\" was not found",
+			expectedAttached:      false,
+		},
+		{
+			testDescription:       "expired token",
+			attachRejectedToken:   true,
+			tokenString:           testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"sub": "baz", "aud": "api", "exp": time.Now().Add(-time.Hour).Unix()}),
+			expectedErrorContains: "token has expired",
+			expectedAttached:      false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler(claimsValidationFn,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithRequiredAudience("api"),
+			options.WithRequiredScopes([]string{"read"}),
+			options.WithRequiredRoles([]string{"admin"}),
+			options.WithAttachRejectedToken(c.attachRejectedToken),
+		)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(context.Background(), c.tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorContains(t, err, c.expectedErrorContains)
+
+		var rejectedErr *options.RejectedTokenError
+		if !c.expectedAttached {
+			require.False(t, errors.As(err, &rejectedErr))
+			continue
+		}
+
+		require.ErrorAs(t, err, &rejectedErr)
+		require.Equal(t, "baz", rejectedErr.Token.Subject())
+		require.Equal(t, options.ClaimsValidationFailureReason, GetValidationFailureReason(err))
+	}
+}
+
+func TestParseTokenWithAllowedSignatureAlgorithms(t *testing.T) {
+	ecPrivKey, ecPubKey := testNewKey(t)
+	rsaPrivKey, rsaPubKey, _ := testDuplicateKey(t)
+
+	// without alg, the algorithm used for verification is the fallback or the default for the key type
+	err := rsaPubKey.Remove(jwk.AlgorithmKey)
+	require.NoError(t, err)
+
+	keySets := testNewTestKeySet(t)
+	privKeySet := jwk.NewSet()
+	privKeySet.Add(ecPrivKey)
+	privKeySet.Add(rsaPrivKey)
+	pubKeySet := jwk.NewSet()
+	pubKeySet.Add(ecPubKey)
+	pubKeySet.Add(rsaPubKey)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	ecToken := testNewTokenStringWithKey(t, ecPrivKey, jwa.ES384, nil)
+	rsaToken := testNewTokenStringWithKey(t, rsaPrivKey, jwa.RS256, nil)
+
+	baseOpts := []options.Option{
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+	}
+
+	cases := []struct {
+		testDescription       string
+		allowedAlgorithms     []string
+		options               []options.Option
+		tokenString           string
+		expectedErrorContains string
+	}{
+		{
+			testDescription:   "no allowlist",
+			allowedAlgorithms: nil,
+			tokenString:       rsaToken,
+		},
+		{
+			testDescription:   "algorithm in allowlist",
+			allowedAlgorithms: []string{"ES384"},
+			tokenString:       ecToken,
+		},
+		{
+			testDescription:       "algorithm outside allowlist",
+			allowedAlgorithms:     []string{"ES384"},
+			tokenString:           rsaToken,
+			expectedErrorContains: "token signature algorithm \"RS256\" is not allowed",
+		},
+		{
+			testDescription:   "key algorithm outside allowlist",
+			allowedAlgorithms: []string{"RS256"},
+			options: []options.Option{
+				options.WithFallbackSignatureAlgorithm("RS512"),
+			},
+			tokenString:           rsaToken,
+			expectedErrorContains: "key signature algorithm \"RS512\" is not allowed",
+		},
+	}
+
+	ctx := context.Background()
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		opts := append([]options.Option{options.WithAllowedSignatureAlgorithms(c.allowedAlgorithms)}, baseOpts...)
+
+		h, err := NewHandler[testClaims](nil, append(opts, c.options...)...)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(ctx, c.tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+
+	// invalid algorithms are rejected when creating the handler
+	_, err = NewHandler[testClaims](nil, append(baseOpts, options.WithAllowedSignatureAlgorithms([]string{"foo"}))...)
+	require.ErrorContains(t, err, "AllowedSignatureAlgorithms not accepted")
+
+	_, err = NewHandler[testClaims](nil, append(baseOpts, options.WithAllowedSignatureAlgorithms([]string{"none"}))...)
+	require.ErrorContains(t, err, "signature algorithm none can't be allowed")
+}
+
+func TestParseTokenWithDeprecatedKeyIDs(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 2, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	deprecatedPrivKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	currentPrivKey, ok := privKeySet.Get(1)
+	require.True(t, ok)
+
+	var usedKeyIDs []string
+	onDeprecatedKeyUsed := func(kid string) {
+		usedKeyIDs = append(usedKeyIDs, kid)
+	}
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithRequiredAudience("foo"),
+		options.WithDeprecatedKeyIDs([]string{deprecatedPrivKey.KeyID()}),
+		options.WithOnDeprecatedKeyUsed(onDeprecatedKeyUsed),
+	)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	_, err = h.ParseToken(ctx, testNewTokenStringWithKey(t, currentPrivKey, jwa.ES384, map[string]interface{}{"aud": "foo"}))
+	require.NoError(t, err)
+	require.Empty(t, usedKeyIDs)
+
+	_, err = h.ParseToken(ctx, testNewTokenStringWithKey(t, deprecatedPrivKey, jwa.ES384, map[string]interface{}{"aud": "foo"}))
+	require.NoError(t, err)
+	require.Equal(t, []string{deprecatedPrivKey.KeyID()}, usedKeyIDs)
+
+	// the hook isn't called for tokens failing validation
+	_, err = h.ParseToken(ctx, testNewTokenStringWithKey(t, deprecatedPrivKey, jwa.ES384, map[string]interface{}{"aud": "bar"}))
+	require.Error(t, err)
+	require.Equal(t, []string{deprecatedPrivKey.KeyID()}, usedKeyIDs)
+}
+
+func TestParseTokenWithJwksUnavailable(t *testing.T) {
+	testServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableUrl := testServer.URL
+	testServer.Close()
+
+	privKeySet, _ := testNewKeySet(t, 1, false)
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, nil)
+	ctx := context.Background()
+
+	cases := []struct {
+		testDescription string
+		options         []options.Option
+	}{
+		{
+			testDescription: "unreachable discovery",
+			options: []options.Option{
+				options.WithIssuer(unreachableUrl),
+				options.WithLazyLoadJwks(true),
+			},
+		},
+		{
+			testDescription: "unreachable jwks",
+			options: []options.Option{
+				options.WithIssuer("http://foo.bar"),
+				options.WithAllowInsecureIssuer(true),
+				options.WithJwksUri(unreachableUrl),
+				options.WithLazyLoadJwks(true),
+			},
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](nil, c.options...)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(ctx, tokenString)
+		require.ErrorIs(t, err, options.ErrJwksUnavailable)
+	}
+
+	// token errors aren't reported as jwks unavailable
+	keySets := testNewTestKeySet(t)
+	jwksServer := testNewJwksServer(t, keySets)
+	defer jwksServer.Close()
+
+	_, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	h, err := NewHandler[testClaims](nil, options.WithIssuer("http://foo.bar"), options.WithAllowInsecureIssuer(true), options.WithJwksUri(jwksServer.URL))
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, "foobar")
+	require.Error(t, err)
+	require.NotErrorIs(t, err, options.ErrJwksUnavailable)
+}
+
+func TestParseTokenWithLazyLoadJwksBackoff(t *testing.T) {
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	var mu sync.Mutex
+	requestCount := 0
+	healthy := false
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		requestCount++
+		if !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(pubKeySet)
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	getRequestCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return requestCount
+	}
+
+	tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, nil)
+	ctx := context.Background()
+
+	// without backoff every request retries the load
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithLazyLoadJwks(true),
+	)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := h.ParseToken(ctx, tokenString)
+		require.ErrorIs(t, err, options.ErrJwksUnavailable)
+		require.NotErrorIs(t, err, options.ErrJwksLoadBackoff)
+	}
+	require.Equal(t, 3, getRequestCount())
+
+	// with backoff the failure is cached until the backoff has passed
+	backoff := 200 * time.Millisecond
+	h, err = NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithLazyLoadJwks(true),
+		options.WithLazyLoadJwksBackoff(backoff),
+	)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, tokenString)
+	require.ErrorIs(t, err, options.ErrJwksUnavailable)
+	require.NotErrorIs(t, err, options.ErrJwksLoadBackoff)
+	require.Equal(t, 4, getRequestCount())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := h.ParseToken(ctx, tokenString)
+			require.ErrorIs(t, err, options.ErrJwksUnavailable)
+			require.ErrorIs(t, err, options.ErrJwksLoadBackoff)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 4, getRequestCount())
+
+	mu.Lock()
+	healthy = true
+	mu.Unlock()
+
+	_, err = h.ParseToken(ctx, tokenString)
+	require.ErrorIs(t, err, options.ErrJwksLoadBackoff)
+	require.Equal(t, 4, getRequestCount())
+
+	time.Sleep(backoff + 50*time.Millisecond)
+
+	_, err = h.ParseToken(ctx, tokenString)
+	require.NoError(t, err)
+	require.Equal(t, 5, getRequestCount())
+}
+
+func TestParseTokenWithMaxTokenLength(t *testing.T) {
+	privKeySet, _ := testNewKeySet(t, 1, false)
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, nil)
+
+	// the jwks can't be loaded, so only the token length is validated before an error is returned
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri("http://foo.bar/baz"),
+		options.WithLazyLoadJwks(true),
+		options.WithMaxTokenLength(len(tokenString)-1),
+	)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(context.Background(), tokenString)
+	require.ErrorIs(t, err, options.ErrTokenTooLong)
+	require.ErrorContains(t, err, fmt.Sprintf("%d bytes, max %d bytes", len(tokenString), len(tokenString)-1))
+
+	_, err = h.ParseToken(context.Background(), tokenString[:len(tokenString)-1])
+	require.Error(t, err)
+	require.NotErrorIs(t, err, options.ErrTokenTooLong)
+}
+
+func TestParseTokenWithPendingJwks(t *testing.T) {
+	currentPrivKeySet, currentPubKeySet := testNewKeySet(t, 1, false)
+	pendingPrivKeySet, pendingPubKeySet := testNewKeySet(t, 1, false)
+	unknownPrivKeySet, _ := testNewKeySet(t, 1, false)
+
+	var requestCount int
+	var mu sync.Mutex
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requestCount++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(currentPubKeySet)
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	getRequestCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requestCount
+	}
+
+	currentPrivKey, ok := currentPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	pendingPrivKey, ok := pendingPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	unknownPrivKey, ok := unknownPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+		options.WithPendingJwks(pendingPubKeySet),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 1, getRequestCount())
+
+	ctx := context.Background()
+
+	_, err = h.ParseToken(ctx, testNewTokenStringWithKey(t, currentPrivKey, jwa.ES384, nil))
+	require.NoError(t, err)
+	require.Equal(t, 1, getRequestCount())
+
+	// the pending key is used without refreshing the jwks
+	_, err = h.ParseToken(ctx, testNewTokenStringWithKey(t, pendingPrivKey, jwa.ES384, nil))
+	require.NoError(t, err)
+	require.Equal(t, 1, getRequestCount())
+
+	// unknown keys still trigger a refresh of the jwks
+	_, err = h.ParseToken(ctx, testNewTokenStringWithKey(t, unknownPrivKey, jwa.ES384, nil))
+	require.Error(t, err)
+	require.Equal(t, 2, getRequestCount())
+
+	// the key rotation happens and the pending key is published in the jwks
+	pendingPubKey, ok := pendingPubKeySet.Get(0)
+	require.True(t, ok)
+
+	currentPubKeySet.Add(pendingPubKey)
+
+	err = h.Reload(ctx)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, testNewTokenStringWithKey(t, pendingPrivKey, jwa.ES384, nil))
+	require.NoError(t, err)
+
+	_, err = NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithDisableKeyID(true),
+		options.WithPendingJwks(pendingPubKeySet),
+	)
+	require.ErrorContains(t, err, "PendingJwks can't be used together with DisableKeyID")
+}
+
+func TestParseTokenWithDecisionCache(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	var mu sync.Mutex
+	validationCalls := make(map[string]int)
+	newClaimsValidationFn := func(policyID string) options.ClaimsValidationFn[testClaims] {
+		return func(claims *testClaims) error {
+			mu.Lock()
+			validationCalls[policyID]++
+			mu.Unlock()
+
+			return nil
+		}
+	}
+
+	getValidationCalls := func(policyID string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return validationCalls[policyID]
+	}
+
+	decisionCache := options.NewMemoryDecisionCache()
+
+	newHandler := func(policyID string, setters ...options.Option) *handler[testClaims] {
+		t.Helper()
+
+		setters = append(setters,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithDecisionCache(decisionCache),
+			options.WithPolicyID(policyID),
+		)
+
+		h, err := NewHandler(newClaimsValidationFn(policyID), setters...)
+		require.NoError(t, err)
+
+		return h
+	}
+
+	allowHandler := newHandler("allow", options.WithRequiredAudience("foo"))
+	denyHandler := newHandler("deny", options.WithRequiredRoles([]string{"admin"}))
+	noCacheHandler := newHandler("no-cache")
+	noCacheHandler.decisionCache = nil
+
+	ctx := context.Background()
+	exp := time.Now().Add(2 * time.Second).Truncate(time.Second)
+	tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{
+		"aud":   "foo",
+		"roles": []string{"user"},
+		"exp":   exp.Unix(),
+	})
+
+	for i := 0; i < 3; i++ {
+		claims, err := allowHandler.ParseToken(ctx, tokenString)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"foo"}, claims["aud"])
+
+		_, err = denyHandler.ParseToken(ctx, tokenString)
+		require.Error(t, err)
+
+		_, err = noCacheHandler.ParseToken(ctx, tokenString)
+		require.NoError(t, err)
+	}
+
+	// the cached allow is reused without running the claims validation again
+	require.Equal(t, 1, getValidationCalls("allow"))
+	require.Equal(t, 3, getValidationCalls("no-cache"))
+
+	// the cached deny is reused
+	_, err := denyHandler.ParseToken(ctx, tokenString)
+	require.ErrorContains(t, err, "token denied by cached decision for policy \"deny\": claims")
+	require.Equal(t, options.ClaimsValidationFailureReason, GetValidationFailureReason(err))
+
+	// the cached decisions are invalidated when the token expires, the token is still
+	// accepted because of the allowed token drift
+	time.Sleep(time.Until(exp) + 50*time.Millisecond)
+
+	_, err = allowHandler.ParseToken(ctx, tokenString)
+	require.NoError(t, err)
+	require.Equal(t, 2, getValidationCalls("allow"))
+
+	_, err = denyHandler.ParseToken(ctx, tokenString)
+	require.ErrorContains(t, err, "required roles [admin] were not found")
+}
+
+func TestParseTokenWithDecisionCacheAndPolicySetters(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithDecisionCache(options.NewMemoryDecisionCache()),
+		options.WithRequiredScopes([]string{"read"}),
+	)
+	require.NoError(t, err)
+
+	tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"aud": "foo", "sub": "test", "scope": "read"})
+
+	cases := []struct {
+		testDescription       string
+		setPolicy             func()
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "allow is cached",
+			setPolicy:             func() {},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "tightened required scopes",
+			setPolicy:             func() { h.SetRequiredScopes([]string{"read", "write"}) },
+			expectedErrorContains: "required scopes [write] were not found",
+		},
+		{
+			testDescription:       "loosened required scopes",
+			setPolicy:             func() { h.SetRequiredScopes([]string{"read"}) },
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "tightened required audience",
+			setPolicy:             func() { h.SetRequiredAudience("bar") },
+			expectedErrorContains: "required audience \"bar\" was not found",
+		},
+		{
+			testDescription:       "loosened required audience",
+			setPolicy:             func() { h.SetRequiredAudience("") },
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "failing claims validation",
+			setPolicy: func() {
+				h.SetClaimsValidationFn(func(claims *testClaims) error {
+					return fmt.Errorf("sub %q isn't foo", (*claims)["sub"])
+				})
+			},
+			expectedErrorContains: "claims validation returned an error",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		c.setPolicy()
+
+		_, err := h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+
+		// the decision made using the current policy is cached
+		_, cachedErr := h.ParseToken(context.Background(), tokenString)
+		require.Equal(t, err == nil, cachedErr == nil)
+	}
+}
+
+func TestParseTokenWithTimingsFn(t *testing.T) {
+	privKeySet1, pubKeySet1 := testNewKeySet(t, 1, false)
+	privKeySet2, pubKeySet2 := testNewKeySet(t, 1, false)
+
+	var mu sync.Mutex
+	currentPubKeySet := pubKeySet1
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keySet := currentPubKeySet
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(keySet)
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
+
+	var timingsResults []options.Timings
+	var timingsErrors []error
+	timingsFn := func(ctx context.Context, timings options.Timings, err error) {
+		timingsResults = append(timingsResults, timings)
+		timingsErrors = append(timingsErrors, err)
+	}
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+		options.WithLazyLoadJwks(true),
+		options.WithTimingsFn(timingsFn),
+	)
+	require.NoError(t, err)
+
+	privKey1, ok := privKeySet1.Get(0)
+	require.True(t, ok)
+
+	privKey2, ok := privKeySet2.Get(0)
+	require.True(t, ok)
+
+	ctx := context.Background()
+
+	// the jwks is lazy loaded
+	_, err = h.ParseToken(ctx, testNewTokenStringWithKey(t, privKey1, jwa.ES384, nil))
+	require.NoError(t, err)
+
+	// the key is found without a refresh
+	_, err = h.ParseToken(ctx, testNewTokenStringWithKey(t, privKey1, jwa.ES384, nil))
+	require.NoError(t, err)
+
+	// the key is found after a refresh
+	mu.Lock()
+	currentPubKeySet = pubKeySet2
+	mu.Unlock()
+
+	_, err = h.ParseToken(ctx, testNewTokenStringWithKey(t, privKey2, jwa.ES384, nil))
+	require.NoError(t, err)
+
+	// errors are passed to the hook
+	_, err = h.ParseToken(ctx, "foobar")
+	require.Error(t, err)
+
+	require.Len(t, timingsResults, 4)
+	require.Equal(t, []error{nil, nil, nil, err}, timingsErrors)
+
+	lazyLoad, cached, refreshed, failed := timingsResults[0], timingsResults[1], timingsResults[2], timingsResults[3]
+
+	require.GreaterOrEqual(t, lazyLoad.JwksLoad, 20*time.Millisecond)
+	require.Zero(t, lazyLoad.KeyRefresh)
+
+	require.Zero(t, cached.JwksLoad)
+	require.Zero(t, cached.KeyRefresh)
+	require.NotZero(t, cached.SignatureVerification)
+
+	require.GreaterOrEqual(t, refreshed.KeyRefresh, 20*time.Millisecond)
+	require.GreaterOrEqual(t, refreshed.KeyLookup, refreshed.KeyRefresh)
+
+	require.Zero(t, failed.KeyLookup)
+	require.Zero(t, failed.SignatureVerification)
+	require.NotZero(t, failed.Total)
+
+	for i, timings := range timingsResults[:3] {
+		t.Logf("Test iteration %d: %+v", i, timings)
+
+		sum := timings.JwksLoad + timings.KeyIDExtraction + timings.KeyLookup + timings.SignatureVerification + timings.ClaimsValidation
+		require.LessOrEqual(t, sum, timings.Total)
+		require.Less(t, timings.Total-sum, 5*time.Millisecond)
+	}
+}
+
+type testVerifier struct {
+	sync.Mutex
+	calls     int
+	lastKid   string
+	returnErr error
+}
+
+func (v *testVerifier) Verify(ctx context.Context, payload []byte, signature []byte, alg string, key jwk.Key) error {
+	v.Lock()
+	v.calls++
+	v.lastKid = key.KeyID()
+	v.Unlock()
+
+	if v.returnErr != nil {
+		return v.returnErr
+	}
+
+	verifier, err := jws.NewVerifier(jwa.SignatureAlgorithm(alg))
+	if err != nil {
+		return err
+	}
+
+	var rawKey interface{}
+	err = key.Raw(&rawKey)
+	if err != nil {
+		return err
+	}
+
+	return verifier.Verify(payload, signature, rawKey)
+}
+
+func (v *testVerifier) getCalls() int {
+	v.Lock()
+	defer v.Unlock()
+
+	return v.calls
+}
+
+func TestParseTokenWithVerifier(t *testing.T) {
+	ecPrivKey, ecPubKey := testNewKey(t)
+	rsaPrivKey, rsaPubKey, _ := testDuplicateKey(t)
+
+	keySets := testNewTestKeySet(t)
+	privKeySet := jwk.NewSet()
+	privKeySet.Add(ecPrivKey)
+	privKeySet.Add(rsaPrivKey)
+	pubKeySet := jwk.NewSet()
+	pubKeySet.Add(ecPubKey)
+	pubKeySet.Add(rsaPubKey)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	ecToken := testNewTokenStringWithKey(t, ecPrivKey, jwa.ES384, map[string]interface{}{"foo": "bar"})
+	rsaToken := testNewTokenStringWithKey(t, rsaPrivKey, jwa.RS256, nil)
+
+	baseOpts := []options.Option{
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+	}
+
+	ctx := context.Background()
+
+	// verification is delegated for the configured key type only
+	verifier := &testVerifier{}
+	h, err := NewHandler[testClaims](nil, append(baseOpts, options.WithVerifier("EC", verifier))...)
+	require.NoError(t, err)
+
+	claims, err := h.ParseToken(ctx, ecToken)
+	require.NoError(t, err)
+	require.Equal(t, "bar", claims["foo"])
+	require.Equal(t, 1, verifier.getCalls())
+	require.Equal(t, ecPubKey.KeyID(), verifier.lastKid)
+
+	_, err = h.ParseToken(ctx, rsaToken)
+	require.NoError(t, err)
+	require.Equal(t, 1, verifier.getCalls())
+
+	// token with a tampered payload is rejected by the verifier
+	tokenParts := strings.Split(ecToken, ".")
+	tamperedPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"http://foo.bar","exp":9999999999}`))
+	tamperedToken := strings.Join([]string{tokenParts[0], tamperedPayload, tokenParts[2]}, ".")
+	_, err = h.ParseToken(ctx, tamperedToken)
+	require.ErrorIs(t, err, options.ErrSignatureVerification)
+	require.Equal(t, 2, verifier.getCalls())
+
+	// errors from the verifier fails the verification
+	failingVerifier := &testVerifier{returnErr: fmt.Errorf("kms unavailable")}
+	h, err = NewHandler[testClaims](nil, append(baseOpts, options.WithVerifier("RSA", failingVerifier))...)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(ctx, ecToken)
+	require.NoError(t, err)
+	require.Equal(t, 0, failingVerifier.getCalls())
+
+	_, err = h.ParseToken(ctx, rsaToken)
+	require.ErrorIs(t, err, options.ErrSignatureVerification)
+	require.ErrorContains(t, err, "kms unavailable")
+	require.Equal(t, 1, failingVerifier.getCalls())
+
+	// invalid key type
+	_, err = NewHandler[testClaims](nil, append(baseOpts, options.WithVerifier("foo", verifier))...)
+	require.ErrorContains(t, err, "Verifiers not accepted")
+}
+
+func TestIsTokenTimeFresh(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	cases := []struct {
+		testDescription string
+		issuedAt        time.Time
+		maxAge          time.Duration
+		allowedDrift    time.Duration
+		expectedResult  bool
+	}{
+		{
+			testDescription: "issued now",
+			issuedAt:        now,
+			maxAge:          time.Minute,
+			allowedDrift:    0,
+			expectedResult:  true,
+		},
+		{
+			testDescription: "issued within max age",
+			issuedAt:        now.Add(-30 * time.Second),
+			maxAge:          time.Minute,
+			allowedDrift:    0,
+			expectedResult:  true,
+		},
+		{
+			testDescription: "issued before max age",
+			issuedAt:        now.Add(-2 * time.Minute),
+			maxAge:          time.Minute,
+			allowedDrift:    0,
+			expectedResult:  false,
+		},
+		{
+			testDescription: "issued before max age, within drift",
+			issuedAt:        now.Add(-65 * time.Second),
+			maxAge:          time.Minute,
+			allowedDrift:    10 * time.Second,
+			expectedResult:  true,
+		},
+		{
+			testDescription: "issued in the future",
+			issuedAt:        now.Add(time.Minute),
+			maxAge:          time.Minute,
+			allowedDrift:    10 * time.Second,
+			expectedResult:  false,
+		},
+		{
+			testDescription: "missing issued at",
+			issuedAt:        time.Time{},
+			maxAge:          time.Minute,
+			allowedDrift:    0,
+			expectedResult:  false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		result := isTokenTimeFresh(c.issuedAt, c.maxAge, c.allowedDrift, now)
+		require.Equal(t, c.expectedResult, result)
+	}
+}
+
+func TestParseTokenWithMaxAuthAge(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithMaxAuthAge(5*time.Minute),
+		options.WithAllowedTokenDrift(10*time.Second),
+	)
+	require.NoError(t, err)
+
+	cases := []struct {
+		testDescription       string
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "fresh auth_time",
+			customClaims: map[string]interface{}{
+				"auth_time": time.Now().Add(-1 * time.Minute).Unix(),
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "auth_time within drift",
+			customClaims: map[string]interface{}{
+				"auth_time": time.Now().Add(-5 * time.Minute).Add(-5 * time.Second).Unix(),
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "stale auth_time",
+			customClaims: map[string]interface{}{
+				"auth_time": time.Now().Add(-10 * time.Minute).Unix(),
+			},
+			expectedErrorContains: "is not within the max auth age 5m0s",
+		},
+		{
+			testDescription:       "missing auth_time",
+			customClaims:          nil,
+			expectedErrorContains: "token does not contain claim \"auth_time\"",
+		},
+		{
+			testDescription: "invalid auth_time",
+			customClaims: map[string]interface{}{
+				"auth_time": "foo",
+			},
+			expectedErrorContains: "token claim \"auth_time\" is not a valid timestamp",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims)
+
+		_, err := h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestParseTokenWithMaxTokenAge(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithMaxTokenAge(5*time.Minute),
+		options.WithAllowedTokenDrift(10*time.Second),
+	)
+	require.NoError(t, err)
+
+	cases := []struct {
+		testDescription       string
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "recently issued",
+			customClaims: map[string]interface{}{
+				"iat": time.Now().Add(-1 * time.Minute).Unix(),
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "issued within drift",
+			customClaims: map[string]interface{}{
+				"iat": time.Now().Add(-5 * time.Minute).Add(-5 * time.Second).Unix(),
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "too old but not expired",
+			customClaims: map[string]interface{}{
+				"iat": time.Now().Add(-10 * time.Minute).Unix(),
+			},
+			expectedErrorContains: "is not within the max token age 5m0s",
+		},
+		{
+			testDescription: "issued in the future",
+			customClaims: map[string]interface{}{
+				"iat": time.Now().Add(1 * time.Minute).Unix(),
+			},
+			expectedErrorContains: "is not within the max token age 5m0s",
+		},
+		{
+			testDescription:       "missing iat",
+			customClaims:          nil,
+			expectedErrorContains: "token does not contain claim \"iat\"",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims)
+
+		_, err := h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestParseTokenWithNowFn(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	now := time.Unix(1600000000, 0)
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithMaxAuthAge(5*time.Minute),
+		options.WithAllowedTokenDrift(10*time.Second),
+		options.WithNowFn(func() time.Time {
+			return now
+		}),
+	)
+	require.NoError(t, err)
+
+	cases := []struct {
+		testDescription       string
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "valid at the injected time",
+			customClaims: map[string]interface{}{
+				"exp":       now.Add(1 * time.Minute).Unix(),
+				"auth_time": now.Add(-1 * time.Minute).Unix(),
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "expired at the injected time",
+			customClaims: map[string]interface{}{
+				"exp":       now.Add(-1 * time.Minute).Unix(),
+				"auth_time": now.Add(-1 * time.Minute).Unix(),
+			},
+			expectedErrorContains: "token has expired",
+		},
+		{
+			testDescription: "stale auth_time at the injected time",
+			customClaims: map[string]interface{}{
+				"exp":       now.Add(1 * time.Minute).Unix(),
+				"auth_time": now.Add(-10 * time.Minute).Unix(),
+			},
+			expectedErrorContains: "is not within the max auth age 5m0s",
+		},
+		{
+			testDescription: "valid now but not yet issued at the injected time",
+			customClaims: map[string]interface{}{
+				"auth_time": time.Now().Unix(),
+			},
+			expectedErrorContains: "is not within the max auth age 5m0s",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims)
+
+		_, err := h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+type testNonceContextKey struct{}
+
+func TestParseTokenWithNonce(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	nonceFromContextFn := func(ctx context.Context) (string, bool) {
+		nonce, ok := ctx.Value(testNonceContextKey{}).(string)
+		return nonce, ok
+	}
+
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithNonceFromContextFn(nonceFromContextFn),
+		options.WithNonceMaxAge(time.Minute),
+		options.WithAllowedTokenDrift(0),
+	)
+	require.NoError(t, err)
+
+	cases := []struct {
+		testDescription       string
+		contextNonce          string
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "fresh token with matching nonce",
+			contextNonce:    "foo",
+			customClaims: map[string]interface{}{
+				"nonce": "foo",
+				"iat":   time.Now().Unix(),
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "stale token with matching nonce",
+			contextNonce:    "foo",
+			customClaims: map[string]interface{}{
+				"nonce": "foo",
+				"iat":   time.Now().Add(-2 * time.Minute).Unix(),
+			},
+			expectedErrorContains: "is not within the nonce max age",
+		},
+		{
+			testDescription: "fresh token with mismatched nonce",
+			contextNonce:    "foo",
+			customClaims: map[string]interface{}{
+				"nonce": "bar",
+				"iat":   time.Now().Unix(),
+			},
+			expectedErrorContains: "required nonce was not found or does not match",
+		},
+		{
+			testDescription: "stale token with mismatched nonce",
+			contextNonce:    "foo",
+			customClaims: map[string]interface{}{
+				"nonce": "bar",
+				"iat":   time.Now().Add(-2 * time.Minute).Unix(),
+			},
+			expectedErrorContains: "required nonce was not found or does not match",
+		},
+		{
+			testDescription: "token without nonce",
+			contextNonce:    "foo",
+			customClaims: map[string]interface{}{
+				"iat": time.Now().Unix(),
 			},
-			numKeys:                 1,
-			customExpirationMinutes: -1,
-			expectedErrorContains:   "token has expired",
+			expectedErrorContains: "required nonce was not found or does not match",
 		},
 		{
-			testDescription: "expired token, without keyID",
-			options: []options.Option{
-				options.WithIssuer("http://foo.bar"),
-				options.WithDiscoveryUri("http://foo.bar"),
-				options.WithJwksUri(testServer.URL),
-				options.WithDisableKeyID(true),
+			testDescription: "token without iat",
+			contextNonce:    "foo",
+			customClaims: map[string]interface{}{
+				"nonce": "foo",
 			},
-			numKeys:                 1,
-			customExpirationMinutes: -1,
-			expectedErrorContains:   "token has expired",
+			expectedErrorContains: "is not within the nonce max age",
+		},
+		{
+			testDescription: "no nonce in context",
+			contextNonce:    "",
+			customClaims: map[string]interface{}{
+				"iat": time.Now().Add(-2 * time.Minute).Unix(),
+			},
+			expectedErrorContains: "",
 		},
 	}
 
 	for i, c := range cases {
 		t.Logf("Test iteration %d: %s", i, c.testDescription)
 
-		opts := &options.Options{}
-
-		for _, setter := range c.options {
-			setter(opts)
-		}
-
-		keySets.setKeys(testNewKeySet(t, c.numKeys, opts.DisableKeyID))
-
-		h, err := NewHandler[testClaims](nil, c.options...)
-		require.NoError(t, err)
-
-		parseTokenFunc := h.ParseToken
-
-		issuer := opts.Issuer
-		if c.customIssuer != "" {
-			issuer = c.customIssuer
-		}
-
-		expirationMinutes := 1
-		if c.customExpirationMinutes != 0 {
-			expirationMinutes = c.customExpirationMinutes
-		}
-
-		customClaims := make(map[string]string)
-		customClaims["foo"] = "bar"
-		if c.customClaims != nil {
-			customClaims = c.customClaims
-		}
-
-		token := testNewCustomTokenString(t, keySets.privateKeySet, issuer, expirationMinutes, customClaims)
-
 		ctx := context.Background()
+		if c.contextNonce != "" {
+			ctx = context.WithValue(ctx, testNonceContextKey{}, c.contextNonce)
+		}
 
-		_, err = parseTokenFunc(ctx, token)
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims)
 
+		_, err := h.ParseToken(ctx, tokenString)
 		if c.expectedErrorContains == "" {
 			require.NoError(t, err)
 		} else {
-			require.Contains(t, err.Error(), c.expectedErrorContains)
+			require.ErrorContains(t, err, c.expectedErrorContains)
 		}
 	}
 }
 
-func TestParseTokenWithKeyID(t *testing.T) {
-	disableKeyID := false
-	keySets := testNewTestKeySet(t)
-	testServer := testNewJwksServer(t, keySets)
-	defer testServer.Close()
+func TestReload(t *testing.T) {
+	ctx := context.Background()
 
-	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+	op1 := optest.NewTesting(t)
+	defer op1.Close(t)
 
-	opts := []options.Option{
-		options.WithIssuer("http://foo.bar"),
-		options.WithDiscoveryUri("http://foo.bar"),
-		options.WithJwksUri(testServer.URL),
-		options.WithDisableKeyID(disableKeyID),
-		options.WithJwksRateLimit(100),
-	}
+	op2 := optest.NewTesting(t)
+	defer op2.Close(t)
 
-	h, err := NewHandler[testClaims](nil, opts...)
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer(op1.GetURL(t)),
+		options.WithJwksRateLimit(100),
+	)
 	require.NoError(t, err)
 
-	parseTokenFunc := h.ParseToken
+	op1Token := op1.GetToken(t).AccessToken
+	op2Token := op2.GetToken(t).AccessToken
 
-	// first token should succeed
-	token1 := testNewTokenString(t, keySets.privateKeySet)
+	_, err = h.ParseToken(ctx, op1Token)
+	require.NoError(t, err)
 
-	ctx := context.Background()
+	_, err = h.ParseToken(ctx, op2Token)
+	require.Error(t, err)
 
-	_, err = parseTokenFunc(ctx, token1)
-	require.NoError(t, err)
+	// the discovery uri isn't used until the handler is reloaded
+	h.SetIssuer(op2.GetURL(t))
+	h.SetDiscoveryUri(GetDiscoveryUriFromIssuer(op2.GetURL(t)))
 
-	// second token should succeed, rotation successful
-	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+	_, err = h.ParseToken(ctx, op2Token)
+	require.Error(t, err)
 
-	token2 := testNewTokenString(t, keySets.privateKeySet)
+	err = h.Reload(ctx)
+	require.NoError(t, err)
 
-	_, err = parseTokenFunc(ctx, token2)
+	_, err = h.ParseToken(ctx, op2Token)
 	require.NoError(t, err)
 
-	// after rotation, first token should fail
-	_, err = parseTokenFunc(ctx, token1)
+	_, err = h.ParseToken(ctx, op1Token)
 	require.Error(t, err)
 
-	// third token should succeed with two keys
-	keySets.setKeys(testNewKeySet(t, 2, disableKeyID))
+	// a failed reload keeps the current keys
+	h.SetDiscoveryUri("http://127.0.0.1:1/.well-known/openid-configuration")
 
-	token3 := testNewTokenString(t, keySets.privateKeySet)
+	err = h.Reload(ctx)
+	require.ErrorContains(t, err, "unable to reload jwks")
 
-	_, err = parseTokenFunc(ctx, token3)
+	_, err = h.ParseToken(ctx, op2Token)
 	require.NoError(t, err)
+}
 
-	// fourth token should fail since they token doesn't contain keyID
-	keySets.setKeys(testNewKeySet(t, 1, true))
+func TestReloadConcurrentParseToken(t *testing.T) {
+	ctx := context.Background()
 
-	token4 := testNewTokenString(t, keySets.privateKeySet)
+	op := optest.NewTesting(t)
+	defer op.Close(t)
 
-	_, err = parseTokenFunc(ctx, token4)
-	require.Error(t, err)
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithJwksRateLimit(100),
+		options.WithLazyLoadJwks(true),
+	)
+	require.NoError(t, err)
 
-	// fifth token should fail since it's the wrong key but correct keyID
-	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
-	currentPrivateKey, found := keySets.privateKeySet.Get(0)
-	require.True(t, found)
+	tokenString := op.GetToken(t).AccessToken
 
-	currentKeyID := currentPrivateKey.KeyID()
-	invalidPrivKey, _ := testNewKey(t)
+	var wg sync.WaitGroup
+	errCh := make(chan error, 100)
 
-	err = invalidPrivKey.Set(jwk.KeyIDKey, currentKeyID)
-	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := h.ParseToken(ctx, tokenString)
+				errCh <- err
+			}
+		}()
+	}
 
-	invalidKeySet := jwk.NewSet()
-	invalidKeySet.Add(invalidPrivKey)
+	for i := 0; i < 10; i++ {
+		err := h.Reload(ctx)
+		require.NoError(t, err)
+	}
 
-	token5 := testNewTokenString(t, invalidKeySet)
+	wg.Wait()
+	close(errCh)
 
-	_, err = parseTokenFunc(ctx, token5)
-	require.ErrorIs(t, err, errSignatureVerification)
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+}
 
-	// sixth token should fail since the jwks can't be refreshed
-	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+type testRecordingRoundTripper struct {
+	sync.Mutex
+	requests   []string
+	maxRetries int
+	next       http.RoundTripper
+}
 
-	token6 := testNewTokenString(t, keySets.privateKeySet)
+func (rt *testRecordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var res *http.Response
+	var err error
+	for i := 0; i <= rt.maxRetries; i++ {
+		rt.Lock()
+		rt.requests = append(rt.requests, req.URL.Path)
+		rt.Unlock()
+
+		res, err = rt.next.RoundTrip(req)
+		if err == nil && res.StatusCode < http.StatusInternalServerError {
+			return res, nil
+		}
 
-	testServer.Close()
+		if i < rt.maxRetries && err == nil {
+			res.Body.Close()
+		}
+	}
 
-	_, err = parseTokenFunc(ctx, token6)
-	require.Error(t, err)
+	return res, err
 }
 
-func TestParseTokenWithoutKeyID(t *testing.T) {
-	disableKeyID := true
-	keySets := testNewTestKeySet(t)
-	testServer := testNewJwksServer(t, keySets)
+func (rt *testRecordingRoundTripper) getRequests() []string {
+	rt.Lock()
+	defer rt.Unlock()
+
+	return append([]string(nil), rt.requests...)
+}
+
+func TestNewHandlerWithRequireJwksSameHostAsIssuer(t *testing.T) {
+	_, pubKeySet := testNewKeySet(t, 1, false)
+
+	var discoveryJwksUri string
+	mux := http.NewServeMux()
+	testServer := httptest.NewServer(mux)
 	defer testServer.Close()
 
-	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+	// the test server listens on 127.0.0.1, localhost is used as another host pointing to it
+	crossHostUrl := strings.Replace(testServer.URL, "127.0.0.1", "localhost", 1)
 
-	opts := []options.Option{
-		options.WithIssuer("http://foo.bar"),
-		options.WithDiscoveryUri("http://foo.bar"),
-		options.WithJwksUri(testServer.URL),
-		options.WithDisableKeyID(disableKeyID),
-		options.WithJwksRateLimit(100),
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   testServer.URL,
+			"jwks_uri": discoveryJwksUri,
+		})
+		require.NoError(t, err)
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(pubKeySet)
+		require.NoError(t, err)
+	})
+
+	cases := []struct {
+		testDescription       string
+		discoveryJwksUri      string
+		options               []options.Option
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "same host from discovery",
+			discoveryJwksUri:      testServer.URL + "/jwks",
+			options:               nil,
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "cross host from discovery",
+			discoveryJwksUri:      crossHostUrl + "/jwks",
+			options:               nil,
+			expectedErrorContains: "jwksUri host \"localhost\" isn't the same as the issuer host \"127.0.0.1\"",
+		},
+		{
+			testDescription:       "same host configured",
+			discoveryJwksUri:      "",
+			options:               []options.Option{options.WithJwksUri(testServer.URL + "/jwks")},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "cross host configured",
+			discoveryJwksUri:      "",
+			options:               []options.Option{options.WithJwksUri(crossHostUrl + "/jwks")},
+			expectedErrorContains: "jwksUri host \"localhost\" isn't the same as the issuer host \"127.0.0.1\"",
+		},
+		{
+			testDescription:       "cross host discovery uri",
+			discoveryJwksUri:      testServer.URL + "/jwks",
+			options:               []options.Option{options.WithDiscoveryUri(crossHostUrl + "/.well-known/openid-configuration")},
+			expectedErrorContains: "discoveryUri host \"localhost\" isn't the same as the issuer host \"127.0.0.1\"",
+		},
 	}
 
-	h, err := NewHandler[testClaims](nil, opts...)
-	require.NoError(t, err)
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
 
-	parseTokenFunc := h.ParseToken
+		discoveryJwksUri = c.discoveryJwksUri
 
-	// first token should succeed
-	token1 := testNewTokenString(t, keySets.privateKeySet)
+		opts := append([]options.Option{
+			options.WithIssuer(testServer.URL),
+			options.WithRequireJwksSameHostAsIssuer(true),
+		}, c.options...)
 
-	ctx := context.Background()
+		_, err := NewHandler[testClaims](nil, opts...)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
 
-	_, err = parseTokenFunc(ctx, token1)
-	require.NoError(t, err)
+		// without RequireJwksSameHostAsIssuer all jwks uris are accepted
+		_, err = NewHandler[testClaims](nil, append(opts, options.WithRequireJwksSameHostAsIssuer(false))...)
+		require.NoError(t, err)
+	}
+}
 
-	// second token should succeed, with key rotation
-	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+func TestParseTokenWithSplitHorizonIssuer(t *testing.T) {
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
 
-	token2 := testNewTokenString(t, keySets.privateKeySet)
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
 
-	_, err = parseTokenFunc(ctx, token2)
-	require.NoError(t, err)
+	// the issuer in the tokens is only reachable through a reverse proxy rewriting the path
+	internalIssuer := "https://idp.internal"
+	externalIssuer := "https://public.example.com"
 
-	// after rotation, first token should fail
-	_, err = parseTokenFunc(ctx, token1)
-	require.Error(t, err)
+	var mu sync.Mutex
+	discoveryRequests := 0
+	mux := http.NewServeMux()
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
 
-	// third token should fail since there are two keys present
-	keySets.setKeys(testNewKeySet(t, 2, disableKeyID))
+	mux.HandleFunc("/idp/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		discoveryRequests++
+		mu.Unlock()
 
-	token3 := testNewTokenString(t, keySets.privateKeySet)
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   internalIssuer,
+			"jwks_uri": testServer.URL + "/idp/jwks",
+		})
+		require.NoError(t, err)
+	})
 
-	_, err = parseTokenFunc(ctx, token3)
-	require.Error(t, err)
+	mux.HandleFunc("/idp/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(pubKeySet)
+		require.NoError(t, err)
+	})
 
-	// fourth token should fail since the jwks can't be refreshed
-	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+	cases := []struct {
+		testDescription                 string
+		options                         []options.Option
+		tokenIssuer                     string
+		expectedNewHandlerErrorContains string
+		expectedErrorContains           string
+		expectedDiscoveryRequests       int
+	}{
+		{
+			testDescription: "rewritten discovery uri",
+			options: []options.Option{
+				options.WithDiscoveryUri(testServer.URL + "/idp/.well-known/openid-configuration"),
+			},
+			tokenIssuer:               internalIssuer,
+			expectedDiscoveryRequests: 1,
+		},
+		{
+			testDescription: "rewritten discovery uri with external issuer in token",
+			options: []options.Option{
+				options.WithDiscoveryUri(testServer.URL + "/idp/.well-known/openid-configuration"),
+			},
+			tokenIssuer:               externalIssuer,
+			expectedErrorContains:     "required issuer \"https://idp.internal\" was not found",
+			expectedDiscoveryRequests: 1,
+		},
+		{
+			testDescription: "rewritten jwks uri skips discovery",
+			options: []options.Option{
+				options.WithJwksUri(testServer.URL + "/idp/jwks"),
+			},
+			tokenIssuer:               internalIssuer,
+			expectedDiscoveryRequests: 0,
+		},
+		{
+			testDescription: "external issuer accepted as alias",
+			options: []options.Option{
+				options.WithDiscoveryUri(testServer.URL + "/idp/.well-known/openid-configuration"),
+				options.WithIssuerAliases([]string{externalIssuer}),
+			},
+			tokenIssuer:               externalIssuer,
+			expectedDiscoveryRequests: 1,
+		},
+		{
+			testDescription: "same host as issuer can't be required",
+			options: []options.Option{
+				options.WithDiscoveryUri(testServer.URL + "/idp/.well-known/openid-configuration"),
+				options.WithRequireJwksSameHostAsIssuer(true),
+			},
+			expectedNewHandlerErrorContains: "discoveryUri host \"127.0.0.1\" isn't the same as the issuer host \"idp.internal\"",
+			expectedDiscoveryRequests:       0,
+		},
+	}
 
-	token4 := testNewTokenString(t, keySets.privateKeySet)
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
 
-	testServer.Close()
+		mu.Lock()
+		discoveryRequests = 0
+		mu.Unlock()
 
-	_, err = parseTokenFunc(ctx, token4)
-	require.Error(t, err)
+		h, err := NewHandler[testClaims](nil, append([]options.Option{options.WithIssuer(internalIssuer)}, c.options...)...)
+		if c.expectedNewHandlerErrorContains != "" {
+			require.ErrorContains(t, err, c.expectedNewHandlerErrorContains)
+		} else {
+			require.NoError(t, err)
+
+			tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{
+				"iss": c.tokenIssuer,
+			})
+
+			_, err = h.ParseToken(context.Background(), tokenString)
+			if c.expectedErrorContains == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, c.expectedErrorContains)
+			}
+		}
+
+		mu.Lock()
+		require.Equal(t, c.expectedDiscoveryRequests, discoveryRequests)
+		mu.Unlock()
+	}
 }
 
-func TestGetAndValidateTokenFromStringWithKeyID(t *testing.T) {
-	disableKeyID := false
-	keySets := testNewTestKeySet(t)
-	testServer := testNewJwksServer(t, keySets)
+func TestNewHandlerWithJwksHttpClient(t *testing.T) {
+	_, pubKeySet := testNewKeySet(t, 1, false)
+
+	var jwksRequests int
+	var mu sync.Mutex
+	mux := http.NewServeMux()
+	testServer := httptest.NewServer(mux)
 	defer testServer.Close()
 
-	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   testServer.URL,
+			"jwks_uri": testServer.URL + "/jwks",
+		})
+		require.NoError(t, err)
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		jwksRequests++
+		failRequest := jwksRequests%2 == 1
+		mu.Unlock()
+
+		// every other request fails
+		if failRequest {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 
-	keyHandler, err := newKeyHandler(http.DefaultClient, testServer.URL, 10*time.Millisecond, 100, disableKeyID)
-	require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(pubKeySet)
+		require.NoError(t, err)
+	})
 
-	token1 := testNewTokenString(t, keySets.privateKeySet)
+	sharedTransport := &testRecordingRoundTripper{
+		next: http.DefaultTransport,
+	}
 
-	parsedHeaders1, err := getHeadersFromTokenString(token1)
-	require.NoError(t, err)
+	jwksTransport := &testRecordingRoundTripper{
+		maxRetries: 2,
+		next:       http.DefaultTransport,
+	}
 
-	keyID, err := getKeyIDFromTokenHeader(parsedHeaders1)
+	// with a dedicated jwks http client, the failed jwks request is retried and the shared client isn't used
+	_, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer(testServer.URL),
+		options.WithHttpClient(&http.Client{Transport: sharedTransport}),
+		options.WithJwksHttpClient(&http.Client{Transport: jwksTransport}),
+	)
 	require.NoError(t, err)
+	require.Empty(t, sharedTransport.getRequests())
+	require.Equal(t, []string{"/.well-known/openid-configuration", "/jwks", "/jwks"}, jwksTransport.getRequests())
+
+	// without a dedicated jwks http client, the shared client is used and the failed jwks request isn't retried
+	_, err = NewHandler[testClaims](
+		nil,
+		options.WithIssuer(testServer.URL),
+		options.WithHttpClient(&http.Client{Transport: sharedTransport}),
+	)
+	require.ErrorContains(t, err, "unable to load jwks")
+	require.Equal(t, []string{"/.well-known/openid-configuration", "/jwks"}, sharedTransport.getRequests())
+}
+
+func TestGetOAuth2MetadataUriFromIssuer(t *testing.T) {
+	cases := []struct {
+		testDescription string
+		issuer          string
+		expectedResult  string
+	}{
+		{
+			testDescription: "issuer without path",
+			issuer:          "https://foo.bar",
+			expectedResult:  "https://foo.bar/.well-known/oauth-authorization-server",
+		},
+		{
+			testDescription: "issuer with trailing slash",
+			issuer:          "https://foo.bar/",
+			expectedResult:  "https://foo.bar/.well-known/oauth-authorization-server",
+		},
+		{
+			testDescription: "issuer with path",
+			issuer:          "https://foo.bar/tenant/baz",
+			expectedResult:  "https://foo.bar/.well-known/oauth-authorization-server/tenant/baz",
+		},
+		{
+			testDescription: "issuer with port and path",
+			issuer:          "http://foo.bar:8080/baz/",
+			expectedResult:  "http://foo.bar:8080/.well-known/oauth-authorization-server/baz",
+		},
+	}
 
-	tokenAlgorithm, err := getTokenAlgorithmFromTokenHeader(parsedHeaders1)
-	require.NoError(t, err)
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+		result := GetOAuth2MetadataUriFromIssuer(c.issuer)
+		require.Equal(t, c.expectedResult, result)
+	}
+}
 
-	pubKey, err := keyHandler.getKey(context.Background(), keyID, tokenAlgorithm)
-	require.NoError(t, err)
+func TestGetJwksUriFromOAuth2MetadataUri(t *testing.T) {
+	var metadata string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/oauth-authorization-server/baz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 
-	alg, err := getSignatureAlgorithm(pubKey.KeyType(), pubKey.Algorithm(), jwa.ES384)
-	require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(metadata))
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
 
-	_, err = getAndValidateTokenFromString(token1, pubKey, alg)
-	require.NoError(t, err)
+	issuer := testServer.URL + "/baz"
+	metadataUri := GetOAuth2MetadataUriFromIssuer(issuer)
 
-	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+	cases := []struct {
+		testDescription       string
+		metadata              string
+		expectedJwksUri       string
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "valid metadata",
+			metadata:              fmt.Sprintf(`{"issuer":%q,"jwks_uri":"https://foo.bar/jwks","token_endpoint":"https://foo.bar/token","response_types_supported":["code"]}`, issuer),
+			expectedJwksUri:       "https://foo.bar/jwks",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "issuer mismatch",
+			metadata:              `{"issuer":"https://foo.bar/baz","jwks_uri":"https://foo.bar/jwks"}`,
+			expectedJwksUri:       "",
+			expectedErrorContains: "metadata issuer \"https://foo.bar/baz\" doesn't match the required issuer",
+		},
+		{
+			testDescription:       "missing issuer",
+			metadata:              `{"jwks_uri":"https://foo.bar/jwks"}`,
+			expectedJwksUri:       "",
+			expectedErrorContains: "metadata issuer \"\" doesn't match the required issuer",
+		},
+		{
+			testDescription:       "missing jwks_uri",
+			metadata:              fmt.Sprintf(`{"issuer":%q}`, issuer),
+			expectedJwksUri:       "",
+			expectedErrorContains: "JwksUri is empty",
+		},
+	}
 
-	token2 := testNewTokenString(t, keySets.privateKeySet)
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
 
-	_, err = getAndValidateTokenFromString(token2, pubKey, alg)
-	require.Error(t, err)
+		metadata = c.metadata
+		data, err := getOAuth2MetadataData(context.Background(), http.DefaultClient, metadataUri, 100*time.Millisecond, issuer)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			require.Equal(t, c.expectedJwksUri, data.JwksUri)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
 }
 
-func TestGetAndValidateTokenFromStringWithoutKeyID(t *testing.T) {
-	disableKeyID := true
+func TestParseTokenWithOAuth2MetadataDiscoveryMode(t *testing.T) {
 	keySets := testNewTestKeySet(t)
-	testServer := testNewJwksServer(t, keySets)
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
 
-	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+	jwksServer := testNewJwksServer(t, keySets)
+	defer jwksServer.Close()
 
-	keyHandler, err := newKeyHandler(http.DefaultClient, testServer.URL, 10*time.Millisecond, 100, disableKeyID)
-	require.NoError(t, err)
+	issuer := "http://foo.bar"
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/oauth-authorization-server" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 
-	token1 := testNewTokenString(t, keySets.privateKeySet)
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   issuer,
+			"jwks_uri": jwksServer.URL,
+		})
+		require.NoError(t, err)
+	}))
+	defer testServer.Close()
 
-	parsedHeaders1, err := getHeadersFromTokenString(token1)
-	require.NoError(t, err)
+	metadataUri := testServer.URL + "/.well-known/oauth-authorization-server"
 
-	tokenAlgorithm, err := getTokenAlgorithmFromTokenHeader(parsedHeaders1)
-	require.NoError(t, err)
+	// the OpenID Connect discovery document doesn't exist
+	_, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer(issuer),
+		options.WithAllowInsecureIssuer(true),
+		options.WithDiscoveryUri(GetDiscoveryUriFromIssuer(testServer.URL)),
+	)
+	require.Error(t, err)
 
-	pubKey, err := keyHandler.getKey(context.Background(), "", tokenAlgorithm)
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer(issuer),
+		options.WithAllowInsecureIssuer(true),
+		options.WithDiscoveryUri(metadataUri),
+		options.WithDiscoveryMode(options.OAuth2MetadataDiscoveryMode),
+	)
 	require.NoError(t, err)
 
-	alg, err := getSignatureAlgorithm(pubKey.KeyType(), pubKey.Algorithm(), jwa.ES384)
-	require.NoError(t, err)
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
 
-	_, err = getAndValidateTokenFromString(token1, pubKey, alg)
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, nil))
 	require.NoError(t, err)
 
-	keySets.setKeys(testNewKeySet(t, 1, disableKeyID))
+	_, err = NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar/baz"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithDiscoveryUri(metadataUri),
+		options.WithDiscoveryMode(options.OAuth2MetadataDiscoveryMode),
+	)
+	require.ErrorContains(t, err, "doesn't match the required issuer")
+}
 
-	token2 := testNewTokenString(t, keySets.privateKeySet)
+func TestNewHandlerWithDiscoveryFetchTimeout(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer testServer.Close()
 
-	_, err = getAndValidateTokenFromString(token2, pubKey, alg)
-	require.ErrorIs(t, err, errSignatureVerification)
+	start := time.Now()
+	_, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithDiscoveryUri(testServer.URL),
+		options.WithDiscoveryFetchTimeout(50*time.Millisecond),
+	)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorIs(t, err, options.ErrJwksUnavailable)
+	require.Less(t, time.Since(start), time.Second)
 }
 
 func TestGetSignatureAlgorithm(t *testing.T) {
 	cases := []struct {
 		inputKty         jwa.KeyType
+		inputCrv         jwa.EllipticCurveAlgorithm
 		inputAlg         string
 		inputFallbackAlg jwa.SignatureAlgorithm
+		inputAllowES256K bool
 		expectedResult   jwa.SignatureAlgorithm
 		expectedError    bool
 	}{
@@ -995,12 +4429,66 @@ func TestGetSignatureAlgorithm(t *testing.T) {
 			expectedResult:   jwa.ES384,
 			expectedError:    false,
 		},
+		{
+			inputKty:         jwa.EC,
+			inputCrv:         secp256k1Curve,
+			inputAlg:         "ES256K",
+			inputFallbackAlg: "",
+			inputAllowES256K: true,
+			expectedResult:   jwa.ES256K,
+			expectedError:    false,
+		},
+		{
+			inputKty:         jwa.EC,
+			inputCrv:         secp256k1Curve,
+			inputAlg:         "",
+			inputFallbackAlg: "",
+			inputAllowES256K: true,
+			expectedResult:   jwa.ES256K,
+			expectedError:    false,
+		},
+		{
+			inputKty:         jwa.EC,
+			inputCrv:         secp256k1Curve,
+			inputAlg:         "ES256K",
+			inputFallbackAlg: "",
+			inputAllowES256K: false,
+			expectedResult:   "",
+			expectedError:    true,
+		},
+		{
+			inputKty:         jwa.EC,
+			inputCrv:         secp256k1Curve,
+			inputAlg:         "",
+			inputFallbackAlg: "",
+			inputAllowES256K: false,
+			expectedResult:   "",
+			expectedError:    true,
+		},
+		{
+			inputKty:         jwa.EC,
+			inputCrv:         jwa.P256,
+			inputAlg:         "ES256K",
+			inputFallbackAlg: "",
+			inputAllowES256K: true,
+			expectedResult:   "",
+			expectedError:    true,
+		},
+		{
+			inputKty:         jwa.EC,
+			inputCrv:         secp256k1Curve,
+			inputAlg:         "ES256",
+			inputFallbackAlg: "",
+			inputAllowES256K: true,
+			expectedResult:   "",
+			expectedError:    true,
+		},
 	}
 
 	for i, c := range cases {
-		t.Logf("Test iteration %d: inputKty=%s, inputAlg=%s, inputFallbackAlg=%s", i, c.inputKty, c.inputAlg, c.inputFallbackAlg)
+		t.Logf("Test iteration %d: inputKty=%s, inputCrv=%s, inputAlg=%s, inputFallbackAlg=%s, inputAllowES256K=%t", i, c.inputKty, c.inputCrv, c.inputAlg, c.inputFallbackAlg, c.inputAllowES256K)
 
-		result, err := getSignatureAlgorithm(c.inputKty, c.inputAlg, c.inputFallbackAlg)
+		result, err := getSignatureAlgorithm(c.inputKty, c.inputCrv, c.inputAlg, c.inputFallbackAlg, c.inputAllowES256K)
 		require.Equal(t, c.expectedResult, result)
 
 		if !c.expectedError {
@@ -1136,3 +4624,269 @@ func testNewCustomTokenString(t *testing.T, privKeySet jwk.Set, issuer string, e
 
 	return string(tokenBytes)
 }
+
+func testNewTokenStringWithKey(t *testing.T, privKey jwk.Key, alg jwa.SignatureAlgorithm, customClaims map[string]interface{}) string {
+	t.Helper()
+
+	jwtToken := jwt.New()
+	err := jwtToken.Set(jwt.IssuerKey, "http://foo.bar")
+	require.NoError(t, err)
+
+	err = jwtToken.Set(jwt.ExpirationKey, time.Now().Add(1*time.Minute).Unix())
+	require.NoError(t, err)
+
+	for k, v := range customClaims {
+		err := jwtToken.Set(k, v)
+		require.NoError(t, err)
+	}
+
+	headers := jws.NewHeaders()
+	err = headers.Set(jws.TypeKey, "JWT")
+	require.NoError(t, err)
+
+	tokenBytes, err := jwt.Sign(jwtToken, alg, privKey, jwt.WithHeaders(headers))
+	require.NoError(t, err)
+
+	return string(tokenBytes)
+}
+
+func TestNewHandlerWithAllowInsecureIssuer(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "https issuer",
+			options: []options.Option{
+				options.WithIssuer("https://foo.bar"),
+			},
+		},
+		{
+			testDescription: "http issuer",
+			options: []options.Option{
+				options.WithIssuer("http://foo.bar"),
+			},
+			expectedErrorContains: "issuer \"http://foo.bar\" is required to use https, use AllowInsecureIssuer to allow it",
+		},
+		{
+			testDescription: "http issuer with AllowInsecureIssuer",
+			options: []options.Option{
+				options.WithIssuer("http://foo.bar"),
+				options.WithAllowInsecureIssuer(true),
+			},
+		},
+		{
+			testDescription: "http localhost issuer",
+			options: []options.Option{
+				options.WithIssuer("http://localhost:8080"),
+			},
+		},
+		{
+			testDescription: "http ipv4 loopback issuer",
+			options: []options.Option{
+				options.WithIssuer("http://127.0.0.1:8080"),
+			},
+		},
+		{
+			testDescription: "http ipv6 loopback issuer",
+			options: []options.Option{
+				options.WithIssuer("http://[::1]:8080"),
+			},
+		},
+		{
+			testDescription: "http discovery uri",
+			options: []options.Option{
+				options.WithIssuer("https://foo.bar"),
+				options.WithDiscoveryUri("http://foo.bar/.well-known/openid-configuration"),
+			},
+			expectedErrorContains: "discoveryUri \"http://foo.bar/.well-known/openid-configuration\" is required to use https",
+		},
+		{
+			testDescription: "http jwks uri",
+			options: []options.Option{
+				options.WithIssuer("https://foo.bar"),
+				options.WithJwksUri("http://foo.bar/jwks"),
+			},
+			expectedErrorContains: "jwksUri \"http://foo.bar/jwks\" is required to use https",
+		},
+		{
+			testDescription: "http jwks uri with AllowInsecureIssuer",
+			options: []options.Option{
+				options.WithIssuer("https://foo.bar"),
+				options.WithJwksUri("http://foo.bar/jwks"),
+				options.WithAllowInsecureIssuer(true),
+			},
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		opts := append([]options.Option{options.WithLazyLoadJwks(true)}, c.options...)
+		_, err := NewHandler[testClaims](nil, opts...)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorContains(t, err, c.expectedErrorContains)
+	}
+}
+
+func TestNewHandlerWithMaxAllowedTokenDrift(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "default drift",
+			options:               nil,
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "drift at the default cap",
+			options: []options.Option{
+				options.WithAllowedTokenDrift(5 * time.Minute),
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "unreasonable drift",
+			options: []options.Option{
+				options.WithAllowedTokenDrift(24 * time.Hour),
+			},
+			expectedErrorContains: "AllowedTokenDrift 24h0m0s is larger than MaxAllowedTokenDrift 5m0s",
+		},
+		{
+			testDescription: "drift above custom cap",
+			options: []options.Option{
+				options.WithAllowedTokenDrift(time.Minute),
+				options.WithMaxAllowedTokenDrift(30 * time.Second),
+			},
+			expectedErrorContains: "AllowedTokenDrift 1m0s is larger than MaxAllowedTokenDrift 30s",
+		},
+		{
+			testDescription: "cap disabled",
+			options: []options.Option{
+				options.WithAllowedTokenDrift(24 * time.Hour),
+				options.WithMaxAllowedTokenDrift(0),
+			},
+			expectedErrorContains: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		opts := []options.Option{
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithLazyLoadJwks(true),
+		}
+
+		_, err := NewHandler[testClaims](nil, append(opts, c.options...)...)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestParseTokenWithAbsoluteMaxExpiryAge(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	cases := []struct {
+		testDescription       string
+		absoluteMaxExpiryAge  time.Duration
+		expiration            time.Time
+		expectedErrorContains string
+	}{
+		{
+			testDescription:      "not expired",
+			absoluteMaxExpiryAge: time.Hour,
+			expiration:           time.Now().Add(time.Minute),
+		},
+		{
+			testDescription:      "expired within the backstop",
+			absoluteMaxExpiryAge: time.Hour,
+			expiration:           time.Now().Add(-10 * time.Minute),
+		},
+		{
+			testDescription:       "expired before the backstop",
+			absoluteMaxExpiryAge:  time.Hour,
+			expiration:            time.Now().Add(-2 * time.Hour),
+			expectedErrorContains: "token expired more than the absolute max expiry age 1h0m0s ago",
+		},
+		{
+			testDescription:       "expired before the drift",
+			absoluteMaxExpiryAge:  time.Hour,
+			expiration:            time.Now().Add(-48 * time.Hour),
+			expectedErrorContains: "token has expired",
+		},
+		{
+			testDescription:      "backstop disabled",
+			absoluteMaxExpiryAge: 0,
+			expiration:           time.Now().Add(-2 * time.Hour),
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](
+			nil,
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithAllowedTokenDrift(24*time.Hour),
+			options.WithMaxAllowedTokenDrift(0),
+			options.WithAbsoluteMaxExpiryAge(c.absoluteMaxExpiryAge),
+		)
+		require.NoError(t, err)
+
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"exp": c.expiration.Unix()})
+
+		_, err = h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorContains(t, err, c.expectedErrorContains)
+		require.Equal(t, options.ExpiredValidationFailureReason, GetValidationFailureReason(err))
+	}
+}
+
+func TestNewHandlerWithAllowES256K(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	_, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithFallbackSignatureAlgorithm("ES256K"),
+	)
+	require.ErrorContains(t, err, "signature algorithm ES256K requires AllowES256K")
+
+	_, err = NewHandler[testClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithAllowES256K(true),
+	)
+	if isES256KSupported() {
+		require.NoError(t, err)
+	} else {
+		require.ErrorContains(t, err, "AllowES256K requires the jwx_es256k build tag")
+	}
+}
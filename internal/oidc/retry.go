@@ -0,0 +1,40 @@
+package oidc
+
+import (
+	"context"
+	"time"
+
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// initKeyHandlerWithRetries runs initKeyHandler, retrying the jwks download JwksFetchRetries times.
+func (h *handler[T]) initKeyHandlerWithRetries(ctx context.Context, jwksUri string) (*keyHandler, error) {
+	return retryWithBackoff(ctx, h.jwksFetchRetries, h.jwksFetchRetryDelay, h.logger, "jwks", func() (*keyHandler, error) {
+		return h.initKeyHandler(jwksUri)
+	})
+}
+
+// retryWithBackoff calls fn until it succeeds or has been retried the number of retries. The
+// delay before the first retry is retryDelay and it's doubled for each following retry. The last
+// error is returned if ctx is done while waiting.
+func retryWithBackoff[R any](ctx context.Context, retries int, retryDelay time.Duration, logger options.Logger, name string,
+	fn func() (R, error)) (R, error) {
+	result, err := fn()
+	delay := retryDelay
+	for retry := 1; err != nil && retry <= retries; retry++ {
+		logger.Debug("retrying fetch after error", "fetch", name, "retry", retry, "delay", delay, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+
+		result, err = fn()
+		delay *= 2
+	}
+
+	return result, err
+}
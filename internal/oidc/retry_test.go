@@ -0,0 +1,157 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestNewHandlerWithJwksFetchRetries(t *testing.T) {
+	_, pubKeySet := testNewKeySet(t, 1, false)
+
+	cases := []struct {
+		testDescription           string
+		discoveryFailures         int32
+		jwksFailures              int32
+		retries                   int
+		expectedDiscoveryRequests int32
+		expectedJwksRequests      int32
+		expectedErrorContains     string
+	}{
+		{
+			testDescription:           "no failures",
+			retries:                   2,
+			expectedDiscoveryRequests: 1,
+			expectedJwksRequests:      1,
+		},
+		{
+			testDescription:           "discovery failing before succeeding",
+			discoveryFailures:         2,
+			retries:                   2,
+			expectedDiscoveryRequests: 3,
+			expectedJwksRequests:      1,
+		},
+		{
+			testDescription:           "jwks failing before succeeding",
+			jwksFailures:              2,
+			retries:                   2,
+			expectedDiscoveryRequests: 1,
+			expectedJwksRequests:      3,
+		},
+		{
+			testDescription:           "discovery failing more times than the retries",
+			discoveryFailures:         3,
+			retries:                   2,
+			expectedDiscoveryRequests: 3,
+			expectedJwksRequests:      0,
+			expectedErrorContains:     "unable to fetch jwksUri from discoveryUri",
+		},
+		{
+			testDescription:           "jwks failing more times than the retries",
+			jwksFailures:              3,
+			retries:                   2,
+			expectedDiscoveryRequests: 1,
+			expectedJwksRequests:      3,
+			expectedErrorContains:     "unable to initialize keyHandler",
+		},
+		{
+			testDescription:           "jwks failing without retries",
+			jwksFailures:              1,
+			expectedDiscoveryRequests: 1,
+			expectedJwksRequests:      1,
+			expectedErrorContains:     "unable to initialize keyHandler",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		var discoveryRequests, jwksRequests int32
+		mux := http.NewServeMux()
+		testServer := httptest.NewServer(mux)
+
+		mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&discoveryRequests, 1) <= c.discoveryFailures {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			err := json.NewEncoder(w).Encode(map[string]string{"jwks_uri": fmt.Sprintf("%s/jwks", testServer.URL)})
+			require.NoError(t, err)
+		})
+
+		mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&jwksRequests, 1) <= c.jwksFailures {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			err := json.NewEncoder(w).Encode(pubKeySet)
+			require.NoError(t, err)
+		})
+
+		h, err := NewHandler[testClaims](nil,
+			options.WithIssuer(testServer.URL),
+			options.WithJwksRateLimit(100),
+			options.WithJwksFetchRetries(c.retries),
+			options.WithJwksFetchRetryDelay(time.Millisecond),
+		)
+		testServer.Close()
+
+		require.Equal(t, c.expectedDiscoveryRequests, atomic.LoadInt32(&discoveryRequests))
+		require.Equal(t, c.expectedJwksRequests, atomic.LoadInt32(&jwksRequests))
+
+		if c.expectedErrorContains != "" {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, c.retries, h.Config().JwksFetchRetries)
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	logger := getLogger(nil)
+
+	calls := 0
+	start := time.Now()
+	result, err := retryWithBackoff(context.Background(), 3, 10*time.Millisecond, logger, "foo", func() (string, error) {
+		calls++
+		if calls < 4 {
+			return "", fmt.Errorf("failure %d", calls)
+		}
+
+		return "foo", nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "foo", result)
+	require.Equal(t, 4, calls)
+
+	// the delays are 10ms, 20ms and 40ms
+	require.GreaterOrEqual(t, time.Since(start), 70*time.Millisecond)
+
+	// the last error is returned when the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	calls = 0
+	start = time.Now()
+	_, err = retryWithBackoff(ctx, 3, time.Hour, logger, "foo", func() (string, error) {
+		calls++
+		return "", fmt.Errorf("failure %d", calls)
+	})
+	require.EqualError(t, err, "failure 1")
+	require.Equal(t, 1, calls)
+	require.Less(t, time.Since(start), time.Second)
+}
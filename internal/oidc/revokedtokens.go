@@ -0,0 +1,39 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// validateTokenNotRevoked returns an error wrapping options.ErrRevokedToken if the token id (`jti`)
+// is in RevokedTokenIDs or returned by RevokedTokenIDsFn. It isn't part of validatePolicy, since
+// the revoked token ids can change and the outcome can't be stored in the decision cache.
+func (h *handler[T]) validateTokenNotRevoked(ctx context.Context, token jwt.Token) error {
+	if len(h.revokedTokenIDs) == 0 && h.revokedTokenIDsFn == nil {
+		return nil
+	}
+
+	jti := token.JwtID()
+	if jti == "" {
+		return nil
+	}
+
+	_, revoked := h.revokedTokenIDs[jti]
+	if !revoked && h.revokedTokenIDsFn != nil {
+		revokedTokenIDs, err := h.revokedTokenIDsFn(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to get revoked token ids: %w", err)
+		}
+
+		_, revoked = revokedTokenIDs[jti]
+	}
+
+	if revoked {
+		return fmt.Errorf("%w: token id %q", options.ErrRevokedToken, jti)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithRevokedTokenIDs(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	revokedTokenIDsFn := func(ctx context.Context) (map[string]struct{}, error) {
+		return map[string]struct{}{"bar": {}}, nil
+	}
+
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		claims                map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "revoked token id",
+			options:               []options.Option{options.WithRevokedTokenIDs([]string{"foo"})},
+			claims:                map[string]interface{}{"jti": "foo"},
+			expectedErrorContains: "token is revoked: token id \"foo\"",
+		},
+		{
+			testDescription: "token id that isn't revoked",
+			options:         []options.Option{options.WithRevokedTokenIDs([]string{"foo"})},
+			claims:          map[string]interface{}{"jti": "baz"},
+		},
+		{
+			testDescription: "token without token id",
+			options:         []options.Option{options.WithRevokedTokenIDs([]string{"foo"})},
+		},
+		{
+			testDescription:       "token id revoked by RevokedTokenIDsFn",
+			options:               []options.Option{options.WithRevokedTokenIDsFn(revokedTokenIDsFn)},
+			claims:                map[string]interface{}{"jti": "bar"},
+			expectedErrorContains: "token is revoked: token id \"bar\"",
+		},
+		{
+			testDescription: "token id that isn't revoked by RevokedTokenIDsFn",
+			options:         []options.Option{options.WithRevokedTokenIDsFn(revokedTokenIDsFn)},
+			claims:          map[string]interface{}{"jti": "foo"},
+		},
+		{
+			testDescription: "token id revoked by RevokedTokenIDs together with RevokedTokenIDsFn",
+			options: []options.Option{
+				options.WithRevokedTokenIDs([]string{"foo"}),
+				options.WithRevokedTokenIDsFn(revokedTokenIDsFn),
+			},
+			claims:                map[string]interface{}{"jti": "foo"},
+			expectedErrorContains: "token is revoked: token id \"foo\"",
+		},
+		{
+			testDescription: "RevokedTokenIDsFn returning an error",
+			options: []options.Option{
+				options.WithRevokedTokenIDsFn(func(ctx context.Context) (map[string]struct{}, error) {
+					return nil, fmt.Errorf("revocation list unavailable")
+				}),
+			},
+			claims:                map[string]interface{}{"jti": "foo"},
+			expectedErrorContains: "unable to get revoked token ids: revocation list unavailable",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		h, err := NewHandler[testClaims](nil, append([]options.Option{
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+		}, c.options...)...)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, c.claims))
+		if c.expectedErrorContains != "" {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+			continue
+		}
+
+		require.NoError(t, err)
+	}
+}
+
+func TestParseTokenWithRevokedTokenIDsAndTokenCache(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	revokedTokenIDs := map[string]struct{}{}
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithTokenCacheTTL(time.Minute),
+		options.WithRevokedTokenIDs([]string{"bar"}),
+		options.WithRevokedTokenIDsFn(func(ctx context.Context) (map[string]struct{}, error) {
+			return revokedTokenIDs, nil
+		}),
+	)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bar"}, h.Config().RevokedTokenIDs)
+
+	token := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"jti": "foo"})
+
+	_, err = h.ParseToken(context.Background(), token)
+	require.NoError(t, err)
+	require.Equal(t, 1, h.tokenCache.len())
+
+	// cached tokens are rejected after being revoked
+	revokedTokenIDs["foo"] = struct{}{}
+
+	_, err = h.ParseToken(context.Background(), token)
+	require.ErrorIs(t, err, options.ErrRevokedToken)
+}
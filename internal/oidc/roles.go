@@ -0,0 +1,159 @@
+package oidc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// getRolesFromClaimValue normalizes the roles claim to a list of roles. The claim can either be
+// an array of strings or a delimited string. If delimiter is empty, the string is split on both
+// commas and whitespace. Arrays are handled like the scopes claims.
+func getRolesFromClaimValue(claimValue interface{}, delimiter string) ([]string, error) {
+	s, ok := claimValue.(string)
+	if ok {
+		return splitRoles(s, delimiter), nil
+	}
+
+	return getScopesFromClaimValue(claimValue)
+}
+
+func splitRoles(s string, delimiter string) []string {
+	var parts []string
+	if delimiter == "" {
+		parts = strings.FieldsFunc(s, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+	} else {
+		parts = strings.Split(s, delimiter)
+	}
+
+	var roles []string
+	for _, part := range parts {
+		role := strings.TrimSpace(part)
+		if role == "" {
+			continue
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles
+}
+
+// expandRoles returns the roles together with all the roles they imply, transitively, according
+// to roleHierarchy. Cycles in the hierarchy are handled by expanding each role only once.
+func expandRoles(roles []string, roleHierarchy map[string][]string) []string {
+	if len(roleHierarchy) == 0 {
+		return roles
+	}
+
+	expanded := make(map[string]struct{}, len(roles))
+	queue := append([]string(nil), roles...)
+	for len(queue) > 0 {
+		role := queue[0]
+		queue = queue[1:]
+
+		_, ok := expanded[role]
+		if ok {
+			continue
+		}
+
+		expanded[role] = struct{}{}
+		queue = append(queue, roleHierarchy[role]...)
+	}
+
+	expandedRoles := make([]string, 0, len(expanded))
+	for role := range expanded {
+		expandedRoles = append(expandedRoles, role)
+	}
+
+	sort.Strings(expandedRoles)
+
+	return expandedRoles
+}
+
+// getNestedClaimValue returns the value of a claim nested in json objects,
+// as an example `resource_access.<client>.roles` used by Keycloak.
+// The error contains the path segment that is missing or isn't an object.
+func getNestedClaimValue(token jwt.Token, path ...string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("claim path is empty")
+	}
+
+	claimPath := strings.Join(path, ".")
+
+	value, ok := token.Get(path[0])
+	if !ok {
+		return nil, fmt.Errorf("claim path %q missing segment %q", claimPath, path[0])
+	}
+
+	for i, key := range path[1:] {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("claim path %q segment %q is not an object, received: %T", claimPath, path[i], value)
+		}
+
+		value, ok = object[key]
+		if !ok {
+			return nil, fmt.Errorf("claim path %q missing segment %q", claimPath, key)
+		}
+	}
+
+	return value, nil
+}
+
+// validateNestedRoles validates that the required roles are present in the claim at path.
+// The claim can be an array of strings, like the Keycloak roles claims, or a string delimited
+// by commas or whitespace.
+func validateNestedRoles(requiredRoles []string, token jwt.Token, path ...string) error {
+	claimName := strings.Join(path, ".")
+
+	claimValue, err := getNestedClaimValue(token, path...)
+	if err != nil {
+		return fmt.Errorf("required roles %v were not found, token does not contain claim %q: %w", requiredRoles, claimName, err)
+	}
+
+	roles, err := getRolesFromClaimValue(claimValue, "")
+	if err != nil {
+		return fmt.Errorf("unable to get roles from claim %q: %w", claimName, err)
+	}
+
+	missingRoles := GetMissingScopes(requiredRoles, roles)
+	if len(missingRoles) > 0 {
+		return fmt.Errorf("required roles %v were not found in claim %q, received: %v", missingRoles, claimName, roles)
+	}
+
+	return nil
+}
+
+// validateRealmRoles validates the Keycloak realm roles in `realm_access.roles`.
+func validateRealmRoles(requiredRealmRoles []string, token jwt.Token) error {
+	return validateNestedRoles(requiredRealmRoles, token, "realm_access", "roles")
+}
+
+// validateClientRoles validates the Keycloak client roles in `resource_access.<client>.roles`.
+func validateClientRoles(requiredClientRoles map[string][]string, token jwt.Token) error {
+	clients := make([]string, 0, len(requiredClientRoles))
+	for client := range requiredClientRoles {
+		clients = append(clients, client)
+	}
+
+	sort.Strings(clients)
+
+	for _, client := range clients {
+		if len(requiredClientRoles[client]) == 0 {
+			continue
+		}
+
+		err := validateNestedRoles(requiredClientRoles[client], token, "resource_access", client, "roles")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,479 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestGetRolesFromClaimValue(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		claimValue            interface{}
+		delimiter             string
+		expectedRoles         []string
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "space delimited string",
+			claimValue:      "admin user",
+			delimiter:       "",
+			expectedRoles:   []string{"admin", "user"},
+		},
+		{
+			testDescription: "comma delimited string",
+			claimValue:      "admin,user",
+			delimiter:       "",
+			expectedRoles:   []string{"admin", "user"},
+		},
+		{
+			testDescription: "comma and space delimited string",
+			claimValue:      "admin, user ,reader",
+			delimiter:       "",
+			expectedRoles:   []string{"admin", "user", "reader"},
+		},
+		{
+			testDescription: "custom delimiter",
+			claimValue:      "admin role;user",
+			delimiter:       ";",
+			expectedRoles:   []string{"admin role", "user"},
+		},
+		{
+			testDescription: "empty string",
+			claimValue:      "",
+			delimiter:       "",
+			expectedRoles:   nil,
+		},
+		{
+			testDescription: "array",
+			claimValue:      []interface{}{"admin", "user"},
+			delimiter:       "",
+			expectedRoles:   []string{"admin", "user"},
+		},
+		{
+			testDescription:       "array with invalid type",
+			claimValue:            []interface{}{"admin", 1234.0},
+			delimiter:             "",
+			expectedErrorContains: "expected string in array, received type: float64",
+		},
+		{
+			testDescription:       "invalid type",
+			claimValue:            true,
+			delimiter:             "",
+			expectedErrorContains: "expected string or array, received type: bool",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		roles, err := getRolesFromClaimValue(c.claimValue, c.delimiter)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			require.Equal(t, c.expectedRoles, roles)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestParseTokenWithRequiredRoles(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "roles as array",
+			customClaims: map[string]interface{}{
+				"roles": []string{"reader", "admin"},
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "roles as space delimited string",
+			customClaims: map[string]interface{}{
+				"roles": "reader admin",
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "roles as comma delimited string",
+			customClaims: map[string]interface{}{
+				"roles": "reader,admin",
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "roles with custom delimiter",
+			options: []options.Option{
+				options.WithRolesDelimiter("|"),
+			},
+			customClaims: map[string]interface{}{
+				"roles": "reader|admin",
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "roles with custom claim name",
+			options: []options.Option{
+				options.WithRolesClaimName("groups"),
+			},
+			customClaims: map[string]interface{}{
+				"groups": []string{"admin"},
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "roles with claim namespace",
+			options: []options.Option{
+				options.WithClaimNamespace("https://myapp.com/"),
+			},
+			customClaims: map[string]interface{}{
+				"https://myapp.com/roles": []string{"admin"},
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "roles with other claim namespace",
+			options: []options.Option{
+				options.WithClaimNamespace("https://myapp.com/"),
+			},
+			customClaims: map[string]interface{}{
+				"https://otherapp.com/roles": []string{"admin"},
+			},
+			expectedErrorContains: "token does not contain claim \"roles\"",
+		},
+		{
+			testDescription: "missing role in array",
+			customClaims: map[string]interface{}{
+				"roles": []string{"reader"},
+			},
+			expectedErrorContains: "required roles [admin] were not found, received: [reader]",
+		},
+		{
+			testDescription: "missing role in string",
+			customClaims: map[string]interface{}{
+				"roles": "reader, writer",
+			},
+			expectedErrorContains: "required roles [admin] were not found, received: [reader writer]",
+		},
+		{
+			testDescription:       "missing roles claim",
+			customClaims:          nil,
+			expectedErrorContains: "token does not contain claim \"roles\"",
+		},
+		{
+			testDescription: "invalid roles claim",
+			customClaims: map[string]interface{}{
+				"roles": 1234,
+			},
+			expectedErrorContains: "unable to get roles from claim \"roles\"",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		opts := []options.Option{
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithJwksUri(testServer.URL),
+			options.WithRequiredRoles([]string{"admin"}),
+		}
+
+		h, err := NewHandler[testClaims](nil, append(opts, c.options...)...)
+		require.NoError(t, err)
+
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims)
+
+		_, err = h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestExpandRoles(t *testing.T) {
+	roleHierarchy := map[string][]string{
+		"admin":  {"editor"},
+		"editor": {"viewer", "commenter"},
+		"owner":  {"admin", "billing"},
+		"cyclic": {"cyclic", "viewer"},
+	}
+
+	cases := []struct {
+		testDescription string
+		roles           []string
+		roleHierarchy   map[string][]string
+		expectedRoles   []string
+	}{
+		{
+			testDescription: "without hierarchy",
+			roles:           []string{"admin"},
+			roleHierarchy:   nil,
+			expectedRoles:   []string{"admin"},
+		},
+		{
+			testDescription: "transitive roles",
+			roles:           []string{"admin"},
+			roleHierarchy:   roleHierarchy,
+			expectedRoles:   []string{"admin", "commenter", "editor", "viewer"},
+		},
+		{
+			testDescription: "multiple roles",
+			roles:           []string{"owner", "viewer"},
+			roleHierarchy:   roleHierarchy,
+			expectedRoles:   []string{"admin", "billing", "commenter", "editor", "owner", "viewer"},
+		},
+		{
+			testDescription: "role without implied roles",
+			roles:           []string{"viewer"},
+			roleHierarchy:   roleHierarchy,
+			expectedRoles:   []string{"viewer"},
+		},
+		{
+			testDescription: "cycle in the hierarchy",
+			roles:           []string{"cyclic"},
+			roleHierarchy:   roleHierarchy,
+			expectedRoles:   []string{"cyclic", "viewer"},
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		require.Equal(t, c.expectedRoles, expandRoles(c.roles, c.roleHierarchy))
+	}
+}
+
+func TestParseTokenWithRoleHierarchy(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithRequiredRoles([]string{"viewer"}),
+		options.WithRoleHierarchy(map[string][]string{
+			"admin":  {"editor"},
+			"editor": {"viewer"},
+		}),
+	)
+	require.NoError(t, err)
+
+	cases := []struct {
+		testDescription       string
+		roles                 interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "viewer",
+			roles:           []string{"viewer"},
+		},
+		{
+			testDescription: "editor implies viewer",
+			roles:           []string{"editor"},
+		},
+		{
+			testDescription: "admin implies viewer through editor",
+			roles:           []string{"admin"},
+		},
+		{
+			testDescription: "admin as delimited string",
+			roles:           "admin",
+		},
+		{
+			testDescription:       "role outside the hierarchy",
+			roles:                 []string{"billing"},
+			expectedErrorContains: "required roles [viewer] were not found, received: [billing]",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		tokenString := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"roles": c.roles})
+
+		_, err = h.ParseToken(context.Background(), tokenString)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			continue
+		}
+
+		require.ErrorContains(t, err, c.expectedErrorContains)
+	}
+}
+
+func TestParseTokenWithRequiredKeycloakRoles(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	issuer := "https://keycloak.example.com/realms/foo"
+
+	keycloakClaims := func(realmRoles []string, clientRoles map[string][]string) map[string]interface{} {
+		resourceAccess := make(map[string]interface{})
+		for client, roles := range clientRoles {
+			resourceAccess[client] = map[string]interface{}{"roles": roles}
+		}
+
+		return map[string]interface{}{
+			"iss":                issuer,
+			"aud":                "account",
+			"azp":                "my-api",
+			"typ":                "Bearer",
+			"sub":                "f1ee5f3c-6e3a-4a2a-8f8e-4f4c0c5e2a10",
+			"session_state":      "0b3d4e2a-9c1f-4c1e-8e6a-2f4d7f7c1b5e",
+			"scope":              "openid profile email",
+			"preferred_username": "test",
+			"realm_access": map[string]interface{}{
+				"roles": realmRoles,
+			},
+			"resource_access": resourceAccess,
+		}
+	}
+
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		customClaims          map[string]interface{}
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "realm and client roles",
+			options: []options.Option{
+				options.WithRequiredRealmRoles([]string{"offline_access", "admin"}),
+				options.WithRequiredClientRoles(map[string][]string{"my-api": {"read"}, "account": {"view-profile"}}),
+			},
+			customClaims: keycloakClaims(
+				[]string{"offline_access", "uma_authorization", "admin"},
+				map[string][]string{"my-api": {"read", "write"}, "account": {"manage-account", "view-profile"}},
+			),
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "missing realm role",
+			options: []options.Option{
+				options.WithRequiredRealmRoles([]string{"admin"}),
+			},
+			customClaims:          keycloakClaims([]string{"offline_access"}, nil),
+			expectedErrorContains: "required roles [admin] were not found in claim \"realm_access.roles\", received: [offline_access]",
+		},
+		{
+			testDescription: "missing client role",
+			options: []options.Option{
+				options.WithRequiredClientRoles(map[string][]string{"my-api": {"write"}}),
+			},
+			customClaims:          keycloakClaims(nil, map[string][]string{"my-api": {"read"}}),
+			expectedErrorContains: "required roles [write] were not found in claim \"resource_access.my-api.roles\", received: [read]",
+		},
+		{
+			testDescription: "missing client",
+			options: []options.Option{
+				options.WithRequiredClientRoles(map[string][]string{"my-api": {"read"}}),
+			},
+			customClaims:          keycloakClaims(nil, map[string][]string{"account": {"read"}}),
+			expectedErrorContains: "token does not contain claim \"resource_access.my-api.roles\": claim path \"resource_access.my-api.roles\" missing segment \"my-api\"",
+		},
+		{
+			testDescription: "missing client roles",
+			options: []options.Option{
+				options.WithRequiredClientRoles(map[string][]string{"my-api": {"read"}}),
+			},
+			customClaims: map[string]interface{}{
+				"iss":             issuer,
+				"azp":             "my-api",
+				"resource_access": map[string]interface{}{"my-api": map[string]interface{}{}},
+			},
+			expectedErrorContains: "claim path \"resource_access.my-api.roles\" missing segment \"roles\"",
+		},
+		{
+			testDescription: "client is not an object",
+			options: []options.Option{
+				options.WithRequiredClientRoles(map[string][]string{"my-api": {"read"}}),
+			},
+			customClaims: map[string]interface{}{
+				"iss":             issuer,
+				"azp":             "my-api",
+				"resource_access": map[string]interface{}{"my-api": []string{"read"}},
+			},
+			expectedErrorContains: "claim path \"resource_access.my-api.roles\" segment \"my-api\" is not an object, received: []interface {}",
+		},
+		{
+			testDescription: "client roles leaf is not an array of strings",
+			options: []options.Option{
+				options.WithRequiredClientRoles(map[string][]string{"my-api": {"read"}}),
+			},
+			customClaims: map[string]interface{}{
+				"iss":             issuer,
+				"azp":             "my-api",
+				"resource_access": map[string]interface{}{"my-api": map[string]interface{}{"roles": 123}},
+			},
+			expectedErrorContains: "unable to get roles from claim \"resource_access.my-api.roles\"",
+		},
+		{
+			testDescription: "missing realm_access",
+			options: []options.Option{
+				options.WithRequiredRealmRoles([]string{"admin"}),
+			},
+			customClaims:          map[string]interface{}{"iss": issuer, "azp": "my-api"},
+			expectedErrorContains: "token does not contain claim \"realm_access.roles\": claim path \"realm_access.roles\" missing segment \"realm_access\"",
+		},
+		{
+			testDescription:       "wrong authorized party",
+			options:               nil,
+			customClaims:          map[string]interface{}{"iss": issuer, "azp": "other-api"},
+			expectedErrorContains: "required audience \"my-api\" was not found",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		opts := append([]options.Option{
+			options.ProfileKeycloak(issuer, "my-api"),
+			options.WithJwksUri(testServer.URL),
+		}, c.options...)
+
+		h, err := NewHandler[testClaims](nil, opts...)
+		require.NoError(t, err)
+
+		_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, c.customClaims))
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// scopeClaimNames are the claims containing scopes, `scope` (RFC 8693) and `scp` (Azure AD and Okta).
+var scopeClaimNames = []string{"scope", "scp"}
+
+// GetScopesFromClaims returns the scopes from the claims. Both the `scope` claim
+// (space separated string, RFC 8693) and the `scp` claim (string or array of strings,
+// used by Azure AD and Okta) are supported.
+// The claims can be of any type that can be marshalled to a json object.
+func GetScopesFromClaims(claims interface{}) ([]string, error) {
+	rawClaims, ok := claims.(map[string]interface{})
+	if !ok {
+		claimsBytes, err := json.Marshal(claims)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal claims to json: %w", err)
+		}
+
+		err = json.Unmarshal(claimsBytes, &rawClaims)
+		if err != nil {
+			return nil, fmt.Errorf("unable to unmarshal claims from json: %w", err)
+		}
+	}
+
+	var scopes []string
+	for _, claimName := range scopeClaimNames {
+		claimValue, ok := rawClaims[claimName]
+		if !ok {
+			continue
+		}
+
+		claimScopes, err := getScopesFromClaimValue(claimValue)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get scopes from claim %q: %w", claimName, err)
+		}
+
+		scopes = append(scopes, claimScopes...)
+	}
+
+	return scopes, nil
+}
+
+func getScopesFromToken(token jwt.Token) ([]string, error) {
+	var scopes []string
+	for _, claimName := range scopeClaimNames {
+		claimValue, ok := token.Get(claimName)
+		if !ok {
+			continue
+		}
+
+		claimScopes, err := getScopesFromClaimValue(claimValue)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get scopes from claim %q: %w", claimName, err)
+		}
+
+		scopes = append(scopes, claimScopes...)
+	}
+
+	return scopes, nil
+}
+
+func getScopesFromClaimValue(claimValue interface{}) ([]string, error) {
+	switch v := claimValue.(type) {
+	case string:
+		return strings.Fields(v), nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		var scopes []string
+		for _, value := range v {
+			scope, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string in array, received type: %T", value)
+			}
+
+			scopes = append(scopes, scope)
+		}
+
+		return scopes, nil
+	default:
+		return nil, fmt.Errorf("expected string or array, received type: %T", claimValue)
+	}
+}
+
+// GetMissingScopes returns the required scopes that can't be found in scopes.
+func GetMissingScopes(requiredScopes []string, scopes []string) []string {
+	var missingScopes []string
+	for _, requiredScope := range requiredScopes {
+		found := false
+		for _, scope := range scopes {
+			if scope == requiredScope {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			missingScopes = append(missingScopes, requiredScope)
+		}
+	}
+
+	return missingScopes
+}
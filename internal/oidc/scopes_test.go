@@ -0,0 +1,122 @@
+package oidc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetScopesFromClaims(t *testing.T) {
+	type typedClaims struct {
+		Scope string `json:"scope"`
+	}
+
+	cases := []struct {
+		testDescription       string
+		claims                interface{}
+		expectedScopes        []string
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "no scopes",
+			claims:                map[string]interface{}{"sub": "foo"},
+			expectedScopes:        nil,
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "scope claim",
+			claims:                map[string]interface{}{"scope": "foo bar"},
+			expectedScopes:        []string{"foo", "bar"},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "scp claim as string",
+			claims:                map[string]interface{}{"scp": "foo bar"},
+			expectedScopes:        []string{"foo", "bar"},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "scp claim as array",
+			claims:                map[string]interface{}{"scp": []interface{}{"foo", "bar"}},
+			expectedScopes:        []string{"foo", "bar"},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "scope and scp claims",
+			claims:                map[string]interface{}{"scope": "foo", "scp": []string{"bar"}},
+			expectedScopes:        []string{"foo", "bar"},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "typed claims",
+			claims:                typedClaims{Scope: "foo bar"},
+			expectedScopes:        []string{"foo", "bar"},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "invalid scope claim",
+			claims:                map[string]interface{}{"scope": 1234},
+			expectedScopes:        nil,
+			expectedErrorContains: "unable to get scopes from claim \"scope\"",
+		},
+		{
+			testDescription:       "invalid scp claim array",
+			claims:                map[string]interface{}{"scp": []interface{}{"foo", 1234}},
+			expectedScopes:        nil,
+			expectedErrorContains: "expected string in array",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		scopes, err := GetScopesFromClaims(c.claims)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+			require.Equal(t, c.expectedScopes, scopes)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestGetMissingScopes(t *testing.T) {
+	cases := []struct {
+		testDescription string
+		requiredScopes  []string
+		scopes          []string
+		expectedResult  []string
+	}{
+		{
+			testDescription: "no required scopes",
+			requiredScopes:  nil,
+			scopes:          []string{"foo"},
+			expectedResult:  nil,
+		},
+		{
+			testDescription: "all required scopes",
+			requiredScopes:  []string{"foo", "bar"},
+			scopes:          []string{"bar", "baz", "foo"},
+			expectedResult:  nil,
+		},
+		{
+			testDescription: "missing required scope",
+			requiredScopes:  []string{"foo", "bar"},
+			scopes:          []string{"foo"},
+			expectedResult:  []string{"bar"},
+		},
+		{
+			testDescription: "no scopes",
+			requiredScopes:  []string{"foo"},
+			scopes:          nil,
+			expectedResult:  []string{"foo"},
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		result := GetMissingScopes(c.requiredScopes, c.scopes)
+		require.Equal(t, c.expectedResult, result)
+	}
+}
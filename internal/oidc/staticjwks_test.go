@@ -0,0 +1,117 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestParseTokenWithJwksJSON(t *testing.T) {
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	unknownPrivKeySet, _ := testNewKeySet(t, 1, false)
+
+	jwksJSON, err := json.Marshal(pubKeySet)
+	require.NoError(t, err)
+
+	// no server is running, the issuer and jwks uri can't be reached
+	h, err := NewHandler[testClaims](
+		nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri("http://foo.bar/jwks"),
+		options.WithJwksJSON(jwksJSON),
+		options.WithBackgroundRefreshInterval(10*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer h.Close()
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	unknownPrivKey, ok := unknownPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, privKey, jwa.ES384, nil))
+	require.NoError(t, err)
+
+	// the static jwks is never refreshed, tokens with unknown key ids are rejected
+	_, err = h.ParseToken(context.Background(), testNewTokenStringWithKey(t, unknownPrivKey, jwa.ES384, nil))
+	require.ErrorIs(t, err, options.ErrUnknownKeyID)
+	require.Equal(t, 1, h.getKeyHandler().keyUpdateCount)
+}
+
+func TestNewHandlerWithJwksJSON(t *testing.T) {
+	_, pubKeySet := testNewKeySet(t, 1, false)
+
+	jwksJSON, err := json.Marshal(pubKeySet)
+	require.NoError(t, err)
+
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "valid jwks",
+			options: []options.Option{
+				options.WithJwksJSON(jwksJSON),
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "invalid jwks",
+			options: []options.Option{
+				options.WithJwksJSON([]byte("foobar")),
+			},
+			expectedErrorContains: "JwksJSON not accepted",
+		},
+		{
+			testDescription: "together with KeySourceFunc",
+			options: []options.Option{
+				options.WithJwksJSON(jwksJSON),
+				options.WithKeySourceFunc(func(ctx context.Context) (jwk.Set, error) {
+					return pubKeySet, nil
+				}),
+			},
+			expectedErrorContains: "JwksJSON can't be used together with KeySourceFunc",
+		},
+		{
+			testDescription: "together with IntrospectionUri",
+			options: []options.Option{
+				options.WithJwksJSON(jwksJSON),
+				options.WithIntrospectionUri("http://foo.bar/introspect"),
+			},
+			expectedErrorContains: "JwksJSON can't be used together with IntrospectionUri",
+		},
+		{
+			testDescription: "together with Issuers",
+			options: []options.Option{
+				options.WithJwksJSON(jwksJSON),
+				options.WithIssuers(options.IssuerConfig{Issuer: "http://bar.baz"}),
+			},
+			expectedErrorContains: "JwksJSON can't be used together with Issuers",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		opts := []options.Option{
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+		}
+
+		_, err := NewHandler[testClaims](nil, append(opts, c.options...)...)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
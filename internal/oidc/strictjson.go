@@ -0,0 +1,82 @@
+package oidc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// checkDuplicateJsonKeys returns an error if any json object in data contains the same key more than once.
+func checkDuplicateJsonKeys(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	err := checkDuplicateJsonKeysInValue(dec, "")
+	if err != nil {
+		return err
+	}
+
+	_, err = dec.Token()
+	if err != io.EOF {
+		return fmt.Errorf("unexpected data after json value")
+	}
+
+	return nil
+}
+
+func checkDuplicateJsonKeysInValue(dec *json.Decoder, path string) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	delim, ok := t.(json.Delim)
+	if !ok {
+		return nil
+	}
+
+	switch delim {
+	case '{':
+		keys := make(map[string]struct{})
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return err
+			}
+
+			key, ok := t.(string)
+			if !ok {
+				return fmt.Errorf("expected string key in json object, received: %v", t)
+			}
+
+			keyPath := key
+			if path != "" {
+				keyPath = fmt.Sprintf("%s.%s", path, key)
+			}
+
+			_, found := keys[key]
+			if found {
+				return fmt.Errorf("duplicate key %q in json object", keyPath)
+			}
+
+			keys[key] = struct{}{}
+
+			err = checkDuplicateJsonKeysInValue(dec, keyPath)
+			if err != nil {
+				return err
+			}
+		}
+	case '[':
+		for i := 0; dec.More(); i++ {
+			err := checkDuplicateJsonKeysInValue(dec, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// consume the closing delimiter
+	_, err = dec.Token()
+	return err
+}
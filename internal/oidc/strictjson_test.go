@@ -0,0 +1,62 @@
+package oidc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDuplicateJsonKeys(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		data                  string
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "no duplicates",
+			data:                  `{"iss":"foo","aud":["bar","baz"],"nested":{"foo":"bar"}}`,
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "same key in different objects",
+			data:                  `{"foo":{"bar":1},"baz":{"bar":2},"list":[{"bar":1},{"bar":2}]}`,
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "duplicate top level key",
+			data:                  `{"aud":"foo","iss":"bar","aud":"baz"}`,
+			expectedErrorContains: "duplicate key \"aud\" in json object",
+		},
+		{
+			testDescription:       "duplicate nested key",
+			data:                  `{"foo":{"bar":1,"bar":2}}`,
+			expectedErrorContains: "duplicate key \"foo.bar\" in json object",
+		},
+		{
+			testDescription:       "duplicate key in object in array",
+			data:                  `{"foo":[{"bar":1},{"bar":1,"bar":2}]}`,
+			expectedErrorContains: "duplicate key \"foo[1].bar\" in json object",
+		},
+		{
+			testDescription:       "invalid json",
+			data:                  `{"foo":`,
+			expectedErrorContains: "EOF",
+		},
+		{
+			testDescription:       "trailing data",
+			data:                  `{"foo":"bar"}{"foo":"bar"}`,
+			expectedErrorContains: "unexpected data after json value",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		err := checkDuplicateJsonKeys([]byte(c.data))
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package oidc
+
+import (
+	"context"
+	"time"
+
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+type timingsContextKey struct{}
+
+// withTimings returns a context used to record durations measured outside of ParseToken,
+// as an example the jwks refresh done by the keyHandler.
+func withTimings(ctx context.Context, timings *options.Timings) context.Context {
+	return context.WithValue(ctx, timingsContextKey{}, timings)
+}
+
+// recordKeyRefresh adds the time since start to the KeyRefresh timing, if the context contains timings.
+func recordKeyRefresh(ctx context.Context, start time.Time) {
+	timings, ok := ctx.Value(timingsContextKey{}).(*options.Timings)
+	if !ok {
+		return
+	}
+
+	timings.KeyRefresh += time.Since(start)
+}
@@ -0,0 +1,134 @@
+package oidc
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// tokenCacheEntry is a token that has been verified and validated. The token string isn't
+// stored, only the hash of it used by the decision cache. The headers and the key used to verify
+// the token are kept to validate them again when the token is read from the cache.
+type tokenCacheEntry struct {
+	key        string
+	tokenHash  string
+	token      jwt.Token
+	headers    jws.Headers
+	signingKey jwk.Key
+	keySet     jwk.Set
+	expiresAt  time.Time
+}
+
+// tokenCache is an in-memory LRU cache of verified tokens, keyed by a hash of the token string
+// received by the handler. Entries are removed when they are read after expiresAt and the least
+// recently used entry is removed when the cache is full.
+type tokenCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// newTokenCache returns a tokenCache, or nil if ttl or size isn't above 0.
+func newTokenCache(ttl time.Duration, size int) *tokenCache {
+	if ttl <= 0 || size <= 0 {
+		return nil
+	}
+
+	return &tokenCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *tokenCache) get(key string, now time.Time) (tokenCacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return tokenCacheEntry{}, false
+	}
+
+	entry := elem.Value.(tokenCacheEntry)
+	if !now.Before(entry.expiresAt) {
+		c.removeElement(elem)
+		return tokenCacheEntry{}, false
+	}
+
+	c.lru.MoveToFront(elem)
+
+	return entry, true
+}
+
+// set stores the entry until the ttl has passed or the token expires, whichever comes first.
+// Tokens without `exp` aren't stored.
+func (c *tokenCache) set(entry tokenCacheEntry, now time.Time) {
+	exp := entry.token.Expiration()
+	if exp.IsZero() {
+		return
+	}
+
+	entry.expiresAt = now.Add(c.ttl)
+	if exp.Before(entry.expiresAt) {
+		entry.expiresAt = exp
+	}
+
+	if !now.Before(entry.expiresAt) {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.size {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *tokenCache) remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	c.removeElement(elem)
+}
+
+func (c *tokenCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+func (c *tokenCache) len() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *tokenCache) removeElement(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(tokenCacheEntry).key)
+}
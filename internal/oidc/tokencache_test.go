@@ -0,0 +1,312 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/optest"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestNewTokenCache(t *testing.T) {
+	require.Nil(t, newTokenCache(0, 10))
+	require.Nil(t, newTokenCache(time.Minute, 0))
+	require.NotNil(t, newTokenCache(time.Minute, 10))
+}
+
+func TestTokenCacheEvictionOnExpiry(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		testDescription string
+		expiration      time.Time
+		foundAfter      time.Duration
+		evictedAfter    time.Duration
+	}{
+		{
+			testDescription: "ttl before token expiration",
+			expiration:      now.Add(time.Hour),
+			foundAfter:      59 * time.Second,
+			evictedAfter:    time.Minute,
+		},
+		{
+			testDescription: "ttl capped at token expiration",
+			expiration:      now.Add(30 * time.Second),
+			foundAfter:      29 * time.Second,
+			evictedAfter:    30 * time.Second,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		cache := newTokenCache(time.Minute, 10)
+		cache.set(tokenCacheEntry{key: "foo", token: testNewJwtToken(t, c.expiration)}, now)
+
+		_, found := cache.get("foo", now.Add(c.foundAfter))
+		require.True(t, found)
+		require.Equal(t, 1, cache.len())
+
+		_, found = cache.get("foo", now.Add(c.evictedAfter))
+		require.False(t, found)
+		require.Equal(t, 0, cache.len())
+	}
+
+	// tokens without exp and expired tokens aren't stored
+	cache := newTokenCache(time.Minute, 10)
+	cache.set(tokenCacheEntry{key: "foo", token: jwt.New()}, now)
+	cache.set(tokenCacheEntry{key: "bar", token: testNewJwtToken(t, now.Add(-time.Second))}, now)
+	require.Equal(t, 0, cache.len())
+}
+
+func TestTokenCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	now := time.Now()
+	token := testNewJwtToken(t, now.Add(time.Hour))
+
+	cache := newTokenCache(time.Minute, 2)
+	cache.set(tokenCacheEntry{key: "foo", token: token}, now)
+	cache.set(tokenCacheEntry{key: "bar", token: token}, now)
+
+	_, found := cache.get("foo", now)
+	require.True(t, found)
+
+	cache.set(tokenCacheEntry{key: "baz", token: token}, now)
+	require.Equal(t, 2, cache.len())
+
+	_, found = cache.get("bar", now)
+	require.False(t, found)
+
+	_, found = cache.get("foo", now)
+	require.True(t, found)
+
+	_, found = cache.get("baz", now)
+	require.True(t, found)
+
+	// storing an existing key replaces the entry without growing the cache
+	cache.set(tokenCacheEntry{key: "baz", tokenHash: "qux", token: token}, now)
+	require.Equal(t, 2, cache.len())
+
+	entry, found := cache.get("baz", now)
+	require.True(t, found)
+	require.Equal(t, "qux", entry.tokenHash)
+}
+
+func TestParseTokenWithTokenCache(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	now := time.Now()
+	verifier := &testVerifier{}
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithJwksRateLimit(100),
+		options.WithVerifier("EC", verifier),
+		options.WithTokenCacheTTL(30*time.Second),
+		options.WithNowFn(func() time.Time {
+			return now
+		}),
+		options.WithShouldCacheFunc(func(token jwt.Token) bool {
+			_, isAdmin := token.Get("admin")
+			return !isAdmin
+		}),
+	)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	token := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"foo": "bar"})
+
+	// the signature is only verified the first time the token is used
+	for i := 0; i < 3; i++ {
+		claims, err := h.ParseToken(ctx, token)
+		require.NoError(t, err)
+		require.Equal(t, "bar", claims["foo"])
+	}
+	require.Equal(t, 1, verifier.getCalls())
+
+	// the token is verified again after the ttl
+	now = now.Add(30 * time.Second)
+	_, err = h.ParseToken(ctx, token)
+	require.NoError(t, err)
+	require.Equal(t, 2, verifier.getCalls())
+
+	// tokens rejected by ShouldCacheFunc are verified on every request
+	adminToken := testNewTokenStringWithKey(t, privKey, jwa.ES384, map[string]interface{}{"admin": true})
+	for i := 0; i < 3; i++ {
+		_, err := h.ParseToken(ctx, adminToken)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 5, verifier.getCalls())
+
+	// the claims of cached tokens are validated on every request
+	h.SetClaimsValidationFn(func(claims *testClaims) error {
+		if (*claims)["foo"] != "baz" {
+			return fmt.Errorf("foo isn't baz")
+		}
+
+		return nil
+	})
+
+	_, err = h.ParseToken(ctx, token)
+	require.ErrorContains(t, err, "foo isn't baz")
+	require.Equal(t, 5, verifier.getCalls())
+
+	h.SetClaimsValidationFn(nil)
+
+	// cached tokens aren't used after the jwks has been updated, since the key may have been removed
+	rotatedPrivKeySet, rotatedPubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(rotatedPrivKeySet, rotatedPubKeySet)
+
+	rotatedPrivKey, ok := rotatedPrivKeySet.Get(0)
+	require.True(t, ok)
+
+	_, err = h.ParseToken(ctx, testNewTokenStringWithKey(t, rotatedPrivKey, jwa.ES384, nil))
+	require.NoError(t, err)
+	require.Equal(t, 6, verifier.getCalls())
+
+	_, err = h.ParseToken(ctx, token)
+	require.ErrorContains(t, err, "unable to get public key")
+	require.Equal(t, 6, verifier.getCalls())
+}
+
+func TestParseTokenWithTokenCacheValidatesHeaders(t *testing.T) {
+	keySets := testNewTestKeySet(t)
+	testServer := testNewJwksServer(t, keySets)
+	defer testServer.Close()
+
+	privKeySet, pubKeySet := testNewKeySet(t, 1, false)
+	keySets.setKeys(privKeySet, pubKeySet)
+
+	privKey, ok := privKeySet.Get(0)
+	require.True(t, ok)
+
+	var strict atomic.Bool
+	verifier := &testVerifier{}
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithJwksUri(testServer.URL),
+		options.WithVerifier("EC", verifier),
+		options.WithTokenCacheTTL(time.Minute),
+		options.WithTokenTypeValidator(func(typ string) error {
+			if strict.Load() && typ != "at+jwt" {
+				return fmt.Errorf("only access tokens are accepted")
+			}
+
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	token := testNewTokenStringWithKey(t, privKey, jwa.ES384, nil)
+
+	_, err = h.ParseToken(ctx, token)
+	require.NoError(t, err)
+	require.Equal(t, 1, verifier.getCalls())
+
+	// the token type of a cached token is validated again
+	strict.Store(true)
+
+	_, err = h.ParseToken(ctx, token)
+	require.ErrorContains(t, err, "only access tokens are accepted")
+	require.Equal(t, 1, verifier.getCalls())
+}
+
+func TestCloseEmptiesTokenCache(t *testing.T) {
+	opFoo := optest.NewTesting(t)
+	defer opFoo.Close(t)
+
+	opBar := optest.NewTesting(t)
+	defer opBar.Close(t)
+
+	h, err := NewHandler[testClaims](nil,
+		options.WithIssuer(opFoo.GetURL(t)),
+		options.WithIssuers(options.IssuerConfig{Issuer: opBar.GetURL(t)}),
+		options.WithTokenCacheTTL(time.Minute),
+	)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(context.Background(), opFoo.GetToken(t).AccessToken)
+	require.NoError(t, err)
+
+	_, err = h.ParseToken(context.Background(), opBar.GetToken(t).AccessToken)
+	require.NoError(t, err)
+
+	barHandler := h.issuerHandlers[opBar.GetURL(t)]
+	require.Equal(t, 1, h.tokenCache.len())
+	require.Equal(t, 1, barHandler.tokenCache.len())
+
+	err = h.Close()
+	require.NoError(t, err)
+	require.Equal(t, 0, h.tokenCache.len())
+	require.Equal(t, 0, barHandler.tokenCache.len())
+
+	// tokens can still be parsed after Close
+	_, err = h.ParseToken(context.Background(), opFoo.GetToken(t).AccessToken)
+	require.NoError(t, err)
+}
+
+func BenchmarkParseTokenWithTokenCache(b *testing.B) {
+	op := optest.NewTesting(b)
+	defer op.Close(b)
+
+	token := op.GetToken(b).AccessToken
+	ctx := context.Background()
+
+	cases := []struct {
+		testDescription string
+		options         []options.Option
+	}{
+		{
+			testDescription: "without_token_cache",
+		},
+		{
+			testDescription: "with_token_cache",
+			options: []options.Option{
+				options.WithTokenCacheTTL(time.Minute),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		h, err := NewHandler[testClaims](nil, append([]options.Option{options.WithIssuer(op.GetURL(b))}, c.options...)...)
+		require.NoError(b, err)
+
+		b.Run(c.testDescription, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_, err := h.ParseToken(ctx, token)
+					if err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func testNewJwtToken(t *testing.T, expiration time.Time) jwt.Token {
+	t.Helper()
+
+	token := jwt.New()
+	err := token.Set(jwt.ExpirationKey, expiration)
+	require.NoError(t, err)
+
+	return token
+}
@@ -0,0 +1,23 @@
+package oidc
+
+import "context"
+
+type tokenIDContextKey struct{}
+
+// WithTokenIDFromClaims returns a copy of ctx from which GetTokenID returns the token id (`jti`)
+// of the validated token. The claims are stored as is and the `jti` is only read by GetTokenID,
+// so requests not using it don't pay for converting the claims.
+func WithTokenIDFromClaims[T any](ctx context.Context, claims T) context.Context {
+	return context.WithValue(ctx, tokenIDContextKey{}, claims)
+}
+
+// GetTokenID returns the token id (`jti`) added to the context by the middleware, or false if
+// the context doesn't contain a validated token or the token doesn't contain a string `jti` claim.
+func GetTokenID(ctx context.Context) (string, bool) {
+	claims := ctx.Value(tokenIDContextKey{})
+	if claims == nil {
+		return "", false
+	}
+
+	return getStringClaimFromClaims(claims, "jti")
+}
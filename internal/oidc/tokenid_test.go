@@ -0,0 +1,60 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTokenID(t *testing.T) {
+	type testTypedClaims struct {
+		TokenID string `json:"jti"`
+	}
+
+	cases := []struct {
+		testDescription string
+		ctx             context.Context
+		expectedTokenID string
+		expectedOk      bool
+	}{
+		{
+			testDescription: "map claims with jti",
+			ctx:             WithTokenIDFromClaims(context.Background(), map[string]interface{}{"jti": "foo"}),
+			expectedTokenID: "foo",
+			expectedOk:      true,
+		},
+		{
+			testDescription: "typed claims with jti",
+			ctx:             WithTokenIDFromClaims(context.Background(), testTypedClaims{TokenID: "foo"}),
+			expectedTokenID: "foo",
+			expectedOk:      true,
+		},
+		{
+			testDescription: "claims without jti",
+			ctx:             WithTokenIDFromClaims(context.Background(), map[string]interface{}{"sub": "foo"}),
+			expectedTokenID: "",
+			expectedOk:      false,
+		},
+		{
+			testDescription: "jti that isn't a string",
+			ctx:             WithTokenIDFromClaims(context.Background(), map[string]interface{}{"jti": 1}),
+			expectedTokenID: "",
+			expectedOk:      false,
+		},
+		{
+			testDescription: "no claims",
+			ctx:             context.Background(),
+			expectedTokenID: "",
+			expectedOk:      false,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		tokenID, ok := GetTokenID(c.ctx)
+		require.Equal(t, c.expectedOk, ok)
+		require.Equal(t, c.expectedTokenID, tokenID)
+	}
+}
@@ -1,7 +1,10 @@
 package oidc
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/xenitab/go-oidc-middleware/options"
@@ -9,21 +12,58 @@ import (
 
 type GetHeaderFn func(key string) string
 
+type GetHeaderValuesFn func(key string) []string
+
 const maxListSeparatorSlices = 20
 
+// tokenNotFoundError wraps errors returned when the request doesn't contain any credentials,
+// making errors.Is(err, options.ErrTokenNotFound) true while keeping the original error.
+type tokenNotFoundError struct {
+	err error
+}
+
+func (e *tokenNotFoundError) Error() string {
+	return e.err.Error()
+}
+
+func (e *tokenNotFoundError) Unwrap() error {
+	return e.err
+}
+
+func (e *tokenNotFoundError) Is(target error) bool {
+	return target == options.ErrTokenNotFound
+}
+
 // GetTokenString extracts a token string.
-func GetTokenString(getHeaderFn GetHeaderFn, tokenStringOpts [][]options.TokenStringOption) (string, error) {
+func GetTokenString(ctx context.Context, getHeaderFn GetHeaderFn, tokenStringOpts [][]options.TokenStringOption) (string, error) {
+	getHeaderValuesFn := func(key string) []string {
+		headerValue := getHeaderFn(key)
+		if headerValue == "" {
+			return nil
+		}
+
+		return []string{headerValue}
+	}
+
+	return GetTokenStringFromValues(ctx, getHeaderValuesFn, tokenStringOpts)
+}
+
+// GetTokenStringFromValues extracts a token string from headers that may be sent more than once.
+func GetTokenStringFromValues(ctx context.Context, getHeaderValuesFn GetHeaderValuesFn,
+	tokenStringOpts [][]options.TokenStringOption) (string, error) {
 	optsList := tokenStringOpts
 	if len(optsList) == 0 {
 		optsList = append(optsList, []options.TokenStringOption{})
 	}
 
 	var err error
+	present := false
 	for _, setters := range optsList {
 		opts := options.NewTokenString(setters...)
+		present = present || isTokenStringPresent(getHeaderValuesFn, opts)
 
 		var tokenString string
-		tokenString, err = getTokenString(getHeaderFn, opts)
+		tokenString, err = getTokenString(ctx, getHeaderValuesFn, opts)
 		if err == nil && tokenString != "" {
 			// if a PostExtractionFn is defined, pass the token to it
 			if opts.PostExtractionFn != nil {
@@ -46,26 +86,193 @@ func GetTokenString(getHeaderFn GetHeaderFn, tokenStringOpts [][]options.TokenSt
 		}
 	}
 
-	return "", fmt.Errorf("unable to extract token: %w", err)
+	err = fmt.Errorf("unable to extract token: %w", err)
+	if !present {
+		return "", &tokenNotFoundError{err}
+	}
+
+	return "", err
+}
+
+// isTokenStringPresent returns true if the header (or the cookie, if CookieName is set) of the
+// token string options is sent with a value, even if a token can't be extracted from it.
+func isTokenStringPresent(getHeaderValuesFn GetHeaderValuesFn, opts *options.TokenStringOptions) bool {
+	headerValues := getHeaderValuesFn(opts.HeaderName)
+	if opts.CookieName == "" {
+		for _, headerValue := range headerValues {
+			if headerValue != "" {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	req := http.Request{Header: http.Header{"Cookie": headerValues}}
+	for _, cookie := range req.Cookies() {
+		if cookie.Name == opts.CookieName && cookie.Value != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetTokenStringFromRequest extracts a token string from a request, using GetTokenStringFn
+// if configured, TokenSources if configured and the TokenString and TokenCookieName options otherwise.
+// NewHandler doesn't accept TokenSources together with the other options.
+func GetTokenStringFromRequest(r *http.Request, opts *options.Options) (string, error) {
+	if len(opts.TokenSources) > 0 {
+		return GetTokenStringFromSources(r, opts.TokenSources)
+	}
+
+	if opts.GetTokenStringFn == nil {
+		return GetTokenStringFromValues(r.Context(), r.Header.Values, GetTokenStringOptions(opts))
+	}
+
+	tokenString, err := opts.GetTokenStringFn(r)
+	if err != nil {
+		return "", fmt.Errorf("unable to extract token: %w", err)
+	}
+
+	if tokenString == "" {
+		err := fmt.Errorf("unable to extract token: get token string function returned an empty token string")
+		return "", &tokenNotFoundError{err}
+	}
+
+	return tokenString, nil
+}
+
+// GetTokenStringFromSources extracts a token string from the first of the sources present in
+// the request. The other sources aren't tried if the token can't be extracted from it.
+func GetTokenStringFromSources(r *http.Request, sources []options.TokenSource) (string, error) {
+	for _, source := range sources {
+		if !isTokenSourcePresent(r, source) {
+			continue
+		}
+
+		tokenString, err := getTokenStringFromSource(r, source)
+		if err != nil {
+			return "", fmt.Errorf("unable to extract token from %s %q: %w", source.Type, source.Name, err)
+		}
+
+		return tokenString, nil
+	}
+
+	err := fmt.Errorf("unable to extract token: none of the %d token sources are present", len(sources))
+
+	return "", &tokenNotFoundError{err}
+}
+
+func isTokenSourcePresent(r *http.Request, source options.TokenSource) bool {
+	switch source.Type {
+	case options.HeaderTokenSourceType:
+		for _, headerValue := range r.Header.Values(source.Name) {
+			if headerValue != "" {
+				return true
+			}
+		}
+
+		return false
+	case options.CookieTokenSourceType:
+		for _, cookie := range r.Cookies() {
+			if cookie.Name == source.Name && cookie.Value != "" {
+				return true
+			}
+		}
+
+		return false
+	case options.QueryTokenSourceType:
+		return r.URL.Query().Get(source.Name) != ""
+	default:
+		return false
+	}
+}
+
+func getTokenStringFromSource(r *http.Request, source options.TokenSource) (string, error) {
+	switch source.Type {
+	case options.HeaderTokenSourceType:
+		return GetTokenStringFromValues(r.Context(), r.Header.Values, [][]options.TokenStringOption{source.TokenString})
+	case options.CookieTokenSourceType:
+		opts := options.NewTokenString(
+			options.WithTokenStringHeaderName("Cookie"),
+			options.WithTokenStringCookieName(source.Name),
+		)
+
+		return getTokenFromCookie(r.Header.Values("Cookie"), opts)
+	case options.QueryTokenSourceType:
+		return r.URL.Query().Get(source.Name), nil
+	default:
+		return "", fmt.Errorf("unknown token source type: %d", source.Type)
+	}
 }
 
-func getTokenString(getHeaderFn GetHeaderFn, opts *options.TokenStringOptions) (string, error) {
-	headerValue := getHeaderFn(opts.HeaderName)
+// GetTokenStringOptions returns the TokenString options with the TokenCookieName cookie
+// appended as the last option, making the cookie a fallback for the configured headers.
+func GetTokenStringOptions(opts *options.Options) [][]options.TokenStringOption {
+	if opts.TokenCookieName == "" {
+		return opts.TokenString
+	}
+
+	tokenStringOpts := opts.TokenString
+	if len(tokenStringOpts) == 0 {
+		tokenStringOpts = [][]options.TokenStringOption{{}}
+	}
+
+	cookieOpts := []options.TokenStringOption{
+		options.WithTokenStringHeaderName("Cookie"),
+		options.WithTokenStringCookieName(opts.TokenCookieName),
+	}
+
+	return append(append([][]options.TokenStringOption{}, tokenStringOpts...), cookieOpts)
+}
+
+func getTokenString(ctx context.Context, getHeaderValuesFn GetHeaderValuesFn, opts *options.TokenStringOptions) (string, error) {
+	if opts.CookieName != "" {
+		return getTokenFromCookie(getHeaderValuesFn(opts.HeaderName), opts)
+	}
+
+	headerValue, err := getHeaderValue(getHeaderValuesFn(opts.HeaderName), opts)
+	if err != nil {
+		return "", err
+	}
+
 	if headerValue == "" {
 		return "", fmt.Errorf("%s header empty", opts.HeaderName)
 	}
 
 	if opts.ListSeparator != "" && strings.Contains(headerValue, opts.ListSeparator) {
 		headerValueList := strings.SplitN(headerValue, opts.ListSeparator, maxListSeparatorSlices)
-		return getTokenFromList(headerValueList, opts)
+		return getTokenFromList(ctx, headerValueList, opts)
+	}
+
+	return getTokenFromString(ctx, headerValue, opts)
+}
+
+func getHeaderValue(headerValues []string, opts *options.TokenStringOptions) (string, error) {
+	if len(headerValues) == 0 {
+		return "", nil
+	}
+
+	if len(headerValues) == 1 {
+		return headerValues[0], nil
 	}
 
-	return getTokenFromString(headerValue, opts)
+	switch opts.HeaderValuePrecedence {
+	case options.FirstHeaderValue:
+		return headerValues[0], nil
+	case options.LastHeaderValue:
+		return headerValues[len(headerValues)-1], nil
+	case options.RejectMultipleHeaderValues:
+		return "", fmt.Errorf("%s header sent more than once: %d", opts.HeaderName, len(headerValues))
+	default:
+		return "", fmt.Errorf("unknown header value precedence: %d", opts.HeaderValuePrecedence)
+	}
 }
 
-func getTokenFromList(headerValueList []string, opts *options.TokenStringOptions) (string, error) {
+func getTokenFromList(ctx context.Context, headerValueList []string, opts *options.TokenStringOptions) (string, error) {
 	for _, headerValue := range headerValueList {
-		tokenString, err := getTokenFromString(headerValue, opts)
+		tokenString, err := getTokenFromString(ctx, headerValue, opts)
 		if err == nil && tokenString != "" {
 			return tokenString, nil
 		}
@@ -74,12 +281,20 @@ func getTokenFromList(headerValueList []string, opts *options.TokenStringOptions
 	return "", fmt.Errorf("no token found in list")
 }
 
-func getTokenFromString(headerValue string, opts *options.TokenStringOptions) (string, error) {
+func getTokenFromString(ctx context.Context, headerValue string, opts *options.TokenStringOptions) (string, error) {
 	if headerValue == "" {
 		return "", fmt.Errorf("%s header empty", opts.HeaderName)
 	}
 
+	if len(opts.TokenSchemes) > 0 {
+		return getTokenFromSchemes(ctx, headerValue, opts)
+	}
+
 	if !strings.HasPrefix(headerValue, opts.TokenPrefix) {
+		if isBasicAuthScheme(headerValue) {
+			return getTokenFromBasicAuth(ctx, headerValue, opts)
+		}
+
 		return "", fmt.Errorf("%s header does not begin with: %s", opts.HeaderName, opts.TokenPrefix)
 	}
 
@@ -91,3 +306,100 @@ func getTokenFromString(headerValue string, opts *options.TokenStringOptions) (s
 
 	return token, nil
 }
+
+// getTokenFromSchemes extracts the token from a `<scheme> <token>` header value, where the
+// scheme is required to be one of TokenSchemes (case-insensitive).
+func getTokenFromSchemes(ctx context.Context, headerValue string, opts *options.TokenStringOptions) (string, error) {
+	scheme, token, ok := strings.Cut(headerValue, " ")
+	if !ok {
+		return "", fmt.Errorf("%s header does not contain a scheme, expected one of: %v", opts.HeaderName, opts.TokenSchemes)
+	}
+
+	for _, tokenScheme := range opts.TokenSchemes {
+		if !strings.EqualFold(scheme, tokenScheme) {
+			continue
+		}
+
+		token = strings.TrimSpace(token)
+		if token == "" {
+			return "", fmt.Errorf("%s header empty after scheme is trimmed", opts.HeaderName)
+		}
+
+		return token, nil
+	}
+
+	if isBasicAuthScheme(headerValue) {
+		return getTokenFromBasicAuth(ctx, headerValue, opts)
+	}
+
+	return "", fmt.Errorf("%s header scheme %q is not one of: %v", opts.HeaderName, scheme, opts.TokenSchemes)
+}
+
+func getTokenFromCookie(headerValues []string, opts *options.TokenStringOptions) (string, error) {
+	req := http.Request{Header: http.Header{"Cookie": headerValues}}
+
+	var cookieValues []string
+	for _, cookie := range req.Cookies() {
+		if cookie.Name == opts.CookieName {
+			cookieValues = append(cookieValues, cookie.Value)
+		}
+	}
+
+	if len(cookieValues) > 1 && opts.HeaderValuePrecedence == options.RejectMultipleHeaderValues {
+		return "", fmt.Errorf("%s cookie sent more than once: %d", opts.CookieName, len(cookieValues))
+	}
+
+	cookieValue, err := getHeaderValue(cookieValues, opts)
+	if err != nil {
+		return "", err
+	}
+
+	if cookieValue == "" {
+		return "", fmt.Errorf("%s cookie empty in %s header", opts.CookieName, opts.HeaderName)
+	}
+
+	return cookieValue, nil
+}
+
+const basicAuthSchemePrefix = "basic "
+
+func isBasicAuthScheme(headerValue string) bool {
+	return len(headerValue) >= len(basicAuthSchemePrefix) && strings.EqualFold(headerValue[:len(basicAuthSchemePrefix)], basicAuthSchemePrefix)
+}
+
+func getTokenFromBasicAuth(ctx context.Context, headerValue string, opts *options.TokenStringOptions) (string, error) {
+	if opts.BasicAuthFn == nil {
+		return "", fmt.Errorf("%s header: %w, expected prefix: %s", opts.HeaderName, options.ErrBasicAuthScheme, opts.TokenPrefix)
+	}
+
+	credentialsBytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(headerValue[len(basicAuthSchemePrefix):]))
+	if err != nil {
+		return "", fmt.Errorf("%s header contains invalid basic auth credentials: %w", opts.HeaderName, err)
+	}
+
+	username, password, ok := strings.Cut(string(credentialsBytes), ":")
+	if !ok {
+		return "", fmt.Errorf("%s header contains invalid basic auth credentials: missing separator", opts.HeaderName)
+	}
+
+	token, err := opts.BasicAuthFn(ctx, username, password)
+	if err != nil {
+		return "", fmt.Errorf("basic auth function returned an error: %w", err)
+	}
+
+	if token == "" {
+		return "", fmt.Errorf("basic auth function returned an empty token string")
+	}
+
+	return token, nil
+}
+
+// ValidateTokenLength returns an error wrapping options.ErrTokenTooLong if the token is
+// longer than maxTokenLength. A maxTokenLength of 0 disables the validation.
+func ValidateTokenLength(tokenString string, maxTokenLength int) error {
+	if maxTokenLength > 0 && len(tokenString) > maxTokenLength {
+		return fmt.Errorf("%w: %d bytes, max %d bytes", options.ErrTokenTooLong, len(tokenString), maxTokenLength)
+	}
+
+	return nil
+}
@@ -1,7 +1,9 @@
 package oidc
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -341,7 +343,7 @@ func TestGetTokenString(t *testing.T) {
 			}
 		}
 
-		token, err := GetTokenString(req.Header.Get, c.options)
+		token, err := GetTokenString(context.Background(), req.Header.Get, c.options)
 		require.Equal(t, c.expectedToken, token)
 
 		if c.expectedErrorContains == "" {
@@ -353,6 +355,642 @@ func TestGetTokenString(t *testing.T) {
 	}
 }
 
+func TestGetTokenStringFromValues(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		headers               map[string][]string
+		options               [][]options.TokenStringOption
+		expectedToken         string
+		expectedErrorContains string
+		expectedTokenNotFound bool
+	}{
+		{
+			testDescription:       "empty headers",
+			headers:               make(map[string][]string),
+			expectedToken:         "",
+			expectedErrorContains: "Authorization header empty",
+			expectedTokenNotFound: true,
+		},
+		{
+			testDescription: "empty Authorization header after prefix is trimmed",
+			headers: map[string][]string{
+				"Authorization": {"Bearer "},
+			},
+			expectedToken:         "",
+			expectedErrorContains: "Authorization header empty after prefix is trimmed",
+			expectedTokenNotFound: false,
+		},
+		{
+			testDescription: "other cookie than the token cookie",
+			headers: map[string][]string{
+				"Cookie": {"foo=bar"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderName("Cookie"),
+					options.WithTokenStringCookieName("session"),
+				},
+			},
+			expectedToken:         "",
+			expectedErrorContains: "session cookie empty in Cookie header",
+			expectedTokenNotFound: true,
+		},
+		{
+			testDescription: "single Authorization header",
+			headers: map[string][]string{
+				"Authorization": {"Bearer foobar"},
+			},
+			expectedToken:         "foobar",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "duplicate Authorization headers, default first",
+			headers: map[string][]string{
+				"Authorization": {"Bearer proxy", "Bearer client"},
+			},
+			expectedToken:         "proxy",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "duplicate Authorization headers, first",
+			headers: map[string][]string{
+				"Authorization": {"Bearer proxy", "Bearer client"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderValuePrecedence(options.FirstHeaderValue),
+				},
+			},
+			expectedToken:         "proxy",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "duplicate Authorization headers, last",
+			headers: map[string][]string{
+				"Authorization": {"Bearer proxy", "Bearer client"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderValuePrecedence(options.LastHeaderValue),
+				},
+			},
+			expectedToken:         "client",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "duplicate Authorization headers, reject",
+			headers: map[string][]string{
+				"Authorization": {"Bearer proxy", "Bearer client"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderValuePrecedence(options.RejectMultipleHeaderValues),
+				},
+			},
+			expectedToken:         "",
+			expectedErrorContains: "Authorization header sent more than once: 2",
+		},
+		{
+			testDescription: "single Authorization header, reject",
+			headers: map[string][]string{
+				"Authorization": {"Bearer foobar"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderValuePrecedence(options.RejectMultipleHeaderValues),
+				},
+			},
+			expectedToken:         "foobar",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "duplicate Authorization headers, reject and fallback to other header",
+			headers: map[string][]string{
+				"Authorization": {"Bearer proxy", "Bearer client"},
+				"Foo":           {"Bar_baz"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderValuePrecedence(options.RejectMultipleHeaderValues),
+				},
+				{
+					options.WithTokenStringHeaderName("Foo"),
+					options.WithTokenStringTokenPrefix("Bar_"),
+				},
+			},
+			expectedToken:         "baz",
+			expectedErrorContains: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		for k, values := range c.headers {
+			for _, v := range values {
+				req.Header.Add(k, v)
+			}
+		}
+
+		token, err := GetTokenStringFromValues(context.Background(), req.Header.Values, c.options)
+		require.Equal(t, c.expectedToken, token)
+
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.expectedErrorContains)
+			require.Equal(t, c.expectedTokenNotFound, errors.Is(err, options.ErrTokenNotFound))
+		}
+	}
+}
+
+func TestGetTokenStringFromCookie(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		headers               map[string][]string
+		options               [][]options.TokenStringOption
+		expectedToken         string
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "cookie header",
+			headers: map[string][]string{
+				"Cookie": {"foo=bar; access_token=foobar"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderName("Cookie"),
+					options.WithTokenStringCookieName("access_token"),
+				},
+			},
+			expectedToken:         "foobar",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "cookie as lower case metadata",
+			headers: map[string][]string{
+				"cookie": {"foo=bar", "access_token=foobar"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderName("cookie"),
+					options.WithTokenStringCookieName("access_token"),
+				},
+			},
+			expectedToken:         "foobar",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "missing cookie",
+			headers: map[string][]string{
+				"Cookie": {"foo=bar"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderName("Cookie"),
+					options.WithTokenStringCookieName("access_token"),
+				},
+			},
+			expectedToken:         "",
+			expectedErrorContains: "access_token cookie empty in Cookie header",
+		},
+		{
+			testDescription: "duplicate cookie, last",
+			headers: map[string][]string{
+				"Cookie": {"access_token=foo; access_token=bar"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderName("Cookie"),
+					options.WithTokenStringCookieName("access_token"),
+					options.WithTokenStringHeaderValuePrecedence(options.LastHeaderValue),
+				},
+			},
+			expectedToken:         "bar",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "duplicate cookie, reject",
+			headers: map[string][]string{
+				"Cookie": {"access_token=foo", "access_token=bar"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderName("Cookie"),
+					options.WithTokenStringCookieName("access_token"),
+					options.WithTokenStringHeaderValuePrecedence(options.RejectMultipleHeaderValues),
+				},
+			},
+			expectedToken:         "",
+			expectedErrorContains: "access_token cookie sent more than once: 2",
+		},
+		{
+			testDescription: "missing cookie, fallback to Authorization header",
+			headers: map[string][]string{
+				"Cookie":        {"foo=bar"},
+				"Authorization": {"Bearer foobar"},
+			},
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringHeaderName("Cookie"),
+					options.WithTokenStringCookieName("access_token"),
+				},
+				{},
+			},
+			expectedToken:         "foobar",
+			expectedErrorContains: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		// a map lookup without canonicalization, like gRPC metadata
+		getHeaderValuesFn := func(key string) []string {
+			return c.headers[key]
+		}
+
+		token, err := GetTokenStringFromValues(context.Background(), getHeaderValuesFn, c.options)
+		require.Equal(t, c.expectedToken, token)
+
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestGetTokenStringFromSources(t *testing.T) {
+	sources := []options.TokenSource{
+		options.HeaderTokenSource(options.WithTokenStringHeaderName("X-Token")),
+		options.CookieTokenSource("access_token"),
+		options.QueryTokenSource("access_token"),
+	}
+
+	cases := []struct {
+		testDescription       string
+		target                string
+		headers               map[string]string
+		expectedToken         string
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "header",
+			target:          "/?access_token=baz",
+			headers: map[string]string{
+				"X-Token": "Bearer foo",
+				"Cookie":  "access_token=bar",
+			},
+			expectedToken: "foo",
+		},
+		{
+			testDescription: "cookie",
+			target:          "/?access_token=baz",
+			headers: map[string]string{
+				"Cookie": "foo=bar; access_token=bar",
+			},
+			expectedToken: "bar",
+		},
+		{
+			testDescription: "query",
+			target:          "/?access_token=baz",
+			headers: map[string]string{
+				"Cookie": "access_token=",
+			},
+			expectedToken: "baz",
+		},
+		{
+			testDescription: "empty header is not present",
+			target:          "/",
+			headers: map[string]string{
+				"X-Token": "",
+				"Cookie":  "access_token=bar",
+			},
+			expectedToken: "bar",
+		},
+		{
+			testDescription: "first present source is used even if it fails",
+			target:          "/",
+			headers: map[string]string{
+				"X-Token": "foo",
+				"Cookie":  "access_token=bar",
+			},
+			expectedErrorContains: "unable to extract token from header \"X-Token\"",
+		},
+		{
+			testDescription:       "no source present",
+			target:                "/?foo=bar",
+			expectedErrorContains: "unable to extract token: none of the 3 token sources are present",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		req := httptest.NewRequest(http.MethodGet, c.target, nil)
+		for k, v := range c.headers {
+			req.Header.Set(k, v)
+		}
+
+		token, err := GetTokenStringFromSources(req, sources)
+		require.Equal(t, c.expectedToken, token)
+
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+func TestNewHandlerWithTokenSources(t *testing.T) {
+	sources := []options.TokenSource{
+		options.HeaderTokenSource(),
+		options.CookieTokenSource("access_token"),
+	}
+
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "token sources",
+			options:               []options.Option{options.WithTokenSources(sources...)},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "together with TokenString",
+			options: []options.Option{
+				options.WithTokenSources(sources...),
+				options.WithTokenString(options.WithTokenStringHeaderName("X-Token")),
+			},
+			expectedErrorContains: "TokenSources can't be used together with TokenString",
+		},
+		{
+			testDescription: "together with TokenCookieName",
+			options: []options.Option{
+				options.WithTokenSources(sources...),
+				options.WithTokenCookieName("access_token"),
+			},
+			expectedErrorContains: "TokenSources can't be used together with TokenCookieName",
+		},
+		{
+			testDescription: "together with GetTokenStringFn",
+			options: []options.Option{
+				options.WithTokenSources(sources...),
+				options.WithGetTokenStringFn(func(r *http.Request) (string, error) {
+					return r.URL.Query().Get("access_token"), nil
+				}),
+			},
+			expectedErrorContains: "TokenSources can't be used together with GetTokenStringFn",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		opts := []options.Option{
+			options.WithIssuer("http://foo.bar"),
+			options.WithAllowInsecureIssuer(true),
+			options.WithLazyLoadJwks(true),
+		}
+
+		_, err := NewHandler[testClaims](nil, append(opts, c.options...)...)
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
+type testBasicAuthContextKey struct{}
+
+func TestGetTokenStringWithBasicAuth(t *testing.T) {
+	basicAuthFn := func(ctx context.Context, username string, password string) (string, error) {
+		if ctx.Value(testBasicAuthContextKey{}) != "foo" {
+			return "", fmt.Errorf("request context not passed")
+		}
+
+		if username != "client" || password != "secret" {
+			return "", fmt.Errorf("invalid client credentials")
+		}
+
+		return "client-token", nil
+	}
+
+	basicCredentials := base64.StdEncoding.EncodeToString([]byte("client:secret"))
+	invalidBasicCredentials := base64.StdEncoding.EncodeToString([]byte("client:wrong"))
+
+	cases := []struct {
+		testDescription       string
+		headerValue           string
+		options               [][]options.TokenStringOption
+		expectedToken         string
+		expectedErrorIs       error
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "bearer scheme",
+			headerValue:           "Bearer foobar",
+			expectedToken:         "foobar",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "basic scheme without BasicAuthFn",
+			headerValue:           "Basic " + basicCredentials,
+			expectedToken:         "",
+			expectedErrorIs:       options.ErrBasicAuthScheme,
+			expectedErrorContains: "Authorization header: basic auth scheme is not supported, expected prefix: Bearer ",
+		},
+		{
+			testDescription: "bearer scheme with BasicAuthFn",
+			headerValue:     "Bearer foobar",
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringBasicAuthFn(basicAuthFn),
+				},
+			},
+			expectedToken:         "foobar",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "basic scheme with BasicAuthFn",
+			headerValue:     "Basic " + basicCredentials,
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringBasicAuthFn(basicAuthFn),
+				},
+			},
+			expectedToken:         "client-token",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "lowercase basic scheme with BasicAuthFn",
+			headerValue:     "basic " + basicCredentials,
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringBasicAuthFn(basicAuthFn),
+				},
+			},
+			expectedToken:         "client-token",
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "basic scheme with BasicAuthFn error",
+			headerValue:     "Basic " + invalidBasicCredentials,
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringBasicAuthFn(basicAuthFn),
+				},
+			},
+			expectedToken:         "",
+			expectedErrorContains: "basic auth function returned an error: invalid client credentials",
+		},
+		{
+			testDescription: "basic scheme with invalid credentials encoding",
+			headerValue:     "Basic foo%bar",
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringBasicAuthFn(basicAuthFn),
+				},
+			},
+			expectedToken:         "",
+			expectedErrorContains: "Authorization header contains invalid basic auth credentials",
+		},
+		{
+			testDescription: "basic scheme without credentials separator",
+			headerValue:     "Basic " + base64.StdEncoding.EncodeToString([]byte("client")),
+			options: [][]options.TokenStringOption{
+				{
+					options.WithTokenStringBasicAuthFn(basicAuthFn),
+				},
+			},
+			expectedToken:         "",
+			expectedErrorContains: "missing separator",
+		},
+		{
+			testDescription:       "unknown scheme",
+			headerValue:           "Digest foobar",
+			expectedToken:         "",
+			expectedErrorContains: "Authorization header does not begin with: Bearer ",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", c.headerValue)
+
+		ctx := context.WithValue(context.Background(), testBasicAuthContextKey{}, "foo")
+		token, err := GetTokenString(ctx, req.Header.Get, c.options)
+		require.Equal(t, c.expectedToken, token)
+
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+			require.Contains(t, err.Error(), c.expectedErrorContains)
+		}
+
+		if c.expectedErrorIs != nil {
+			require.ErrorIs(t, err, c.expectedErrorIs)
+		}
+	}
+}
+
+func TestGetTokenStringWithTokenSchemes(t *testing.T) {
+	tokenSchemesOpts := [][]options.TokenStringOption{
+		{
+			options.WithTokenStringTokenSchemes([]string{"Bearer", "Token"}),
+		},
+	}
+
+	cases := []struct {
+		testDescription       string
+		headerValue           string
+		options               [][]options.TokenStringOption
+		expectedToken         string
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "bearer scheme",
+			headerValue:     "Bearer foobar",
+			options:         tokenSchemesOpts,
+			expectedToken:   "foobar",
+		},
+		{
+			testDescription: "token scheme",
+			headerValue:     "Token foobar",
+			options:         tokenSchemesOpts,
+			expectedToken:   "foobar",
+		},
+		{
+			testDescription: "case-insensitive scheme",
+			headerValue:     "bEARER foobar",
+			options:         tokenSchemesOpts,
+			expectedToken:   "foobar",
+		},
+		{
+			testDescription:       "unlisted scheme",
+			headerValue:           "JWT foobar",
+			options:               tokenSchemesOpts,
+			expectedToken:         "",
+			expectedErrorContains: "Authorization header scheme \"JWT\" is not one of: [Bearer Token]",
+		},
+		{
+			testDescription:       "token scheme without TokenSchemes",
+			headerValue:           "Token foobar",
+			options:               nil,
+			expectedToken:         "",
+			expectedErrorContains: "Authorization header does not begin with: Bearer ",
+		},
+		{
+			testDescription:       "missing scheme",
+			headerValue:           "foobar",
+			options:               tokenSchemesOpts,
+			expectedToken:         "",
+			expectedErrorContains: "Authorization header does not contain a scheme, expected one of: [Bearer Token]",
+		},
+		{
+			testDescription:       "empty token",
+			headerValue:           "Token  ",
+			options:               tokenSchemesOpts,
+			expectedToken:         "",
+			expectedErrorContains: "Authorization header empty after scheme is trimmed",
+		},
+		{
+			testDescription:       "basic scheme without BasicAuthFn",
+			headerValue:           "Basic Zm9vOmJhcg==",
+			options:               tokenSchemesOpts,
+			expectedToken:         "",
+			expectedErrorContains: "basic auth scheme is not supported",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", c.headerValue)
+
+		token, err := GetTokenString(context.Background(), req.Header.Get, c.options)
+		require.Equal(t, c.expectedToken, token)
+
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
+
 func TestGetTokenFromString(t *testing.T) {
 	cases := []struct {
 		testDescription       string
@@ -392,7 +1030,7 @@ func TestGetTokenFromString(t *testing.T) {
 
 		opts := options.NewTokenString(c.options...)
 
-		token, err := getTokenFromString(c.headerValue, opts)
+		token, err := getTokenFromString(context.Background(), c.headerValue, opts)
 		require.Equal(t, c.expectedToken, token)
 
 		if c.expectedErrorContains == "" {
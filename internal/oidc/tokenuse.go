@@ -0,0 +1,26 @@
+package oidc
+
+import (
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+// validateTokenUse validates that the `token_use` claim, used by AWS Cognito, is requiredTokenUse.
+func validateTokenUse(requiredTokenUse string, token jwt.Token) error {
+	claimValue, ok := token.Get("token_use")
+	if !ok {
+		return fmt.Errorf("required token_use %q was not found, token does not contain claim \"token_use\"", requiredTokenUse)
+	}
+
+	tokenUse, ok := claimValue.(string)
+	if !ok {
+		return fmt.Errorf("unable to get token_use, expected string but received: %T", claimValue)
+	}
+
+	if tokenUse != requiredTokenUse {
+		return fmt.Errorf("required token_use %q was not found, received: %s", requiredTokenUse, tokenUse)
+	}
+
+	return nil
+}
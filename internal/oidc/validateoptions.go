@@ -0,0 +1,306 @@
+package oidc
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// validateOptions returns an error if a handler can't be created using the options. It's used by
+// NewHandler and Validate and doesn't fetch anything over the network.
+func validateOptions(opts *options.Options) error {
+	validateFns := []func(opts *options.Options) error{
+		validateIssuerOptions,
+		validateClaimsPolicyOptions,
+		validateTokenSourceOptions,
+		validateKeyPolicyOptions,
+		validateIntrospectionOptions,
+		validateIssuersOptions,
+		validateJwksOptions,
+		validateCacheOptions,
+	}
+
+	for _, validateFn := range validateFns {
+		err := validateFn(opts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateIssuerOptions(opts *options.Options) error {
+	issuerConfig, _ := getIssuerConfigs(opts)
+	if issuerConfig.Issuer == "" {
+		return fmt.Errorf("issuer is empty")
+	}
+
+	if opts.AllowInsecureIssuer {
+		return nil
+	}
+
+	uris := []struct{ name, uri string }{
+		{"issuer", issuerConfig.Issuer},
+		{"discoveryUri", issuerConfig.DiscoveryUri},
+		{"jwksUri", issuerConfig.JwksUri},
+	}
+	for _, u := range uris {
+		err := validateHttpsUri(u.name, u.uri)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateClaimsPolicyOptions(opts *options.Options) error {
+	if opts.AudienceIsIssuer && opts.RequiredAudience != "" {
+		return fmt.Errorf("AudienceIsIssuer can't be used together with RequiredAudience")
+	}
+	if opts.AudienceIsIssuer && len(opts.RequiredAudiences) > 0 {
+		return fmt.Errorf("AudienceIsIssuer can't be used together with RequiredAudiences")
+	}
+	if opts.MaxAllowedTokenDrift > 0 && opts.AllowedTokenDrift > opts.MaxAllowedTokenDrift {
+		return fmt.Errorf("AllowedTokenDrift %s is larger than MaxAllowedTokenDrift %s", opts.AllowedTokenDrift, opts.MaxAllowedTokenDrift)
+	}
+
+	_, err := getRequiredClaimsRegex(opts.RequiredClaimsRegex)
+
+	return err
+}
+
+func validateTokenSourceOptions(opts *options.Options) error {
+	if len(opts.TokenSources) == 0 {
+		return nil
+	}
+	if len(opts.TokenString) > 0 {
+		return fmt.Errorf("TokenSources can't be used together with TokenString")
+	}
+	if opts.TokenCookieName != "" {
+		return fmt.Errorf("TokenSources can't be used together with TokenCookieName")
+	}
+	if opts.GetTokenStringFn != nil {
+		return fmt.Errorf("TokenSources can't be used together with GetTokenStringFn")
+	}
+
+	return nil
+}
+
+func validateKeyPolicyOptions(opts *options.Options) error {
+	if opts.PendingJwks != nil && opts.DisableKeyID {
+		return fmt.Errorf("PendingJwks can't be used together with DisableKeyID")
+	}
+	if opts.AllowES256K && !isES256KSupported() {
+		return fmt.Errorf("AllowES256K requires the jwx_es256k build tag")
+	}
+
+	_, err := getFallbackSignatureAlgorithm(opts.FallbackSignatureAlgorithm, opts.AllowES256K)
+	if err != nil {
+		return err
+	}
+
+	_, err = getAllowedKeyTypes(opts.AllowedKeyTypes)
+	if err != nil {
+		return err
+	}
+
+	_, err = getAllowedSignatureAlgorithms(opts.AllowedSignatureAlgorithms, opts.AllowES256K)
+	if err != nil {
+		return err
+	}
+
+	_, err = getVerifiers(opts.Verifiers)
+
+	return err
+}
+
+func validateIntrospectionOptions(opts *options.Options) error {
+	if opts.IntrospectionUri == "" {
+		return nil
+	}
+	if len(opts.JwksJSON) > 0 {
+		return fmt.Errorf("JwksJSON can't be used together with IntrospectionUri")
+	}
+	if _, issuers := getIssuerConfigs(opts); len(issuers) > 0 {
+		return fmt.Errorf("Issuers can't be used together with IntrospectionUri")
+	}
+
+	return nil
+}
+
+func validateIssuersOptions(opts *options.Options) error {
+	issuerConfig, issuers := getIssuerConfigs(opts)
+	if len(issuers) == 0 {
+		return nil
+	}
+	if len(opts.JwksJSON) > 0 {
+		return fmt.Errorf("JwksJSON can't be used together with Issuers")
+	}
+	if opts.KeySourceFunc != nil {
+		return fmt.Errorf("Issuers can't be used together with KeySourceFunc")
+	}
+
+	seen := map[string]struct{}{issuerConfig.Issuer: {}}
+	for i, c := range issuers {
+		if c.Issuer == "" {
+			return fmt.Errorf("Issuers[%d]: issuer is empty", i)
+		}
+
+		if _, duplicate := seen[c.Issuer]; duplicate {
+			return fmt.Errorf("Issuers[%d]: issuer %q is configured more than once", i, c.Issuer)
+		}
+
+		seen[c.Issuer] = struct{}{}
+	}
+
+	return nil
+}
+
+func validateJwksOptions(opts *options.Options) error {
+	if len(opts.JwksJSON) == 0 {
+		return nil
+	}
+	if opts.KeySourceFunc != nil {
+		return fmt.Errorf("JwksJSON can't be used together with KeySourceFunc")
+	}
+
+	_, err := jwk.Parse(opts.JwksJSON)
+	if err != nil {
+		return fmt.Errorf("JwksJSON not accepted: %w", err)
+	}
+
+	return nil
+}
+
+func validateCacheOptions(opts *options.Options) error {
+	if opts.TokenCacheTTL > 0 && opts.TokenCacheSize <= 0 {
+		return fmt.Errorf("TokenCacheSize needs to be larger than 0 when TokenCacheTTL is used")
+	}
+
+	return nil
+}
+
+// getIssuerConfigs returns the config of the issuer of the handler itself, using the first of
+// Issuers if Issuer isn't set and the discovery uri of the issuer if DiscoveryUri isn't set,
+// together with the configs of the additional issuers.
+func getIssuerConfigs(opts *options.Options) (options.IssuerConfig, []options.IssuerConfig) {
+	issuerConfig := options.IssuerConfig{
+		Issuer:       opts.Issuer,
+		DiscoveryUri: opts.DiscoveryUri,
+		JwksUri:      opts.JwksUri,
+	}
+
+	issuers := opts.Issuers
+	if issuerConfig.Issuer == "" && len(issuers) > 0 {
+		issuerConfig = issuers[0]
+		issuers = issuers[1:]
+	}
+
+	if issuerConfig.DiscoveryUri == "" && opts.DiscoveryMode == options.OAuth2MetadataDiscoveryMode {
+		issuerConfig.DiscoveryUri = GetOAuth2MetadataUriFromIssuer(issuerConfig.Issuer)
+	}
+	if issuerConfig.DiscoveryUri == "" {
+		issuerConfig.DiscoveryUri = GetDiscoveryUriFromIssuer(issuerConfig.Issuer)
+	}
+
+	return issuerConfig, issuers
+}
+
+func getFallbackSignatureAlgorithm(s string, allowES256K bool) (jwa.SignatureAlgorithm, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	alg, err := getSignatureAlgorithmFromString(s, allowES256K)
+	if err != nil {
+		return "", fmt.Errorf("FallbackSignatureAlgorithm not accepted: %w", err)
+	}
+
+	return alg, nil
+}
+
+func getAllowedKeyTypes(ktys []string) ([]jwa.KeyType, error) {
+	var keyTypes []jwa.KeyType
+	for _, kty := range ktys {
+		keyType, err := getKeyTypeFromString(kty)
+		if err != nil {
+			return nil, fmt.Errorf("AllowedKeyTypes not accepted: %w", err)
+		}
+
+		keyTypes = append(keyTypes, keyType)
+	}
+
+	return keyTypes, nil
+}
+
+func getAllowedSignatureAlgorithms(algs []string, allowES256K bool) ([]jwa.SignatureAlgorithm, error) {
+	var signatureAlgorithms []jwa.SignatureAlgorithm
+	for _, s := range algs {
+		alg, err := getSignatureAlgorithmFromString(s, allowES256K)
+		if err != nil {
+			return nil, fmt.Errorf("AllowedSignatureAlgorithms not accepted: %w", err)
+		}
+		if alg == jwa.NoSignature {
+			return nil, fmt.Errorf("AllowedSignatureAlgorithms not accepted: signature algorithm %s can't be allowed", alg)
+		}
+
+		signatureAlgorithms = append(signatureAlgorithms, alg)
+	}
+
+	return signatureAlgorithms, nil
+}
+
+func getVerifiers(verifiers map[string]options.Verifier) (map[jwa.KeyType]options.Verifier, error) {
+	var keyTypeVerifiers map[jwa.KeyType]options.Verifier
+	for kty, verifier := range verifiers {
+		keyType, err := getKeyTypeFromString(kty)
+		if err != nil {
+			return nil, fmt.Errorf("Verifiers not accepted: %w", err)
+		}
+
+		if keyTypeVerifiers == nil {
+			keyTypeVerifiers = make(map[jwa.KeyType]options.Verifier)
+		}
+
+		keyTypeVerifiers[keyType] = verifier
+	}
+
+	return keyTypeVerifiers, nil
+}
+
+func getRequiredClaimsRegex(exprs map[string]string) (map[string]*regexp.Regexp, error) {
+	var requiredClaimsRegex map[string]*regexp.Regexp
+	for claimName, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("RequiredClaimsRegex not accepted for claim %q: %w", claimName, err)
+		}
+
+		if requiredClaimsRegex == nil {
+			requiredClaimsRegex = make(map[string]*regexp.Regexp)
+		}
+
+		requiredClaimsRegex[claimName] = re
+	}
+
+	return requiredClaimsRegex, nil
+}
+
+// getStringSet returns the values as a set, or nil if there are no values.
+func getStringSet(values []string) map[string]struct{} {
+	var set map[string]struct{}
+	for _, value := range values {
+		if set == nil {
+			set = make(map[string]struct{})
+		}
+
+		set[value] = struct{}{}
+	}
+
+	return set
+}
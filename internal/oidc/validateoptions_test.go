@@ -0,0 +1,89 @@
+package oidc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+func TestValidateOptions(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		options               []options.Option
+		expectedErrorContains string
+	}{
+		{
+			testDescription: "issuer",
+			options: []options.Option{
+				options.WithIssuer("https://foo.bar"),
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "no issuer",
+			options:               []options.Option{},
+			expectedErrorContains: "issuer is empty",
+		},
+		{
+			testDescription: "first of issuers",
+			options: []options.Option{
+				options.WithIssuers(options.IssuerConfig{Issuer: "https://foo.bar"}),
+			},
+			expectedErrorContains: "",
+		},
+		{
+			testDescription: "insecure first of issuers",
+			options: []options.Option{
+				options.WithIssuers(options.IssuerConfig{Issuer: "http://foo.bar"}),
+			},
+			expectedErrorContains: "issuer",
+		},
+		{
+			testDescription: "duplicate issuer",
+			options: []options.Option{
+				options.WithIssuer("https://foo.bar"),
+				options.WithIssuers(options.IssuerConfig{Issuer: "https://foo.bar"}),
+			},
+			expectedErrorContains: "Issuers[0]: issuer \"https://foo.bar\" is configured more than once",
+		},
+		{
+			testDescription: "issuers together with introspection",
+			options: []options.Option{
+				options.WithIssuer("https://foo.bar"),
+				options.WithIssuers(options.IssuerConfig{Issuer: "https://bar.baz"}),
+				options.WithIntrospectionUri("https://foo.bar/introspect"),
+			},
+			expectedErrorContains: "Issuers can't be used together with IntrospectionUri",
+		},
+		{
+			testDescription: "invalid allowed key type",
+			options: []options.Option{
+				options.WithIssuer("https://foo.bar"),
+				options.WithAllowedKeyTypes([]string{"foo"}),
+			},
+			expectedErrorContains: "AllowedKeyTypes not accepted",
+		},
+		{
+			testDescription: "token cache without size",
+			options: []options.Option{
+				options.WithIssuer("https://foo.bar"),
+				options.WithTokenCacheTTL(time.Minute),
+				options.WithTokenCacheSize(0),
+			},
+			expectedErrorContains: "TokenCacheSize needs to be larger than 0 when TokenCacheTTL is used",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		err := validateOptions(options.New(c.options...))
+		if c.expectedErrorContains == "" {
+			require.NoError(t, err)
+		} else {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+		}
+	}
+}
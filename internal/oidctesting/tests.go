@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/xenitab/go-oidc-middleware/internal/oidc"
@@ -53,6 +55,15 @@ func RunTests(t *testing.T, testName string, tester tester) {
 	runTestLazyLoad(t, testName, tester)
 	runTestRequirements(t, testName, tester)
 	runTestErrorHandler(t, testName, tester)
+	runTestMultipleHeaders(t, testName, tester)
+	runTestTokenCookie(t, testName, tester)
+	runTestGetTokenStringFn(t, testName, tester)
+	runTestTokenSources(t, testName, tester)
+	runTestSubjectFn(t, testName, tester)
+	runTestTokenExpiresInHeader(t, testName, tester)
+	runTestRequireAudienceForRequestPath(t, testName, tester)
+	runTestJwksUnavailable(t, testName, tester)
+	runTestMaxTokenLength(t, testName, tester)
 }
 
 func runTestNew(t *testing.T, testName string, tester tester) {
@@ -99,6 +110,7 @@ func runTestNew(t *testing.T, testName string, tester tester) {
 				testDescription: "fake issuer panics",
 				config: []options.Option{
 					options.WithIssuer("http://foo.bar/baz"),
+					options.WithAllowInsecureIssuer(true),
 				},
 				expectPanic: true,
 			},
@@ -106,6 +118,7 @@ func runTestNew(t *testing.T, testName string, tester tester) {
 				testDescription: "fake issuer with lazy load doesn't panic",
 				config: []options.Option{
 					options.WithIssuer("http://foo.bar/baz"),
+					options.WithAllowInsecureIssuer(true),
 					options.WithLazyLoadJwks(true),
 				},
 				expectPanic: false,
@@ -187,6 +200,7 @@ func runTestLazyLoad(t *testing.T, testName string, tester tester) {
 		oidcHandler, err := oidc.NewHandler[TestClaims](
 			nil,
 			options.WithIssuer("http://foo.bar/baz"),
+			options.WithAllowInsecureIssuer(true),
 			options.WithRequiredAudience("test-client"),
 			options.WithRequiredTokenType("JWT+AT"),
 			options.WithLazyLoadJwks(true),
@@ -202,9 +216,19 @@ func runTestLazyLoad(t *testing.T, testName string, tester tester) {
 
 		require.Equal(t, http.StatusBadRequest, recNoAuth.Result().StatusCode)
 
-		// Test with authentication
+		// Test with authentication while the jwks can't be loaded
 		token := op.GetToken(t)
-		testHttpWithAuthenticationFailure(t, token, handler)
+		reqUnavailable := httptest.NewRequest(http.MethodGet, "/", nil)
+		token.SetAuthHeader(reqUnavailable)
+		recUnavailable := httptest.NewRecorder()
+		handler.ServeHTTP(recUnavailable, reqUnavailable)
+
+		// the echo JWT middleware responds with 401 to all errors
+		if strings.Contains(t.Name(), "OidcEchoJwt") {
+			require.Equal(t, http.StatusUnauthorized, recUnavailable.Result().StatusCode)
+		} else {
+			require.Equal(t, http.StatusServiceUnavailable, recUnavailable.Result().StatusCode)
+		}
 
 		oidcHandler.SetIssuer(op.GetURL(t))
 		oidcHandler.SetDiscoveryUri(oidc.GetDiscoveryUriFromIssuer(op.GetURL(t)))
@@ -375,6 +399,709 @@ func runTestErrorHandler(t *testing.T, testName string, tester tester) {
 	})
 }
 
+func runTestMultipleHeaders(t *testing.T, testName string, tester tester) {
+	t.Helper()
+
+	t.Run(fmt.Sprintf("%s_multiple_headers", testName), func(t *testing.T) {
+		if strings.Contains(t.Name(), "OidcEchoJwt") {
+			t.Skip("TokenString isn't supported by Echo JWT")
+		}
+
+		op := optest.NewTesting(t)
+		defer op.Close(t)
+
+		token := op.GetToken(t)
+
+		cases := []struct {
+			testDescription    string
+			precedence         options.HeaderValuePrecedence
+			headerValues       []string
+			expectedStatusCode int
+		}{
+			{
+				testDescription:    "first wins with valid first token",
+				precedence:         options.FirstHeaderValue,
+				headerValues:       []string{"Bearer " + token.AccessToken, "Bearer foobar"},
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "first wins with invalid first token",
+				precedence:         options.FirstHeaderValue,
+				headerValues:       []string{"Bearer foobar", "Bearer " + token.AccessToken},
+				expectedStatusCode: http.StatusUnauthorized,
+			},
+			{
+				testDescription:    "last wins with valid last token",
+				precedence:         options.LastHeaderValue,
+				headerValues:       []string{"Bearer foobar", "Bearer " + token.AccessToken},
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "last wins with invalid last token",
+				precedence:         options.LastHeaderValue,
+				headerValues:       []string{"Bearer " + token.AccessToken, "Bearer foobar"},
+				expectedStatusCode: http.StatusUnauthorized,
+			},
+			{
+				testDescription:    "reject multiple",
+				precedence:         options.RejectMultipleHeaderValues,
+				headerValues:       []string{"Bearer " + token.AccessToken, "Bearer " + token.AccessToken},
+				expectedStatusCode: http.StatusBadRequest,
+			},
+			{
+				testDescription:    "reject multiple with single header",
+				precedence:         options.RejectMultipleHeaderValues,
+				headerValues:       []string{"Bearer " + token.AccessToken},
+				expectedStatusCode: http.StatusOK,
+			},
+		}
+
+		for i, c := range cases {
+			t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+			handler := tester.NewHandlerFn(
+				nil,
+				options.WithIssuer(op.GetURL(t)),
+				options.WithTokenString(
+					options.WithTokenStringHeaderValuePrecedence(c.precedence),
+				),
+			)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, v := range c.headerValues {
+				req.Header.Add("Authorization", v)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, c.expectedStatusCode, rec.Result().StatusCode)
+		}
+	})
+}
+
+func runTestTokenCookie(t *testing.T, testName string, tester tester) {
+	t.Helper()
+
+	t.Run(fmt.Sprintf("%s_token_cookie", testName), func(t *testing.T) {
+		op := optest.NewTesting(t)
+		defer op.Close(t)
+
+		token := op.GetToken(t)
+
+		cases := []struct {
+			testDescription    string
+			authHeader         string
+			cookieValue        string
+			expectedStatusCode int
+			skipEchoJwt        bool
+		}{
+			{
+				testDescription:    "valid token in cookie",
+				authHeader:         "",
+				cookieValue:        token.AccessToken,
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "valid token in header",
+				authHeader:         "Bearer " + token.AccessToken,
+				cookieValue:        "",
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "header is preferred over cookie",
+				authHeader:         "Bearer " + token.AccessToken,
+				cookieValue:        "foobar",
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "invalid header isn't replaced by cookie",
+				authHeader:         "Bearer foobar",
+				cookieValue:        token.AccessToken,
+				expectedStatusCode: http.StatusUnauthorized,
+				// echo JWT tries every extracted token until one is valid
+				skipEchoJwt: true,
+			},
+			{
+				testDescription:    "invalid token in cookie",
+				authHeader:         "",
+				cookieValue:        "foobar",
+				expectedStatusCode: http.StatusUnauthorized,
+			},
+		}
+
+		for i, c := range cases {
+			t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+			if c.skipEchoJwt && strings.Contains(t.Name(), "OidcEchoJwt") {
+				continue
+			}
+
+			handler := tester.NewHandlerFn(
+				nil,
+				options.WithIssuer(op.GetURL(t)),
+				options.WithTokenCookieName("access_token"),
+			)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if c.authHeader != "" {
+				req.Header.Set("Authorization", c.authHeader)
+			}
+			if c.cookieValue != "" {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: c.cookieValue})
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, c.expectedStatusCode, rec.Result().StatusCode)
+		}
+	})
+}
+
+func runTestGetTokenStringFn(t *testing.T, testName string, tester tester) {
+	t.Helper()
+
+	t.Run(fmt.Sprintf("%s_get_token_string_fn", testName), func(t *testing.T) {
+		op := optest.NewTesting(t)
+		defer op.Close(t)
+
+		token := op.GetToken(t)
+
+		getTokenStringFromQuery := func(r *http.Request) (string, error) {
+			tokenString := r.URL.Query().Get("access_token")
+			if tokenString == "" {
+				return "", fmt.Errorf("access_token query parameter missing")
+			}
+
+			return tokenString, nil
+		}
+
+		cases := []struct {
+			testDescription    string
+			target             string
+			authHeader         string
+			expectedStatusCode int
+		}{
+			{
+				testDescription:    "valid token in query",
+				target:             "/?access_token=" + token.AccessToken,
+				authHeader:         "",
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "invalid token in query",
+				target:             "/?access_token=foobar",
+				authHeader:         "",
+				expectedStatusCode: http.StatusUnauthorized,
+			},
+			{
+				testDescription:    "missing token in query",
+				target:             "/",
+				authHeader:         "",
+				expectedStatusCode: http.StatusBadRequest,
+			},
+			{
+				testDescription:    "header isn't used",
+				target:             "/",
+				authHeader:         "Bearer " + token.AccessToken,
+				expectedStatusCode: http.StatusBadRequest,
+			},
+		}
+
+		for i, c := range cases {
+			t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+			handler := tester.NewHandlerFn(
+				nil,
+				options.WithIssuer(op.GetURL(t)),
+				options.WithGetTokenStringFn(getTokenStringFromQuery),
+			)
+
+			req := httptest.NewRequest(http.MethodGet, c.target, nil)
+			if c.authHeader != "" {
+				req.Header.Set("Authorization", c.authHeader)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, c.expectedStatusCode, rec.Result().StatusCode)
+		}
+	})
+}
+
+func runTestTokenSources(t *testing.T, testName string, tester tester) {
+	t.Helper()
+
+	t.Run(fmt.Sprintf("%s_token_sources", testName), func(t *testing.T) {
+		op := optest.NewTesting(t)
+		defer op.Close(t)
+
+		token := op.GetToken(t)
+
+		handler := tester.NewHandlerFn(
+			nil,
+			options.WithIssuer(op.GetURL(t)),
+			options.WithTokenSources(
+				options.HeaderTokenSource(),
+				options.CookieTokenSource("access_token"),
+				options.QueryTokenSource("access_token"),
+			),
+		)
+
+		cases := []struct {
+			testDescription    string
+			authHeader         string
+			cookie             string
+			query              string
+			expectedStatusCode int
+		}{
+			{
+				testDescription:    "valid token in header",
+				authHeader:         "Bearer " + token.AccessToken,
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "valid token in cookie",
+				cookie:             token.AccessToken,
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "valid token in query",
+				query:              token.AccessToken,
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "header is preferred over cookie and query",
+				authHeader:         "Bearer " + token.AccessToken,
+				cookie:             "foobar",
+				query:              "foobar",
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "invalid token in header, valid token in cookie",
+				authHeader:         "Bearer foobar",
+				cookie:             token.AccessToken,
+				expectedStatusCode: http.StatusUnauthorized,
+			},
+			{
+				testDescription:    "header without bearer prefix, valid token in cookie",
+				authHeader:         "foobar",
+				cookie:             token.AccessToken,
+				expectedStatusCode: http.StatusBadRequest,
+			},
+			{
+				testDescription:    "cookie is preferred over query",
+				cookie:             token.AccessToken,
+				query:              "foobar",
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "invalid token in cookie, valid token in query",
+				cookie:             "foobar",
+				query:              token.AccessToken,
+				expectedStatusCode: http.StatusUnauthorized,
+			},
+			{
+				testDescription:    "no token",
+				expectedStatusCode: http.StatusBadRequest,
+			},
+		}
+
+		for i, c := range cases {
+			t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+			target := "/"
+			if c.query != "" {
+				target = "/?access_token=" + c.query
+			}
+
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if c.authHeader != "" {
+				req.Header.Set("Authorization", c.authHeader)
+			}
+
+			if c.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: c.cookie})
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, c.expectedStatusCode, rec.Result().StatusCode)
+		}
+	})
+}
+
+func runTestSubjectFn(t *testing.T, testName string, tester tester) {
+	t.Helper()
+
+	t.Run(fmt.Sprintf("%s_subject_fn", testName), func(t *testing.T) {
+		op := optest.NewTesting(t)
+		defer op.Close(t)
+
+		token := op.GetToken(t)
+
+		cases := []struct {
+			testDescription    string
+			authHeader         string
+			expectedStatusCode int
+			expectedSubjects   []string
+		}{
+			{
+				testDescription:    "valid token",
+				authHeader:         "Bearer " + token.AccessToken,
+				expectedStatusCode: http.StatusOK,
+				expectedSubjects:   []string{"test"},
+			},
+			{
+				testDescription:    "invalid token",
+				authHeader:         "Bearer foobar",
+				expectedStatusCode: http.StatusUnauthorized,
+				expectedSubjects:   nil,
+			},
+			{
+				testDescription:    "missing token",
+				authHeader:         "",
+				expectedStatusCode: http.StatusBadRequest,
+				expectedSubjects:   nil,
+			},
+		}
+
+		for i, c := range cases {
+			t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+			var subjects []string
+			handler := tester.NewHandlerFn(
+				nil,
+				options.WithIssuer(op.GetURL(t)),
+				options.WithSubjectFn(func(r *http.Request, sub string) {
+					require.Equal(t, "bar", r.URL.Query().Get("foo"))
+					subjects = append(subjects, sub)
+				}),
+			)
+
+			req := httptest.NewRequest(http.MethodGet, "/?foo=bar", nil)
+			if c.authHeader != "" {
+				req.Header.Set("Authorization", c.authHeader)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, c.expectedStatusCode, rec.Result().StatusCode)
+			require.Equal(t, c.expectedSubjects, subjects)
+		}
+	})
+}
+
+func runTestRequireAudienceForRequestPath(t *testing.T, testName string, tester tester) {
+	t.Helper()
+
+	t.Run(fmt.Sprintf("%s_require_audience_for_request_path", testName), func(t *testing.T) {
+		newTestUser := func(resource ...string) optest.TestUser {
+			return optest.TestUser{
+				Audience:           "test-client",
+				Subject:            "test",
+				AccessTokenKeyType: "JWT+AT",
+				IdTokenKeyType:     "JWT",
+				ExtraAccessTokenClaims: map[string]interface{}{
+					"resource": resource,
+				},
+			}
+		}
+
+		op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+			"test":     newTestUser("https://api.example.com", "https://api.example.com/"),
+			"orders":   newTestUser("https://api.example.com", "https://api.example.com/orders"),
+			"resource": newTestUser("https://api.example.com"),
+		}))
+		defer op.Close(t)
+
+		cases := []struct {
+			testDescription    string
+			user               string
+			requiredAudience   string
+			expectedStatusCode int
+		}{
+			{
+				testDescription:    "audience authorizing all paths",
+				user:               "test",
+				requiredAudience:   "https://api.example.com",
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "audience authorizing another path",
+				user:               "orders",
+				requiredAudience:   "https://api.example.com",
+				expectedStatusCode: http.StatusUnauthorized,
+			},
+			{
+				testDescription:    "audience without path",
+				user:               "resource",
+				requiredAudience:   "https://api.example.com",
+				expectedStatusCode: http.StatusUnauthorized,
+			},
+			{
+				testDescription:    "audience authorizing all paths of another resource",
+				user:               "test",
+				requiredAudience:   "https://other.example.com",
+				expectedStatusCode: http.StatusUnauthorized,
+			},
+		}
+
+		for i, c := range cases {
+			t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+			handler := tester.NewHandlerFn(
+				nil,
+				options.WithIssuer(op.GetURL(t)),
+				options.WithRequiredAudience(c.requiredAudience),
+				options.WithAudienceClaimName("resource"),
+				options.WithRequireAudienceForRequestPath(true),
+			)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			op.GetTokenByUser(t, c.user).SetAuthHeader(req)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, c.expectedStatusCode, rec.Result().StatusCode)
+		}
+	})
+}
+
+func runTestTokenExpiresInHeader(t *testing.T, testName string, tester tester) {
+	t.Helper()
+
+	t.Run(fmt.Sprintf("%s_token_expires_in_header", testName), func(t *testing.T) {
+		op := optest.NewTesting(t, optest.WithTokenExpiration(10*time.Minute))
+		defer op.Close(t)
+
+		token := op.GetToken(t)
+
+		cases := []struct {
+			testDescription    string
+			headerName         string
+			authHeader         string
+			expectedStatusCode int
+			expectedExpiresIn  time.Duration
+		}{
+			{
+				testDescription:    "valid token",
+				headerName:         "X-Token-Expires-In",
+				authHeader:         "Bearer " + token.AccessToken,
+				expectedStatusCode: http.StatusOK,
+				expectedExpiresIn:  10 * time.Minute,
+			},
+			{
+				testDescription:    "valid token without header configured",
+				headerName:         "",
+				authHeader:         "Bearer " + token.AccessToken,
+				expectedStatusCode: http.StatusOK,
+			},
+			{
+				testDescription:    "invalid token",
+				headerName:         "X-Token-Expires-In",
+				authHeader:         "Bearer foobar",
+				expectedStatusCode: http.StatusUnauthorized,
+			},
+		}
+
+		for i, c := range cases {
+			t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+			handler := tester.NewHandlerFn(
+				nil,
+				options.WithIssuer(op.GetURL(t)),
+				options.WithTokenExpiresInHeader(c.headerName),
+			)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", c.authHeader)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, c.expectedStatusCode, rec.Result().StatusCode)
+
+			headerValue := rec.Result().Header.Get("X-Token-Expires-In")
+			if c.expectedExpiresIn == 0 {
+				require.Empty(t, headerValue)
+				continue
+			}
+
+			expiresIn, err := strconv.Atoi(headerValue)
+			require.NoError(t, err)
+			require.InDelta(t, c.expectedExpiresIn.Seconds(), expiresIn, 10)
+		}
+	})
+}
+
+func runTestJwksUnavailable(t *testing.T, testName string, tester tester) {
+	t.Helper()
+
+	t.Run(fmt.Sprintf("%s_jwks_unavailable", testName), func(t *testing.T) {
+		op := optest.NewTesting(t)
+
+		errs := &testErrorRecorder{}
+
+		handler := tester.NewHandlerFn(
+			nil,
+			options.WithIssuer(op.GetURL(t)),
+			options.WithErrorHandler(errs.errorHandler),
+		)
+
+		token := op.GetToken(t)
+
+		// Test with bad token while the provider is reachable
+		badToken := op.GetToken(t)
+		badToken.AccessToken = "foobar"
+		testHttpWithAuthenticationFailure(t, badToken, handler)
+		require.NotErrorIs(t, errs.getErr(), options.ErrJwksUnavailable)
+
+		// Test with a token signed by a new key while the provider is unreachable
+		op.RotateKeys(t)
+		tokenWithRotatedKey := op.GetToken(t)
+		op.Close(t)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		tokenWithRotatedKey.SetAuthHeader(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.ErrorIs(t, errs.getErr(), options.ErrJwksUnavailable)
+
+		// the echo JWT middleware responds with 401 to all errors
+		if strings.Contains(t.Name(), "OidcEchoJwt") {
+			require.Equal(t, http.StatusUnauthorized, rec.Result().StatusCode)
+		} else {
+			require.Equal(t, http.StatusServiceUnavailable, rec.Result().StatusCode)
+			require.Equal(t, oidc.JwksUnavailableRetryAfter, rec.Result().Header.Get("Retry-After"))
+		}
+
+		// Test with bad token while the provider is unreachable
+		testHttpWithAuthenticationFailure(t, badToken, handler)
+		require.NotErrorIs(t, errs.getErr(), options.ErrJwksUnavailable)
+
+		// Test with a token signed by an already known key while the provider is unreachable
+		testHttpWithAuthentication(t, token, handler)
+	})
+}
+
+func runTestMaxTokenLength(t *testing.T, testName string, tester tester) {
+	t.Helper()
+
+	t.Run(fmt.Sprintf("%s_max_token_length", testName), func(t *testing.T) {
+		op := optest.NewTesting(t)
+		defer op.Close(t)
+
+		errs := &testErrorRecorder{}
+
+		// the echo JWT middleware responds with 401 to all errors
+		expectedStatusCode := http.StatusBadRequest
+		if strings.Contains(t.Name(), "OidcEchoJwt") {
+			expectedStatusCode = http.StatusUnauthorized
+		}
+
+		token := op.GetToken(t)
+
+		cases := []struct {
+			testDescription    string
+			maxTokenLength     int
+			accessToken        string
+			expectedStatusCode int
+			expectedErr        error
+		}{
+			{
+				testDescription:    "default max token length with valid token",
+				maxTokenLength:     -1,
+				accessToken:        token.AccessToken,
+				expectedStatusCode: http.StatusOK,
+				expectedErr:        nil,
+			},
+			{
+				testDescription:    "over-length token is rejected without being parsed",
+				maxTokenLength:     100,
+				accessToken:        strings.Repeat("a", 101),
+				expectedStatusCode: expectedStatusCode,
+				expectedErr:        options.ErrTokenTooLong,
+			},
+			{
+				testDescription:    "valid token longer than max token length",
+				maxTokenLength:     100,
+				accessToken:        token.AccessToken,
+				expectedStatusCode: expectedStatusCode,
+				expectedErr:        options.ErrTokenTooLong,
+			},
+			{
+				testDescription:    "disabled max token length",
+				maxTokenLength:     0,
+				accessToken:        token.AccessToken,
+				expectedStatusCode: http.StatusOK,
+				expectedErr:        nil,
+			},
+		}
+
+		for i, c := range cases {
+			t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+			opts := []options.Option{
+				options.WithIssuer(op.GetURL(t)),
+				options.WithErrorHandler(errs.errorHandler),
+			}
+
+			if c.maxTokenLength >= 0 {
+				opts = append(opts, options.WithMaxTokenLength(c.maxTokenLength))
+			}
+
+			handler := tester.NewHandlerFn(nil, opts...)
+
+			errs.reset()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+c.accessToken)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			require.Equal(t, c.expectedStatusCode, rec.Result().StatusCode)
+
+			if c.expectedErr == nil {
+				require.NoError(t, errs.getErr())
+			} else {
+				require.ErrorIs(t, errs.getErr(), c.expectedErr)
+			}
+		}
+	})
+}
+
+// testErrorRecorder stores the last error passed to its errorHandler, which can be called
+// concurrently by the handlers.
+type testErrorRecorder struct {
+	sync.RWMutex
+	err error
+}
+
+func (r *testErrorRecorder) errorHandler(description options.ErrorDescription, err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.err = err
+}
+
+func (r *testErrorRecorder) getErr() error {
+	r.RLock()
+	defer r.RUnlock()
+	return r.err
+}
+
+func (r *testErrorRecorder) reset() {
+	r.Lock()
+	defer r.Unlock()
+	r.err = nil
+}
+
 func testHttpWithAuthentication(tb testing.TB, token *optest.TokenResponse, handler http.Handler) {
 	tb.Helper()
 
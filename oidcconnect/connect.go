@@ -0,0 +1,124 @@
+package oidcconnect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/xenitab/go-oidc-middleware/internal/oidc"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+// NewInterceptor returns an OpenID Connect (OIDC) discovery `connect.Interceptor` for unary and
+// streaming handlers. The token is read from the `Authorization: Bearer` header (configurable using
+// options.WithTokenString) and the claims are added to the context, use ClaimsFromContext to get them.
+// Responds with `connect.CodeUnauthenticated` if the token is missing or invalid and with
+// `connect.CodeUnavailable` if the jwks can't be fetched. Clients using the interceptor are not affected.
+// GetTokenStringFn, TokenSources and SubjectFn aren't used, since they require a request.
+func NewInterceptor[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) connect.Interceptor {
+	oidcHandler, err := oidc.NewHandler(claimsValidationFn, setters...)
+	if err != nil {
+		panic(fmt.Sprintf("oidc discovery: %v", err))
+	}
+
+	return toInterceptor(oidcHandler.ParseToken, setters...)
+}
+
+type interceptor[T any] struct {
+	parseToken oidc.ParseTokenFunc[T]
+	opts       *options.Options
+}
+
+func toInterceptor[T any](parseToken oidc.ParseTokenFunc[T], setters ...options.Option) connect.Interceptor {
+	return &interceptor[T]{
+		parseToken: parseToken,
+		opts:       options.New(setters...),
+	}
+}
+
+// WrapUnary implements connect.Interceptor.
+func (i *interceptor[T]) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+
+		ctxWithClaims, err := i.authenticate(ctx, req.Spec().Procedure, req.Header())
+		if err != nil {
+			return nil, err
+		}
+
+		return next(ctxWithClaims, req)
+	}
+}
+
+// WrapStreamingClient implements connect.Interceptor, client streams are passed through as is.
+func (i *interceptor[T]) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+// WrapStreamingHandler implements connect.Interceptor.
+func (i *interceptor[T]) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		ctxWithClaims, err := i.authenticate(ctx, conn.Spec().Procedure, conn.RequestHeader())
+		if err != nil {
+			return err
+		}
+
+		return next(ctxWithClaims, conn)
+	}
+}
+
+// authenticate validates the token from the request headers and returns a context with the claims,
+// or a connect error to return to the client. The description is used as the error message instead
+// of the error to avoid exposing details of the validation.
+func (i *interceptor[T]) authenticate(ctx context.Context, procedure string, header http.Header) (context.Context, error) {
+	tokenString, err := oidc.GetTokenStringFromValues(ctx, header.Values, oidc.GetTokenStringOptions(i.opts))
+	if err != nil {
+		return nil, onError(i.opts.ErrorHandler, connect.CodeUnauthenticated, options.GetTokenErrorDescription, err)
+	}
+
+	err = oidc.ValidateTokenLength(tokenString, i.opts.MaxTokenLength)
+	if err != nil {
+		return nil, onError(i.opts.ErrorHandler, connect.CodeUnauthenticated, options.GetTokenErrorDescription, err)
+	}
+
+	if i.opts.RequireAudienceForRequestPath {
+		ctx = oidc.WithRequestPath(ctx, procedure)
+	}
+
+	claims, err := i.parseToken(ctx, tokenString)
+	if errors.Is(err, options.ErrJwksUnavailable) {
+		return nil, onError(i.opts.ErrorHandler, connect.CodeUnavailable, options.ParseTokenErrorDescription, err)
+	}
+	if err != nil {
+		return nil, onError(i.opts.ErrorHandler, connect.CodeUnauthenticated, options.ParseTokenErrorDescription, err)
+	}
+
+	ctxWithClaims := context.WithValue(ctx, i.opts.ClaimsContextKeyName, claims)
+
+	return oidc.WithTokenIDFromClaims(ctxWithClaims, claims), nil
+}
+
+func onError(errorHandler options.ErrorHandler, code connect.Code, description options.ErrorDescription, err error) error {
+	if errorHandler != nil {
+		errorHandler(description, err)
+	}
+
+	return connect.NewError(code, errors.New(string(description)))
+}
+
+// ClaimsFromContext returns the claims added to the context by the interceptor as T, using the
+// ClaimsContextKeyName passed to the interceptor. If the claims are stored using another type, as an
+// example `map[string]interface{}`, they are converted to T using json. The result is a copy and
+// can be modified. An error is returned if no claims are found or they can't be converted to T.
+func ClaimsFromContext[T any](ctx context.Context, keyName options.ClaimsContextKeyName) (T, error) {
+	return oidc.ClaimsFromContext[T](ctx, keyName)
+}
+
+// GetTokenID is oidctoken.GetTokenID for the context passed to the handler by the interceptor.
+func GetTokenID(ctx context.Context) (string, bool) {
+	return oidc.GetTokenID(ctx)
+}
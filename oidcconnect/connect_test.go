@@ -0,0 +1,348 @@
+package oidcconnect
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/optest"
+	"github.com/xenitab/go-oidc-middleware/options"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testClaims map[string]interface{}
+
+type testTypedClaims struct {
+	Subject  string   `json:"sub"`
+	Audience []string `json:"aud"`
+}
+
+const (
+	testUnaryProcedure  = "/test.v1.TestService/Subject"
+	testStreamProcedure = "/test.v1.TestService/SubjectStream"
+)
+
+type testClient struct {
+	unary  *connect.Client[emptypb.Empty, wrapperspb.StringValue]
+	stream *connect.Client[emptypb.Empty, wrapperspb.StringValue]
+}
+
+func TestInterceptor(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	var errorDescriptions []options.ErrorDescription
+	client := testNewClient(t, NewInterceptor[testClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithErrorHandler(func(description options.ErrorDescription, err error) {
+			errorDescriptions = append(errorDescriptions, description)
+		}),
+	))
+
+	cases := []struct {
+		testDescription          string
+		authHeader               string
+		expectedCode             connect.Code
+		expectedErrorDescription options.ErrorDescription
+	}{
+		{
+			testDescription: "valid token",
+			authHeader:      "Bearer " + token.AccessToken,
+		},
+		{
+			testDescription:          "invalid token",
+			authHeader:               "Bearer foobar",
+			expectedCode:             connect.CodeUnauthenticated,
+			expectedErrorDescription: options.ParseTokenErrorDescription,
+		},
+		{
+			testDescription:          "missing token",
+			authHeader:               "",
+			expectedCode:             connect.CodeUnauthenticated,
+			expectedErrorDescription: options.GetTokenErrorDescription,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		errorDescriptions = nil
+
+		unaryReq := connect.NewRequest(&emptypb.Empty{})
+		streamReq := connect.NewRequest(&emptypb.Empty{})
+		if c.authHeader != "" {
+			unaryReq.Header().Set("Authorization", c.authHeader)
+			streamReq.Header().Set("Authorization", c.authHeader)
+		}
+
+		res, unaryErr := client.unary.CallUnary(context.Background(), unaryReq)
+
+		stream, err := client.stream.CallServerStream(context.Background(), streamReq)
+		require.NoError(t, err)
+		received := stream.Receive()
+		streamErr := stream.Err()
+		require.NoError(t, stream.Close())
+
+		if c.expectedCode != 0 {
+			require.Equal(t, c.expectedCode, connect.CodeOf(unaryErr))
+			require.Equal(t, c.expectedCode, connect.CodeOf(streamErr))
+			require.False(t, received)
+
+			var connectErr *connect.Error
+			require.True(t, errors.As(unaryErr, &connectErr))
+			require.Equal(t, string(c.expectedErrorDescription), connectErr.Message())
+			require.Equal(t, []options.ErrorDescription{c.expectedErrorDescription, c.expectedErrorDescription}, errorDescriptions)
+			continue
+		}
+
+		require.NoError(t, unaryErr)
+		require.NoError(t, streamErr)
+		require.Empty(t, errorDescriptions)
+		require.Equal(t, "test", res.Msg.GetValue())
+		require.True(t, received)
+		require.Equal(t, "test", stream.Msg().GetValue())
+	}
+}
+
+func TestInterceptorWithRequireAudienceForRequestPath(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"test": {
+			Audience:           "test-client",
+			Subject:            "test",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"resource": []string{"https://api.example.com", "https://api.example.com/test.v1.TestService"},
+			},
+		},
+		"orders": {
+			Audience:           "test-client",
+			Subject:            "orders",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"resource": []string{"https://api.example.com", "https://api.example.com/orders.v1.OrdersService"},
+			},
+		},
+	}), optest.WithDefaultTestUser("test"))
+	defer op.Close(t)
+
+	client := testNewClient(t, NewInterceptor[testClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithAudienceClaimName("resource"),
+		options.WithRequiredAudience("https://api.example.com"),
+		options.WithRequireAudienceForRequestPath(true),
+	))
+
+	cases := []struct {
+		testDescription string
+		user            string
+		expectedCode    connect.Code
+	}{
+		{
+			testDescription: "audience scoped to the service",
+			user:            "test",
+		},
+		{
+			testDescription: "audience scoped to another service",
+			user:            "orders",
+			expectedCode:    connect.CodeUnauthenticated,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		token := op.GetTokenByUser(t, c.user)
+		req := connect.NewRequest(&emptypb.Empty{})
+		req.Header().Set("Authorization", "Bearer "+token.AccessToken)
+
+		_, err := client.unary.CallUnary(context.Background(), req)
+		if c.expectedCode == 0 {
+			require.NoError(t, err)
+			continue
+		}
+		require.Equal(t, c.expectedCode, connect.CodeOf(err))
+	}
+}
+
+func TestInterceptorWithJwksUnavailable(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	testServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableUrl := testServer.URL
+	testServer.Close()
+
+	client := testNewClient(t, NewInterceptor[testClaims](
+		nil,
+		options.WithIssuer(unreachableUrl),
+		options.WithLazyLoadJwks(true),
+	))
+
+	req := connect.NewRequest(&emptypb.Empty{})
+	req.Header().Set("Authorization", "Bearer "+token.AccessToken)
+
+	_, err := client.unary.CallUnary(context.Background(), req)
+	require.Equal(t, connect.CodeUnavailable, connect.CodeOf(err))
+}
+
+func TestClaimsFromContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), options.DefaultClaimsContextKeyName, map[string]interface{}{
+		"sub": "foo",
+		"aud": []interface{}{"bar"},
+	})
+
+	claims, err := ClaimsFromContext[testTypedClaims](ctx, options.DefaultClaimsContextKeyName)
+	require.NoError(t, err)
+	require.Equal(t, testTypedClaims{Subject: "foo", Audience: []string{"bar"}}, claims)
+
+	_, err = ClaimsFromContext[testTypedClaims](ctx, "custom")
+	require.Error(t, err)
+
+	_, err = ClaimsFromContext[testTypedClaims](context.Background(), options.DefaultClaimsContextKeyName)
+	require.Error(t, err)
+}
+
+func TestClaimsFromContextWithClaimsContextKeyName(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	keyName := options.ClaimsContextKeyName("custom")
+	interceptor := NewInterceptor[testClaims](nil, options.WithIssuer(op.GetURL(t)), options.WithClaimsContextKeyName(string(keyName)))
+
+	var claims testTypedClaims
+	var claimsErr error
+	next := interceptor.WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		claims, claimsErr = ClaimsFromContext[testTypedClaims](ctx, keyName)
+		return nil, nil
+	})
+
+	token := op.GetToken(t)
+	req := connect.NewRequest(&emptypb.Empty{})
+	req.Header().Set("Authorization", "Bearer "+token.AccessToken)
+
+	_, err := next(context.Background(), req)
+	require.NoError(t, err)
+	require.NoError(t, claimsErr)
+	require.Equal(t, "test", claims.Subject)
+}
+
+func TestGetTokenID(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"test": {
+			Audience:           "test-client",
+			Subject:            "test",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"jti": "foo",
+			},
+		},
+		"without-jti": {
+			Audience:           "test-client",
+			Subject:            "without-jti",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+		},
+	}), optest.WithDefaultTestUser("test"))
+	defer op.Close(t)
+
+	interceptor := NewInterceptor[testClaims](nil, options.WithIssuer(op.GetURL(t)))
+
+	var tokenID string
+	next := interceptor.WrapUnary(func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		tokenID, _ = GetTokenID(ctx)
+		return nil, nil
+	})
+
+	cases := []struct {
+		testDescription string
+		user            string
+		expectedTokenID string
+	}{
+		{
+			testDescription: "token with jti",
+			user:            "test",
+			expectedTokenID: "foo",
+		},
+		{
+			testDescription: "token without jti",
+			user:            "without-jti",
+			expectedTokenID: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		tokenID = ""
+		token := op.GetTokenByUser(t, c.user)
+		req := connect.NewRequest(&emptypb.Empty{})
+		req.Header().Set("Authorization", "Bearer "+token.AccessToken)
+
+		_, err := next(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, c.expectedTokenID, tokenID)
+	}
+}
+
+// testNewClient starts a connect test server responding with the subject of the claims from the
+// context, using a unary and a server streaming handler, and returns clients for both.
+func testNewClient(t *testing.T, interceptor connect.Interceptor) testClient {
+	t.Helper()
+
+	getSubject := func(ctx context.Context) (*wrapperspb.StringValue, error) {
+		claims, err := ClaimsFromContext[testTypedClaims](ctx, options.DefaultClaimsContextKeyName)
+		if err != nil {
+			return nil, connect.NewError(connect.CodeInternal, errors.New("claims not found"))
+		}
+
+		return wrapperspb.String(claims.Subject), nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(testUnaryProcedure, connect.NewUnaryHandler(
+		testUnaryProcedure,
+		func(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[wrapperspb.StringValue], error) {
+			subject, err := getSubject(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return connect.NewResponse(subject), nil
+		},
+		connect.WithInterceptors(interceptor),
+	))
+	mux.Handle(testStreamProcedure, connect.NewServerStreamHandler(
+		testStreamProcedure,
+		func(ctx context.Context, req *connect.Request[emptypb.Empty], stream *connect.ServerStream[wrapperspb.StringValue]) error {
+			subject, err := getSubject(ctx)
+			if err != nil {
+				return err
+			}
+
+			return stream.Send(subject)
+		},
+		connect.WithInterceptors(interceptor),
+	))
+
+	testServer := httptest.NewServer(mux)
+	t.Cleanup(testServer.Close)
+
+	return testClient{
+		unary:  connect.NewClient[emptypb.Empty, wrapperspb.StringValue](testServer.Client(), testServer.URL+testUnaryProcedure),
+		stream: connect.NewClient[emptypb.Empty, wrapperspb.StringValue](testServer.Client(), testServer.URL+testStreamProcedure),
+	}
+}
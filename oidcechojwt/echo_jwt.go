@@ -1,15 +1,19 @@
 package oidcechojwt
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 	"github.com/xenitab/go-oidc-middleware/internal/oidc"
 	"github.com/xenitab/go-oidc-middleware/options"
 )
 
 // New returns an OpenID Connect (OIDC) discovery `ParseTokenFunc`
 // to be used with the the echo `JWT` middleware.
+// The echo `JWT` middleware responds with 401 to all errors, use its `ErrorHandlerWithContext`
+// and `errors.Is(err, options.ErrJwksUnavailable)` to respond with 503 if the jwks can't be fetched.
 func New[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) func(auth string, c echo.Context) (interface{}, error) {
 	h, err := oidc.NewHandler(claimsValidationFn, setters...)
 	if err != nil {
@@ -19,6 +23,83 @@ func New[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...opt
 	return toEchoJWTParseTokenFunc(h.ParseToken, setters...)
 }
 
+// NewWithClose returns the same `ParseTokenFunc` as New together with its close function,
+// which behaves like oidctoken.TokenHandler.Close.
+func NewWithClose[T any](claimsValidationFn options.ClaimsValidationFn[T],
+	setters ...options.Option) (func(auth string, c echo.Context) (interface{}, error), func() error) {
+	h, err := oidc.NewHandler(claimsValidationFn, setters...)
+	if err != nil {
+		panic(fmt.Sprintf("oidc discovery: %v", err))
+	}
+
+	return toEchoJWTParseTokenFunc(h.ParseToken, setters...), h.Close
+}
+
+// TokenLookup returns a `TokenLookup` for the echo `JWT` middleware reading the token from
+// the `Authorization: Bearer` header and, if `options.WithTokenCookieName()` is used, falling
+// back to the cookie with that name. Note that the echo `JWT` middleware also tries the cookie
+// if the token from the header is invalid. An empty string is returned if
+// `options.WithGetTokenStringFn()` or `options.WithTokenSources()` is used, use TokenLookupFuncs together with it.
+func TokenLookup(setters ...options.Option) string {
+	opts := options.New(setters...)
+
+	if opts.GetTokenStringFn != nil || len(opts.TokenSources) > 0 {
+		return ""
+	}
+
+	tokenLookup := "header:Authorization:Bearer "
+	if opts.TokenCookieName != "" {
+		tokenLookup = fmt.Sprintf("%s,cookie:%s", tokenLookup, opts.TokenCookieName)
+	}
+
+	return tokenLookup
+}
+
+// TokenLookupFuncs returns `TokenLookupFuncs` for the echo `JWT` middleware using the function
+// configured with `options.WithGetTokenStringFn()` or the sources configured with
+// `options.WithTokenSources()`, or nil if none of them are configured.
+func TokenLookupFuncs(setters ...options.Option) []middleware.ValuesExtractor {
+	opts := options.New(setters...)
+
+	if opts.GetTokenStringFn == nil && len(opts.TokenSources) == 0 {
+		return nil
+	}
+
+	valuesExtractor := func(c echo.Context) ([]string, error) {
+		tokenString, err := oidc.GetTokenStringFromRequest(c.Request(), opts)
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{tokenString}, nil
+	}
+
+	return []middleware.ValuesExtractor{valuesExtractor}
+}
+
+// DefaultContextKey is the key used by the echo `JWT` middleware to store the claims returned by
+// the `ParseTokenFunc` in the echo context, unless its `ContextKey` is configured.
+const DefaultContextKey = "user"
+
+// GetClaims returns the claims stored in the echo context by the echo `JWT` middleware (using
+// DefaultContextKey) as T. The claims are stored as the type used with New and, if it isn't T,
+// they are converted to T using json, which makes it possible to get the claims as a struct with
+// json tags. The result is a copy and can be modified. ok is false if no claims are found or they
+// can't be converted to T.
+func GetClaims[T any](c echo.Context) (T, bool) {
+	claims := c.Get(DefaultContextKey)
+	if claims == nil {
+		return *new(T), false
+	}
+
+	typedClaims, err := oidc.ConvertClaims[T](claims)
+	if err != nil {
+		return *new(T), false
+	}
+
+	return typedClaims, true
+}
+
 type echoJWTParseTokenFunc func(auth string, c echo.Context) (interface{}, error)
 
 func onError(errorHandler options.ErrorHandler, description options.ErrorDescription, err error) {
@@ -33,14 +114,39 @@ func toEchoJWTParseTokenFunc[T any](parseToken oidc.ParseTokenFunc[T], setters .
 	echoJWTParseTokenFunc := func(auth string, c echo.Context) (interface{}, error) {
 		ctx := c.Request().Context()
 
+		err := oidc.ValidateTokenLength(auth, opts.MaxTokenLength)
+		if err != nil {
+			onError(opts.ErrorHandler, options.GetTokenErrorDescription, err)
+			return nil, err
+		}
+
+		if opts.RequireAudienceForRequestPath {
+			ctx = oidc.WithRequestPath(ctx, c.Request().URL.Path)
+		}
+
 		claims, err := parseToken(ctx, auth)
 		if err != nil {
 			onError(opts.ErrorHandler, options.ParseTokenErrorDescription, err)
 			return nil, err
 		}
 
+		c.SetRequest(c.Request().WithContext(oidc.WithTokenIDFromClaims(c.Request().Context(), claims)))
+
+		if opts.SubjectFn != nil {
+			opts.SubjectFn(c.Request(), oidc.GetSubjectFromClaims(claims))
+		}
+
+		if expiresIn, ok := oidc.GetTokenExpiresInHeaderValue(claims, opts); ok {
+			c.Response().Header().Set(opts.TokenExpiresInHeader, expiresIn)
+		}
+
 		return claims, nil
 	}
 
 	return echoJWTParseTokenFunc
 }
+
+// GetTokenID is oidctoken.GetTokenID for `c.Request().Context()`.
+func GetTokenID(ctx context.Context) (string, bool) {
+	return oidc.GetTokenID(ctx)
+}
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/xenitab/go-oidc-middleware/internal/oidc"
 	"github.com/xenitab/go-oidc-middleware/internal/oidctesting"
+	"github.com/xenitab/go-oidc-middleware/optest"
 	"github.com/xenitab/go-oidc-middleware/options"
 
 	"github.com/labstack/echo/v4"
@@ -28,7 +30,7 @@ func BenchmarkSuite(b *testing.B) {
 	oidctesting.RunBenchmarks(b, testName, newTestHandler(b))
 }
 
-func testGetEchoRouter(tb testing.TB, parseToken echoJWTParseTokenFunc) *echo.Echo {
+func testGetEchoRouter(tb testing.TB, parseToken echoJWTParseTokenFunc, opts ...options.Option) *echo.Echo {
 	tb.Helper()
 
 	e := echo.New()
@@ -36,7 +38,9 @@ func testGetEchoRouter(tb testing.TB, parseToken echoJWTParseTokenFunc) *echo.Ec
 	e.HideBanner = true
 
 	e.Use(middleware.JWTWithConfig(middleware.JWTConfig{
-		ParseTokenFunc: parseToken,
+		ParseTokenFunc:   parseToken,
+		TokenLookup:      TokenLookup(opts...),
+		TokenLookupFuncs: TokenLookupFuncs(opts...),
 	}))
 
 	e.GET("/", func(c echo.Context) error {
@@ -109,19 +113,174 @@ func (h *testHandler) NewHandlerFn(claimsValidationFn options.ClaimsValidationFn
 	h.tb.Helper()
 
 	echoParseToken := New(claimsValidationFn, opts...)
-	return testGetEchoRouter(h.tb, echoParseToken)
+	return testGetEchoRouter(h.tb, echoParseToken, opts...)
 }
 
 func (h *testHandler) ToHandlerFn(parseToken oidc.ParseTokenFunc[oidctesting.TestClaims], opts ...options.Option) http.Handler {
 	h.tb.Helper()
 
 	echoParseToken := toEchoJWTParseTokenFunc(parseToken, opts...)
-	return testGetEchoRouter(h.tb, echoParseToken)
+	return testGetEchoRouter(h.tb, echoParseToken, opts...)
 }
 
 func (h *testHandler) NewTestServer(opts ...options.Option) oidctesting.ServerTester {
 	h.tb.Helper()
 
 	echoParseToken := New[oidctesting.TestClaims](nil, opts...)
-	return newTestServer(h.tb, testGetEchoRouter(h.tb, echoParseToken))
+	return newTestServer(h.tb, testGetEchoRouter(h.tb, echoParseToken, opts...))
+}
+
+func TestGetClaims(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	type customClaims struct {
+		Subject string `json:"sub"`
+		Issuer  string `json:"iss"`
+	}
+
+	type invalidClaims struct {
+		Subject int `json:"sub"`
+	}
+
+	token := op.GetToken(t)
+
+	cases := []struct {
+		testDescription string
+		parseToken      echoJWTParseTokenFunc
+	}{
+		{
+			testDescription: "claims stored as the custom struct",
+			parseToken:      New[customClaims](nil, options.WithIssuer(op.GetURL(t))),
+		},
+		{
+			testDescription: "claims stored as a map",
+			parseToken:      New[oidctesting.TestClaims](nil, options.WithIssuer(op.GetURL(t))),
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		e := echo.New()
+		e.Use(middleware.JWTWithConfig(middleware.JWTConfig{
+			ParseTokenFunc: c.parseToken,
+		}))
+
+		e.GET("/", func(c echo.Context) error {
+			claims, ok := GetClaims[customClaims](c)
+			if !ok {
+				return echo.NewHTTPError(http.StatusInternalServerError, "claims not found")
+			}
+
+			_, ok = GetClaims[invalidClaims](c)
+			if ok {
+				return echo.NewHTTPError(http.StatusInternalServerError, "claims converted to invalid type")
+			}
+
+			return c.String(http.StatusOK, fmt.Sprintf("%s %s", claims.Subject, claims.Issuer))
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		token.SetAuthHeader(req)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+		require.Equal(t, fmt.Sprintf("test %s", op.GetURL(t)), rec.Body.String())
+	}
+
+	e := echo.New()
+	_, ok := GetClaims[customClaims](e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder()))
+	require.False(t, ok)
+}
+
+func TestNewWithClose(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	parseToken, closeFn := NewWithClose[oidctesting.TestClaims](nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithBackgroundRefreshInterval(time.Minute),
+		options.WithTokenCacheTTL(time.Minute),
+	)
+
+	e := echo.New()
+	e.Use(middleware.JWTWithConfig(middleware.JWTConfig{
+		ParseTokenFunc: parseToken,
+	}))
+
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		op.GetToken(t).SetAuthHeader(req)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+
+		// tokens are still parsed after close
+		require.NoError(t, closeFn())
+	}
+}
+
+func TestGetTokenID(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"test": {
+			Audience:           "test-client",
+			Subject:            "test",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"jti": "foo",
+			},
+		},
+		"without-jti": {
+			Audience:           "test-client",
+			Subject:            "without-jti",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+		},
+	}), optest.WithDefaultTestUser("test"))
+	defer op.Close(t)
+	e := echo.New()
+	e.Use(middleware.JWTWithConfig(middleware.JWTConfig{
+		ParseTokenFunc: New[oidctesting.TestClaims](nil, options.WithIssuer(op.GetURL(t))),
+	}))
+
+	e.GET("/", func(c echo.Context) error {
+		tokenID, _ := GetTokenID(c.Request().Context())
+		return c.String(http.StatusOK, tokenID)
+	})
+
+	cases := []struct {
+		testDescription string
+		user            string
+		expectedTokenID string
+	}{
+		{
+			testDescription: "token with jti",
+			user:            "test",
+			expectedTokenID: "foo",
+		},
+		{
+			testDescription: "token without jti",
+			user:            "without-jti",
+			expectedTokenID: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		op.GetTokenByUser(t, c.user).SetAuthHeader(req)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+		require.Equal(t, c.expectedTokenID, rec.Body.String())
+	}
 }
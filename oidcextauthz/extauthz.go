@@ -0,0 +1,190 @@
+package oidcextauthz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/xenitab/go-oidc-middleware/internal/oidc"
+	"github.com/xenitab/go-oidc-middleware/options"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// New returns an OpenID Connect (OIDC) discovery Envoy external authorization
+// (`envoy.service.auth.v3.Authorization`) gRPC server.
+// Responds with an `OkResponse` and the claims configured with options.WithAuthRequestClaimHeaders
+// as headers for the upstream if the token is valid, and with a `DeniedResponse` (401, or 503 with a
+// Retry-After header if the jwks can't be fetched) otherwise.
+// Register it using `authv3.RegisterAuthorizationServer(grpcServer, oidcextauthz.New(...))`.
+func New[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) authv3.AuthorizationServer {
+	oidcHandler, err := oidc.NewHandler(claimsValidationFn, setters...)
+	if err != nil {
+		panic(fmt.Sprintf("oidc discovery: %v", err))
+	}
+
+	return toAuthorizationServer(oidcHandler.ParseToken, setters...)
+}
+
+type authorizationServer[T any] struct {
+	authv3.UnimplementedAuthorizationServer
+	parseToken oidc.ParseTokenFunc[T]
+	opts       *options.Options
+}
+
+func toAuthorizationServer[T any](parseToken oidc.ParseTokenFunc[T], setters ...options.Option) authv3.AuthorizationServer {
+	return &authorizationServer[T]{
+		parseToken: parseToken,
+		opts:       options.New(setters...),
+	}
+}
+
+func onError(errorHandler options.ErrorHandler, description options.ErrorDescription, err error) {
+	if errorHandler != nil {
+		errorHandler(description, err)
+	}
+}
+
+// Check implements `envoy.service.auth.v3.Authorization`.
+func (s *authorizationServer[T]) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	r, err := newHttpRequest(ctx, req.GetAttributes().GetRequest().GetHttp())
+	if err != nil {
+		onError(s.opts.ErrorHandler, options.GetTokenErrorDescription, err)
+		return newDeniedResponse(codes.Unauthenticated, typev3.StatusCode_Unauthorized, nil), nil
+	}
+
+	tokenString, err := oidc.GetTokenStringFromRequest(r, s.opts)
+	if err != nil {
+		onError(s.opts.ErrorHandler, options.GetTokenErrorDescription, err)
+		return newDeniedResponse(codes.Unauthenticated, typev3.StatusCode_Unauthorized, nil), nil
+	}
+
+	err = oidc.ValidateTokenLength(tokenString, s.opts.MaxTokenLength)
+	if err != nil {
+		onError(s.opts.ErrorHandler, options.GetTokenErrorDescription, err)
+		return newDeniedResponse(codes.Unauthenticated, typev3.StatusCode_Unauthorized, nil), nil
+	}
+
+	if s.opts.RequireAudienceForRequestPath {
+		ctx = oidc.WithRequestPath(ctx, r.URL.Path)
+	}
+
+	claims, err := s.parseToken(ctx, tokenString)
+	if errors.Is(err, options.ErrJwksUnavailable) {
+		onError(s.opts.ErrorHandler, options.ParseTokenErrorDescription, err)
+		retryAfter := map[string]string{"Retry-After": oidc.JwksUnavailableRetryAfter}
+		return newDeniedResponse(codes.Unavailable, typev3.StatusCode_ServiceUnavailable, retryAfter), nil
+	}
+	if err != nil {
+		onError(s.opts.ErrorHandler, options.ParseTokenErrorDescription, err)
+		return newDeniedResponse(codes.Unauthenticated, typev3.StatusCode_Unauthorized, nil), nil
+	}
+
+	headers, err := oidc.GetClaimHeaders(claims, s.opts.AuthRequestClaimHeaders)
+	if err != nil {
+		onError(s.opts.ErrorHandler, options.ConvertTokenErrorDescription, err)
+		return newDeniedResponse(codes.Internal, typev3.StatusCode_InternalServerError, nil), nil
+	}
+
+	return newOkResponse(headers, s.opts.AuthRequestClaimHeaders), nil
+}
+
+// newHttpRequest converts the request attributes sent by Envoy to an *http.Request,
+// making it possible to reuse the token extraction of the other middlewares.
+func newHttpRequest(ctx context.Context, attributes *authv3.AttributeContext_HttpRequest) (*http.Request, error) {
+	path := attributes.GetPath()
+	if path == "" {
+		path = "/"
+	}
+
+	u, err := url.ParseRequestURI(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse request path: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, attributes.GetMethod(), u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	r.Host = attributes.GetHost()
+	for key, value := range attributes.GetHeaders() {
+		r.Header.Set(key, value)
+	}
+
+	return r, nil
+}
+
+func newOkResponse(headers map[string]string, claimHeaders map[string]string) *authv3.CheckResponse {
+	okResponse := &authv3.OkHttpResponse{}
+
+	for _, headerName := range getSortedHeaderNames(claimHeaders) {
+		headerValue, ok := headers[headerName]
+		if !ok {
+			// headers sent by the client are removed if the claim is missing
+			okResponse.HeadersToRemove = append(okResponse.HeadersToRemove, headerName)
+			continue
+		}
+
+		okResponse.Headers = append(okResponse.Headers, newHeaderValueOption(headerName, headerValue))
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{
+			Code: int32(codes.OK),
+		},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: okResponse,
+		},
+	}
+}
+
+func newDeniedResponse(code codes.Code, httpStatusCode typev3.StatusCode, headers map[string]string) *authv3.CheckResponse {
+	deniedResponse := &authv3.DeniedHttpResponse{
+		Status: &typev3.HttpStatus{
+			Code: httpStatusCode,
+		},
+	}
+
+	for headerName, headerValue := range headers {
+		deniedResponse.Headers = append(deniedResponse.Headers, newHeaderValueOption(headerName, headerValue))
+	}
+
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{
+			Code: int32(code),
+		},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: deniedResponse,
+		},
+	}
+}
+
+func newHeaderValueOption(headerName string, headerValue string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header: &corev3.HeaderValue{
+			Key:   headerName,
+			Value: headerValue,
+		},
+		Append:       wrapperspb.Bool(false),
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
+
+func getSortedHeaderNames(claimHeaders map[string]string) []string {
+	headerNames := make([]string, 0, len(claimHeaders))
+	for _, headerName := range claimHeaders {
+		headerNames = append(headerNames, headerName)
+	}
+
+	sort.Strings(headerNames)
+
+	return headerNames
+}
@@ -0,0 +1,267 @@
+package oidcextauthz
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/internal/oidc"
+	"github.com/xenitab/go-oidc-middleware/optest"
+	"github.com/xenitab/go-oidc-middleware/options"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type testClaims map[string]interface{}
+
+func TestCheck(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	var errorDescriptions []options.ErrorDescription
+	client := testNewAuthorizationClient(t, New[testClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithAuthRequestClaimHeaders(map[string]string{
+			"sub":     "x-auth-subject",
+			"aud":     "x-auth-audience",
+			"missing": "x-auth-missing",
+		}),
+		options.WithErrorHandler(func(description options.ErrorDescription, err error) {
+			errorDescriptions = append(errorDescriptions, description)
+		}),
+	))
+
+	cases := []struct {
+		testDescription          string
+		headers                  map[string]string
+		expectedCode             codes.Code
+		expectedHttpStatusCode   typev3.StatusCode
+		expectedHeaders          map[string]string
+		expectedHeadersToRemove  []string
+		expectedErrorDescription options.ErrorDescription
+	}{
+		{
+			testDescription: "valid token",
+			headers: map[string]string{
+				"authorization": "Bearer " + token.AccessToken,
+			},
+			expectedCode: codes.OK,
+			expectedHeaders: map[string]string{
+				"x-auth-subject":  "test",
+				"x-auth-audience": "test-client",
+			},
+			expectedHeadersToRemove: []string{"x-auth-missing"},
+		},
+		{
+			testDescription: "invalid token",
+			headers: map[string]string{
+				"authorization": "Bearer foobar",
+			},
+			expectedCode:             codes.Unauthenticated,
+			expectedHttpStatusCode:   typev3.StatusCode_Unauthorized,
+			expectedErrorDescription: options.ParseTokenErrorDescription,
+		},
+		{
+			testDescription:          "missing token",
+			headers:                  map[string]string{},
+			expectedCode:             codes.Unauthenticated,
+			expectedHttpStatusCode:   typev3.StatusCode_Unauthorized,
+			expectedErrorDescription: options.GetTokenErrorDescription,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		errorDescriptions = nil
+
+		res, err := client.Check(context.Background(), testNewCheckRequest(c.headers))
+		require.NoError(t, err)
+		require.Equal(t, int32(c.expectedCode), res.GetStatus().GetCode())
+
+		if c.expectedCode != codes.OK {
+			require.Equal(t, c.expectedHttpStatusCode, res.GetDeniedResponse().GetStatus().GetCode())
+			require.Nil(t, res.GetOkResponse())
+			require.Equal(t, []options.ErrorDescription{c.expectedErrorDescription}, errorDescriptions)
+			continue
+		}
+
+		require.Nil(t, res.GetDeniedResponse())
+		require.Equal(t, c.expectedHeaders, testGetHeaders(res.GetOkResponse().GetHeaders()))
+		require.Equal(t, c.expectedHeadersToRemove, res.GetOkResponse().GetHeadersToRemove())
+		require.Empty(t, errorDescriptions)
+
+		for _, header := range res.GetOkResponse().GetHeaders() {
+			require.Equal(t, corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD, header.GetAppendAction())
+			require.False(t, header.GetAppend().GetValue())
+		}
+	}
+}
+
+func TestCheckWithGetTokenStringFn(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	client := testNewAuthorizationClient(t, New[testClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithGetTokenStringFn(func(r *http.Request) (string, error) {
+			return r.URL.Query().Get("access_token"), nil
+		}),
+	))
+
+	req := testNewCheckRequest(nil)
+	req.Attributes.Request.Http.Path = "/events?access_token=" + token.AccessToken
+
+	res, err := client.Check(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, int32(codes.OK), res.GetStatus().GetCode())
+}
+
+func TestCheckWithRequireAudienceForRequestPath(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"test": {
+			Audience:           "test-client",
+			Subject:            "test",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"resource": []string{"https://api.example.com", "https://api.example.com/orders"},
+			},
+		},
+	}), optest.WithDefaultTestUser("test"))
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	client := testNewAuthorizationClient(t, New[testClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithAudienceClaimName("resource"),
+		options.WithRequiredAudience("https://api.example.com"),
+		options.WithRequireAudienceForRequestPath(true),
+	))
+
+	cases := []struct {
+		testDescription string
+		path            string
+		expectedCode    codes.Code
+	}{
+		{
+			testDescription: "path in the scope of the audience",
+			path:            "/orders/123?foo=bar",
+			expectedCode:    codes.OK,
+		},
+		{
+			testDescription: "path outside the scope of the audience",
+			path:            "/invoices/123",
+			expectedCode:    codes.Unauthenticated,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		req := testNewCheckRequest(map[string]string{"authorization": "Bearer " + token.AccessToken})
+		req.Attributes.Request.Http.Path = c.path
+
+		res, err := client.Check(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, int32(c.expectedCode), res.GetStatus().GetCode())
+	}
+}
+
+func TestCheckWithJwksUnavailable(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	testServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableUrl := testServer.URL
+	testServer.Close()
+
+	client := testNewAuthorizationClient(t, New[testClaims](
+		nil,
+		options.WithIssuer(unreachableUrl),
+		options.WithLazyLoadJwks(true),
+	))
+
+	res, err := client.Check(context.Background(), testNewCheckRequest(map[string]string{
+		"authorization": "Bearer " + token.AccessToken,
+	}))
+	require.NoError(t, err)
+	require.Equal(t, int32(codes.Unavailable), res.GetStatus().GetCode())
+	require.Equal(t, typev3.StatusCode_ServiceUnavailable, res.GetDeniedResponse().GetStatus().GetCode())
+	require.Equal(t, map[string]string{"Retry-After": oidc.JwksUnavailableRetryAfter}, testGetHeaders(res.GetDeniedResponse().GetHeaders()))
+}
+
+func testNewAuthorizationClient(t *testing.T, authorizationServer authv3.AuthorizationServer) authv3.AuthorizationClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+
+	grpcServer := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(grpcServer, authorizationServer)
+
+	go func() {
+		err := grpcServer.Serve(listener)
+		if err != nil {
+			t.Logf("grpc server stopped: %v", err)
+		}
+	}()
+
+	conn, err := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		grpcServer.Stop()
+	})
+
+	return authv3.NewAuthorizationClient(conn)
+}
+
+func testNewCheckRequest(headers map[string]string) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method:  http.MethodGet,
+					Path:    "/",
+					Host:    "foo.bar",
+					Headers: headers,
+				},
+			},
+		},
+	}
+}
+
+func testGetHeaders(headerValueOptions []*corev3.HeaderValueOption) map[string]string {
+	headers := make(map[string]string)
+	for _, headerValueOption := range headerValueOptions {
+		headers[headerValueOption.GetHeader().GetKey()] = headerValueOption.GetHeader().GetValue()
+	}
+
+	return headers
+}
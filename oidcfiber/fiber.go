@@ -1,15 +1,21 @@
 package oidcfiber
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
 	"github.com/xenitab/go-oidc-middleware/internal/oidc"
 	"github.com/xenitab/go-oidc-middleware/options"
 )
 
 // New returns an OpenID Connect (OIDC) discovery handler (middleware)
 // to be used with `fiber`.
+// The claims are stored in `c.Locals` using ClaimsContextKeyName (defaults to `claims`),
+// use options.WithClaimsContextKeyName to avoid collisions with other middlewares.
 func New[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) fiber.Handler {
 	oidcHandler, err := oidc.NewHandler(claimsValidationFn, setters...)
 	if err != nil {
@@ -19,6 +25,17 @@ func New[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...opt
 	return toFiberHandler(oidcHandler.ParseToken, setters...)
 }
 
+// NewWithClose returns the same fiber.Handler as New together with its close function,
+// which behaves like oidctoken.TokenHandler.Close.
+func NewWithClose[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) (fiber.Handler, func() error) {
+	oidcHandler, err := oidc.NewHandler(claimsValidationFn, setters...)
+	if err != nil {
+		panic(fmt.Sprintf("oidc discovery: %v", err))
+	}
+
+	return toFiberHandler(oidcHandler.ParseToken, setters...), oidcHandler.Close
+}
+
 func onError(c *fiber.Ctx, errorHandler options.ErrorHandler, statusCode int, description options.ErrorDescription, err error) error {
 	if errorHandler != nil {
 		errorHandler(description, err)
@@ -27,28 +44,81 @@ func onError(c *fiber.Ctx, errorHandler options.ErrorHandler, statusCode int, de
 	return c.SendStatus(statusCode)
 }
 
+func getTokenString(c *fiber.Ctx, opts *options.Options) (string, error) {
+	if opts.GetTokenStringFn != nil || len(opts.TokenSources) > 0 {
+		var r http.Request
+		err := fasthttpadaptor.ConvertRequest(c.Context(), &r, true)
+		if err != nil {
+			return "", fmt.Errorf("unable to convert request: %w", err)
+		}
+
+		return oidc.GetTokenStringFromRequest(&r, opts)
+	}
+
+	getHeaderValuesFn := func(key string) []string {
+		var values []string
+		for _, value := range c.Request().Header.PeekAll(key) {
+			values = append(values, string(value))
+		}
+
+		return values
+	}
+
+	return oidc.GetTokenStringFromValues(c.UserContext(), getHeaderValuesFn, oidc.GetTokenStringOptions(opts))
+}
+
 func toFiberHandler[T any](parseToken oidc.ParseTokenFunc[T], setters ...options.Option) fiber.Handler {
 	opts := options.New(setters...)
 
 	return func(c *fiber.Ctx) error {
-		ctx := c.Context()
+		var ctx context.Context = c.Context()
 
-		getHeaderFn := func(key string) string {
-			return c.Get(key)
+		tokenString, err := getTokenString(c, opts)
+		if err != nil {
+			return onError(c, opts.ErrorHandler, fiber.StatusBadRequest, options.GetTokenErrorDescription, err)
 		}
 
-		tokenString, err := oidc.GetTokenString(getHeaderFn, opts.TokenString)
+		err = oidc.ValidateTokenLength(tokenString, opts.MaxTokenLength)
 		if err != nil {
 			return onError(c, opts.ErrorHandler, fiber.StatusBadRequest, options.GetTokenErrorDescription, err)
 		}
 
+		if opts.RequireAudienceForRequestPath {
+			ctx = oidc.WithRequestPath(ctx, c.Path())
+		}
+
 		claims, err := parseToken(ctx, tokenString)
+		if errors.Is(err, options.ErrJwksUnavailable) {
+			c.Set(fiber.HeaderRetryAfter, oidc.JwksUnavailableRetryAfter)
+			return onError(c, opts.ErrorHandler, fiber.StatusServiceUnavailable, options.ParseTokenErrorDescription, err)
+		}
 		if err != nil {
 			return onError(c, opts.ErrorHandler, fiber.StatusUnauthorized, options.ParseTokenErrorDescription, err)
 		}
 
 		c.Locals(string(opts.ClaimsContextKeyName), claims)
+		c.SetUserContext(oidc.WithTokenIDFromClaims(c.UserContext(), claims))
+
+		if opts.SubjectFn != nil {
+			var r http.Request
+			err := fasthttpadaptor.ConvertRequest(c.Context(), &r, true)
+			if err != nil {
+				err = fmt.Errorf("unable to convert request: %w", err)
+				return onError(c, opts.ErrorHandler, fiber.StatusInternalServerError, options.ConvertTokenErrorDescription, err)
+			}
+
+			opts.SubjectFn(&r, oidc.GetSubjectFromClaims(claims))
+		}
+
+		if expiresIn, ok := oidc.GetTokenExpiresInHeaderValue(claims, opts); ok {
+			c.Set(opts.TokenExpiresInHeader, expiresIn)
+		}
 
 		return c.Next()
 	}
 }
+
+// GetTokenID is oidctoken.GetTokenID for `c.UserContext()`.
+func GetTokenID(ctx context.Context) (string, bool) {
+	return oidc.GetTokenID(ctx)
+}
@@ -1,13 +1,17 @@
 package oidcfiber
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/xenitab/go-oidc-middleware/internal/oidc"
 	"github.com/xenitab/go-oidc-middleware/internal/oidctesting"
+	"github.com/xenitab/go-oidc-middleware/optest"
 	"github.com/xenitab/go-oidc-middleware/options"
 
 	"github.com/gofiber/fiber/v2"
@@ -153,3 +157,151 @@ func (f *testFiberHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err = res.Body.Close()
 	require.NoError(f.tb, err)
 }
+
+func TestClaimsContextKeyName(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+	})
+
+	// another middleware using the default key
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("claims", "foo")
+		return c.Next()
+	})
+
+	app.Use(New[oidctesting.TestClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithRequiredTokenType("JWT+AT"),
+		options.WithClaimsContextKeyName("oidc_claims"),
+	))
+
+	app.Get("/", func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("oidc_claims").(oidctesting.TestClaims)
+		if !ok {
+			return c.SendStatus(fiber.StatusUnauthorized)
+		}
+
+		other, ok := c.Locals("claims").(string)
+		if !ok || other != "foo" {
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		return c.JSON(claims)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	op.GetToken(t).SetAuthHeader(req)
+
+	res, err := app.Test(req, -1)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var claims oidctesting.TestClaims
+	err = json.NewDecoder(res.Body).Decode(&claims)
+	require.NoError(t, err)
+	require.Equal(t, "test", claims["sub"])
+}
+
+func TestNewWithClose(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+	})
+
+	middleware, closeFn := NewWithClose[oidctesting.TestClaims](nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithBackgroundRefreshInterval(time.Minute),
+		options.WithTokenCacheTTL(time.Minute),
+	)
+	app.Use(middleware)
+
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		op.GetToken(t).SetAuthHeader(req)
+
+		res, err := app.Test(req, -1)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		// requests are still handled after close
+		require.NoError(t, closeFn())
+	}
+}
+
+func TestGetTokenID(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"test": {
+			Audience:           "test-client",
+			Subject:            "test",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"jti": "foo",
+			},
+		},
+		"without-jti": {
+			Audience:           "test-client",
+			Subject:            "without-jti",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+		},
+	}), optest.WithDefaultTestUser("test"))
+	defer op.Close(t)
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: true,
+	})
+
+	app.Use(New[oidctesting.TestClaims](nil, options.WithIssuer(op.GetURL(t))))
+
+	app.Get("/", func(c *fiber.Ctx) error {
+		tokenID, _ := GetTokenID(c.UserContext())
+		return c.SendString(tokenID)
+	})
+
+	cases := []struct {
+		testDescription string
+		user            string
+		expectedTokenID string
+	}{
+		{
+			testDescription: "token with jti",
+			user:            "test",
+			expectedTokenID: "foo",
+		},
+		{
+			testDescription: "token without jti",
+			user:            "without-jti",
+			expectedTokenID: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		op.GetTokenByUser(t, c.user).SetAuthHeader(req)
+
+		res, err := app.Test(req, -1)
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, c.expectedTokenID, string(body))
+	}
+}
@@ -1,6 +1,8 @@
 package oidcgin
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -20,6 +22,17 @@ func New[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...opt
 	return toGinHandler(oidcHandler.ParseToken, setters...)
 }
 
+// NewWithClose returns the same gin.HandlerFunc as New together with its close function,
+// which behaves like oidctoken.TokenHandler.Close.
+func NewWithClose[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) (gin.HandlerFunc, func() error) {
+	oidcHandler, err := oidc.NewHandler(claimsValidationFn, setters...)
+	if err != nil {
+		panic(fmt.Sprintf("oidc discovery: %v", err))
+	}
+
+	return toGinHandler(oidcHandler.ParseToken, setters...), oidcHandler.Close
+}
+
 func onError(c *gin.Context, errorHandler options.ErrorHandler, statusCode int, description options.ErrorDescription, err error) {
 	if errorHandler != nil {
 		errorHandler(description, err)
@@ -35,20 +48,49 @@ func toGinHandler[T any](parseToken oidc.ParseTokenFunc[T], setters ...options.O
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
 
-		tokenString, err := oidc.GetTokenString(c.Request.Header.Get, opts.TokenString)
+		tokenString, err := oidc.GetTokenStringFromRequest(c.Request, opts)
+		if err != nil {
+			onError(c, opts.ErrorHandler, http.StatusBadRequest, options.GetTokenErrorDescription, err)
+			return
+		}
+
+		err = oidc.ValidateTokenLength(tokenString, opts.MaxTokenLength)
 		if err != nil {
 			onError(c, opts.ErrorHandler, http.StatusBadRequest, options.GetTokenErrorDescription, err)
 			return
 		}
 
+		if opts.RequireAudienceForRequestPath {
+			ctx = oidc.WithRequestPath(ctx, c.Request.URL.Path)
+		}
+
 		claims, err := parseToken(ctx, tokenString)
+		if errors.Is(err, options.ErrJwksUnavailable) {
+			c.Header("Retry-After", oidc.JwksUnavailableRetryAfter)
+			onError(c, opts.ErrorHandler, http.StatusServiceUnavailable, options.ParseTokenErrorDescription, err)
+			return
+		}
 		if err != nil {
 			onError(c, opts.ErrorHandler, http.StatusUnauthorized, options.ParseTokenErrorDescription, err)
 			return
 		}
 
 		c.Set(string(opts.ClaimsContextKeyName), claims)
+		c.Request = c.Request.WithContext(oidc.WithTokenIDFromClaims(c.Request.Context(), claims))
+
+		if opts.SubjectFn != nil {
+			opts.SubjectFn(c.Request, oidc.GetSubjectFromClaims(claims))
+		}
+
+		if expiresIn, ok := oidc.GetTokenExpiresInHeaderValue(claims, opts); ok {
+			c.Header(opts.TokenExpiresInHeader, expiresIn)
+		}
 
 		c.Next()
 	}
 }
+
+// GetTokenID is oidctoken.GetTokenID for `c.Request.Context()`.
+func GetTokenID(ctx context.Context) (string, bool) {
+	return oidc.GetTokenID(ctx)
+}
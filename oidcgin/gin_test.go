@@ -5,12 +5,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/xenitab/go-oidc-middleware/internal/oidc"
 	"github.com/xenitab/go-oidc-middleware/internal/oidctesting"
+	"github.com/xenitab/go-oidc-middleware/optest"
 	"github.com/xenitab/go-oidc-middleware/options"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
 )
 
 const testName = "OidcGin"
@@ -114,3 +117,83 @@ func (h *testHandler) NewTestServer(opts ...options.Option) oidctesting.ServerTe
 	middleware := New[oidctesting.TestClaims](nil, opts...)
 	return newTestServer(h.tb, testGetGinRouter(h.tb, middleware))
 }
+
+func TestNewWithClose(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	middleware, closeFn := NewWithClose[oidctesting.TestClaims](nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithBackgroundRefreshInterval(time.Minute),
+		options.WithTokenCacheTTL(time.Minute),
+	)
+	router := testGetGinRouter(t, middleware)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		op.GetToken(t).SetAuthHeader(req)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+
+		// requests are still handled after close
+		require.NoError(t, closeFn())
+	}
+}
+
+func TestGetTokenID(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"test": {
+			Audience:           "test-client",
+			Subject:            "test",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"jti": "foo",
+			},
+		},
+		"without-jti": {
+			Audience:           "test-client",
+			Subject:            "without-jti",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+		},
+	}), optest.WithDefaultTestUser("test"))
+	defer op.Close(t)
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(New[oidctesting.TestClaims](nil, options.WithIssuer(op.GetURL(t))))
+	router.GET("/", func(c *gin.Context) {
+		tokenID, _ := GetTokenID(c.Request.Context())
+		c.String(http.StatusOK, tokenID)
+	})
+
+	cases := []struct {
+		testDescription string
+		user            string
+		expectedTokenID string
+	}{
+		{
+			testDescription: "token with jti",
+			user:            "test",
+			expectedTokenID: "foo",
+		},
+		{
+			testDescription: "token without jti",
+			user:            "without-jti",
+			expectedTokenID: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		op.GetTokenByUser(t, c.user).SetAuthHeader(req)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+		require.Equal(t, c.expectedTokenID, rec.Body.String())
+	}
+}
@@ -0,0 +1,131 @@
+package oidcgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/xenitab/go-oidc-middleware/internal/oidc"
+	"github.com/xenitab/go-oidc-middleware/options"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor returns an OpenID Connect (OIDC) discovery gRPC unary server interceptor.
+// The token is read from the `authorization` metadata key (configurable using options.WithTokenString)
+// and the claims are added to the context, use ClaimsFromContext to get them.
+// Responds with `codes.Unauthenticated` if the token is missing or invalid and with `codes.Unavailable`
+// if the jwks can't be fetched. GetTokenStringFn, TokenSources and SubjectFn aren't used, since they
+// require a request.
+func UnaryServerInterceptor[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) grpc.UnaryServerInterceptor {
+	oidcHandler, err := oidc.NewHandler(claimsValidationFn, setters...)
+	if err != nil {
+		panic(fmt.Sprintf("oidc discovery: %v", err))
+	}
+
+	return toUnaryServerInterceptor(oidcHandler.ParseToken, setters...)
+}
+
+// StreamServerInterceptor returns an OpenID Connect (OIDC) discovery gRPC stream server interceptor.
+// It validates the token when the stream is created and behaves like UnaryServerInterceptor,
+// the claims are added to the context of the stream.
+func StreamServerInterceptor[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) grpc.StreamServerInterceptor {
+	oidcHandler, err := oidc.NewHandler(claimsValidationFn, setters...)
+	if err != nil {
+		panic(fmt.Sprintf("oidc discovery: %v", err))
+	}
+
+	return toStreamServerInterceptor(oidcHandler.ParseToken, setters...)
+}
+
+func toUnaryServerInterceptor[T any](parseToken oidc.ParseTokenFunc[T], setters ...options.Option) grpc.UnaryServerInterceptor {
+	opts := options.New(setters...)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctxWithClaims, err := authenticate(ctx, info.FullMethod, parseToken, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctxWithClaims, req)
+	}
+}
+
+func toStreamServerInterceptor[T any](parseToken oidc.ParseTokenFunc[T], setters ...options.Option) grpc.StreamServerInterceptor {
+	opts := options.New(setters...)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctxWithClaims, err := authenticate(ss.Context(), info.FullMethod, parseToken, opts)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctxWithClaims})
+	}
+}
+
+// serverStream replaces the context of a grpc.ServerStream with the context containing the claims.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticate validates the token from the incoming metadata and returns a context with the claims,
+// or a status error to return to the client. The description is used as the status message instead
+// of the error to avoid exposing details of the validation.
+func authenticate[T any](ctx context.Context, fullMethod string, parseToken oidc.ParseTokenFunc[T], opts *options.Options) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	tokenString, err := oidc.GetTokenStringFromValues(ctx, md.Get, oidc.GetTokenStringOptions(opts))
+	if err != nil {
+		return nil, onError(opts.ErrorHandler, codes.Unauthenticated, options.GetTokenErrorDescription, err)
+	}
+
+	err = oidc.ValidateTokenLength(tokenString, opts.MaxTokenLength)
+	if err != nil {
+		return nil, onError(opts.ErrorHandler, codes.Unauthenticated, options.GetTokenErrorDescription, err)
+	}
+
+	if opts.RequireAudienceForRequestPath {
+		ctx = oidc.WithRequestPath(ctx, fullMethod)
+	}
+
+	claims, err := parseToken(ctx, tokenString)
+	if errors.Is(err, options.ErrJwksUnavailable) {
+		return nil, onError(opts.ErrorHandler, codes.Unavailable, options.ParseTokenErrorDescription, err)
+	}
+	if err != nil {
+		return nil, onError(opts.ErrorHandler, codes.Unauthenticated, options.ParseTokenErrorDescription, err)
+	}
+
+	ctxWithClaims := context.WithValue(ctx, opts.ClaimsContextKeyName, claims)
+
+	return oidc.WithTokenIDFromClaims(ctxWithClaims, claims), nil
+}
+
+func onError(errorHandler options.ErrorHandler, code codes.Code, description options.ErrorDescription, err error) error {
+	if errorHandler != nil {
+		errorHandler(description, err)
+	}
+
+	return status.Error(code, string(description))
+}
+
+// ClaimsFromContext returns the claims added to the context by the interceptors as T, using the
+// ClaimsContextKeyName passed to the interceptors. If the claims are stored using another type, as an
+// example `map[string]interface{}`, they are converted to T using json. The result is a copy and
+// can be modified. An error is returned if no claims are found or they can't be converted to T.
+func ClaimsFromContext[T any](ctx context.Context, keyName options.ClaimsContextKeyName) (T, error) {
+	return oidc.ClaimsFromContext[T](ctx, keyName)
+}
+
+// GetTokenID is oidctoken.GetTokenID for the context passed to the handler by the interceptors.
+func GetTokenID(ctx context.Context) (string, bool) {
+	return oidc.GetTokenID(ctx)
+}
@@ -0,0 +1,416 @@
+package oidcgrpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/optest"
+	"github.com/xenitab/go-oidc-middleware/options"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type testClaims map[string]interface{}
+
+type testTypedClaims struct {
+	Subject  string   `json:"sub"`
+	Audience []string `json:"aud"`
+}
+
+// testHealthServer records the subject of the claims from the context of the last call.
+type testHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	subject string
+}
+
+func (s *testHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s.subject = testGetSubject(ctx)
+
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (s *testHealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	s.subject = testGetSubject(stream.Context())
+
+	return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+}
+
+func TestServerInterceptors(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	var errorDescriptions []options.ErrorDescription
+	setters := []options.Option{
+		options.WithIssuer(op.GetURL(t)),
+		options.WithErrorHandler(func(description options.ErrorDescription, err error) {
+			errorDescriptions = append(errorDescriptions, description)
+		}),
+	}
+
+	healthServer := &testHealthServer{}
+	client := testNewHealthClient(t, healthServer,
+		grpc.UnaryInterceptor(UnaryServerInterceptor[testClaims](nil, setters...)),
+		grpc.StreamInterceptor(StreamServerInterceptor[testClaims](nil, setters...)),
+	)
+
+	cases := []struct {
+		testDescription          string
+		metadata                 metadata.MD
+		expectedCode             codes.Code
+		expectedErrorDescription options.ErrorDescription
+	}{
+		{
+			testDescription: "valid token",
+			metadata:        metadata.Pairs("authorization", "Bearer "+token.AccessToken),
+			expectedCode:    codes.OK,
+		},
+		{
+			testDescription:          "invalid token",
+			metadata:                 metadata.Pairs("authorization", "Bearer foobar"),
+			expectedCode:             codes.Unauthenticated,
+			expectedErrorDescription: options.ParseTokenErrorDescription,
+		},
+		{
+			testDescription:          "missing token",
+			metadata:                 metadata.MD{},
+			expectedCode:             codes.Unauthenticated,
+			expectedErrorDescription: options.GetTokenErrorDescription,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		ctx := metadata.NewOutgoingContext(context.Background(), c.metadata)
+
+		errorDescriptions = nil
+		healthServer.subject = ""
+
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.Equal(t, c.expectedCode, status.Code(err))
+
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.Equal(t, c.expectedCode, status.Code(err))
+
+		if c.expectedCode != codes.OK {
+			require.Equal(t, string(c.expectedErrorDescription), status.Convert(err).Message())
+			require.Equal(t, []options.ErrorDescription{c.expectedErrorDescription, c.expectedErrorDescription}, errorDescriptions)
+			require.Empty(t, healthServer.subject)
+			continue
+		}
+
+		require.Empty(t, errorDescriptions)
+		require.Equal(t, "test", healthServer.subject)
+	}
+}
+
+func TestServerInterceptorsWithRequireAudienceForRequestPath(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"health": {
+			Audience:           "test-client",
+			Subject:            "health",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"resource": []string{"https://api.example.com", "https://api.example.com/grpc.health.v1.Health"},
+			},
+		},
+		"orders": {
+			Audience:           "test-client",
+			Subject:            "orders",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"resource": []string{"https://api.example.com", "https://api.example.com/orders.v1.Orders"},
+			},
+		},
+	}), optest.WithDefaultTestUser("health"))
+	defer op.Close(t)
+
+	setters := []options.Option{
+		options.WithIssuer(op.GetURL(t)),
+		options.WithAudienceClaimName("resource"),
+		options.WithRequiredAudience("https://api.example.com"),
+		options.WithRequireAudienceForRequestPath(true),
+	}
+
+	client := testNewHealthClient(t, &testHealthServer{},
+		grpc.UnaryInterceptor(UnaryServerInterceptor[testClaims](nil, setters...)),
+		grpc.StreamInterceptor(StreamServerInterceptor[testClaims](nil, setters...)),
+	)
+
+	cases := []struct {
+		testDescription string
+		user            string
+		expectedCode    codes.Code
+	}{
+		{
+			testDescription: "audience scoped to the service",
+			user:            "health",
+			expectedCode:    codes.OK,
+		},
+		{
+			testDescription: "audience scoped to another service",
+			user:            "orders",
+			expectedCode:    codes.Unauthenticated,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		token := op.GetTokenByUser(t, c.user)
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token.AccessToken)
+
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.Equal(t, c.expectedCode, status.Code(err))
+
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.Equal(t, c.expectedCode, status.Code(err))
+	}
+}
+
+func TestServerInterceptorsWithJwksUnavailable(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	testServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableUrl := testServer.URL
+	testServer.Close()
+
+	setters := []options.Option{
+		options.WithIssuer(unreachableUrl),
+		options.WithLazyLoadJwks(true),
+	}
+
+	client := testNewHealthClient(t, &testHealthServer{},
+		grpc.UnaryInterceptor(UnaryServerInterceptor[testClaims](nil, setters...)),
+		grpc.StreamInterceptor(StreamServerInterceptor[testClaims](nil, setters...)),
+	)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token.AccessToken)
+
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestServerInterceptorsWithGrpcGatewayCookie(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	setters := []options.Option{
+		options.WithIssuer(op.GetURL(t)),
+		options.WithTokenString(
+			options.WithTokenStringHeaderName("grpcgateway-cookie"),
+			options.WithTokenStringCookieName("access_token"),
+		),
+	}
+
+	client := testNewHealthClient(t, &testHealthServer{},
+		grpc.UnaryInterceptor(UnaryServerInterceptor[testClaims](nil, setters...)),
+		grpc.StreamInterceptor(StreamServerInterceptor[testClaims](nil, setters...)),
+	)
+
+	cases := []struct {
+		testDescription string
+		cookie          string
+		expectedCode    codes.Code
+	}{
+		{
+			testDescription: "token in cookie",
+			cookie:          "foo=bar; access_token=" + token.AccessToken,
+			expectedCode:    codes.OK,
+		},
+		{
+			testDescription: "without token cookie",
+			cookie:          "foo=bar",
+			expectedCode:    codes.Unauthenticated,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		// grpc-gateway forwards the Cookie header as the grpcgateway-cookie metadata
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "grpcgateway-cookie", c.cookie)
+
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.Equal(t, c.expectedCode, status.Code(err))
+
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.Equal(t, c.expectedCode, status.Code(err))
+	}
+}
+
+func TestClaimsFromContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), options.DefaultClaimsContextKeyName, map[string]interface{}{
+		"sub": "foo",
+		"aud": []interface{}{"bar"},
+	})
+
+	claims, err := ClaimsFromContext[testTypedClaims](ctx, options.DefaultClaimsContextKeyName)
+	require.NoError(t, err)
+	require.Equal(t, testTypedClaims{Subject: "foo", Audience: []string{"bar"}}, claims)
+
+	_, err = ClaimsFromContext[testTypedClaims](ctx, "custom")
+	require.Error(t, err)
+
+	_, err = ClaimsFromContext[testTypedClaims](context.Background(), options.DefaultClaimsContextKeyName)
+	require.Error(t, err)
+
+	ctx = context.WithValue(context.Background(), options.DefaultClaimsContextKeyName, map[string]interface{}{
+		"sub": 1,
+	})
+
+	_, err = ClaimsFromContext[testTypedClaims](ctx, options.DefaultClaimsContextKeyName)
+	require.Error(t, err)
+}
+
+func TestClaimsFromContextWithClaimsContextKeyName(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	keyName := options.ClaimsContextKeyName("custom")
+	interceptor := UnaryServerInterceptor[testClaims](nil, options.WithIssuer(op.GetURL(t)), options.WithClaimsContextKeyName(string(keyName)))
+
+	var claims testTypedClaims
+	var claimsErr error
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		claims, claimsErr = ClaimsFromContext[testTypedClaims](ctx, keyName)
+		return nil, nil
+	}
+
+	token := op.GetToken(t)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token.AccessToken))
+
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+	require.NoError(t, claimsErr)
+	require.Equal(t, "test", claims.Subject)
+}
+
+func TestGetTokenID(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"test": {
+			Audience:           "test-client",
+			Subject:            "test",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"jti": "foo",
+			},
+		},
+		"without-jti": {
+			Audience:           "test-client",
+			Subject:            "without-jti",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+		},
+	}), optest.WithDefaultTestUser("test"))
+	defer op.Close(t)
+
+	interceptor := UnaryServerInterceptor[testClaims](nil, options.WithIssuer(op.GetURL(t)))
+
+	var tokenID string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		tokenID, _ = GetTokenID(ctx)
+		return nil, nil
+	}
+
+	cases := []struct {
+		testDescription string
+		user            string
+		expectedTokenID string
+	}{
+		{
+			testDescription: "token with jti",
+			user:            "test",
+			expectedTokenID: "foo",
+		},
+		{
+			testDescription: "token without jti",
+			user:            "without-jti",
+			expectedTokenID: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		tokenID = ""
+		token := op.GetTokenByUser(t, c.user)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token.AccessToken))
+
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		require.NoError(t, err)
+		require.Equal(t, c.expectedTokenID, tokenID)
+	}
+}
+
+func testGetSubject(ctx context.Context) string {
+	claims, err := ClaimsFromContext[testTypedClaims](ctx, options.DefaultClaimsContextKeyName)
+	if err != nil {
+		return ""
+	}
+
+	return claims.Subject
+}
+
+func testNewHealthClient(t *testing.T, healthServer healthpb.HealthServer, serverOpts ...grpc.ServerOption) healthpb.HealthClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+
+	grpcServer := grpc.NewServer(serverOpts...)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	go func() {
+		err := grpcServer.Serve(listener)
+		if err != nil {
+			t.Logf("grpc server stopped: %v", err)
+		}
+	}()
+
+	conn, err := grpc.DialContext(
+		context.Background(),
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		grpcServer.Stop()
+	})
+
+	return healthpb.NewHealthClient(conn)
+}
@@ -2,8 +2,11 @@ package oidchttp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/xenitab/go-oidc-middleware/internal/oidc"
 	"github.com/xenitab/go-oidc-middleware/options"
@@ -11,6 +14,8 @@ import (
 
 // New returns an OpenID Connect (OIDC) discovery handler (middleware)
 // to be used with `net/http`, `mux` and `chi`.
+// The claims are added to the request context and are shared by all readers of the
+// context, they should be treated as read-only. Use ClaimsFromContext to get a copy.
 func New[T any](h http.Handler, claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) http.Handler {
 	oidcHandler, err := oidc.NewHandler(claimsValidationFn, setters...)
 	if err != nil {
@@ -20,6 +25,17 @@ func New[T any](h http.Handler, claimsValidationFn options.ClaimsValidationFn[T]
 	return toHttpHandler(h, oidcHandler.ParseToken, setters...)
 }
 
+// NewWithClose returns the same handler (middleware) as New together with its close function,
+// which behaves like oidctoken.TokenHandler.Close.
+func NewWithClose[T any](h http.Handler, claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) (http.Handler, func() error) {
+	oidcHandler, err := oidc.NewHandler(claimsValidationFn, setters...)
+	if err != nil {
+		panic(fmt.Sprintf("oidc discovery: %v", err))
+	}
+
+	return toHttpHandler(h, oidcHandler.ParseToken, setters...), oidcHandler.Close
+}
+
 func onError(w http.ResponseWriter, errorHandler options.ErrorHandler, statusCode int, description options.ErrorDescription, err error) {
 	if errorHandler != nil {
 		errorHandler(description, err)
@@ -28,29 +44,324 @@ func onError(w http.ResponseWriter, errorHandler options.ErrorHandler, statusCod
 	w.WriteHeader(statusCode)
 }
 
+// onErrorResponse calls the ErrorHandler and writes the error response, using the ErrorResponseHandler
+// if configured and the status code together with a RFC 6750 `WWW-Authenticate` header otherwise.
+// A token with a stale auth_time gets a challenge to re-authenticate with the required `max_age`.
+// The error is recorded in the request context, see ErrorFromContext.
+func onErrorResponse(w http.ResponseWriter, r *http.Request, opts *options.Options, statusCode int, description options.ErrorDescription, err error) {
+	r = withContextError(r, err)
+
+	if opts.ErrorHandler != nil {
+		opts.ErrorHandler(description, err)
+	}
+
+	if opts.ErrorResponseHandler != nil {
+		opts.ErrorResponseHandler(w, r, err)
+		return
+	}
+
+	switch statusCode {
+	case http.StatusBadRequest:
+		if errors.Is(err, options.ErrTokenNotFound) {
+			w.Header().Set("WWW-Authenticate", getRealmWWWAuthenticateHeader(opts.Realm))
+			break
+		}
+
+		w.Header().Set("WWW-Authenticate", getWWWAuthenticateHeader("invalid_request", description))
+	case http.StatusUnauthorized:
+		if maxAuthAge, ok := oidc.GetMaxAuthAgeFromError(err); ok {
+			w.Header().Set("WWW-Authenticate", getMaxAgeWWWAuthenticateHeader(maxAuthAge))
+			break
+		}
+
+		w.Header().Set("WWW-Authenticate", getWWWAuthenticateHeader("invalid_token", description))
+	case http.StatusServiceUnavailable:
+		w.Header().Set("Retry-After", oidc.JwksUnavailableRetryAfter)
+	}
+
+	w.WriteHeader(statusCode)
+}
+
+type errorContextKey struct{}
+
+type contextError struct {
+	err error
+}
+
+// NewErrorContext returns a context in which the middleware records the error if the request is
+// rejected. Use it in a middleware running before the one returned by New, as an example an access
+// log middleware, and call ErrorFromContext after the request has been handled.
+func NewErrorContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, errorContextKey{}, &contextError{})
+}
+
+// ErrorFromContext returns the error recorded by the middleware if the request was rejected, or nil.
+// The context needs to be created using NewErrorContext, except for the request passed to the
+// ErrorResponseHandler which always contains the error.
+func ErrorFromContext(ctx context.Context) error {
+	ctxErr, ok := ctx.Value(errorContextKey{}).(*contextError)
+	if !ok {
+		return nil
+	}
+
+	return ctxErr.err
+}
+
+// FailureReasonFromContext returns the reason of the error recorded by the middleware, as an
+// example `expired` or `signature`, or an empty string if the request wasn't rejected.
+func FailureReasonFromContext(ctx context.Context) options.ValidationFailureReason {
+	err := ErrorFromContext(ctx)
+	if err == nil {
+		return ""
+	}
+
+	return oidc.GetValidationFailureReason(err)
+}
+
+// withContextError records the error in the context created by NewErrorContext, or in a new
+// context if the request doesn't contain one.
+func withContextError(r *http.Request, err error) *http.Request {
+	ctxErr, ok := r.Context().Value(errorContextKey{}).(*contextError)
+	if ok {
+		ctxErr.err = err
+		return r
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), errorContextKey{}, &contextError{err}))
+}
+
+// getWWWAuthenticateHeader returns the value of the `WWW-Authenticate` header described in RFC 6750.
+// The description is used instead of the error to avoid exposing details of the validation.
+func getWWWAuthenticateHeader(errorCode string, description options.ErrorDescription) string {
+	return fmt.Sprintf("Bearer error=%q, error_description=%q", errorCode, description)
+}
+
+// getRealmWWWAuthenticateHeader returns the `WWW-Authenticate` header for requests without any credentials,
+// which doesn't contain an error code as described in RFC 6750 section 3.1.
+func getRealmWWWAuthenticateHeader(realm string) string {
+	if realm == "" {
+		return "Bearer"
+	}
+
+	return fmt.Sprintf("Bearer realm=%q", realm)
+}
+
+// getMaxAgeWWWAuthenticateHeader challenges the client to re-authenticate the user when the auth_time
+// of the token isn't within the max auth age, with the required `max_age` in seconds as in RFC 9470.
+func getMaxAgeWWWAuthenticateHeader(maxAuthAge time.Duration) string {
+	return fmt.Sprintf("Bearer error=%q, error_description=%q, max_age=\"%d\"", "invalid_token", "auth too old", int64(maxAuthAge/time.Second))
+}
+
 func toHttpHandler[T any](h http.Handler, parseToken oidc.ParseTokenFunc[T], setters ...options.Option) http.Handler {
 	opts := options.New(setters...)
 
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		tokenString, err := oidc.GetTokenString(r.Header.Get, opts.TokenString)
+		tokenString, err := oidc.GetTokenStringFromRequest(r, opts)
+		if err != nil {
+			onErrorResponse(w, r, opts, http.StatusBadRequest, options.GetTokenErrorDescription, err)
+			return
+		}
+
+		err = oidc.ValidateTokenLength(tokenString, opts.MaxTokenLength)
 		if err != nil {
-			onError(w, opts.ErrorHandler, http.StatusBadRequest, options.GetTokenErrorDescription, err)
+			onErrorResponse(w, r, opts, http.StatusBadRequest, options.GetTokenErrorDescription, err)
 			return
 		}
 
+		if opts.AudienceFromTLSServerName {
+			audience, err := oidc.GetTLSServerNameAudience(r)
+			if err != nil {
+				onErrorResponse(w, r, opts, http.StatusUnauthorized, options.ParseTokenErrorDescription, err)
+				return
+			}
+
+			ctx = oidc.WithRequestAudience(ctx, audience)
+		}
+
+		if opts.RequireAudienceForRequestPath {
+			ctx = oidc.WithRequestPath(ctx, r.URL.Path)
+		}
+
 		claims, err := parseToken(ctx, tokenString)
+		if errors.Is(err, options.ErrJwksUnavailable) {
+			onErrorResponse(w, r, opts, http.StatusServiceUnavailable, options.ParseTokenErrorDescription, err)
+			return
+		}
 		if err != nil {
-			onError(w, opts.ErrorHandler, http.StatusUnauthorized, options.ParseTokenErrorDescription, err)
+			onErrorResponse(w, r, opts, http.StatusUnauthorized, options.ParseTokenErrorDescription, err)
 			return
 		}
 
 		ctxWithClaims := context.WithValue(ctx, opts.ClaimsContextKeyName, claims)
+		ctxWithClaims = oidc.WithTokenIDFromClaims(ctxWithClaims, claims)
 		reqWithClaims := r.WithContext(ctxWithClaims)
 
+		if opts.SubjectFn != nil {
+			opts.SubjectFn(reqWithClaims, oidc.GetSubjectFromClaims(claims))
+		}
+
+		if expiresIn, ok := oidc.GetTokenExpiresInHeaderValue(claims, opts); ok {
+			w.Header().Set(opts.TokenExpiresInHeader, expiresIn)
+		}
+
 		h.ServeHTTP(w, reqWithClaims)
 	}
 
 	return http.HandlerFunc(fn)
 }
+
+// AuthRequestHandler returns an OpenID Connect (OIDC) discovery handler to be used as the endpoint
+// of authorization subrequests, like nginx `auth_request` and Envoy `ext_authz`.
+// Responds with 200 and the claims configured with options.WithAuthRequestClaimHeaders as response
+// headers if the token is valid, 401 if it isn't (including when no token can be extracted) and
+// 503 with a Retry-After header if the jwks can't be fetched.
+func AuthRequestHandler[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) http.Handler {
+	oidcHandler, err := oidc.NewHandler(claimsValidationFn, setters...)
+	if err != nil {
+		panic(fmt.Sprintf("oidc discovery: %v", err))
+	}
+
+	return toAuthRequestHandler(oidcHandler.ParseToken, setters...)
+}
+
+func toAuthRequestHandler[T any](parseToken oidc.ParseTokenFunc[T], setters ...options.Option) http.Handler {
+	opts := options.New(setters...)
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		// nginx auth_request only accepts 2xx, 401 and 403, any other status is handled as an error
+		tokenString, err := oidc.GetTokenStringFromRequest(r, opts)
+		if err != nil {
+			onError(w, opts.ErrorHandler, http.StatusUnauthorized, options.GetTokenErrorDescription, err)
+			return
+		}
+
+		err = oidc.ValidateTokenLength(tokenString, opts.MaxTokenLength)
+		if err != nil {
+			onError(w, opts.ErrorHandler, http.StatusUnauthorized, options.GetTokenErrorDescription, err)
+			return
+		}
+
+		if opts.AudienceFromTLSServerName {
+			audience, err := oidc.GetTLSServerNameAudience(r)
+			if err != nil {
+				onError(w, opts.ErrorHandler, http.StatusUnauthorized, options.ParseTokenErrorDescription, err)
+				return
+			}
+
+			ctx = oidc.WithRequestAudience(ctx, audience)
+		}
+
+		if opts.RequireAudienceForRequestPath {
+			path, err := getAuthRequestPath(r)
+			if err != nil {
+				onError(w, opts.ErrorHandler, http.StatusUnauthorized, options.ParseTokenErrorDescription, err)
+				return
+			}
+
+			ctx = oidc.WithRequestPath(ctx, path)
+		}
+
+		claims, err := parseToken(ctx, tokenString)
+		if errors.Is(err, options.ErrJwksUnavailable) {
+			w.Header().Set("Retry-After", oidc.JwksUnavailableRetryAfter)
+			onError(w, opts.ErrorHandler, http.StatusServiceUnavailable, options.ParseTokenErrorDescription, err)
+			return
+		}
+		if err != nil {
+			onError(w, opts.ErrorHandler, http.StatusUnauthorized, options.ParseTokenErrorDescription, err)
+			return
+		}
+
+		if opts.SubjectFn != nil {
+			opts.SubjectFn(r, oidc.GetSubjectFromClaims(claims))
+		}
+
+		headers, err := oidc.GetClaimHeaders(claims, opts.AuthRequestClaimHeaders)
+		if err != nil {
+			onError(w, opts.ErrorHandler, http.StatusInternalServerError, options.ConvertTokenErrorDescription, err)
+			return
+		}
+
+		for headerName, headerValue := range headers {
+			w.Header().Set(headerName, headerValue)
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// getAuthRequestPath returns the path of the original request of an authorization subrequest, as
+// forwarded by nginx in the X-Original-URI header, or the path of the subrequest if it isn't set.
+func getAuthRequestPath(r *http.Request) (string, error) {
+	originalUri := r.Header.Get("X-Original-URI")
+	if originalUri == "" {
+		return r.URL.Path, nil
+	}
+
+	u, err := url.ParseRequestURI(originalUri)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse X-Original-URI: %w", err)
+	}
+
+	return u.Path, nil
+}
+
+// ClaimsFromContext returns a deep copy of the claims added to the context by the middleware as T,
+// using the ClaimsContextKeyName passed to the middleware. If the claims are stored using another
+// type, as an example `map[string]interface{}`, they are converted to T using json, which makes it
+// possible to get the claims as a struct with json tags. The copy can be modified without affecting
+// other readers of the context, which makes it safe to use by concurrent readers, as an example when
+// fanning out a request to multiple backends. An error is returned if no claims are found or they
+// can't be converted to T.
+func ClaimsFromContext[T any](ctx context.Context, keyName options.ClaimsContextKeyName) (T, error) {
+	return oidc.ClaimsFromContext[T](ctx, keyName)
+}
+
+// ClaimsFromRequest is ClaimsFromContext using the request context.
+func ClaimsFromRequest[T any](r *http.Request, keyName options.ClaimsContextKeyName) (T, error) {
+	return ClaimsFromContext[T](r.Context(), keyName)
+}
+
+// RequireScopes returns a middleware that requires the token to contain all the scopes.
+// It needs to run after the middleware returned by New, since it uses the already validated
+// claims from the request context, using keyName which needs to be the ClaimsContextKeyName
+// passed to New. Scopes are read from the `scope` and `scp` claims.
+// Responds with 401 if no claims can be found and 403 if scopes are missing.
+func RequireScopes(keyName options.ClaimsContextKeyName, scopes ...string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			claims := r.Context().Value(keyName)
+			if claims == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			tokenScopes, err := oidc.GetScopesFromClaims(claims)
+			if err != nil {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			missingScopes := oidc.GetMissingScopes(scopes, tokenScopes)
+			if len(missingScopes) > 0 {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// GetTokenID is oidctoken.GetTokenID for the request context of a handler behind the middleware.
+func GetTokenID(ctx context.Context) (string, bool) {
+	return oidc.GetTokenID(ctx)
+}
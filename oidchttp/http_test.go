@@ -1,13 +1,21 @@
 package oidchttp
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/require"
 	"github.com/xenitab/go-oidc-middleware/internal/oidc"
 	"github.com/xenitab/go-oidc-middleware/internal/oidctesting"
+	"github.com/xenitab/go-oidc-middleware/optest"
 	"github.com/xenitab/go-oidc-middleware/options"
 )
 
@@ -21,6 +29,425 @@ func BenchmarkSuite(b *testing.B) {
 	oidctesting.RunBenchmarks(b, testName, newTestHttpHandler(b))
 }
 
+func TestRequireScopes(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"test": {
+			Audience:           "test-client",
+			Subject:            "test",
+			AccessTokenKeyType: "JWT+AT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"scope": "read write",
+			},
+		},
+	}))
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	cases := []struct {
+		testDescription    string
+		requiredScopes     []string
+		withToken          bool
+		expectedStatusCode int
+	}{
+		{
+			testDescription:    "token has required scope",
+			requiredScopes:     []string{"read"},
+			withToken:          true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			testDescription:    "token has all required scopes",
+			requiredScopes:     []string{"read", "write"},
+			withToken:          true,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			testDescription:    "token lacks required scope",
+			requiredScopes:     []string{"admin"},
+			withToken:          true,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			testDescription:    "token lacks one of the required scopes",
+			requiredScopes:     []string{"read", "admin"},
+			withToken:          true,
+			expectedStatusCode: http.StatusForbidden,
+		},
+		{
+			testDescription:    "without token",
+			requiredScopes:     []string{"read"},
+			withToken:          false,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		scopeHandler := RequireScopes(options.DefaultClaimsContextKeyName, c.requiredScopes...)(testGetHttpHandler(t))
+		handler := New[oidctesting.TestClaims](scopeHandler, nil, options.WithIssuer(op.GetURL(t)))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.withToken {
+			token.SetAuthHeader(req)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, c.expectedStatusCode, rec.Result().StatusCode)
+	}
+
+	// without the main middleware, no claims can be found
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	token.SetAuthHeader(req)
+	rec := httptest.NewRecorder()
+	RequireScopes(options.DefaultClaimsContextKeyName, "read")(testGetHttpHandler(t)).ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Result().StatusCode)
+
+	// the claims are read using the ClaimsContextKeyName passed to the main middleware
+	keyName := options.ClaimsContextKeyName("custom")
+	handler := New[oidctesting.TestClaims](
+		RequireScopes(keyName, "read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithClaimsContextKeyName(string(keyName)),
+	)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	token.SetAuthHeader(req)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+}
+
+func TestClaimsFromContext(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	var wg sync.WaitGroup
+	fanOutHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				claims, err := ClaimsFromContext[oidctesting.TestClaims](r.Context(), options.DefaultClaimsContextKeyName)
+				require.NoError(t, err)
+				require.Equal(t, "test", claims["sub"])
+
+				claims["sub"] = fmt.Sprintf("backend-%d", i)
+				require.Equal(t, fmt.Sprintf("backend-%d", i), claims["sub"])
+			}(i)
+		}
+
+		wg.Wait()
+
+		claims, ok := r.Context().Value(options.DefaultClaimsContextKeyName).(oidctesting.TestClaims)
+		require.True(t, ok)
+		require.Equal(t, "test", claims["sub"])
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := New[oidctesting.TestClaims](fanOutHandler, nil, options.WithIssuer(op.GetURL(t)))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	token.SetAuthHeader(req)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+
+	_, err := ClaimsFromContext[oidctesting.TestClaims](context.Background(), options.DefaultClaimsContextKeyName)
+	require.ErrorContains(t, err, "claims not found in context using key \"claims\"")
+}
+
+func TestClaimsFromRequest(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"test": {
+			Audience:           "test-client",
+			Subject:            "test",
+			AccessTokenKeyType: "JWT+AT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"resource_access": map[string]interface{}{
+					"my-api": map[string]interface{}{
+						"roles": []string{"read", "write"},
+					},
+				},
+			},
+		},
+	}))
+	defer op.Close(t)
+
+	type customClaims struct {
+		Subject        string `json:"sub"`
+		ResourceAccess map[string]struct {
+			Roles []string `json:"roles"`
+		} `json:"resource_access"`
+	}
+
+	type invalidClaims struct {
+		Subject int `json:"sub"`
+	}
+
+	token := op.GetToken(t)
+
+	var typedClaims customClaims
+	var typedErr, invalidErr error
+	claimsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		typedClaims, typedErr = ClaimsFromRequest[customClaims](r, options.DefaultClaimsContextKeyName)
+		_, invalidErr = ClaimsFromRequest[invalidClaims](r, options.DefaultClaimsContextKeyName)
+
+		// the untyped claims are still stored as configured
+		claims, err := ClaimsFromRequest[oidctesting.TestClaims](r, options.DefaultClaimsContextKeyName)
+		require.NoError(t, err)
+		require.Equal(t, "test", claims["sub"])
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := New[oidctesting.TestClaims](claimsHandler, nil, options.WithIssuer(op.GetURL(t)))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	token.SetAuthHeader(req)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+
+	require.NoError(t, typedErr)
+	require.Equal(t, "test", typedClaims.Subject)
+	require.Equal(t, []string{"read", "write"}, typedClaims.ResourceAccess["my-api"].Roles)
+	require.Error(t, invalidErr)
+
+	_, err := ClaimsFromRequest[customClaims](httptest.NewRequest(http.MethodGet, "/", nil), options.DefaultClaimsContextKeyName)
+	require.Error(t, err)
+}
+
+func TestNewErrorResponse(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	testServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableUrl := testServer.URL
+	testServer.Close()
+
+	var responseErrors []error
+	customErrorResponseHandler := func(w http.ResponseWriter, r *http.Request, err error) {
+		responseErrors = append(responseErrors, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTeapot)
+		_, err = w.Write([]byte(`{"error":"custom"}`))
+		require.NoError(t, err)
+	}
+
+	cases := []struct {
+		testDescription         string
+		options                 []options.Option
+		authHeader              string
+		expectedStatusCode      int
+		expectedWWWAuthenticate string
+		expectedRetryAfter      string
+		expectedResponseErrors  int
+	}{
+		{
+			testDescription:         "missing token",
+			authHeader:              "",
+			expectedStatusCode:      http.StatusBadRequest,
+			expectedWWWAuthenticate: `Bearer`,
+		},
+		{
+			testDescription: "missing token with realm",
+			options: []options.Option{
+				options.WithRealm("api"),
+			},
+			authHeader:              "",
+			expectedStatusCode:      http.StatusBadRequest,
+			expectedWWWAuthenticate: `Bearer realm="api"`,
+		},
+		{
+			testDescription: "malformed request",
+			options: []options.Option{
+				options.WithRealm("api"),
+			},
+			authHeader:              "Bearer ",
+			expectedStatusCode:      http.StatusBadRequest,
+			expectedWWWAuthenticate: `Bearer error="invalid_request", error_description="unable to get token string"`,
+		},
+		{
+			testDescription:         "invalid token",
+			authHeader:              "Bearer foobar",
+			expectedStatusCode:      http.StatusUnauthorized,
+			expectedWWWAuthenticate: `Bearer error="invalid_token", error_description="unable to parse token string"`,
+		},
+		{
+			testDescription: "jwks unavailable",
+			options: []options.Option{
+				options.WithIssuer(unreachableUrl),
+				options.WithLazyLoadJwks(true),
+			},
+			authHeader:         "Bearer foobar",
+			expectedStatusCode: http.StatusServiceUnavailable,
+			expectedRetryAfter: oidc.JwksUnavailableRetryAfter,
+		},
+		{
+			testDescription: "custom handler with missing token",
+			options: []options.Option{
+				options.WithErrorResponseHandler(customErrorResponseHandler),
+			},
+			authHeader:             "",
+			expectedStatusCode:     http.StatusTeapot,
+			expectedResponseErrors: 1,
+		},
+		{
+			testDescription: "custom handler with invalid token",
+			options: []options.Option{
+				options.WithErrorResponseHandler(customErrorResponseHandler),
+			},
+			authHeader:             "Bearer foobar",
+			expectedStatusCode:     http.StatusTeapot,
+			expectedResponseErrors: 1,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		responseErrors = nil
+		var errorDescriptions []options.ErrorDescription
+
+		opts := []options.Option{
+			options.WithIssuer(op.GetURL(t)),
+			options.WithErrorHandler(func(description options.ErrorDescription, err error) {
+				errorDescriptions = append(errorDescriptions, description)
+			}),
+		}
+
+		handler := New[oidctesting.TestClaims](testGetHttpHandler(t), nil, append(opts, c.options...)...)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.authHeader != "" {
+			req.Header.Set("Authorization", c.authHeader)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		res := rec.Result()
+		require.Equal(t, c.expectedStatusCode, res.StatusCode)
+		require.Equal(t, c.expectedWWWAuthenticate, res.Header.Get("WWW-Authenticate"))
+		require.Equal(t, c.expectedRetryAfter, res.Header.Get("Retry-After"))
+		require.Len(t, responseErrors, c.expectedResponseErrors)
+		require.Len(t, errorDescriptions, 1)
+
+		if c.expectedResponseErrors > 0 {
+			require.Error(t, responseErrors[0])
+			require.Equal(t, `{"error":"custom"}`, rec.Body.String())
+		}
+	}
+}
+
+func TestErrorFromContext(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+	tokenParts := strings.Split(token.AccessToken, ".")
+	tamperedPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"foo","sub":"bar"}`))
+
+	cases := []struct {
+		testDescription    string
+		options            []options.Option
+		authHeader         string
+		expectedStatusCode int
+		expectedReason     options.ValidationFailureReason
+	}{
+		{
+			testDescription:    "valid token",
+			authHeader:         "Bearer " + token.AccessToken,
+			expectedStatusCode: http.StatusOK,
+			expectedReason:     "",
+		},
+		{
+			testDescription:    "missing token",
+			authHeader:         "",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedReason:     options.OtherValidationFailureReason,
+		},
+		{
+			testDescription:    "invalid signature",
+			authHeader:         fmt.Sprintf("Bearer %s.%s.%s", tokenParts[0], tamperedPayload, tokenParts[2]),
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedReason:     options.SignatureValidationFailureReason,
+		},
+		{
+			testDescription: "wrong audience",
+			options: []options.Option{
+				options.WithRequiredAudience("foo"),
+			},
+			authHeader:         "Bearer " + token.AccessToken,
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedReason:     options.AudienceValidationFailureReason,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		handler := New[oidctesting.TestClaims](testGetHttpHandler(t), nil, append([]options.Option{options.WithIssuer(op.GetURL(t))}, c.options...)...)
+
+		var recordedErr error
+		var recordedReason options.ValidationFailureReason
+		accessLogHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := NewErrorContext(r.Context())
+			handler.ServeHTTP(w, r.WithContext(ctx))
+
+			recordedErr = ErrorFromContext(ctx)
+			recordedReason = FailureReasonFromContext(ctx)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.authHeader != "" {
+			req.Header.Set("Authorization", c.authHeader)
+		}
+
+		rec := httptest.NewRecorder()
+		accessLogHandler.ServeHTTP(rec, req)
+
+		require.Equal(t, c.expectedStatusCode, rec.Result().StatusCode)
+		require.Equal(t, c.expectedReason, recordedReason)
+
+		if c.expectedReason == "" {
+			require.NoError(t, recordedErr)
+			continue
+		}
+
+		require.Error(t, recordedErr)
+	}
+
+	// the request passed to the ErrorResponseHandler always contains the error
+	var responseErr error
+	handler := New[oidctesting.TestClaims](testGetHttpHandler(t), nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithErrorResponseHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			responseErr = ErrorFromContext(r.Context())
+			w.WriteHeader(http.StatusUnauthorized)
+		}),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer foobar")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.ErrorContains(t, responseErr, "unable to parse tokenString")
+
+	require.NoError(t, ErrorFromContext(context.Background()))
+	require.Equal(t, options.ValidationFailureReason(""), FailureReasonFromContext(context.Background()))
+}
+
 func testGetHttpHandler(tb testing.TB) http.Handler {
 	tb.Helper()
 
@@ -106,3 +533,472 @@ func (h *testHttpHandler) NewTestServer(opts ...options.Option) oidctesting.Serv
 	handler := testGetHttpHandler(h.tb)
 	return newTestServer(h.tb, New[oidctesting.TestClaims](handler, nil, opts...))
 }
+
+func TestAuthRequestHandler(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	authServer := httptest.NewServer(AuthRequestHandler[oidctesting.TestClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithAuthRequestClaimHeaders(map[string]string{
+			"sub":     "X-Auth-Subject",
+			"aud":     "X-Auth-Audience",
+			"missing": "X-Auth-Missing",
+		}),
+	))
+	defer authServer.Close()
+
+	// emulates nginx auth_request and Envoy ext_authz: the original headers are forwarded to the
+	// auth server without the body, the request is only passed to the upstream if it responds with
+	// 200 and the headers from its response are added to the upstream request
+	upstreamHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Subject", r.Header.Get("X-Auth-Subject"))
+		w.Header().Set("X-Upstream-Audience", r.Header.Get("X-Auth-Audience"))
+		w.WriteHeader(http.StatusOK)
+	})
+
+	proxyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authReq, err := http.NewRequestWithContext(r.Context(), r.Method, authServer.URL+r.URL.Path, nil)
+		require.NoError(t, err)
+		authReq.Header = r.Header.Clone()
+
+		authRes, err := http.DefaultClient.Do(authReq)
+		require.NoError(t, err)
+		defer authRes.Body.Close()
+
+		if authRes.StatusCode != http.StatusOK {
+			w.WriteHeader(authRes.StatusCode)
+			return
+		}
+
+		for _, headerName := range []string{"X-Auth-Subject", "X-Auth-Audience", "X-Auth-Missing"} {
+			r.Header.Del(headerName)
+			if headerValue := authRes.Header.Get(headerName); headerValue != "" {
+				r.Header.Set(headerName, headerValue)
+			}
+		}
+
+		upstreamHandler.ServeHTTP(w, r)
+	})
+
+	cases := []struct {
+		testDescription    string
+		method             string
+		authHeader         string
+		expectedStatusCode int
+		expectedSubject    string
+		expectedAudience   string
+	}{
+		{
+			testDescription:    "nginx subrequest with valid token",
+			method:             http.MethodGet,
+			authHeader:         "Bearer " + token.AccessToken,
+			expectedStatusCode: http.StatusOK,
+			expectedSubject:    "test",
+			expectedAudience:   "test-client",
+		},
+		{
+			testDescription:    "envoy request with valid token and original method",
+			method:             http.MethodPost,
+			authHeader:         "Bearer " + token.AccessToken,
+			expectedStatusCode: http.StatusOK,
+			expectedSubject:    "test",
+			expectedAudience:   "test-client",
+		},
+		{
+			testDescription:    "invalid token",
+			method:             http.MethodGet,
+			authHeader:         "Bearer foobar",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			testDescription:    "missing token",
+			method:             http.MethodGet,
+			authHeader:         "",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		req := httptest.NewRequest(c.method, "/api/foo", nil)
+		if c.authHeader != "" {
+			req.Header.Set("Authorization", c.authHeader)
+		}
+
+		// headers sent by the client are never trusted by the upstream
+		req.Header.Set("X-Auth-Subject", "spoofed")
+
+		rec := httptest.NewRecorder()
+		proxyHandler.ServeHTTP(rec, req)
+
+		res := rec.Result()
+		require.Equal(t, c.expectedStatusCode, res.StatusCode)
+		require.Equal(t, c.expectedSubject, res.Header.Get("X-Upstream-Subject"))
+		require.Equal(t, c.expectedAudience, res.Header.Get("X-Upstream-Audience"))
+	}
+
+	// the jwks being unavailable isn't reported as an invalid token
+	unavailableServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableUrl := unavailableServer.URL
+	unavailableServer.Close()
+
+	handler := AuthRequestHandler[oidctesting.TestClaims](
+		nil,
+		options.WithIssuer(unreachableUrl),
+		options.WithLazyLoadJwks(true),
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	token.SetAuthHeader(req)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Result().StatusCode)
+	require.Equal(t, oidc.JwksUnavailableRetryAfter, rec.Result().Header.Get("Retry-After"))
+}
+
+func TestAuthRequestHandlerWithRequireAudienceForRequestPath(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"test": {
+			Audience:           "test-client",
+			Subject:            "test",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"resource": []string{"https://api.example.com", "https://api.example.com/orders"},
+			},
+		},
+	}), optest.WithDefaultTestUser("test"))
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	handler := AuthRequestHandler[oidctesting.TestClaims](
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithAudienceClaimName("resource"),
+		options.WithRequiredAudience("https://api.example.com"),
+		options.WithRequireAudienceForRequestPath(true),
+	)
+
+	cases := []struct {
+		testDescription    string
+		path               string
+		originalUri        string
+		expectedStatusCode int
+	}{
+		{
+			testDescription:    "original uri in the scope of the audience",
+			path:               "/auth",
+			originalUri:        "/orders/123?foo=bar",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			testDescription:    "original uri outside the scope of the audience",
+			path:               "/auth",
+			originalUri:        "/invoices/123",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			testDescription:    "invalid original uri",
+			path:               "/orders/123",
+			originalUri:        "orders",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			testDescription:    "path in the scope of the audience without original uri",
+			path:               "/orders/123",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			testDescription:    "path outside the scope of the audience without original uri",
+			path:               "/auth",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		token.SetAuthHeader(req)
+		if c.originalUri != "" {
+			req.Header.Set("X-Original-URI", c.originalUri)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, c.expectedStatusCode, rec.Result().StatusCode)
+	}
+}
+
+func TestAudienceFromTLSServerName(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"tenant-a": {
+			Audience:           "a.example.com",
+			Subject:            "tenant-a",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+		},
+		"tenant-b": {
+			Audience:           "b.example.com",
+			Subject:            "tenant-b",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+		},
+	}), optest.WithDefaultTestUser("tenant-a"))
+	defer op.Close(t)
+
+	tokenA := op.GetTokenByUser(t, "tenant-a")
+	tokenB := op.GetTokenByUser(t, "tenant-b")
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// the decision cache is shared between the server names, making sure decisions aren't reused across them
+	oidcHandler := New[oidctesting.TestClaims](h,
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithRequiredAudience("a.example.com"),
+		options.WithAudienceFromTLSServerName(true),
+		options.WithDecisionCache(options.NewMemoryDecisionCache()),
+	)
+
+	tlsServer := httptest.NewTLSServer(oidcHandler)
+	defer tlsServer.Close()
+
+	cases := []struct {
+		testDescription    string
+		serverName         string
+		host               string
+		token              *optest.TokenResponse
+		expectedStatusCode int
+	}{
+		{
+			testDescription:    "token for tenant a using server name a",
+			serverName:         "a.example.com",
+			token:              tokenA,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			testDescription:    "token for tenant a using server name b",
+			serverName:         "b.example.com",
+			token:              tokenA,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			testDescription:    "token for tenant b using server name b",
+			serverName:         "b.example.com",
+			token:              tokenB,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			testDescription:    "token for tenant a using server name b and host a",
+			serverName:         "b.example.com",
+			host:               "a.example.com",
+			token:              tokenA,
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			testDescription:    "token for tenant b using server name b and host a",
+			serverName:         "b.example.com",
+			host:               "a.example.com",
+			token:              tokenB,
+			expectedStatusCode: http.StatusOK,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		transport := tlsServer.Client().Transport.(*http.Transport).Clone()
+		// the test certificate isn't valid for the server names
+		transport.TLSClientConfig.InsecureSkipVerify = true
+		transport.TLSClientConfig.ServerName = c.serverName
+		client := &http.Client{Transport: transport}
+
+		req, err := http.NewRequest(http.MethodGet, tlsServer.URL, nil)
+		require.NoError(t, err)
+		if c.host != "" {
+			req.Host = c.host
+		}
+		c.token.SetAuthHeader(req)
+
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+
+		require.Equal(t, c.expectedStatusCode, res.StatusCode)
+	}
+
+	// requests without TLS are rejected
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	tokenA.SetAuthHeader(req)
+
+	rec := httptest.NewRecorder()
+	oidcHandler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Result().StatusCode)
+}
+
+func TestMaxAuthAgeChallenge(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"fresh": {
+			Audience:           "test-client",
+			Subject:            "fresh",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"auth_time": time.Now().Add(-1 * time.Minute).Unix(),
+			},
+		},
+		"stale": {
+			Audience:           "test-client",
+			Subject:            "stale",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"auth_time": time.Now().Add(-1 * time.Hour).Unix(),
+			},
+		},
+		"missing": {
+			Audience:           "test-client",
+			Subject:            "missing",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+		},
+	}), optest.WithDefaultTestUser("fresh"))
+	defer op.Close(t)
+
+	handler := New[oidctesting.TestClaims](testGetHttpHandler(t),
+		nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithMaxAuthAge(5*time.Minute),
+	)
+
+	cases := []struct {
+		testDescription         string
+		user                    string
+		expectedStatusCode      int
+		expectedWWWAuthenticate string
+	}{
+		{
+			testDescription:    "fresh auth_time",
+			user:               "fresh",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			testDescription:         "stale auth_time",
+			user:                    "stale",
+			expectedStatusCode:      http.StatusUnauthorized,
+			expectedWWWAuthenticate: `Bearer error="invalid_token", error_description="auth too old", max_age="300"`,
+		},
+		{
+			testDescription:         "missing auth_time",
+			user:                    "missing",
+			expectedStatusCode:      http.StatusUnauthorized,
+			expectedWWWAuthenticate: `Bearer error="invalid_token", error_description="unable to parse token string"`,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		token := op.GetTokenByUser(t, c.user)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		res := rec.Result()
+		require.Equal(t, c.expectedStatusCode, res.StatusCode)
+		require.Equal(t, c.expectedWWWAuthenticate, res.Header.Get("WWW-Authenticate"))
+	}
+}
+
+func TestNewWithClose(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	handler, closeFn := NewWithClose[oidctesting.TestClaims](http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), nil,
+		options.WithIssuer(op.GetURL(t)),
+		options.WithBackgroundRefreshInterval(time.Minute),
+		options.WithTokenCacheTTL(time.Minute),
+	)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		op.GetToken(t).SetAuthHeader(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+
+		// requests are still handled after close
+		require.NoError(t, closeFn())
+	}
+}
+
+func TestGetTokenID(t *testing.T) {
+	op := optest.NewTesting(t, optest.WithTestUsers(map[string]optest.TestUser{
+		"test": {
+			Audience:           "test-client",
+			Subject:            "test",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+			ExtraAccessTokenClaims: map[string]interface{}{
+				"jti": "foo",
+			},
+		},
+		"without-jti": {
+			Audience:           "test-client",
+			Subject:            "without-jti",
+			AccessTokenKeyType: "JWT",
+			IdTokenKeyType:     "JWT",
+		},
+	}), optest.WithDefaultTestUser("test"))
+	defer op.Close(t)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenID, _ := GetTokenID(r.Context())
+		_, err := w.Write([]byte(tokenID))
+		require.NoError(t, err)
+	})
+
+	handler := New[oidctesting.TestClaims](h, nil, options.WithIssuer(op.GetURL(t)))
+
+	cases := []struct {
+		testDescription string
+		user            string
+		expectedTokenID string
+	}{
+		{
+			testDescription: "token with jti",
+			user:            "test",
+			expectedTokenID: "foo",
+		},
+		{
+			testDescription: "token without jti",
+			user:            "without-jti",
+			expectedTokenID: "",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		op.GetTokenByUser(t, c.user).SetAuthHeader(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Result().StatusCode)
+		require.Equal(t, c.expectedTokenID, rec.Body.String())
+	}
+}
@@ -2,17 +2,40 @@ package oidctoken
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 
 	"github.com/xenitab/go-oidc-middleware/internal/oidc"
 	"github.com/xenitab/go-oidc-middleware/options"
 )
 
+// Diagnostics contains the outcome of each step run by Validate.
+type Diagnostics = oidc.Diagnostics
+
+// DiagnosticsStep contains the outcome of a single step run by Validate.
+type DiagnosticsStep = oidc.DiagnosticsStep
+
+// Config contains the effective configuration used by the TokenHandler.
+type Config = oidc.Config
+
 // TokenHandler is used to parse tokens.
 type TokenHandler[T any] struct {
 	parseTokenFunc oidc.ParseTokenFunc[T]
+	validateFunc   func(ctx context.Context, sampleToken string) (*Diagnostics, error)
+	reloadFunc     func(ctx context.Context) error
+	configFunc     func() Config
+	closeFunc      func() error
+	policySetter   policySetter[T]
 	tokenOptions   *options.Options
 }
 
+type policySetter[T any] interface {
+	SetRequiredAudience(requiredAudience string)
+	SetRequiredScopes(requiredScopes []string)
+	SetClaimsValidationFn(claimsValidationFn options.ClaimsValidationFn[T])
+	SetPolicyID(policyID string)
+}
+
 // New returns an OpenID Connect (OIDC) discovery token handler.
 // Can be used to create your own middleware.
 func New[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) (*TokenHandler[T], error) {
@@ -25,10 +48,37 @@ func New[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...opt
 
 	return &TokenHandler[T]{
 		parseTokenFunc: oidcHandler.ParseToken,
+		validateFunc:   oidcHandler.Validate,
+		reloadFunc:     oidcHandler.Reload,
+		configFunc:     oidcHandler.Config,
+		closeFunc:      oidcHandler.Close,
+		policySetter:   oidcHandler,
 		tokenOptions:   tokenOpts,
 	}, nil
 }
 
+// ParseTokenFunc takes a context and a string and returns the validated claims or an error.
+type ParseTokenFunc[T any] func(ctx context.Context, tokenString string) (T, error)
+
+// NewParseTokenFunc returns an OpenID Connect (OIDC) discovery ParseTokenFunc, running the same
+// validation as the middlewares (signature, issuer, audience, scopes, expiration and claims).
+// Can be used to validate tokens that aren't received over HTTP, as an example from a message queue.
+// The handler can't be closed, BackgroundRefreshInterval is therefore rejected since its goroutine
+// would never be stopped. Use New and Close if the jwks needs to be refreshed in the background.
+func NewParseTokenFunc[T any](claimsValidationFn options.ClaimsValidationFn[T], setters ...options.Option) (ParseTokenFunc[T], error) {
+	opts := options.New(setters...)
+	if opts.BackgroundRefreshInterval > 0 {
+		return nil, fmt.Errorf("BackgroundRefreshInterval can't be used together with NewParseTokenFunc, use New and Close instead")
+	}
+
+	tokenHandler, err := New(claimsValidationFn, setters...)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenHandler.ParseToken, nil
+}
+
 // ParseToken takes a context and a string and returns a jwt.Token or an error.
 // jwt.Token is from `github.com/lestrrat-go/jwx/jwt`.
 func (t *TokenHandler[T]) ParseToken(ctx context.Context, tokenString string) (T, error) {
@@ -40,8 +90,124 @@ func (t *TokenHandler[T]) ParseToken(ctx context.Context, tokenString string) (T
 	return claims, nil
 }
 
+// Validate verifies the configuration end-to-end and can be used as a warmup call before serving traffic.
+// The options are validated the same way as by New, then the issuer discovery, the jwks download
+// (skipped when IntrospectionUri is used), the same steps for each of the Issuers and (if sampleToken
+// isn't empty) the validation of the sample token are run and the outcome of each step is returned
+// as Diagnostics.
+func (t *TokenHandler[T]) Validate(ctx context.Context, sampleToken string) (*Diagnostics, error) {
+	return t.validateFunc(ctx, sampleToken)
+}
+
+// Reload re-runs the discovery and downloads the jwks, then atomically replaces the keys used to
+// validate tokens. Can be used to force a full reload at runtime, as an example on SIGHUP.
+// If an error is returned, the old keys are kept.
+func (t *TokenHandler[T]) Reload(ctx context.Context) error {
+	return t.reloadFunc(ctx)
+}
+
+// Config returns a copy of the effective configuration, after defaults have been
+// applied and the discovery has been resolved. Can be used for debugging.
+func (t *TokenHandler[T]) Config() Config {
+	return t.configFunc()
+}
+
+// Close stops the background refresh of the jwks started by BackgroundRefreshInterval and
+// empties the token cache. Can be used when handlers are created dynamically, as an example per
+// tenant, to avoid leaking goroutines. Tokens can still be parsed after Close, the jwks is then
+// only downloaded on demand. It's safe to call Close more than once. The close functions returned
+// by NewWithClose in the middleware packages behave the same way.
+func (t *TokenHandler[T]) Close() error {
+	return t.closeFunc()
+}
+
+// SetRequiredAudience replaces the required audience at runtime, without reloading the keys.
+// Tokens parsed after it returns use the new required audience.
+func (t *TokenHandler[T]) SetRequiredAudience(requiredAudience string) {
+	t.policySetter.SetRequiredAudience(requiredAudience)
+}
+
+// SetRequiredScopes replaces the required scopes at runtime, without reloading the keys.
+// Tokens parsed after it returns use the new required scopes.
+func (t *TokenHandler[T]) SetRequiredScopes(requiredScopes []string) {
+	t.policySetter.SetRequiredScopes(requiredScopes)
+}
+
+// SetRequiredClaims replaces the function validating the required claims at runtime, without
+// reloading the keys. Tokens parsed after it returns use the new function.
+func (t *TokenHandler[T]) SetRequiredClaims(claimsValidationFn options.ClaimsValidationFn[T]) {
+	t.policySetter.SetClaimsValidationFn(claimsValidationFn)
+}
+
+// SetPolicyID replaces the policy id used by the DecisionCache. Decisions made using the previous
+// policy aren't reused after the other setters, even if the policy id is unchanged.
+func (t *TokenHandler[T]) SetPolicyID(policyID string) {
+	t.policySetter.SetPolicyID(policyID)
+}
+
+// CopyClaims returns a deep copy of the claims. Claims shared with other readers, as an example
+// using a request context, should be treated as read-only and copied before being modified.
+func CopyClaims[T any](claims T) (T, error) {
+	return oidc.CopyClaims(claims)
+}
+
+// GetSubjectFromClaims returns the `sub` claim, or an empty string if the claims don't contain
+// a string `sub` claim. Can be used to call options.SubjectFn from your own middleware.
+func GetSubjectFromClaims[T any](claims T) string {
+	return oidc.GetSubjectFromClaims(claims)
+}
+
+// WithRequestPath returns a context containing the path of the request, required by ParseToken
+// when options.WithRequireAudienceForRequestPath is used.
+func WithRequestPath(ctx context.Context, path string) context.Context {
+	return oidc.WithRequestPath(ctx, path)
+}
+
+// WithTokenIDFromClaims returns a context from which GetTokenID returns the token id (`jti`) of the
+// validated token. Can be used to expose the token id from your own middleware.
+func WithTokenIDFromClaims[T any](ctx context.Context, claims T) context.Context {
+	return oidc.WithTokenIDFromClaims(ctx, claims)
+}
+
+// GetTokenID returns the token id (`jti`) added to the context using WithTokenIDFromClaims, or false
+// if the token doesn't contain a `jti` claim. Can be used to correlate audit logs without parsing the
+// token again. The GetTokenID functions of the middleware packages work the same way.
+func GetTokenID(ctx context.Context) (string, bool) {
+	return oidc.GetTokenID(ctx)
+}
+
+// GetTokenExpiresInHeaderValue returns the value of the TokenExpiresInHeader response header, or false
+// if the header isn't configured or the claims don't contain an `exp` claim. Can be used to set the
+// header from your own middleware.
+func GetTokenExpiresInHeaderValue[T any](claims T, opts *options.Options) (string, bool) {
+	return oidc.GetTokenExpiresInHeaderValue(claims, opts)
+}
+
 // GetTokenString takes a GetHeaderFn `func(key string) string` and [][]options.TokenStringOption and
-// returns the token as an string or an error.
+// returns the token as an string or an error. A BasicAuthFn receives `context.Background()`, use
+// GetTokenStringFromValues to pass the context of the request.
 func GetTokenString(getHeaderFn oidc.GetHeaderFn, tokenStringOpts [][]options.TokenStringOption) (string, error) {
-	return oidc.GetTokenString(getHeaderFn, tokenStringOpts)
+	return oidc.GetTokenString(context.Background(), getHeaderFn, tokenStringOpts)
+}
+
+// GetTokenStringFromValues takes a context, a GetHeaderValuesFn `func(key string) []string` and
+// [][]options.TokenStringOption and returns the token as an string or an error. Use it instead of GetTokenString
+// to handle headers sent more than once, based on the HeaderValuePrecedence of the token string options.
+// The context is passed to BasicAuthFn.
+func GetTokenStringFromValues(ctx context.Context, getHeaderValuesFn oidc.GetHeaderValuesFn,
+	tokenStringOpts [][]options.TokenStringOption) (string, error) {
+	return oidc.GetTokenStringFromValues(ctx, getHeaderValuesFn, tokenStringOpts)
+}
+
+// GetTokenStringFromRequest takes an *http.Request and an options.Options pointer and returns the token
+// as an string or an error, using GetTokenStringFn if configured, TokenSources if configured and the
+// TokenString and TokenCookieName options otherwise.
+func GetTokenStringFromRequest(r *http.Request, opts *options.Options) (string, error) {
+	return oidc.GetTokenStringFromRequest(r, opts)
+}
+
+// GetTokenStringOptions takes an options.Options pointer and returns the [][]options.TokenStringOption
+// to use with GetTokenString or GetTokenStringFromValues, including the TokenCookieName fallback.
+func GetTokenStringOptions(opts *options.Options) [][]options.TokenStringOption {
+	return oidc.GetTokenStringOptions(opts)
 }
@@ -3,13 +3,17 @@ package oidctoken
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/require"
 	"github.com/xenitab/go-oidc-middleware/internal/oidc"
 	"github.com/xenitab/go-oidc-middleware/internal/oidctesting"
+	"github.com/xenitab/go-oidc-middleware/optest"
 	"github.com/xenitab/go-oidc-middleware/options"
 )
 
@@ -138,13 +142,28 @@ func testToHttpHandler[T any](tb testing.TB, h http.Handler, parseToken oidc.Par
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		tokenString, err := GetTokenString(r.Header.Get, opts.TokenString)
+		tokenString, err := GetTokenStringFromRequest(r, opts)
 		if err != nil {
 			testOnError(tb, w, opts.ErrorHandler, http.StatusBadRequest, options.GetTokenErrorDescription, err)
 			return
 		}
 
+		err = oidc.ValidateTokenLength(tokenString, opts.MaxTokenLength)
+		if err != nil {
+			testOnError(tb, w, opts.ErrorHandler, http.StatusBadRequest, options.GetTokenErrorDescription, err)
+			return
+		}
+
+		if opts.RequireAudienceForRequestPath {
+			ctx = WithRequestPath(ctx, r.URL.Path)
+		}
+
 		claims, err := parseToken(ctx, tokenString)
+		if errors.Is(err, options.ErrJwksUnavailable) {
+			w.Header().Set("Retry-After", oidc.JwksUnavailableRetryAfter)
+			testOnError(tb, w, opts.ErrorHandler, http.StatusServiceUnavailable, options.ParseTokenErrorDescription, err)
+			return
+		}
 		if err != nil {
 			testOnError(tb, w, opts.ErrorHandler, http.StatusUnauthorized, options.ParseTokenErrorDescription, err)
 			return
@@ -153,8 +172,87 @@ func testToHttpHandler[T any](tb testing.TB, h http.Handler, parseToken oidc.Par
 		ctxWithClaims := context.WithValue(ctx, opts.ClaimsContextKeyName, claims)
 		reqWithClaims := r.WithContext(ctxWithClaims)
 
+		if opts.SubjectFn != nil {
+			opts.SubjectFn(reqWithClaims, GetSubjectFromClaims(claims))
+		}
+
+		if expiresIn, ok := GetTokenExpiresInHeaderValue(claims, opts); ok {
+			w.Header().Set(opts.TokenExpiresInHeader, expiresIn)
+		}
+
 		h.ServeHTTP(w, reqWithClaims)
 	}
 
 	return http.HandlerFunc(fn)
 }
+
+func TestNewParseTokenFunc(t *testing.T) {
+	op := optest.NewTesting(t)
+	defer op.Close(t)
+
+	token := op.GetToken(t)
+
+	cases := []struct {
+		testDescription string
+		options         []options.Option
+		tokenString     string
+		newFails        bool
+		succeeds        bool
+	}{
+		{
+			testDescription: "valid token",
+			options: []options.Option{
+				options.WithIssuer(op.GetURL(t)),
+				options.WithRequiredAudience("test-client"),
+			},
+			tokenString: token.AccessToken,
+			succeeds:    true,
+		},
+		{
+			testDescription: "required audience doesn't match",
+			options: []options.Option{
+				options.WithIssuer(op.GetURL(t)),
+				options.WithRequiredAudience("foo"),
+			},
+			tokenString: token.AccessToken,
+			succeeds:    false,
+		},
+		{
+			testDescription: "invalid token",
+			options: []options.Option{
+				options.WithIssuer(op.GetURL(t)),
+			},
+			tokenString: "foobar",
+			succeeds:    false,
+		},
+		{
+			testDescription: "background refresh can't be stopped",
+			options: []options.Option{
+				options.WithIssuer(op.GetURL(t)),
+				options.WithBackgroundRefreshInterval(time.Minute),
+			},
+			tokenString: token.AccessToken,
+			newFails:    true,
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		parseToken, err := NewParseTokenFunc[oidctesting.TestClaims](nil, c.options...)
+		if c.newFails {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+
+		claims, err := parseToken(context.Background(), c.tokenString)
+		if !c.succeeds {
+			require.Error(t, err)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.Equal(t, "test", claims["sub"])
+	}
+}
@@ -0,0 +1,14 @@
+//go:build jwx_es256k
+// +build jwx_es256k
+
+package optest
+
+import (
+	"crypto/elliptic"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+func getSecp256k1Curve() (elliptic.Curve, error) {
+	return secp256k1.S256(), nil
+}
@@ -0,0 +1,13 @@
+//go:build !jwx_es256k
+// +build !jwx_es256k
+
+package optest
+
+import (
+	"crypto/elliptic"
+	"fmt"
+)
+
+func getSecp256k1Curve() (elliptic.Curve, error) {
+	return nil, fmt.Errorf("signing algorithm ES256K requires the jwx_es256k build tag")
+}
@@ -0,0 +1,24 @@
+//go:build jwx_es256k
+// +build jwx_es256k
+
+package optest
+
+import (
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWithSigningAlgorithmES256K(t *testing.T) {
+	op, err := New(WithSigningAlgorithm(jwa.ES256K))
+	require.NoError(t, err)
+	defer op.Close()
+
+	token, err := op.GetToken()
+	require.NoError(t, err)
+
+	_, err = jwt.Parse([]byte(token.AccessToken), jwt.WithKeySet(op.jwks.getPublicKeySet()))
+	require.NoError(t, err)
+}
@@ -14,17 +14,26 @@ import (
 
 type jwksHandler struct {
 	sync.RWMutex
+	alg         jwa.SignatureAlgorithm
+	curve       elliptic.Curve
 	privateKeys []jwk.Key
 	publicKeys  []jwk.Key
 }
 
-func newJwksHandler() (*jwksHandler, error) {
+func newJwksHandler(alg jwa.SignatureAlgorithm) (*jwksHandler, error) {
+	curve, err := getCurveFromSignatureAlgorithm(alg)
+	if err != nil {
+		return nil, err
+	}
+
 	h := &jwksHandler{
+		alg:         alg,
+		curve:       curve,
 		privateKeys: []jwk.Key{},
 		publicKeys:  []jwk.Key{},
 	}
 
-	err := h.addNewKey()
+	err = h.addNewKey()
 	if err != nil {
 		return nil, err
 	}
@@ -33,7 +42,7 @@ func newJwksHandler() (*jwksHandler, error) {
 }
 
 func (h *jwksHandler) addNewKey() error {
-	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	ecdsaKey, err := ecdsa.GenerateKey(h.curve, rand.Reader)
 	if err != nil {
 		fmt.Printf("failed to generate new ECDSA privatre key: %s\n", err)
 		return err
@@ -73,7 +82,7 @@ func (h *jwksHandler) addNewKey() error {
 		return err
 	}
 
-	err = pubKey.Set(jwk.AlgorithmKey, jwa.ES384)
+	err = pubKey.Set(jwk.AlgorithmKey, h.alg)
 	if err != nil {
 		return err
 	}
@@ -145,3 +154,18 @@ func (h *jwksHandler) getPublicKeySet() jwk.Set {
 
 	return keySet
 }
+
+func getCurveFromSignatureAlgorithm(alg jwa.SignatureAlgorithm) (elliptic.Curve, error) {
+	switch alg {
+	case jwa.ES256:
+		return elliptic.P256(), nil
+	case jwa.ES384:
+		return elliptic.P384(), nil
+	case jwa.ES512:
+		return elliptic.P521(), nil
+	case jwa.ES256K:
+		return getSecp256k1Curve()
+	default:
+		return nil, fmt.Errorf("signing algorithm %q not supported", alg)
+	}
+}
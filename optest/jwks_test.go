@@ -3,11 +3,12 @@ package optest
 import (
 	"testing"
 
+	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/stretchr/testify/require"
 )
 
 func TestNewJwksHandler(t *testing.T) {
-	jwks, err := newJwksHandler()
+	jwks, err := newJwksHandler(jwa.ES384)
 	require.NoError(t, err)
 
 	require.Equal(t, 1, len(jwks.privateKeys))
@@ -15,7 +16,7 @@ func TestNewJwksHandler(t *testing.T) {
 }
 
 func TestAddNewKey(t *testing.T) {
-	jwks, err := newJwksHandler()
+	jwks, err := newJwksHandler(jwa.ES384)
 	require.NoError(t, err)
 
 	err = jwks.addNewKey()
@@ -26,7 +27,7 @@ func TestAddNewKey(t *testing.T) {
 }
 
 func TestRemoveOldestKey(t *testing.T) {
-	jwks, err := newJwksHandler()
+	jwks, err := newJwksHandler(jwa.ES384)
 	require.NoError(t, err)
 
 	err = jwks.removeOldestKey()
@@ -49,7 +50,7 @@ func TestRemoveOldestKey(t *testing.T) {
 }
 
 func TestGetPrivateKey(t *testing.T) {
-	jwks, err := newJwksHandler()
+	jwks, err := newJwksHandler(jwa.ES384)
 	require.NoError(t, err)
 
 	require.Equal(t, jwks.privateKeys[0], jwks.getPrivateKey())
@@ -66,7 +67,7 @@ func TestGetPrivateKey(t *testing.T) {
 }
 
 func TestGetPublicKey(t *testing.T) {
-	jwks, err := newJwksHandler()
+	jwks, err := newJwksHandler(jwa.ES384)
 	require.NoError(t, err)
 
 	require.Equal(t, jwks.publicKeys[0], jwks.getPublicKey())
@@ -83,7 +84,7 @@ func TestGetPublicKey(t *testing.T) {
 }
 
 func TestGetPublicKeySet(t *testing.T) {
-	jwks, err := newJwksHandler()
+	jwks, err := newJwksHandler(jwa.ES384)
 	require.NoError(t, err)
 
 	keySet := jwks.getPublicKeySet()
@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jws"
 	"github.com/lestrrat-go/jwx/jwt"
 )
@@ -29,13 +30,7 @@ type OPTest struct {
 
 // New sets up a new test OpenID Provider.
 func New(setters ...Option) (*OPTest, error) {
-	jwks, err := newJwksHandler()
-	if err != nil {
-		return nil, err
-	}
-
 	op := &OPTest{
-		jwks:           jwks,
 		opaqueTokens:   newOpaqueAccessTokenContainer(),
 		authorizations: newAuthorizationCacheContainer(),
 	}
@@ -62,9 +57,10 @@ func New(setters ...Option) (*OPTest, error) {
 				IdTokenKeyType:     "JWT",
 			},
 		},
-		TokenExpiration: time.Hour,
-		AutoStart:       true,
-		AccessTokenType: JwtAccessTokenType,
+		TokenExpiration:  time.Hour,
+		AutoStart:        true,
+		AccessTokenType:  JwtAccessTokenType,
+		SigningAlgorithm: jwa.ES384,
 	}
 
 	for _, setter := range setters {
@@ -80,6 +76,13 @@ func New(setters ...Option) (*OPTest, error) {
 		return nil, fmt.Errorf("the DefaultTestUser %q could not be found in TestUsers: %v", opts.DefaultTestUser, opts.TestUsers)
 	}
 
+	jwks, err := newJwksHandler(opts.SigningAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	op.jwks = jwks
+
 	if opts.AutoStart {
 		srv.Start()
 		if opts.Issuer == "" {
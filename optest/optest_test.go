@@ -12,7 +12,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
 	"github.com/lestrrat-go/jwx/jwt"
 	"github.com/stretchr/testify/require"
 )
@@ -469,3 +471,56 @@ func TestNew(t *testing.T) {
 	_, err = New(WithTestUsers(testUsers), WithDefaultTestUser("foo"))
 	require.NoError(t, err)
 }
+
+func TestNewWithSigningAlgorithm(t *testing.T) {
+	cases := []struct {
+		testDescription       string
+		signingAlgorithm      jwa.SignatureAlgorithm
+		expectedErrorContains string
+	}{
+		{
+			testDescription:       "ES256",
+			signingAlgorithm:      jwa.ES256,
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "ES384",
+			signingAlgorithm:      jwa.ES384,
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "ES512",
+			signingAlgorithm:      jwa.ES512,
+			expectedErrorContains: "",
+		},
+		{
+			testDescription:       "RS256 isn't supported",
+			signingAlgorithm:      jwa.RS256,
+			expectedErrorContains: "signing algorithm \"RS256\" not supported",
+		},
+	}
+
+	for i, c := range cases {
+		t.Logf("Test iteration %d: %s", i, c.testDescription)
+
+		op, err := New(WithSigningAlgorithm(c.signingAlgorithm))
+		if c.expectedErrorContains != "" {
+			require.ErrorContains(t, err, c.expectedErrorContains)
+			continue
+		}
+
+		require.NoError(t, err)
+
+		token, err := op.GetToken()
+		require.NoError(t, err)
+
+		msg, err := jws.Parse([]byte(token.AccessToken))
+		require.NoError(t, err)
+		require.Equal(t, c.signingAlgorithm, msg.Signatures()[0].ProtectedHeaders().Algorithm())
+
+		_, err = jwt.Parse([]byte(token.AccessToken), jwt.WithKeySet(op.jwks.getPublicKeySet()))
+		require.NoError(t, err)
+
+		op.Close()
+	}
+}
@@ -1,6 +1,10 @@
 package optest
 
-import "time"
+import (
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+)
 
 // Options is the configuration object for OPTest.
 type Options struct {
@@ -11,6 +15,7 @@ type Options struct {
 	AutoStart          bool
 	AccessTokenType    AccessTokenType
 	LoginPromptEnabled bool
+	SigningAlgorithm   jwa.SignatureAlgorithm
 }
 
 // AccessTokenType defines the type of token to be used.
@@ -81,3 +86,12 @@ func WithLoginPrompt() Option {
 		opts.LoginPromptEnabled = true
 	}
 }
+
+// WithSigningAlgorithm configures the algorithm used to sign tokens.
+// Supported values: ES256 ES384 ES512 ES256K (requires the jwx_es256k build tag)
+// Default is ES384.
+func WithSigningAlgorithm(opt jwa.SignatureAlgorithm) Option {
+	return func(opts *Options) {
+		opts.SigningAlgorithm = opt
+	}
+}
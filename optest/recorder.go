@@ -0,0 +1,155 @@
+package optest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// RecorderMode defines if the Recorder records or replays responses.
+type RecorderMode int
+
+const (
+	// ReplayRecorderMode replays the responses from the golden files, without using the network.
+	ReplayRecorderMode RecorderMode = iota
+	// RecordRecorderMode sends the requests and writes the responses to the golden files.
+	RecordRecorderMode
+)
+
+// Recorder is an http.RoundTripper recording the discovery and jwks responses of
+// an OpenID Provider to golden files, and replaying them without network access.
+// Use it with `options.WithHttpClient(recorder.Client())`.
+type Recorder struct {
+	mu        sync.Mutex
+	dir       string
+	mode      RecorderMode
+	transport http.RoundTripper
+}
+
+type recordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+// NewRecorder returns a Recorder using golden files in dir. The transport is used to send
+// the requests in RecordRecorderMode, http.DefaultTransport is used if it's nil.
+func NewRecorder(dir string, mode RecorderMode, transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return &Recorder{
+		dir:       dir,
+		mode:      mode,
+		transport: transport,
+	}
+}
+
+// Client returns an http.Client using the Recorder as transport.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{
+		Transport: r,
+	}
+}
+
+// RoundTrip implements http.RoundTripper. Only GET requests are supported, as used for the discovery and the jwks.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return nil, fmt.Errorf("recorder only supports GET requests, received: %s", req.Method)
+	}
+
+	if r.mode == RecordRecorderMode {
+		return r.record(req)
+	}
+
+	return r.replay(req)
+}
+
+func (r *Recorder) record(req *http.Request) (*http.Response, error) {
+	res, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := recordedResponse{
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       string(bodyBytes),
+	}
+
+	recordedBytes, err := json.MarshalIndent(recorded, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err = os.MkdirAll(r.dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(r.getGoldenFilePath(req), recordedBytes, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRecordedHttpResponse(req, recorded), nil
+}
+
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	recordedBytes, err := os.ReadFile(r.getGoldenFilePath(req))
+	r.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("no recorded response for %s: %w", req.URL.String(), err)
+	}
+
+	var recorded recordedResponse
+	err = json.Unmarshal(recordedBytes, &recorded)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse recorded response for %s: %w", req.URL.String(), err)
+	}
+
+	return newRecordedHttpResponse(req, recorded), nil
+}
+
+// getGoldenFilePath returns the path of the golden file for the request, based on the host and path.
+func (r *Recorder) getGoldenFilePath(req *http.Request) string {
+	replacer := strings.NewReplacer("/", "_", ":", "_", ".", "_", "?", "_", "&", "_", "=", "_")
+	name := replacer.Replace(strings.TrimSuffix(req.URL.Host+req.URL.Path, "/"))
+	if req.URL.RawQuery != "" {
+		name = name + "_" + replacer.Replace(req.URL.RawQuery)
+	}
+
+	return filepath.Join(r.dir, name+".json")
+}
+
+func newRecordedHttpResponse(req *http.Request, recorded recordedResponse) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewBufferString(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,71 @@
+package optest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/xenitab/go-oidc-middleware/oidctoken"
+	"github.com/xenitab/go-oidc-middleware/options"
+)
+
+type testRoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (fn testRoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return fn(req)
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+
+	op := NewTesting(t)
+	issuer := op.GetURL(t)
+	token := op.GetToken(t)
+
+	recorder := NewRecorder(dir, RecordRecorderMode, nil)
+	recordHandler, err := oidctoken.New[map[string]interface{}](nil,
+		options.WithIssuer(issuer),
+		options.WithHttpClient(recorder.Client()),
+	)
+	require.NoError(t, err)
+
+	_, err = recordHandler.ParseToken(context.Background(), token.AccessToken)
+	require.NoError(t, err)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	// the provider is shut down and any request sent to the network fails
+	op.Close(t)
+	failingTransport := testRoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("network disabled")
+	})
+
+	replayer := NewRecorder(dir, ReplayRecorderMode, failingTransport)
+	replayHandler, err := oidctoken.New[map[string]interface{}](nil,
+		options.WithIssuer(issuer),
+		options.WithHttpClient(replayer.Client()),
+	)
+	require.NoError(t, err)
+
+	claims, err := replayHandler.ParseToken(context.Background(), token.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, "test", claims["sub"])
+
+	_, err = oidctoken.New[map[string]interface{}](nil,
+		options.WithIssuer("http://foo.bar"),
+		options.WithAllowInsecureIssuer(true),
+		options.WithHttpClient(replayer.Client()),
+	)
+	require.ErrorContains(t, err, "no recorded response for http://foo.bar/.well-known/openid-configuration")
+
+	req, err := http.NewRequest(http.MethodPost, issuer, nil)
+	require.NoError(t, err)
+
+	_, err = replayer.RoundTrip(req)
+	require.EqualError(t, err, "recorder only supports GET requests, received: POST")
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/lestrrat-go/jwx/jwa"
 	"github.com/lestrrat-go/jwx/jws"
 	"github.com/lestrrat-go/jwx/jwt"
 )
@@ -71,7 +70,7 @@ func (op *OPTest) newAccessToken(id string, user TestUser, nonce string, now tim
 		}
 	}
 
-	signedToken, err := jwt.Sign(token, jwa.ES384, privKey, jwt.WithHeaders(headers))
+	signedToken, err := jwt.Sign(token, op.jwks.alg, privKey, jwt.WithHeaders(headers))
 	if err != nil {
 		return "", err
 	}
@@ -131,7 +130,7 @@ func (op *OPTest) newIdToken(id string, user TestUser, nonce string, now time.Ti
 		}
 	}
 
-	signedToken, err := jwt.Sign(token, jwa.ES384, privKey, jwt.WithHeaders(headers))
+	signedToken, err := jwt.Sign(token, op.jwks.alg, privKey, jwt.WithHeaders(headers))
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,98 @@
+package options
+
+import (
+	"sync"
+	"time"
+)
+
+// DecisionCacheKey identifies an authorization decision, using a hash of the token, the policy id,
+// the policy generation, the audience required for the request if it's derived from the request and
+// the request path if RequireAudienceForRequestPath is used. PolicyGeneration is increased by the
+// handler every time its policy is changed at runtime.
+type DecisionCacheKey struct {
+	TokenHash        string
+	PolicyID         string
+	PolicyGeneration uint64
+	Audience         string
+	Path             string
+}
+
+// Decision is the outcome of an authorization decision. Reason is the reason the token was
+// denied, reported to Metrics when the decision is reused, and empty if it's allowed.
+type Decision struct {
+	Allowed bool
+	Reason  ValidationFailureReason
+}
+
+// DecisionCache stores the outcome of the authorization decisions made after the token signature
+// has been verified. Implementations need to be safe for concurrent use and shall not return
+// decisions after expiresAt.
+type DecisionCache interface {
+	Get(key DecisionCacheKey) (decision Decision, found bool)
+	Set(key DecisionCacheKey, decision Decision, expiresAt time.Time)
+}
+
+type decisionEntry struct {
+	decision  Decision
+	expiresAt time.Time
+}
+
+type memoryDecisionCache struct {
+	sync.Mutex
+	decisions     map[DecisionCacheKey]decisionEntry
+	purgeInterval time.Duration
+	lastPurge     time.Time
+}
+
+// NewMemoryDecisionCache returns an in-memory DecisionCache. Expired decisions are removed
+// when they are read and all expired decisions are removed at most once per minute when
+// new decisions are stored.
+func NewMemoryDecisionCache() DecisionCache {
+	return &memoryDecisionCache{
+		decisions:     make(map[DecisionCacheKey]decisionEntry),
+		purgeInterval: time.Minute,
+		lastPurge:     time.Now(),
+	}
+}
+
+func (c *memoryDecisionCache) Get(key DecisionCacheKey) (Decision, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, ok := c.decisions[key]
+	if !ok {
+		return Decision{}, false
+	}
+
+	if !time.Now().Before(entry.expiresAt) {
+		delete(c.decisions, key)
+		return Decision{}, false
+	}
+
+	return entry.decision, true
+}
+
+func (c *memoryDecisionCache) Set(key DecisionCacheKey, decision Decision, expiresAt time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPurge) >= c.purgeInterval {
+		for k, d := range c.decisions {
+			if !now.Before(d.expiresAt) {
+				delete(c.decisions, k)
+			}
+		}
+
+		c.lastPurge = now
+	}
+
+	if !now.Before(expiresAt) {
+		return
+	}
+
+	c.decisions[key] = decisionEntry{
+		decision:  decision,
+		expiresAt: expiresAt,
+	}
+}
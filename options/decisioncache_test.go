@@ -0,0 +1,63 @@
+package options
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryDecisionCache(t *testing.T) {
+	cache := NewMemoryDecisionCache()
+
+	allowKey := DecisionCacheKey{TokenHash: "foo", PolicyID: "allow"}
+	denyKey := DecisionCacheKey{TokenHash: "foo", PolicyID: "deny"}
+	expiredKey := DecisionCacheKey{TokenHash: "bar", PolicyID: "allow"}
+
+	_, found := cache.Get(allowKey)
+	require.False(t, found)
+
+	cache.Set(allowKey, Decision{Allowed: true}, time.Now().Add(100*time.Millisecond))
+	cache.Set(denyKey, Decision{Reason: ClaimsValidationFailureReason}, time.Now().Add(time.Minute))
+	cache.Set(expiredKey, Decision{Allowed: true}, time.Now().Add(-time.Second))
+
+	decision, found := cache.Get(allowKey)
+	require.True(t, found)
+	require.Equal(t, Decision{Allowed: true}, decision)
+
+	decision, found = cache.Get(denyKey)
+	require.True(t, found)
+	require.Equal(t, Decision{Reason: ClaimsValidationFailureReason}, decision)
+
+	_, found = cache.Get(expiredKey)
+	require.False(t, found)
+
+	time.Sleep(150 * time.Millisecond)
+
+	_, found = cache.Get(allowKey)
+	require.False(t, found)
+
+	decision, found = cache.Get(denyKey)
+	require.True(t, found)
+	require.False(t, decision.Allowed)
+}
+
+func TestMemoryDecisionCachePurge(t *testing.T) {
+	cache := &memoryDecisionCache{
+		decisions:     make(map[DecisionCacheKey]decisionEntry),
+		purgeInterval: 50 * time.Millisecond,
+		lastPurge:     time.Now(),
+	}
+
+	cache.Set(DecisionCacheKey{TokenHash: "foo"}, Decision{Allowed: true}, time.Now().Add(10*time.Millisecond))
+	cache.Set(DecisionCacheKey{TokenHash: "bar"}, Decision{Allowed: true}, time.Now().Add(time.Minute))
+	require.Len(t, cache.decisions, 2)
+
+	time.Sleep(60 * time.Millisecond)
+
+	cache.Set(DecisionCacheKey{TokenHash: "baz"}, Decision{Allowed: true}, time.Now().Add(time.Minute))
+	require.Len(t, cache.decisions, 2)
+
+	_, found := cache.decisions[DecisionCacheKey{TokenHash: "foo"}]
+	require.False(t, found)
+}
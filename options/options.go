@@ -1,8 +1,13 @@
 package options
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
 )
 
 // ClaimsValidationFn is a generic function to validate calims.
@@ -11,6 +16,130 @@ import (
 // no additional validation of the claims will be done.
 type ClaimsValidationFn[T any] func(*T) error
 
+// ClaimsValidator validates the claims of a token using the request context, as an example
+// rules spanning multiple claims or depending on the request. It's called after all other
+// validations have passed. If an error is returned, the claims failed the validation.
+type ClaimsValidator func(ctx context.Context, claims map[string]interface{}) error
+
+// Verifier is used to delegate the signature verification of a token, as an example
+// to a cloud KMS or HSM instead of verifying it locally.
+// payload is the JWS signing input (base64url encoded header and payload separated by a dot),
+// signature is the decoded signature from the token and key is the key from the jwks that
+// matched the token.
+// If an error is returned, the signature verification failed.
+type Verifier interface {
+	Verify(ctx context.Context, payload []byte, signature []byte, alg string, key jwk.Key) error
+}
+
+// JwksResponseExtractor takes the response body from the jwks uri and returns the jwks.
+// Can be used to unwrap keys returned in a non-standard envelope, like `{"data":{"keys":[...]}}`.
+type JwksResponseExtractor func(body []byte) ([]byte, error)
+
+// KeySourceFunc returns the keys used to verify the tokens, replacing the download of the jwks.
+// Can be used to assemble the keys from any source, like PEM files listed in a manifest or a database.
+type KeySourceFunc func(ctx context.Context) (jwk.Set, error)
+
+// NonceFromContextFn returns the nonce that is expected in the `nonce` claim of the token.
+// The nonce is usually added to the request context by an upstream middleware.
+// If ok is false, no nonce is expected and the nonce validation is skipped.
+type NonceFromContextFn func(ctx context.Context) (nonce string, ok bool)
+
+// RevokedTokenIDsFn returns the current set of revoked token ids (`jti`), as an example from a
+// periodically downloaded revocation list. It's called for every token with a `jti` and should
+// return a set that is kept in memory.
+type RevokedTokenIDsFn func(ctx context.Context) (map[string]struct{}, error)
+
+// Timings contains the time spent in the steps of parsing a token. KeyRefresh is included in
+// KeyLookup and all steps are included in Total. Steps that weren't run are zero.
+type Timings struct {
+	// JwksLoad is the time spent loading the jwks if LazyLoadJwks is enabled and it wasn't loaded.
+	JwksLoad time.Duration
+	// KeyIDExtraction is the time spent parsing the token header, extracting the `kid` and `alg`.
+	KeyIDExtraction time.Duration
+	// KeyLookup is the time spent finding the key in the jwks, including KeyRefresh.
+	KeyLookup time.Duration
+	// KeyRefresh is the time spent refreshing, or waiting for a refresh of, the jwks.
+	KeyRefresh time.Duration
+	// SignatureVerification is the time spent verifying the signature.
+	SignatureVerification time.Duration
+	// Introspection is the time spent calling the introspection endpoint, if IntrospectionUri is used.
+	Introspection time.Duration
+	// ClaimsValidation is the time spent validating the claims.
+	ClaimsValidation time.Duration
+	// Total is the total time spent parsing the token.
+	Total time.Duration
+}
+
+// TimingsFn is called with the time spent in the steps of parsing a token, after each token
+// has been parsed. err is the error returned when parsing the token.
+type TimingsFn func(ctx context.Context, timings Timings, err error)
+
+// Logger is used by the handler to emit debug logs, as an example about jwks refreshes, key ids
+// missing from the jwks, rate limited refreshes and validation failures. keysAndValues contains
+// alternating keys and values, which makes it easy to adapt to most structured loggers.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+}
+
+// ValidationFailureReason describes why a token failed the validation, used by Metrics.
+type ValidationFailureReason string
+
+const (
+	// ExpiredValidationFailureReason is used when the token has expired or is too old.
+	ExpiredValidationFailureReason ValidationFailureReason = "expired"
+	// SignatureValidationFailureReason is used when the signature of the token is invalid.
+	SignatureValidationFailureReason ValidationFailureReason = "signature"
+	// IssuerValidationFailureReason is used when the token doesn't contain the required issuer.
+	IssuerValidationFailureReason ValidationFailureReason = "issuer"
+	// AudienceValidationFailureReason is used when the token doesn't contain the required audience.
+	AudienceValidationFailureReason ValidationFailureReason = "audience"
+	// ClaimsValidationFailureReason is used when the token is missing a required claim, role, group or scope,
+	// or when the claims validation function returns an error.
+	ClaimsValidationFailureReason ValidationFailureReason = "claims"
+	// JwksUnavailableValidationFailureReason is used when the jwks or the introspection endpoint is unavailable.
+	JwksUnavailableValidationFailureReason ValidationFailureReason = "jwks_unavailable"
+	// OtherValidationFailureReason is used for all other errors, as an example a malformed token.
+	OtherValidationFailureReason ValidationFailureReason = "other"
+)
+
+// Metrics is used to collect metrics about the token validation, as an example using
+// Prometheus counters and histograms. The methods are called concurrently and shouldn't block.
+type Metrics interface {
+	// ObserveValidation is called after each token has been parsed.
+	// ok is false if the validation failed and reason contains why.
+	ObserveValidation(ok bool, reason ValidationFailureReason)
+	// ObserveJwksFetch is called after each download of the jwks with the time it took
+	// and the error, nil if the jwks was downloaded.
+	ObserveJwksFetch(duration time.Duration, err error)
+}
+
+// IssuerConfig configures a trusted issuer, used with Issuers. DiscoveryUri and JwksUri are
+// optional and work the same way as the options with the same name.
+type IssuerConfig struct {
+	Issuer       string
+	DiscoveryUri string
+	JwksUri      string
+}
+
+// NowFn returns the current time, used when validating the time claims of a token.
+type NowFn func() time.Time
+
+// GetTokenStringFn extracts the token string from a request, replacing the built-in extraction
+// configured with TokenString, TokenCookieName and TokenSources.
+type GetTokenStringFn func(r *http.Request) (string, error)
+
+// TokenTypeValidator validates the token type (`typ` header) of a token. typ is empty if the
+// token header doesn't contain a type. If an error is returned, the token type isn't allowed.
+type TokenTypeValidator func(typ string) error
+
+// ShouldCacheFunc decides if a token that has been verified and validated is stored in the
+// token cache. Tokens for which it returns false are verified again on every request.
+type ShouldCacheFunc func(token jwt.Token) bool
+
+// SubjectFn is called by the middlewares with the request and the `sub` claim of the token,
+// after the token has been validated. sub is empty if the token doesn't contain a `sub` claim.
+type SubjectFn func(r *http.Request, sub string)
+
 // ClaimsContextKeyName is the type for they key value used to pass claims using request context.
 // Using separate type because of the following: https://staticcheck.io/docs/checks#SA1029
 type ClaimsContextKeyName string
@@ -21,6 +150,9 @@ const DefaultClaimsContextKeyName ClaimsContextKeyName = "claims"
 // ErrorHandler is called by the middleware if not nil
 type ErrorHandler func(description ErrorDescription, err error)
 
+// ErrorResponseHandler is called by the oidchttp middleware to write the error response if not nil
+type ErrorResponseHandler func(w http.ResponseWriter, r *http.Request, err error)
+
 // ErrorDescription is used to pass the description of the error to ErrorHandler
 type ErrorDescription string
 
@@ -33,24 +165,188 @@ const (
 	ConvertTokenErrorDescription ErrorDescription = "unable to convert token to map"
 )
 
+// ErrJwksUnavailable is wrapped by the errors returned when the discovery document or the jwks
+// can't be fetched, as an example if the provider is unreachable. Use errors.Is to check for it.
+// The middlewares respond with 503 and a Retry-After header instead of 401 for these errors.
+var ErrJwksUnavailable = errors.New("jwks unavailable")
+
+// ErrDiscoveryIssuerMismatch is wrapped by the errors returned when UseDiscoveryIssuer is used and
+// the issuer of the discovery document doesn't match the configured issuer.
+var ErrDiscoveryIssuerMismatch = errors.New("discovery issuer mismatch")
+
+// ErrJwksLoadBackoff is wrapped by the errors returned when the jwks isn't loaded because
+// the previous lazy load failed less than LazyLoadJwksBackoff ago. ErrJwksUnavailable is also wrapped.
+var ErrJwksLoadBackoff = errors.New("jwks load backing off after a failure")
+
+// ErrInactiveToken is returned when the introspection endpoint responds that the token isn't active,
+// as an example if it has expired or been revoked.
+var ErrInactiveToken = errors.New("token is not active")
+
+// ErrRevokedToken is wrapped by the errors returned when the token id (`jti`) of the token is
+// in RevokedTokenIDs or returned by RevokedTokenIDsFn.
+var ErrRevokedToken = errors.New("token is revoked")
+
+// ErrUnknownKeyID is wrapped by the errors returned when the key id (kid) of the token can't be
+// found in the jwks, even after refreshing it. As an example a client using a revoked or foreign key.
+var ErrUnknownKeyID = errors.New("unknown key id")
+
+// ErrBlockedKeyID is wrapped by the errors returned when the token is signed, or claims to be
+// signed, using a key id (kid) in BlockedKeyIDs.
+var ErrBlockedKeyID = errors.New("blocked key id")
+
+// ErrRSAKeyTooSmall is wrapped by the errors returned when the token is signed using an RSA key
+// smaller than MinRSAKeyBits.
+var ErrRSAKeyTooSmall = errors.New("rsa key too small")
+
+// ErrSignatureVerification is wrapped by the errors returned when the signature of the token is invalid,
+// as an example if the token has been tampered with.
+var ErrSignatureVerification = errors.New("failed to verify signature")
+
+// ErrNoneAlgorithm is returned for tokens using the `none` algorithm (unsigned tokens), which are
+// always rejected before any key lookup.
+var ErrNoneAlgorithm = errors.New("token algorithm none is not allowed")
+
+// ErrAuthTooOld is wrapped by the errors returned when the auth_time of the token isn't within MaxAuthAge,
+// the client is expected to re-authenticate the user.
+var ErrAuthTooOld = errors.New("auth too old")
+
+// ErrTokenTooLong is wrapped by the errors returned when the token is longer than MaxTokenLength.
+var ErrTokenTooLong = errors.New("token too long")
+
+// ErrTokenNotFound is wrapped by the errors returned when the request doesn't contain any credentials,
+// as an example if the Authorization header is missing. Malformed credentials don't wrap it.
+var ErrTokenNotFound = errors.New("token not found")
+
+// RejectedTokenError is returned when AttachRejectedToken is enabled and a token with a valid
+// signature, issuer and audience fails the required claims, roles, groups or scopes validation.
+// Token contains the verified token, as an example to log the claims of rejected tokens from
+// the ErrorHandler. Use errors.As to get it. Tokens failing any other validation are never attached.
+type RejectedTokenError struct {
+	Token jwt.Token
+	Err   error
+}
+
+func (e *RejectedTokenError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RejectedTokenError) Unwrap() error {
+	return e.Err
+}
+
+// DiscoveryMode defines which metadata document is used to discover the jwks uri.
+type DiscoveryMode int
+
+const (
+	// OpenIDConnectDiscoveryMode uses the OpenID Connect discovery document
+	// (`/.well-known/openid-configuration`).
+	OpenIDConnectDiscoveryMode DiscoveryMode = iota
+	// OAuth2MetadataDiscoveryMode uses the OAuth 2.0 Authorization Server Metadata document
+	// (`/.well-known/oauth-authorization-server`, RFC 8414) and requires the `issuer` in the
+	// document to match the configured issuer.
+	OAuth2MetadataDiscoveryMode
+)
+
 // Options defines the options for OIDC Middleware.
 type Options struct {
-	Issuer                     string
-	DiscoveryUri               string
-	DiscoveryFetchTimeout      time.Duration
-	JwksUri                    string
-	JwksFetchTimeout           time.Duration
-	JwksRateLimit              uint
-	FallbackSignatureAlgorithm string
-	AllowedTokenDrift          time.Duration
-	LazyLoadJwks               bool
-	RequiredTokenType          string
-	RequiredAudience           string
-	DisableKeyID               bool
-	HttpClient                 *http.Client
-	TokenString                [][]TokenStringOption
-	ClaimsContextKeyName       ClaimsContextKeyName
-	ErrorHandler               ErrorHandler
+	Issuer                        string
+	IssuerAliases                 []string
+	Issuers                       []IssuerConfig
+	DiscoveryUri                  string
+	DiscoveryMode                 DiscoveryMode
+	UseDiscoveryIssuer            bool
+	DiscoveryFetchTimeout         time.Duration
+	JwksUri                       string
+	JwksFetchTimeout              time.Duration
+	JwksFetchRetries              int
+	JwksFetchRetryDelay           time.Duration
+	JwksRateLimit                 uint
+	JwksResponseExtractor         JwksResponseExtractor
+	KeySourceFunc                 KeySourceFunc
+	JwksJSON                      []byte
+	PendingJwks                   jwk.Set
+	DecryptionKeys                jwk.Set
+	RequireJwksSameHostAsIssuer   bool
+	AllowInsecureIssuer           bool
+	IntrospectionUri              string
+	IntrospectionClientID         string
+	IntrospectionClientSecret     string
+	IntrospectionFetchTimeout     time.Duration
+	FallbackSignatureAlgorithm    string
+	AllowES256K                   bool
+	AllowedTokenDrift             time.Duration
+	MaxAllowedTokenDrift          time.Duration
+	AbsoluteMaxExpiryAge          time.Duration
+	NowFn                         NowFn
+	MaxAuthAge                    time.Duration
+	MaxTokenAge                   time.Duration
+	LazyLoadJwks                  bool
+	LazyLoadJwksBackoff           time.Duration
+	BackgroundRefreshInterval     time.Duration
+	MaxTokenLength                int
+	RequiredTokenType             string
+	RequiredTokenTypes            []string
+	AllowMissingTokenType         bool
+	RequiredTokenUse              string
+	TokenTypeValidator            TokenTypeValidator
+	RequiredAudience              string
+	RequiredAudiences             []string
+	AudienceIsIssuer              bool
+	AllowMissingAudience          bool
+	AudienceClaimName             string
+	RequireAudienceForRequestPath bool
+	ClaimNamespace                string
+	RequiredRoles                 []string
+	RequiredScopes                []string
+	RolesClaimName                string
+	RolesDelimiter                string
+	RoleHierarchy                 map[string][]string
+	RequiredRealmRoles            []string
+	RequiredClientRoles           map[string][]string
+	RequiredGroupsAny             []string
+	RequiredGroupsAll             []string
+	RequiredClaimsRegex           map[string]string
+	RequiredClaimsPresent         []string
+	StrictClaimsPresence          bool
+	GroupsClaimName               string
+	StrictClaimsDecoding          bool
+	AttachRejectedToken           bool
+	DisableKeyID                  bool
+	MaxFallbackKeys               int
+	AllowedKeyTypes               []string
+	MinRSAKeyBits                 int
+	AllowedSignatureAlgorithms    []string
+	DeprecatedKeyIDs              []string
+	OnDeprecatedKeyUsed           func(kid string)
+	BlockedKeyIDs                 []string
+	Verifiers                     map[string]Verifier
+	NonceFromContextFn            NonceFromContextFn
+	NonceMaxAge                   time.Duration
+	RevokedTokenIDs               []string
+	RevokedTokenIDsFn             RevokedTokenIDsFn
+	ClaimsValidator               ClaimsValidator
+	DecisionCache                 DecisionCache
+	PolicyID                      string
+	TokenCacheTTL                 time.Duration
+	TokenCacheSize                int
+	ShouldCacheFunc               ShouldCacheFunc
+	TimingsFn                     TimingsFn
+	Metrics                       Metrics
+	Logger                        Logger
+	HttpClient                    *http.Client
+	JwksHttpClient                *http.Client
+	TokenString                   [][]TokenStringOption
+	TokenCookieName               string
+	TokenSources                  []TokenSource
+	GetTokenStringFn              GetTokenStringFn
+	SubjectFn                     SubjectFn
+	TokenExpiresInHeader          string
+	ClaimsContextKeyName          ClaimsContextKeyName
+	ErrorHandler                  ErrorHandler
+	ErrorResponseHandler          ErrorResponseHandler
+	Realm                         string
+	AuthRequestClaimHeaders       map[string]string
+	AudienceFromTLSServerName     bool
 }
 
 // New takes Option setters and returns an Options pointer.
@@ -58,12 +354,21 @@ type Options struct {
 // needed by any external application using this library.
 func New(setters ...Option) *Options {
 	opts := &Options{
-		DiscoveryFetchTimeout: 5 * time.Second,
-		JwksFetchTimeout:      5 * time.Second,
-		JwksRateLimit:         1,
-		AllowedTokenDrift:     10 * time.Second,
-		HttpClient:            http.DefaultClient,
-		ClaimsContextKeyName:  DefaultClaimsContextKeyName,
+		DiscoveryFetchTimeout:     5 * time.Second,
+		JwksFetchTimeout:          5 * time.Second,
+		JwksFetchRetryDelay:       time.Second,
+		IntrospectionFetchTimeout: 5 * time.Second,
+		JwksRateLimit:             1,
+		AllowedTokenDrift:         10 * time.Second,
+		MaxAllowedTokenDrift:      5 * time.Minute,
+		MaxTokenLength:            32768,
+		AudienceClaimName:         "aud",
+		RolesClaimName:            "roles",
+		GroupsClaimName:           "groups",
+		NonceMaxAge:               5 * time.Minute,
+		HttpClient:                http.DefaultClient,
+		ClaimsContextKeyName:      DefaultClaimsContextKeyName,
+		TokenCacheSize:            10000,
 	}
 
 	for _, setter := range setters {
@@ -84,8 +389,34 @@ func WithIssuer(opt string) Option {
 	}
 }
 
+// WithIssuerAliases sets the IssuerAliases parameter for an Options pointer.
+// IssuerAliases are other values of the Issuer `iss` claim accepted for the configured issuer,
+// as an example `accounts.google.com` for `https://accounts.google.com`.
+// The issuer is still used for discovery.
+// Defaults to empty slice
+func WithIssuerAliases(opt []string) Option {
+	return func(opts *Options) {
+		opts.IssuerAliases = opt
+	}
+}
+
+// WithIssuers sets the Issuers parameter for an Options pointer.
+// Issuers are additional trusted issuers, each with its own discovery and jwks. The issuer
+// of a token is read before the signature is verified and the keys of that issuer are used,
+// which means a key id is only trusted for the issuer serving it. Tokens from other issuers
+// are rejected before any key is looked up. If Issuer isn't set, the first of the Issuers
+// is used as Issuer. All other options are shared by the issuers.
+// Can't be used together with IntrospectionUri or KeySourceFunc.
+// Defaults to empty slice
+func WithIssuers(opt ...IssuerConfig) Option {
+	return func(opts *Options) {
+		opts.Issuers = opt
+	}
+}
+
 // WithDiscoveryUri sets the Issuer parameter for an Options pointer.
-// DiscoveryUri is where the `jwks_uri` will be grabbed
+// DiscoveryUri is where the `jwks_uri` will be grabbed. Can be used when the issuer is only reachable
+// through a reverse proxy, the Issuer is still used to validate the `iss` claim.
 // Defaults to `fmt.Sprintf("%s/.well-known/openid-configuration", strings.TrimSuffix(issuer, "/"))`
 func WithDiscoveryUri(opt string) Option {
 	return func(opts *Options) {
@@ -93,6 +424,33 @@ func WithDiscoveryUri(opt string) Option {
 	}
 }
 
+// WithDiscoveryMode sets the DiscoveryMode parameter for an Options pointer.
+// DiscoveryMode defines which metadata document is used to discover the jwks uri.
+// Use OAuth2MetadataDiscoveryMode for OAuth 2.0 authorization servers not supporting
+// OpenID Connect discovery. The DiscoveryUri is created from the issuer using RFC 8414
+// if not configured.
+// Defaults to OpenIDConnectDiscoveryMode
+func WithDiscoveryMode(opt DiscoveryMode) Option {
+	return func(opts *Options) {
+		opts.DiscoveryMode = opt
+	}
+}
+
+// WithUseDiscoveryIssuer sets the UseDiscoveryIssuer parameter for an Options pointer.
+// UseDiscoveryIssuer validates the `issuer` of the discovery document and requires it as the
+// issuer of the tokens instead of Issuer, for providers returning an issuer that differs from the
+// configured one by a trailing slash. Loading the jwks fails with ErrDiscoveryIssuerMismatch if
+// the issuers differ otherwise. Only used when the jwks uri is read from the discovery document.
+// With Issuers, tokens using the issuer of a discovery document are routed to the first of the
+// Issuers, in the configured order, whose discovery document contains it. Issuers using
+// LazyLoadJwks only know it after their jwks has been loaded by a token using the configured issuer.
+// Defaults to false
+func WithUseDiscoveryIssuer(opt bool) Option {
+	return func(opts *Options) {
+		opts.UseDiscoveryIssuer = opt
+	}
+}
+
 // WithDiscoveryFetchTimeout sets the DiscoveryFetchTimeout parameter for an Options pointer.
 // DiscoveryFetchTimeout sets the context timeout when downloading the discovery metadata
 // Defaults to 5 seconds
@@ -111,6 +469,70 @@ func WithJwksUri(opt string) Option {
 	}
 }
 
+// WithRequireJwksSameHostAsIssuer sets the RequireJwksSameHostAsIssuer parameter for an Options pointer.
+// RequireJwksSameHostAsIssuer rejects a jwks uri (from discovery or configured) and a discovery uri
+// with another host than the issuer, as an example a tampered discovery document pointing
+// the jwks uri to an unexpected domain.
+// Defaults to false
+func WithRequireJwksSameHostAsIssuer(opt bool) Option {
+	return func(opts *Options) {
+		opts.RequireJwksSameHostAsIssuer = opt
+	}
+}
+
+// WithAllowInsecureIssuer sets the AllowInsecureIssuer parameter for an Options pointer.
+// By default, the issuer, discovery uri and jwks uri are required to use the `https` scheme,
+// except for loopback hosts (`localhost`, `127.0.0.1` and `::1`). AllowInsecureIssuer allows
+// `http` for any host and should only be used for testing.
+// Defaults to false
+func WithAllowInsecureIssuer(opt bool) Option {
+	return func(opts *Options) {
+		opts.AllowInsecureIssuer = opt
+	}
+}
+
+// WithIntrospectionUri sets the IntrospectionUri parameter for an Options pointer.
+// IntrospectionUri is the OAuth 2.0 token introspection endpoint (RFC 7662). If set, tokens are
+// validated by the introspection endpoint instead of verifying the signature using the jwks, which
+// makes it possible to use opaque access tokens. The token is required to be `active` and the claims
+// are built from the introspection response, where issuer, audience, roles, scopes and the claims
+// validation function are validated like for a JWT. The jwks isn't loaded.
+// Errors calling the introspection endpoint wrap ErrJwksUnavailable, making the middlewares respond with 503.
+// Defaults to empty string `""` and means the jwks is used.
+func WithIntrospectionUri(opt string) Option {
+	return func(opts *Options) {
+		opts.IntrospectionUri = opt
+	}
+}
+
+// WithIntrospectionClientID sets the IntrospectionClientID parameter for an Options pointer.
+// IntrospectionClientID is the client id used to authenticate to the introspection endpoint,
+// using HTTP basic authentication together with IntrospectionClientSecret.
+// Defaults to empty string `""` and means no authentication is used.
+func WithIntrospectionClientID(opt string) Option {
+	return func(opts *Options) {
+		opts.IntrospectionClientID = opt
+	}
+}
+
+// WithIntrospectionClientSecret sets the IntrospectionClientSecret parameter for an Options pointer.
+// IntrospectionClientSecret is the client secret used together with IntrospectionClientID.
+// Defaults to empty string `""`
+func WithIntrospectionClientSecret(opt string) Option {
+	return func(opts *Options) {
+		opts.IntrospectionClientSecret = opt
+	}
+}
+
+// WithIntrospectionFetchTimeout sets the IntrospectionFetchTimeout parameter for an Options pointer.
+// IntrospectionFetchTimeout sets the context timeout when calling the introspection endpoint.
+// Defaults to 5 seconds
+func WithIntrospectionFetchTimeout(opt time.Duration) Option {
+	return func(opts *Options) {
+		opts.IntrospectionFetchTimeout = opt
+	}
+}
+
 // WithJwksFetchTimeout sets the JwksFetchTimeout parameter for an Options pointer.
 // JwksFetchTimeout sets the context timeout when downloading the jwks
 // Defaults to 5 seconds
@@ -120,6 +542,27 @@ func WithJwksFetchTimeout(opt time.Duration) Option {
 	}
 }
 
+// WithJwksFetchRetries sets the JwksFetchRetries parameter for an Options pointer.
+// JwksFetchRetries is the number of times the discovery and the jwks download are retried when
+// the jwks is loaded, as an example by New when LazyLoadJwks isn't used. Makes it possible to
+// handle transient network errors at startup. The delay between the retries starts at
+// JwksFetchRetryDelay and is doubled for each retry. Refreshes of an already loaded jwks aren't retried.
+// Defaults to 0 and means no retries.
+func WithJwksFetchRetries(opt int) Option {
+	return func(opts *Options) {
+		opts.JwksFetchRetries = opt
+	}
+}
+
+// WithJwksFetchRetryDelay sets the JwksFetchRetryDelay parameter for an Options pointer.
+// JwksFetchRetryDelay is the delay before the first retry, see JwksFetchRetries.
+// Defaults to 1 second
+func WithJwksFetchRetryDelay(opt time.Duration) Option {
+	return func(opts *Options) {
+		opts.JwksFetchRetryDelay = opt
+	}
+}
+
 // WithJwksRateLimit sets the JwksFetchTimeout parameter for an Options pointer.
 // JwksRateLimit takes an uint and makes sure that the jwks will at a maximum
 // be requested these many times per second.
@@ -131,6 +574,67 @@ func WithJwksRateLimit(opt uint) Option {
 	}
 }
 
+// WithJwksResponseExtractor sets the JwksResponseExtractor parameter for an Options pointer.
+// JwksResponseExtractor is used to extract the jwks from the response body of the jwks uri
+// before it is parsed. Needed if the provider returns the keys in a non-standard envelope.
+// Defaults to nil and means the response body is parsed as a standard jwks.
+func WithJwksResponseExtractor(opt JwksResponseExtractor) Option {
+	return func(opts *Options) {
+		opts.JwksResponseExtractor = opt
+	}
+}
+
+// WithKeySourceFunc sets the KeySourceFunc parameter for an Options pointer.
+// KeySourceFunc fully replaces the built-in jwks fetching: it's called to load the keys and
+// every time the keys are refreshed (when a token has an unknown key id), using the same
+// rate limiting and fetch timeout as the jwks uri. Discovery isn't used and JwksUri is ignored.
+// Defaults to nil and means the jwks is downloaded from JwksUri or the discovery document.
+func WithKeySourceFunc(opt KeySourceFunc) Option {
+	return func(opts *Options) {
+		opts.KeySourceFunc = opt
+	}
+}
+
+// WithJwksJSON sets the JwksJSON parameter for an Options pointer.
+// JwksJSON is a jwks used as is instead of downloading it, as an example read from a file in
+// deployments that can't reach the discovery document or jwks uri. It's parsed once by New,
+// nothing is fetched over the network and the keys are never refreshed, a token with an unknown
+// key id is rejected. When used together with BackgroundRefreshInterval, the keys are instead only
+// used until the background refresh has downloaded the jwks from JwksUri or the discovery
+// document, New doesn't wait for the network. Can't be used together with KeySourceFunc,
+// IntrospectionUri or Issuers.
+// Defaults to nil and means the jwks is downloaded from JwksUri or the discovery document.
+func WithJwksJSON(opt []byte) Option {
+	return func(opts *Options) {
+		opts.JwksJSON = opt
+	}
+}
+
+// WithPendingJwks sets the PendingJwks parameter for an Options pointer.
+// PendingJwks takes a jwk.Set with keys that will be used by the provider after an
+// upcoming key rotation. If the key id from a token can't be found in the jwks, the
+// pending keys are used before the jwks is refreshed. This makes it possible for tokens
+// signed with the new keys to be validated as soon as the rotation happens.
+// The keys are only used for tokens from Issuer, not the ones from Issuers.
+// Can't be used together with DisableKeyID.
+// Defaults to nil
+func WithPendingJwks(opt jwk.Set) Option {
+	return func(opts *Options) {
+		opts.PendingJwks = opt
+	}
+}
+
+// WithDecryptionKeys sets the DecryptionKeys parameter for an Options pointer.
+// DecryptionKeys takes a jwk.Set with the private keys used to decrypt encrypted tokens (JWE).
+// If a token is a JWE, it's decrypted using the key matching the key id in the JWE header
+// (or every key if there isn't a key id) and the inner signed token is then validated as usual.
+// Defaults to nil, encrypted tokens are rejected.
+func WithDecryptionKeys(opt jwk.Set) Option {
+	return func(opts *Options) {
+		opts.DecryptionKeys = opt
+	}
+}
+
 // WithFallbackSignatureAlgorithm sets the FallbackSignatureAlgorithm parameter for an Options pointer.
 // FallbackSignatureAlgorithm needs to be used when the jwks doesn't contain the alg key.
 // If not specified and jwks doesn't contain alg key, will default to:
@@ -146,6 +650,17 @@ func WithFallbackSignatureAlgorithm(opt string) Option {
 	}
 }
 
+// WithAllowES256K sets the AllowES256K parameter for an Options pointer.
+// AllowES256K enables validation of tokens signed with ES256K (ECDSA using the secp256k1 curve).
+// Keys without the alg key using the secp256k1 curve will default to ES256K.
+// Requires the application to be built with the jwx_es256k build tag.
+// Defaults to false
+func WithAllowES256K(opt bool) Option {
+	return func(opts *Options) {
+		opts.AllowES256K = opt
+	}
+}
+
 // WithAllowedTokenDrift sets the AllowedTokenDrift parameter for an Options pointer.
 // AllowedTokenDrift adds the duration to the token expiration to allow
 // for time drift between parties.
@@ -156,6 +671,60 @@ func WithAllowedTokenDrift(opt time.Duration) Option {
 	}
 }
 
+// WithMaxAllowedTokenDrift sets the MaxAllowedTokenDrift parameter for an Options pointer.
+// MaxAllowedTokenDrift is the upper bound of AllowedTokenDrift, the handler fails to be created if
+// AllowedTokenDrift is larger. Protects against misconfigurations silently accepting expired tokens.
+// Defaults to 5 minutes and 0 means no upper bound.
+func WithMaxAllowedTokenDrift(opt time.Duration) Option {
+	return func(opts *Options) {
+		opts.MaxAllowedTokenDrift = opt
+	}
+}
+
+// WithAbsoluteMaxExpiryAge sets the AbsoluteMaxExpiryAge parameter for an Options pointer.
+// AbsoluteMaxExpiryAge is a backstop independent of AllowedTokenDrift: tokens without an `exp`
+// claim or that expired more than the duration ago are always rejected, even if a large drift
+// would accept them.
+// Defaults to 0 and means only AllowedTokenDrift is used.
+func WithAbsoluteMaxExpiryAge(opt time.Duration) Option {
+	return func(opts *Options) {
+		opts.AbsoluteMaxExpiryAge = opt
+	}
+}
+
+// WithNowFn sets the NowFn parameter for an Options pointer.
+// NowFn is used to get the current time when validating the expiration, `auth_time` and
+// issued at claims of a token. Can be used by tests to inject a fixed clock.
+// Defaults to time.Now
+func WithNowFn(opt NowFn) Option {
+	return func(opts *Options) {
+		opts.NowFn = opt
+	}
+}
+
+// WithMaxAuthAge sets the MaxAuthAge parameter for an Options pointer.
+// MaxAuthAge requires the `auth_time` claim to be present and that the user
+// authenticated within the duration. AllowedTokenDrift is added to allow for
+// time drift between parties. Can be used to force re-authentication for
+// sensitive operations.
+// Defaults to 0 and means `auth_time` isn't validated.
+func WithMaxAuthAge(opt time.Duration) Option {
+	return func(opts *Options) {
+		opts.MaxAuthAge = opt
+	}
+}
+
+// WithMaxTokenAge sets the MaxTokenAge parameter for an Options pointer.
+// MaxTokenAge requires the issued at `iat` claim to be present and that the token
+// was issued within the duration, even if it hasn't expired. AllowedTokenDrift is
+// added to allow for time drift between parties.
+// Defaults to 0 and means `iat` isn't validated.
+func WithMaxTokenAge(opt time.Duration) Option {
+	return func(opts *Options) {
+		opts.MaxTokenAge = opt
+	}
+}
+
 // WithLazyLoadJwks sets the LazyLoadJwks parameter for an Options pointer.
 // LazyLoadJwks makes it possible to use OIDC Discovery without being
 // able to load the keys at startup.
@@ -168,6 +737,39 @@ func WithLazyLoadJwks(opt bool) Option {
 	}
 }
 
+// WithLazyLoadJwksBackoff sets the LazyLoadJwksBackoff parameter for an Options pointer.
+// LazyLoadJwksBackoff is the duration a failed lazy load of the jwks is cached. Requests during
+// that time fail with an error wrapping both ErrJwksLoadBackoff and ErrJwksUnavailable, without
+// fetching the discovery document or the jwks again. Concurrent lazy loads are also deduplicated.
+// Defaults to 0 and means every request tries to load the jwks until it succeeds.
+func WithLazyLoadJwksBackoff(opt time.Duration) Option {
+	return func(opts *Options) {
+		opts.LazyLoadJwksBackoff = opt
+	}
+}
+
+// WithBackgroundRefreshInterval sets the BackgroundRefreshInterval parameter for an Options pointer.
+// BackgroundRefreshInterval is the interval the jwks is downloaded at in the background, making new
+// keys available before a token signed with them is received. JwksRateLimit is respected and the
+// refresh is skipped if an update is already in progress. The goroutine is stopped by closing the
+// handler, see NewWithClose in the middleware packages and oidctoken.TokenHandler.Close.
+// Defaults to 0 and means the jwks is only downloaded when a token with an unknown key id is received.
+func WithBackgroundRefreshInterval(opt time.Duration) Option {
+	return func(opts *Options) {
+		opts.BackgroundRefreshInterval = opt
+	}
+}
+
+// WithMaxTokenLength sets the MaxTokenLength parameter for an Options pointer.
+// MaxTokenLength is the max length (in bytes) of a token, longer tokens are rejected
+// before being parsed with an error wrapping ErrTokenTooLong. Set to 0 to disable.
+// Defaults to 32768
+func WithMaxTokenLength(opt int) Option {
+	return func(opts *Options) {
+		opts.MaxTokenLength = opt
+	}
+}
+
 // WithRequiredTokenType sets the RequiredTokenType parameter for an Options pointer.
 // RequiredTokenType is used if only specific tokens should be allowed.
 // Default is empty string `""` and means all token types are allowed.
@@ -180,6 +782,49 @@ func WithRequiredTokenType(opt string) Option {
 	}
 }
 
+// WithRequiredTokenTypes sets the RequiredTokenTypes parameter for an Options pointer.
+// RequiredTokenTypes works like RequiredTokenType, but allows any of the token types, as an
+// example `JWT` and `at+jwt`. RequiredTokenType is also allowed if set.
+// Default is empty slice and means only RequiredTokenType is used.
+func WithRequiredTokenTypes(opt []string) Option {
+	return func(opts *Options) {
+		opts.RequiredTokenTypes = opt
+	}
+}
+
+// WithAllowMissingTokenType sets the AllowMissingTokenType parameter for an Options pointer.
+// AllowMissingTokenType accepts tokens without a TokenType in the header of the JWT even if
+// RequiredTokenType or RequiredTokenTypes is set, for providers that don't set it. Tokens with
+// another token type are still rejected.
+// Defaults to false
+func WithAllowMissingTokenType(opt bool) Option {
+	return func(opts *Options) {
+		opts.AllowMissingTokenType = opt
+	}
+}
+
+// WithRequiredTokenUse sets the RequiredTokenUse parameter for an Options pointer.
+// RequiredTokenUse is the required value of the `token_use` claim, used by AWS Cognito
+// to differentiate between access tokens (`access`) and id tokens (`id`) since Cognito
+// doesn't set the TokenType in the header of the JWT.
+// Default is empty string `""` and means the `token_use` claim isn't validated.
+func WithRequiredTokenUse(opt string) Option {
+	return func(opts *Options) {
+		opts.RequiredTokenUse = opt
+	}
+}
+
+// WithTokenTypeValidator sets the TokenTypeValidator parameter for an Options pointer.
+// TokenTypeValidator is called with the token type of each token and is used instead of
+// RequiredTokenType if not nil, as an example when the allowed token types (`at+jwt`,
+// `logout+jwt` or vendor specific types) are loaded dynamically and change over time.
+// Defaults to nil and means RequiredTokenType is used.
+func WithTokenTypeValidator(opt TokenTypeValidator) Option {
+	return func(opts *Options) {
+		opts.TokenTypeValidator = opt
+	}
+}
+
 // WithRequiredAudience sets the RequiredAudience parameter for an Options pointer.
 // RequiredAudience is used to require a specific Audience `aud` in the claims.
 // Defaults to empty string `""` and means all audiences are allowed.
@@ -189,6 +834,235 @@ func WithRequiredAudience(opt string) Option {
 	}
 }
 
+// WithRequiredAudiences sets the RequiredAudiences parameter for an Options pointer.
+// RequiredAudiences requires the Audience `aud` in the claims to contain at least one of the audiences,
+// as an example when tokens are issued for one of several clients. If RequiredAudience is also set,
+// it is handled as one more audience in the list, matching any of them is enough.
+// Defaults to nil and means all audiences are allowed.
+func WithRequiredAudiences(opt []string) Option {
+	return func(opts *Options) {
+		opts.RequiredAudiences = opt
+	}
+}
+
+// WithAudienceIsIssuer sets the AudienceIsIssuer parameter for an Options pointer.
+// AudienceIsIssuer requires the Audience `aud` in the claims to be the configured issuer,
+// as an example for self-issued service-to-service tokens. Can't be used together with RequiredAudience or RequiredAudiences.
+// Defaults to false
+func WithAudienceIsIssuer(opt bool) Option {
+	return func(opts *Options) {
+		opts.AudienceIsIssuer = opt
+	}
+}
+
+// WithAllowMissingAudience sets the AllowMissingAudience parameter for an Options pointer.
+// AllowMissingAudience skips the audience validation (RequiredAudience(s) or AudienceIsIssuer) for tokens
+// without the Audience `aud` claim. Tokens with an audience not matching the required one are still rejected.
+// Defaults to false
+func WithAllowMissingAudience(opt bool) Option {
+	return func(opts *Options) {
+		opts.AllowMissingAudience = opt
+	}
+}
+
+// WithAudienceClaimName sets the AudienceClaimName parameter for an Options pointer.
+// AudienceClaimName is the name of the claim RequiredAudience(s) is validated against.
+// Can be used with authorization servers that put the resource indicator in another
+// claim, like `resource`. The claim can be either a string or an array of strings.
+// Defaults to `aud`
+func WithAudienceClaimName(opt string) Option {
+	return func(opts *Options) {
+		opts.AudienceClaimName = opt
+	}
+}
+
+// WithRequireAudienceForRequestPath sets the RequireAudienceForRequestPath parameter for an Options pointer.
+// RequireAudienceForRequestPath requires, in addition to one of the required audiences, an audience
+// scoping the token to the path of the request: one of the required audiences followed by the
+// request path or a parent of it. As an example `https://api.example.com/orders` for the required
+// audience `https://api.example.com` and the request path `/orders/123`. The claim in AudienceClaimName
+// is used, which makes it possible to use resource indicators. The request path is added by the
+// middlewares, use oidctoken.WithRequestPath when parsing tokens directly. oidcgrpc uses the full
+// method (`/package.Service/Method`), oidcconnect the procedure and oidcextauthz the path of the
+// request sent by Envoy, without the query. oidchttp.AuthRequestHandler uses the X-Original-URI
+// header set by nginx, or the path of the subrequest if it isn't set.
+// Defaults to false
+func WithRequireAudienceForRequestPath(opt bool) Option {
+	return func(opts *Options) {
+		opts.RequireAudienceForRequestPath = opt
+	}
+}
+
+// WithClaimNamespace sets the ClaimNamespace parameter for an Options pointer.
+// ClaimNamespace is the prefix used by providers (like Auth0) for custom claims, as an
+// example `https://myapp.com/` for the claim `https://myapp.com/roles`. Claims with the
+// prefix are also made available without it (`roles`), so that ClaimsValidationFn and the
+// claims type don't need to know about the namespace. Claims already present in the token
+// are never overwritten. RequiredRoles, the required groups, RequiredClaimsPresent and
+// RequiredClaimsRegex also use the claims without the prefix.
+// Defaults to empty string `""` and means claims are used as is.
+func WithClaimNamespace(opt string) Option {
+	return func(opts *Options) {
+		opts.ClaimNamespace = opt
+	}
+}
+
+// WithRequiredRoles sets the RequiredRoles parameter for an Options pointer.
+// RequiredRoles requires all the roles to be present in the RolesClaimName claim.
+// The claim can be either an array of strings or a string delimited by RolesDelimiter.
+// Defaults to empty slice and means no roles are required.
+func WithRequiredRoles(opt []string) Option {
+	return func(opts *Options) {
+		opts.RequiredRoles = opt
+	}
+}
+
+// WithRequiredScopes sets the RequiredScopes parameter for an Options pointer.
+// RequiredScopes requires all the scopes to be present in the token. Scopes are read from
+// both the `scope` claim (space separated string) and the `scp` claim (string or array of
+// strings, used by Azure AD and Okta), which makes the same configuration work across providers.
+// Defaults to nil and means no scopes are required.
+func WithRequiredScopes(opt []string) Option {
+	return func(opts *Options) {
+		opts.RequiredScopes = opt
+	}
+}
+
+// WithRolesClaimName sets the RolesClaimName parameter for an Options pointer.
+// RolesClaimName is the name of the claim RequiredRoles is validated against.
+// Defaults to `roles`
+func WithRolesClaimName(opt string) Option {
+	return func(opts *Options) {
+		opts.RolesClaimName = opt
+	}
+}
+
+// WithRolesDelimiter sets the RolesDelimiter parameter for an Options pointer.
+// RolesDelimiter is used to split the RolesClaimName claim if it is a string.
+// Defaults to empty string `""` and means the string is split on both commas and whitespace.
+func WithRolesDelimiter(opt string) Option {
+	return func(opts *Options) {
+		opts.RolesDelimiter = opt
+	}
+}
+
+// WithRoleHierarchy sets the RoleHierarchy parameter for an Options pointer.
+// RoleHierarchy maps roles to the roles they imply, as an example
+// `{"admin": {"editor"}, "editor": {"viewer"}}` makes a token with the `admin` role fulfill
+// RequiredRoles `viewer`. The roles of the token are expanded transitively before RequiredRoles
+// is validated.
+// Defaults to empty map and means roles don't imply other roles.
+func WithRoleHierarchy(opt map[string][]string) Option {
+	return func(opts *Options) {
+		opts.RoleHierarchy = opt
+	}
+}
+
+// WithRequiredRealmRoles sets the RequiredRealmRoles parameter for an Options pointer.
+// RequiredRealmRoles requires all the roles to be present in the Keycloak realm roles
+// claim `realm_access.roles`.
+// Defaults to empty slice and means no realm roles are required.
+func WithRequiredRealmRoles(opt []string) Option {
+	return func(opts *Options) {
+		opts.RequiredRealmRoles = opt
+	}
+}
+
+// WithRequiredClientRoles sets the RequiredClientRoles parameter for an Options pointer.
+// RequiredClientRoles requires all the roles of each client (the map key) to be present in the
+// Keycloak client roles claim `resource_access.<client>.roles`.
+// Defaults to nil and means no client roles are required.
+func WithRequiredClientRoles(opt map[string][]string) Option {
+	return func(opts *Options) {
+		opts.RequiredClientRoles = opt
+	}
+}
+
+// WithRequiredGroupsAny sets the RequiredGroupsAny parameter for an Options pointer.
+// RequiredGroupsAny requires at least one of the groups to be present in the GroupsClaimName claim.
+// Defaults to empty slice and means no groups are required.
+func WithRequiredGroupsAny(opt []string) Option {
+	return func(opts *Options) {
+		opts.RequiredGroupsAny = opt
+	}
+}
+
+// WithRequiredGroupsAll sets the RequiredGroupsAll parameter for an Options pointer.
+// RequiredGroupsAll requires all the groups to be present in the GroupsClaimName claim.
+// Can be used together with RequiredGroupsAny, in which case both need to be satisfied.
+// Defaults to empty slice and means no groups are required.
+func WithRequiredGroupsAll(opt []string) Option {
+	return func(opts *Options) {
+		opts.RequiredGroupsAll = opt
+	}
+}
+
+// WithRequiredClaimsRegex sets the RequiredClaimsRegex parameter for an Options pointer.
+// RequiredClaimsRegex maps claim names to regular expressions (RE2 syntax) the claim values
+// are required to match, as an example `{"email": ".*@example\\.com$"}`. If the claim is an
+// array, at least one of the values needs to match. The expressions aren't anchored, use `^`
+// and `$` to match the whole value. The expressions are compiled when the handler is created.
+// Defaults to empty map and means no claims are matched.
+func WithRequiredClaimsRegex(opt map[string]string) Option {
+	return func(opts *Options) {
+		opts.RequiredClaimsRegex = opt
+	}
+}
+
+// WithRequiredClaimsPresent sets the RequiredClaimsPresent parameter for an Options pointer.
+// RequiredClaimsPresent are the claims required to be present in the token, with any value.
+// Use StrictClaimsPresence to also reject claims with an empty value.
+// Defaults to empty slice and means no claims are required to be present.
+func WithRequiredClaimsPresent(opt []string) Option {
+	return func(opts *Options) {
+		opts.RequiredClaimsPresent = opt
+	}
+}
+
+// WithStrictClaimsPresence sets the StrictClaimsPresence parameter for an Options pointer.
+// StrictClaimsPresence handles claims that are null, an empty string, an empty array or an
+// empty object as missing when validating RequiredClaimsPresent and RequiredClaimsRegex,
+// as an example to reject a token with `"email": ""` or `"groups": []`.
+// Defaults to false
+func WithStrictClaimsPresence(opt bool) Option {
+	return func(opts *Options) {
+		opts.StrictClaimsPresence = opt
+	}
+}
+
+// WithGroupsClaimName sets the GroupsClaimName parameter for an Options pointer.
+// GroupsClaimName is the name of the claim RequiredGroupsAny and RequiredGroupsAll are validated against.
+// The claim can be either an array of strings or a string delimited by commas or whitespace.
+// Defaults to `groups`
+func WithGroupsClaimName(opt string) Option {
+	return func(opts *Options) {
+		opts.GroupsClaimName = opt
+	}
+}
+
+// WithAttachRejectedToken sets the AttachRejectedToken parameter for an Options pointer.
+// AttachRejectedToken makes the handler return a RejectedTokenError containing the verified token
+// when the required claims, roles, groups or scopes validation fails, as an example to log the claims
+// of rejected tokens for fraud analysis. The token is never attached if the signature, issuer,
+// audience or expiration validation fails. The token is still rejected.
+// Defaults to false.
+func WithAttachRejectedToken(opt bool) Option {
+	return func(opts *Options) {
+		opts.AttachRejectedToken = opt
+	}
+}
+
+// WithStrictClaimsDecoding sets the StrictClaimsDecoding parameter for an Options pointer.
+// StrictClaimsDecoding rejects tokens where the payload contains the same key more than once
+// in a json object, like two `aud` claims. Different json parsers may interpret duplicate keys
+// differently, which can be used to bypass validation.
+// Defaults to false
+func WithStrictClaimsDecoding(opt bool) Option {
+	return func(opts *Options) {
+		opts.StrictClaimsDecoding = opt
+	}
+}
+
 // WithDisableKeyID sets the DisableKeyID parameter for an Options pointer.
 // DisableKeyID adjusts if a KeyID needs to be extracted from the token or not
 // Defaults to false and means KeyID is required to be present in both the jwks and token
@@ -203,6 +1077,246 @@ func WithDisableKeyID(opt bool) Option {
 	}
 }
 
+// WithMaxFallbackKeys sets the MaxFallbackKeys parameter for an Options pointer.
+// MaxFallbackKeys is the max number of other keys from the jwks tried when the signature can't
+// be verified using the key matching the key id of the token. A workaround for providers signing
+// tokens with a new key while still using the key id of the old key during a rotation.
+// Only keys with an allowed key type and the same signature algorithm as the token are tried.
+// Defaults to 0 and means only the key matching the key id is used.
+func WithMaxFallbackKeys(opt int) Option {
+	return func(opts *Options) {
+		opts.MaxFallbackKeys = opt
+	}
+}
+
+// WithAllowedKeyTypes sets the AllowedKeyTypes parameter for an Options pointer.
+// AllowedKeyTypes restricts which key types (kty) from the jwks can be used to
+// verify tokens. Keys of other types are ignored and tokens signed with them rejected.
+// Defaults to empty slice and means all key types are allowed.
+//
+// Example values: RSA EC OKP
+func WithAllowedKeyTypes(opt []string) Option {
+	return func(opts *Options) {
+		opts.AllowedKeyTypes = opt
+	}
+}
+
+// WithMinRSAKeyBits sets the MinRSAKeyBits parameter for an Options pointer.
+// MinRSAKeyBits is the minimum size (in bits) of the RSA keys used to verify tokens. Tokens
+// signed with a smaller key are rejected with an error wrapping ErrRSAKeyTooSmall, even if the
+// key is in the jwks. Smaller keys are also never used by DisableKeyID and MaxFallbackKeys.
+// Defaults to 0 and means RSA keys of all sizes are allowed.
+func WithMinRSAKeyBits(opt int) Option {
+	return func(opts *Options) {
+		opts.MinRSAKeyBits = opt
+	}
+}
+
+// WithAllowedSignatureAlgorithms sets the AllowedSignatureAlgorithms parameter for an Options pointer.
+// AllowedSignatureAlgorithms restricts which signature algorithms can be used by tokens, protecting
+// against algorithm substitution. Both the `alg` header of the token and the algorithm used to verify
+// it are required to be in the list. `none` can't be allowed.
+// Defaults to empty slice and means all algorithms supported by the keys are allowed.
+//
+// Example values: RS256 ES256
+func WithAllowedSignatureAlgorithms(opt []string) Option {
+	return func(opts *Options) {
+		opts.AllowedSignatureAlgorithms = opt
+	}
+}
+
+// WithDeprecatedKeyIDs sets the DeprecatedKeyIDs parameter for an Options pointer.
+// DeprecatedKeyIDs are key ids (kid) that are still accepted but are about to be removed.
+// OnDeprecatedKeyUsed is called when a token is validated using one of them.
+// Defaults to empty slice and means no keys are deprecated.
+func WithDeprecatedKeyIDs(opt []string) Option {
+	return func(opts *Options) {
+		opts.DeprecatedKeyIDs = opt
+	}
+}
+
+// WithOnDeprecatedKeyUsed sets the OnDeprecatedKeyUsed parameter for an Options pointer.
+// OnDeprecatedKeyUsed is called with the key id (kid) when a token is successfully validated
+// using a key in DeprecatedKeyIDs. Can be used to log or emit metrics to track lingering
+// usage of a key before removing it.
+// Defaults to nil
+func WithOnDeprecatedKeyUsed(opt func(kid string)) Option {
+	return func(opts *Options) {
+		opts.OnDeprecatedKeyUsed = opt
+	}
+}
+
+// WithBlockedKeyIDs sets the BlockedKeyIDs parameter for an Options pointer.
+// BlockedKeyIDs are key ids (kid) that are rejected even if they are still in the jwks, as an
+// example after a key has been compromised and until the provider removes it. Tokens with a
+// blocked key id in the header are rejected before the key is looked up, with an error wrapping
+// ErrBlockedKeyID. Blocked keys are also never used by DisableKeyID and MaxFallbackKeys.
+// Defaults to empty slice and means no keys are blocked.
+func WithBlockedKeyIDs(opt []string) Option {
+	return func(opts *Options) {
+		opts.BlockedKeyIDs = opt
+	}
+}
+
+// WithVerifier adds a Verifier for a key type (kty) to the Verifiers parameter for an Options pointer.
+// Verifiers makes it possible to delegate the signature verification of tokens signed
+// with keys of a specific key type to an external verifier, like a cloud KMS.
+// Can be used multiple times to configure verifiers for different key types.
+// Defaults to empty map and means all signatures are verified locally.
+//
+// Example values for kty: RSA EC OKP
+func WithVerifier(kty string, verifier Verifier) Option {
+	return func(opts *Options) {
+		if opts.Verifiers == nil {
+			opts.Verifiers = make(map[string]Verifier)
+		}
+
+		opts.Verifiers[kty] = verifier
+	}
+}
+
+// WithNonceFromContextFn sets the NonceFromContextFn parameter for an Options pointer.
+// NonceFromContextFn is used to get the expected nonce from the request context. When
+// a nonce is expected, the `nonce` claim of the token is required to match it and the
+// token is required to be issued (`iat`) within NonceMaxAge.
+// Defaults to nil and means no nonce validation is done.
+func WithNonceFromContextFn(opt NonceFromContextFn) Option {
+	return func(opts *Options) {
+		opts.NonceFromContextFn = opt
+	}
+}
+
+// WithNonceMaxAge sets the NonceMaxAge parameter for an Options pointer.
+// NonceMaxAge is the maximum age of the token, based on the issued at (`iat`) claim,
+// when a nonce is expected. AllowedTokenDrift is added to allow for time drift between parties.
+// Only used together with NonceFromContextFn.
+// Defaults to 5 minutes
+func WithNonceMaxAge(opt time.Duration) Option {
+	return func(opts *Options) {
+		opts.NonceMaxAge = opt
+	}
+}
+
+// WithRevokedTokenIDs sets the RevokedTokenIDs parameter for an Options pointer.
+// RevokedTokenIDs are token ids (`jti`) that are rejected with an error wrapping ErrRevokedToken,
+// even if the token is otherwise valid. Tokens without a `jti` aren't affected. Cached tokens are
+// also checked, see TokenCacheTTL.
+// Defaults to empty slice and means no tokens are revoked.
+func WithRevokedTokenIDs(opt []string) Option {
+	return func(opts *Options) {
+		opts.RevokedTokenIDs = opt
+	}
+}
+
+// WithRevokedTokenIDsFn sets the RevokedTokenIDsFn parameter for an Options pointer.
+// RevokedTokenIDsFn returns the current set of revoked token ids (`jti`), for revocation lists
+// that are updated at runtime. It's used together with RevokedTokenIDs and tokens are rejected
+// if it returns an error.
+// Defaults to nil
+func WithRevokedTokenIDsFn(opt RevokedTokenIDsFn) Option {
+	return func(opts *Options) {
+		opts.RevokedTokenIDsFn = opt
+	}
+}
+
+// WithClaimsValidator sets the ClaimsValidator parameter for an Options pointer.
+// ClaimsValidator is called with the request context and the claims of the token, after the
+// signature, issuer, audience and all other validations (including the ClaimsValidationFn) have
+// passed. It's called for every request and its outcome isn't stored in the DecisionCache.
+// Defaults to nil
+func WithClaimsValidator(opt ClaimsValidator) Option {
+	return func(opts *Options) {
+		opts.ClaimsValidator = opt
+	}
+}
+
+// WithDecisionCache sets the DecisionCache parameter for an Options pointer.
+// DecisionCache stores the outcome of the validations run after the token signature has been
+// verified (issuer, audience, roles and the ClaimsValidationFn), keyed by a hash of the token
+// and the PolicyID. Cached outcomes are reused until the token expires or the policy is changed
+// using the setters of the handler. The expiration, the max auth age and the nonce are always
+// validated. The same cache can be shared by multiple handlers as long as they use different
+// PolicyID. Tokens without `exp` aren't cached.
+// Defaults to nil
+func WithDecisionCache(opt DecisionCache) Option {
+	return func(opts *Options) {
+		opts.DecisionCache = opt
+	}
+}
+
+// WithPolicyID sets the PolicyID parameter for an Options pointer.
+// PolicyID identifies the validations configured for the handler in the DecisionCache.
+// Needs to be unique for each handler sharing a DecisionCache.
+// Defaults to ""
+func WithPolicyID(opt string) Option {
+	return func(opts *Options) {
+		opts.PolicyID = opt
+	}
+}
+
+// WithTokenCacheTTL sets the TokenCacheTTL parameter for an Options pointer.
+// TokenCacheTTL enables an in-memory cache of verified tokens, keyed by a hash of the token,
+// making repeated requests with the same token skip the signature verification. Tokens are
+// cached for at most TokenCacheTTL and never after they expire. Cached tokens are only used
+// while the jwks they were verified with is current and the claims are validated on every
+// request. Tokens without `exp` aren't cached.
+// Defaults to 0 and means the token cache is disabled.
+func WithTokenCacheTTL(opt time.Duration) Option {
+	return func(opts *Options) {
+		opts.TokenCacheTTL = opt
+	}
+}
+
+// WithTokenCacheSize sets the TokenCacheSize parameter for an Options pointer.
+// TokenCacheSize is the max number of tokens in the token cache, the least recently used
+// token is evicted when it's full. Only used if TokenCacheTTL is set, it then needs to be larger than 0.
+// Defaults to 10000
+func WithTokenCacheSize(opt int) Option {
+	return func(opts *Options) {
+		opts.TokenCacheSize = opt
+	}
+}
+
+// WithShouldCacheFunc sets the ShouldCacheFunc parameter for an Options pointer.
+// ShouldCacheFunc is called before a token is stored in the token cache, as an example to
+// always verify tokens with elevated privileges. Only used if TokenCacheTTL is set.
+// Defaults to nil and means all tokens with `exp` are cached.
+func WithShouldCacheFunc(opt ShouldCacheFunc) Option {
+	return func(opts *Options) {
+		opts.ShouldCacheFunc = opt
+	}
+}
+
+// WithMetrics sets the Metrics parameter for an Options pointer.
+// Metrics is called with the outcome of each token validation and the latency of each jwks
+// download, as an example to expose Prometheus metrics without depending on Prometheus.
+// Defaults to nil and means no metrics are collected.
+func WithMetrics(opt Metrics) Option {
+	return func(opts *Options) {
+		opts.Metrics = opt
+	}
+}
+
+// WithLogger sets the Logger parameter for an Options pointer.
+// Logger is used to emit debug logs about jwks refreshes, key ids missing from the jwks,
+// rate limited refreshes and validation failures (including the failure reason).
+// Defaults to nil and means nothing is logged.
+func WithLogger(opt Logger) Option {
+	return func(opts *Options) {
+		opts.Logger = opt
+	}
+}
+
+// WithTimingsFn sets the TimingsFn parameter for an Options pointer.
+// TimingsFn is called with a breakdown of the time spent parsing each token, as an example
+// to find out if jwks refreshes or the signature verification dominate the latency.
+// Defaults to nil and means no timings are recorded.
+func WithTimingsFn(opt TimingsFn) Option {
+	return func(opts *Options) {
+		opts.TimingsFn = opt
+	}
+}
+
 // WithHttpClient sets the HttpClient parameter for an Options pointer.
 // HttpClient takes a *http.Client for external calls
 // Defaults to http.DefaultClient
@@ -212,6 +1326,17 @@ func WithHttpClient(opt *http.Client) Option {
 	}
 }
 
+// WithJwksHttpClient sets the JwksHttpClient parameter for an Options pointer.
+// JwksHttpClient takes a *http.Client used only for the discovery and jwks calls.
+// Can be used to add retries, logging or request ids to these calls, using a custom
+// http.RoundTripper, without changing the http.Client shared with the rest of the application.
+// Defaults to HttpClient
+func WithJwksHttpClient(opt *http.Client) Option {
+	return func(opts *Options) {
+		opts.JwksHttpClient = opt
+	}
+}
+
 // WithTokenString sets the TokenString parameter for an Options pointer.
 // TokenString makes it possible to configure how the JWT token should be extracted from
 // an http header. Not supported by Echo JWT and will be ignored if used by it.
@@ -225,6 +1350,73 @@ func WithTokenString(setters ...TokenStringOption) Option {
 	}
 }
 
+// WithTokenCookieName sets the TokenCookieName parameter for an Options pointer.
+// TokenCookieName makes the token to also be read from the cookie with this name, as an
+// example for a browser application storing the token in an HttpOnly cookie.
+// The header(s) configured with TokenString are preferred and the cookie is only used as a
+// fallback if no token could be extracted from them. The cookie value is used as is, without
+// expecting a `Bearer ` prefix. Echo JWT extracts the token itself, use
+// `oidcechojwt.TokenLookup()` to configure its `TokenLookup`.
+// Default: ""
+func WithTokenCookieName(opt string) Option {
+	return func(opts *Options) {
+		opts.TokenCookieName = opt
+	}
+}
+
+// WithTokenSources sets the TokenSources parameter for an Options pointer.
+// TokenSources makes the token to be read from the first of the sources sent with a non-empty value,
+// trying them in order, as an example `Authorization` header, then cookie and then query parameter.
+// The first present source is used even if the token can't be extracted from it, the other sources
+// are never tried. Can't be used together with TokenString, TokenCookieName or GetTokenStringFn,
+// use HeaderTokenSource and CookieTokenSource instead. Fiber converts its request to an `*http.Request`
+// to use it, Echo JWT extracts the token itself, use `oidcechojwt.TokenLookupFuncs()` together with it.
+// Not supported by oidcgrpc and oidcconnect, which read the token from the metadata or headers using
+// TokenString.
+// Default: nil
+func WithTokenSources(opt ...TokenSource) Option {
+	return func(opts *Options) {
+		opts.TokenSources = opt
+	}
+}
+
+// WithGetTokenStringFn sets the GetTokenStringFn parameter for an Options pointer.
+// GetTokenStringFn is used instead of the built-in token extraction if not nil, as an example
+// to read the token from a query parameter during an EventSource or WebSocket handshake.
+// An empty token string is handled as an error. Fiber converts its request to an `*http.Request`
+// before calling it. Echo JWT extracts the token itself, use `oidcechojwt.TokenLookupFuncs()`
+// to configure its `TokenLookupFuncs`.
+// Default: nil
+func WithGetTokenStringFn(opt GetTokenStringFn) Option {
+	return func(opts *Options) {
+		opts.GetTokenStringFn = opt
+	}
+}
+
+// WithSubjectFn sets the SubjectFn parameter for an Options pointer.
+// SubjectFn is called with the request and the subject of the token after the token has been
+// validated, as an example to include the subject in the access logs of the application.
+// Fiber converts its request to an `*http.Request` before calling it. Isn't used by the
+// Envoy external authorization server, since it doesn't receive an `*http.Request`.
+// Default: nil
+func WithSubjectFn(opt SubjectFn) Option {
+	return func(opts *Options) {
+		opts.SubjectFn = opt
+	}
+}
+
+// WithTokenExpiresInHeader sets the TokenExpiresInHeader parameter for an Options pointer.
+// TokenExpiresInHeader is the name of a response header, as an example `X-Token-Expires-In`,
+// set to the number of seconds the token remains valid based on its `exp` claim. Can be used
+// by clients to refresh the token before it expires. The header is set by oidchttp.New, oidcgin,
+// oidcfiber and oidcechojwt after the token has been validated and isn't set for tokens without `exp`.
+// Default: ""
+func WithTokenExpiresInHeader(opt string) Option {
+	return func(opts *Options) {
+		opts.TokenExpiresInHeader = opt
+	}
+}
+
 // WithClaimsContextKeyName sets the ClaimsContextKeyName parameter for an Options pointer.
 // ClaimsContextKeyName is the name of key that will be used to pass claims using request context.
 // Not supported by Echo JWT and will be ignored if used by it.
@@ -252,3 +1444,50 @@ func WithErrorHandler(opt ErrorHandler) Option {
 		opts.ErrorHandler = opt
 	}
 }
+
+// WithErrorResponseHandler sets the ErrorResponseHandler parameter for an Options pointer.
+// ErrorResponseHandler is called by oidchttp.New instead of writing the default error response,
+// which is the status code together with a RFC 6750 `WWW-Authenticate` header. ErrorHandler is
+// still called before it. The error wraps options.ErrJwksUnavailable if the jwks can't be fetched.
+// Defaults to nil
+func WithErrorResponseHandler(opt ErrorResponseHandler) Option {
+	return func(opts *Options) {
+		opts.ErrorResponseHandler = opt
+	}
+}
+
+// WithRealm sets the Realm parameter for an Options pointer.
+// Realm is used as the `realm` of the RFC 6750 `WWW-Authenticate` header written by oidchttp.New
+// for requests without a token, as an example `Bearer realm="api"`.
+// Defaults to empty string and means the header doesn't contain a realm.
+func WithRealm(opt string) Option {
+	return func(opts *Options) {
+		opts.Realm = opt
+	}
+}
+
+// WithAuthRequestClaimHeaders sets the AuthRequestClaimHeaders parameter for an Options pointer.
+// AuthRequestClaimHeaders maps claim names to the response headers set by `oidchttp.AuthRequestHandler`
+// for valid tokens, as an example `map[string]string{"sub": "X-Auth-Subject"}`. The proxy (nginx
+// `auth_request_set` or Envoy `allowed_upstream_headers`) can then forward them to the upstream.
+// Lists are joined with a comma and objects are encoded as json. Missing claims are skipped.
+// Only used by `oidchttp.AuthRequestHandler` and `oidcextauthz.New`.
+// Defaults to nil
+func WithAuthRequestClaimHeaders(opt map[string]string) Option {
+	return func(opts *Options) {
+		opts.AuthRequestClaimHeaders = opt
+	}
+}
+
+// WithAudienceFromTLSServerName sets the AudienceFromTLSServerName parameter for an Options pointer.
+// AudienceFromTLSServerName requires the audience of the token to be the TLS server name (SNI) the
+// client connected with, as an example for SNI based multi-tenancy. It replaces RequiredAudience,
+// RequiredAudiences and AudienceIsIssuer and, unlike the Host header, can't differ from the
+// certificate the client validated. Requests without a TLS server name are rejected.
+// Only used by `oidchttp.New`.
+// Defaults to false
+func WithAudienceFromTLSServerName(opt bool) Option {
+	return func(opts *Options) {
+		opts.AudienceFromTLSServerName = opt
+	}
+}
@@ -9,66 +9,253 @@ import (
 )
 
 func TestOptions(t *testing.T) {
+	decisionCache := NewMemoryDecisionCache()
+
 	expectedResult := &Options{
-		Issuer:                     "foo",
-		DiscoveryUri:               "foo",
-		DiscoveryFetchTimeout:      1234 * time.Second,
-		JwksUri:                    "foo",
-		JwksFetchTimeout:           1234 * time.Second,
-		JwksRateLimit:              1234,
-		FallbackSignatureAlgorithm: "foo",
-		AllowedTokenDrift:          1234 * time.Second,
-		LazyLoadJwks:               true,
-		RequiredTokenType:          "foo",
-		RequiredAudience:           "foo",
-		DisableKeyID:               true,
+		Issuer:                        "foo",
+		IssuerAliases:                 []string{"foo"},
+		Issuers:                       []IssuerConfig{{Issuer: "bar", DiscoveryUri: "baz", JwksUri: "qux"}},
+		DiscoveryUri:                  "foo",
+		DiscoveryMode:                 OAuth2MetadataDiscoveryMode,
+		UseDiscoveryIssuer:            true,
+		DiscoveryFetchTimeout:         1234 * time.Second,
+		JwksUri:                       "foo",
+		JwksFetchTimeout:              1234 * time.Second,
+		JwksFetchRetries:              1234,
+		JwksFetchRetryDelay:           1234 * time.Second,
+		JwksRateLimit:                 1234,
+		JwksResponseExtractor:         nil,
+		KeySourceFunc:                 nil,
+		JwksJSON:                      []byte(`{"keys":[]}`),
+		PendingJwks:                   nil,
+		DecryptionKeys:                nil,
+		RequireJwksSameHostAsIssuer:   true,
+		AllowInsecureIssuer:           true,
+		IntrospectionUri:              "foo",
+		IntrospectionClientID:         "foo",
+		IntrospectionClientSecret:     "bar",
+		IntrospectionFetchTimeout:     1234 * time.Second,
+		FallbackSignatureAlgorithm:    "foo",
+		AllowES256K:                   true,
+		AllowedTokenDrift:             1234 * time.Second,
+		MaxAllowedTokenDrift:          1234 * time.Second,
+		AbsoluteMaxExpiryAge:          1234 * time.Second,
+		NowFn:                         nil,
+		MaxAuthAge:                    1234 * time.Second,
+		MaxTokenAge:                   1234 * time.Second,
+		LazyLoadJwks:                  true,
+		LazyLoadJwksBackoff:           1234 * time.Second,
+		BackgroundRefreshInterval:     1234 * time.Second,
+		MaxTokenLength:                1234,
+		RequiredTokenType:             "foo",
+		RequiredTokenTypes:            []string{"bar"},
+		AllowMissingTokenType:         true,
+		RequiredTokenUse:              "foo",
+		TokenTypeValidator:            nil,
+		RequiredAudience:              "foo",
+		RequiredAudiences:             []string{"foo", "bar"},
+		AudienceIsIssuer:              true,
+		AllowMissingAudience:          true,
+		AudienceClaimName:             "foo",
+		RequireAudienceForRequestPath: true,
+		ClaimNamespace:                "foo",
+		RequiredRoles:                 []string{"foo"},
+		RequiredScopes:                []string{"foo"},
+		RolesClaimName:                "foo",
+		RolesDelimiter:                "foo",
+		RoleHierarchy:                 map[string][]string{"admin": {"viewer"}},
+		RequiredRealmRoles:            []string{"foo"},
+		RequiredClientRoles:           map[string][]string{"foo": {"bar"}},
+		RequiredGroupsAny:             []string{"foo"},
+		RequiredGroupsAll:             []string{"bar"},
+		RequiredClaimsRegex:           map[string]string{"email": ".*@example\\.com$"},
+		RequiredClaimsPresent:         []string{"email"},
+		StrictClaimsPresence:          true,
+		GroupsClaimName:               "foo",
+		StrictClaimsDecoding:          true,
+		AttachRejectedToken:           true,
+		DisableKeyID:                  true,
+		MaxFallbackKeys:               1234,
+		AllowedKeyTypes:               []string{"foo"},
+		MinRSAKeyBits:                 1234,
+		AllowedSignatureAlgorithms:    []string{"foo"},
+		DeprecatedKeyIDs:              []string{"foo"},
+		OnDeprecatedKeyUsed:           nil,
+		BlockedKeyIDs:                 []string{"bar"},
+		Verifiers: map[string]Verifier{
+			"foo": nil,
+		},
+		NonceFromContextFn: nil,
+		NonceMaxAge:        1234 * time.Second,
+		RevokedTokenIDs:    []string{"foo"},
+		RevokedTokenIDsFn:  nil,
+		ClaimsValidator:    nil,
+		DecisionCache:      decisionCache,
+		PolicyID:           "foo",
+		TokenCacheTTL:      1234 * time.Second,
+		TokenCacheSize:     1234,
+		ShouldCacheFunc:    nil,
+		TimingsFn:          nil,
+		Metrics:            nil,
+		Logger:             nil,
 		HttpClient: &http.Client{
 			Timeout: 1234 * time.Second,
 		},
-		TokenString:          nil,
+		JwksHttpClient: &http.Client{
+			Timeout: 4321 * time.Second,
+		},
+		TokenString:     nil,
+		TokenCookieName: "foobar",
+		TokenSources: []TokenSource{
+			{Type: CookieTokenSourceType, Name: "access_token"},
+			{Type: QueryTokenSourceType, Name: "access_token"},
+		},
+		GetTokenStringFn:     nil,
+		SubjectFn:            nil,
+		TokenExpiresInHeader: "X-Token-Expires-In",
 		ClaimsContextKeyName: ClaimsContextKeyName("foo"),
 		ErrorHandler:         nil,
+		ErrorResponseHandler: nil,
+		Realm:                "api",
+		AuthRequestClaimHeaders: map[string]string{
+			"sub": "X-Auth-Subject",
+		},
+		AudienceFromTLSServerName: true,
 	}
 
 	expectedFirstTokenString := &TokenStringOptions{
-		HeaderName:    "foo",
-		TokenPrefix:   "bar_",
-		ListSeparator: ",",
+		HeaderName:            "foo",
+		TokenPrefix:           "bar_",
+		ListSeparator:         ",",
+		HeaderValuePrecedence: LastHeaderValue,
 	}
 
 	expectedSecondTokenString := &TokenStringOptions{
 		HeaderName:    "too",
 		TokenPrefix:   "lar_",
+		TokenSchemes:  []string{"Bearer", "Token"},
 		ListSeparator: "",
+		BasicAuthFn:   nil,
+		CookieName:    "baz",
 	}
 
 	setters := []Option{
 		WithIssuer("foo"),
+		WithIssuerAliases([]string{"foo"}),
+		WithIssuers(IssuerConfig{Issuer: "bar", DiscoveryUri: "baz", JwksUri: "qux"}),
 		WithDiscoveryUri("foo"),
+		WithDiscoveryMode(OAuth2MetadataDiscoveryMode),
+		WithUseDiscoveryIssuer(true),
 		WithDiscoveryFetchTimeout(1234 * time.Second),
 		WithJwksUri("foo"),
 		WithJwksFetchTimeout(1234 * time.Second),
+		WithJwksFetchRetries(1234),
+		WithJwksFetchRetryDelay(1234 * time.Second),
 		WithJwksRateLimit(1234),
+		WithJwksResponseExtractor(nil),
+		WithKeySourceFunc(nil),
+		WithJwksJSON([]byte(`{"keys":[]}`)),
+		WithPendingJwks(nil),
+		WithDecryptionKeys(nil),
+		WithRequireJwksSameHostAsIssuer(true),
+		WithAllowInsecureIssuer(true),
+		WithIntrospectionUri("foo"),
+		WithIntrospectionClientID("foo"),
+		WithIntrospectionClientSecret("bar"),
+		WithIntrospectionFetchTimeout(1234 * time.Second),
 		WithFallbackSignatureAlgorithm("foo"),
+		WithAllowES256K(true),
 		WithAllowedTokenDrift(1234 * time.Second),
+		WithMaxAllowedTokenDrift(1234 * time.Second),
+		WithAbsoluteMaxExpiryAge(1234 * time.Second),
+		WithNowFn(nil),
+		WithMaxAuthAge(1234 * time.Second),
+		WithMaxTokenAge(1234 * time.Second),
 		WithLazyLoadJwks(true),
+		WithLazyLoadJwksBackoff(1234 * time.Second),
+		WithBackgroundRefreshInterval(1234 * time.Second),
+		WithMaxTokenLength(1234),
 		WithRequiredTokenType("foo"),
+		WithRequiredTokenTypes([]string{"bar"}),
+		WithAllowMissingTokenType(true),
+		WithRequiredTokenUse("foo"),
+		WithTokenTypeValidator(nil),
 		WithRequiredAudience("foo"),
+		WithRequiredAudiences([]string{"foo", "bar"}),
+		WithAudienceIsIssuer(true),
+		WithAllowMissingAudience(true),
+		WithAudienceClaimName("foo"),
+		WithRequireAudienceForRequestPath(true),
+		WithClaimNamespace("foo"),
+		WithRequiredRoles([]string{"foo"}),
+		WithRequiredScopes([]string{"foo"}),
+		WithRolesClaimName("foo"),
+		WithRolesDelimiter("foo"),
+		WithRoleHierarchy(map[string][]string{"admin": {"viewer"}}),
+		WithRequiredRealmRoles([]string{"foo"}),
+		WithRequiredClientRoles(map[string][]string{"foo": {"bar"}}),
+		WithRequiredGroupsAny([]string{"foo"}),
+		WithRequiredGroupsAll([]string{"bar"}),
+		WithRequiredClaimsRegex(map[string]string{"email": ".*@example\\.com$"}),
+		WithRequiredClaimsPresent([]string{"email"}),
+		WithStrictClaimsPresence(true),
+		WithGroupsClaimName("foo"),
+		WithStrictClaimsDecoding(true),
+		WithAttachRejectedToken(true),
 		WithDisableKeyID(true),
+		WithMaxFallbackKeys(1234),
+		WithAllowedKeyTypes([]string{"foo"}),
+		WithMinRSAKeyBits(1234),
+		WithAllowedSignatureAlgorithms([]string{"foo"}),
+		WithDeprecatedKeyIDs([]string{"foo"}),
+		WithOnDeprecatedKeyUsed(nil),
+		WithBlockedKeyIDs([]string{"bar"}),
+		WithVerifier("foo", nil),
+		WithNonceFromContextFn(nil),
+		WithNonceMaxAge(1234 * time.Second),
+		WithRevokedTokenIDs([]string{"foo"}),
+		WithRevokedTokenIDsFn(nil),
+		WithClaimsValidator(nil),
+		WithDecisionCache(decisionCache),
+		WithPolicyID("foo"),
+		WithTokenCacheTTL(1234 * time.Second),
+		WithTokenCacheSize(1234),
+		WithShouldCacheFunc(nil),
+		WithTimingsFn(nil),
+		WithMetrics(nil),
+		WithLogger(nil),
 		WithHttpClient(&http.Client{
 			Timeout: 1234 * time.Second,
 		}),
+		WithJwksHttpClient(&http.Client{
+			Timeout: 4321 * time.Second,
+		}),
 		WithTokenString(
 			WithTokenStringHeaderName("foo"),
 			WithTokenStringTokenPrefix("bar_"),
 			WithTokenStringListSeparator(","),
+			WithTokenStringHeaderValuePrecedence(LastHeaderValue),
 		),
 		WithTokenString(
 			WithTokenStringHeaderName("too"),
 			WithTokenStringTokenPrefix("lar_"),
+			WithTokenStringTokenSchemes([]string{"Bearer", "Token"}),
+			WithTokenStringBasicAuthFn(nil),
+			WithTokenStringCookieName("baz"),
 		),
+		WithTokenCookieName("foobar"),
+		WithTokenSources(CookieTokenSource("access_token"), QueryTokenSource("access_token")),
+		WithGetTokenStringFn(nil),
+		WithSubjectFn(nil),
+		WithTokenExpiresInHeader("X-Token-Expires-In"),
 		WithClaimsContextKeyName("foo"),
 		WithErrorHandler(nil),
+		WithErrorResponseHandler(nil),
+		WithRealm("api"),
+		WithAuthRequestClaimHeaders(map[string]string{
+			"sub": "X-Auth-Subject",
+		}),
+		WithAudienceFromTLSServerName(true),
 	}
 
 	result := &Options{}
@@ -0,0 +1,62 @@
+package options
+
+const (
+	// GoogleIssuer is the issuer of Google ID tokens.
+	GoogleIssuer = "https://accounts.google.com"
+	// GoogleJwksUri is the uri of the jwks used to sign Google ID tokens.
+	GoogleJwksUri = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+// ProfileGoogle sets the options used to validate Google ID tokens:
+// - Issuer `https://accounts.google.com`, also accepting `accounts.google.com` in the Issuer `iss` claim
+// - JwksUri `https://www.googleapis.com/oauth2/v3/certs`, skipping the discovery
+// - RequiredAudience is the OAuth 2.0 client id
+// - RequiredTokenType `JWT`
+//
+// Options set after ProfileGoogle override the ones set by it.
+func ProfileGoogle(clientID string) Option {
+	return func(opts *Options) {
+		opts.Issuer = GoogleIssuer
+		opts.IssuerAliases = []string{"accounts.google.com"}
+		opts.JwksUri = GoogleJwksUri
+		opts.RequiredAudience = clientID
+		opts.RequiredTokenType = "JWT"
+	}
+}
+
+// ProfileCognito sets the options used to validate AWS Cognito access tokens for a user pool:
+// - Issuer is the user pool url, as an example `https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_foo`
+// - RequiredAudience is the app client id, validated against the `client_id` claim since
+// Cognito access tokens don't contain an Audience `aud` claim
+// - RequiredTokenUse `access`, rejecting id tokens
+// - GroupsClaimName `cognito:groups`
+//
+// Use RequiredGroupsAny and RequiredGroupsAll to require Cognito groups. To validate id tokens,
+// use RequiredTokenUse `id` and AudienceClaimName `aud`.
+// Options set after ProfileCognito override the ones set by it.
+func ProfileCognito(issuer string, clientID string) Option {
+	return func(opts *Options) {
+		opts.Issuer = issuer
+		opts.RequiredAudience = clientID
+		opts.AudienceClaimName = "client_id"
+		opts.RequiredTokenUse = "access"
+		opts.GroupsClaimName = "cognito:groups"
+	}
+}
+
+// ProfileKeycloak sets the options used to validate Keycloak access tokens for a realm:
+// - Issuer is the realm url, as an example `https://keycloak.example.com/realms/foo`
+// - RequiredAudience is the client id, validated against the Authorized party `azp` claim since
+// Keycloak uses `account` as the Audience `aud` unless an audience mapper is configured
+// - RequiredTokenType `JWT`
+//
+// Use RequiredRealmRoles and RequiredClientRoles to require Keycloak roles.
+// Options set after ProfileKeycloak override the ones set by it.
+func ProfileKeycloak(issuer string, clientID string) Option {
+	return func(opts *Options) {
+		opts.Issuer = issuer
+		opts.RequiredAudience = clientID
+		opts.AudienceClaimName = "azp"
+		opts.RequiredTokenType = "JWT"
+	}
+}
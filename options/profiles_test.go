@@ -0,0 +1,43 @@
+package options
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileGoogle(t *testing.T) {
+	opts := New(ProfileGoogle("foo"))
+	require.Equal(t, "https://accounts.google.com", opts.Issuer)
+	require.Equal(t, []string{"accounts.google.com"}, opts.IssuerAliases)
+	require.Equal(t, "https://www.googleapis.com/oauth2/v3/certs", opts.JwksUri)
+	require.Equal(t, "foo", opts.RequiredAudience)
+	require.Equal(t, "JWT", opts.RequiredTokenType)
+
+	opts = New(ProfileGoogle("foo"), WithJwksUri("bar"))
+	require.Equal(t, "bar", opts.JwksUri)
+}
+
+func TestProfileCognito(t *testing.T) {
+	opts := New(ProfileCognito("https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_foo", "bar"))
+	require.Equal(t, "https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_foo", opts.Issuer)
+	require.Equal(t, "bar", opts.RequiredAudience)
+	require.Equal(t, "client_id", opts.AudienceClaimName)
+	require.Equal(t, "access", opts.RequiredTokenUse)
+	require.Equal(t, "cognito:groups", opts.GroupsClaimName)
+
+	opts = New(ProfileCognito("https://cognito-idp.eu-west-1.amazonaws.com/eu-west-1_foo", "bar"), WithRequiredTokenUse("id"), WithAudienceClaimName("aud"))
+	require.Equal(t, "id", opts.RequiredTokenUse)
+	require.Equal(t, "aud", opts.AudienceClaimName)
+}
+
+func TestProfileKeycloak(t *testing.T) {
+	opts := New(ProfileKeycloak("https://keycloak.example.com/realms/foo", "bar"))
+	require.Equal(t, "https://keycloak.example.com/realms/foo", opts.Issuer)
+	require.Equal(t, "bar", opts.RequiredAudience)
+	require.Equal(t, "azp", opts.AudienceClaimName)
+	require.Equal(t, "JWT", opts.RequiredTokenType)
+
+	opts = New(ProfileKeycloak("https://keycloak.example.com/realms/foo", "bar"), WithAudienceClaimName("aud"))
+	require.Equal(t, "aud", opts.AudienceClaimName)
+}
@@ -0,0 +1,60 @@
+package options
+
+// TokenSourceType defines which part of the request a TokenSource extracts the token from.
+type TokenSourceType int
+
+const (
+	// HeaderTokenSourceType extracts the token from a header, configured using TokenStringOption setters.
+	HeaderTokenSourceType TokenSourceType = iota
+	// CookieTokenSourceType extracts the token from a cookie, the value is used as is.
+	CookieTokenSourceType
+	// QueryTokenSourceType extracts the token from a query parameter, the value is used as is.
+	QueryTokenSourceType
+)
+
+func (t TokenSourceType) String() string {
+	switch t {
+	case HeaderTokenSourceType:
+		return "header"
+	case CookieTokenSourceType:
+		return "cookie"
+	case QueryTokenSourceType:
+		return "query parameter"
+	default:
+		return "unknown"
+	}
+}
+
+// TokenSource is a typed token extractor used with WithTokenSources.
+// Use HeaderTokenSource, CookieTokenSource or QueryTokenSource to create it.
+type TokenSource struct {
+	Type        TokenSourceType
+	Name        string
+	TokenString []TokenStringOption
+}
+
+// HeaderTokenSource returns a TokenSource extracting the token from a header, configured the same
+// way as WithTokenString. Without setters, the token is read from the `Authorization: Bearer` header.
+func HeaderTokenSource(setters ...TokenStringOption) TokenSource {
+	return TokenSource{
+		Type:        HeaderTokenSourceType,
+		Name:        NewTokenString(setters...).HeaderName,
+		TokenString: setters,
+	}
+}
+
+// CookieTokenSource returns a TokenSource extracting the token from the cookie with the name.
+func CookieTokenSource(name string) TokenSource {
+	return TokenSource{
+		Type: CookieTokenSourceType,
+		Name: name,
+	}
+}
+
+// QueryTokenSource returns a TokenSource extracting the token from the query parameter with the name.
+func QueryTokenSource(name string) TokenSource {
+	return TokenSource{
+		Type: QueryTokenSourceType,
+		Name: name,
+	}
+}
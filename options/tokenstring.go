@@ -1,11 +1,39 @@
 package options
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrBasicAuthScheme is returned when a header uses the Basic scheme and no BasicAuthFn is configured.
+var ErrBasicAuthScheme = errors.New("basic auth scheme is not supported")
+
+// BasicAuthFn takes the credentials from a header using the Basic scheme and returns a token,
+// as an example by using the client credentials grant. ctx is the context of the request.
+type BasicAuthFn func(ctx context.Context, username string, password string) (string, error)
+
+// HeaderValuePrecedence defines which value is used if a header is sent more than once.
+type HeaderValuePrecedence int
+
+const (
+	// FirstHeaderValue uses the first value if the header is sent more than once.
+	FirstHeaderValue HeaderValuePrecedence = iota
+	// LastHeaderValue uses the last value if the header is sent more than once.
+	LastHeaderValue
+	// RejectMultipleHeaderValues returns an error if the header is sent more than once.
+	RejectMultipleHeaderValues
+)
+
 // TokenStringOptions handles the settings for how to extract the token from a request.
 type TokenStringOptions struct {
-	HeaderName       string
-	TokenPrefix      string
-	ListSeparator    string
-	PostExtractionFn func(string) (string, error)
+	HeaderName            string
+	TokenPrefix           string
+	TokenSchemes          []string
+	ListSeparator         string
+	HeaderValuePrecedence HeaderValuePrecedence
+	BasicAuthFn           BasicAuthFn
+	CookieName            string
+	PostExtractionFn      func(string) (string, error)
 }
 
 // NewTokenString takes TokenStringOption setters and returns
@@ -14,10 +42,14 @@ type TokenStringOptions struct {
 // needed by any external application using this library.
 func NewTokenString(setters ...TokenStringOption) *TokenStringOptions {
 	opts := &TokenStringOptions{
-		HeaderName:       "Authorization",
-		TokenPrefix:      "Bearer ",
-		ListSeparator:    "",
-		PostExtractionFn: nil,
+		HeaderName:            "Authorization",
+		TokenPrefix:           "Bearer ",
+		TokenSchemes:          nil,
+		ListSeparator:         "",
+		HeaderValuePrecedence: FirstHeaderValue,
+		BasicAuthFn:           nil,
+		CookieName:            "",
+		PostExtractionFn:      nil,
 	}
 
 	for _, setter := range setters {
@@ -49,6 +81,18 @@ func WithTokenStringTokenPrefix(opt string) TokenStringOption {
 	}
 }
 
+// WithTokenStringTokenSchemes sets the TokenSchemes parameter for a TokenStringOptions pointer.
+// TokenSchemes defines the accepted authentication schemes, as an example `Bearer` and `Token`
+// for `Authorization: Token <jwt>` sent by legacy clients. The scheme is matched case-insensitively
+// and TokenPrefix is ignored if it's set.
+// Not supported by Echo JWT and will be ignored if used by it.
+// Default: nil
+func WithTokenStringTokenSchemes(opt []string) TokenStringOption {
+	return func(opts *TokenStringOptions) {
+		opts.TokenSchemes = opt
+	}
+}
+
 // WithTokenStringListSeparator sets the ListSeparator parameter for a TokenStringOptions pointer.
 // ListSeparator defines if the value of the header is a list or not.
 // The value will be split (up to 20 slices) by the ListSeparator.
@@ -59,6 +103,40 @@ func WithTokenStringListSeparator(opt string) TokenStringOption {
 	}
 }
 
+// WithTokenStringHeaderValuePrecedence sets the HeaderValuePrecedence parameter for a TokenStringOptions pointer.
+// HeaderValuePrecedence defines which value is used if the header is sent more than once,
+// as an example when both a proxy and the client sends an Authorization header.
+// Not supported by Echo JWT and will be ignored if used by it.
+// Default: options.FirstHeaderValue
+func WithTokenStringHeaderValuePrecedence(opt HeaderValuePrecedence) TokenStringOption {
+	return func(opts *TokenStringOptions) {
+		opts.HeaderValuePrecedence = opt
+	}
+}
+
+// WithTokenStringBasicAuthFn sets the BasicAuthFn parameter for a TokenStringOptions pointer.
+// BasicAuthFn is used if the header uses the Basic scheme instead of TokenPrefix. It receives
+// the request context and the decoded credentials and returns the token that should be validated.
+// If not set, an error wrapping options.ErrBasicAuthScheme is returned for the Basic scheme.
+// Default: nil
+func WithTokenStringBasicAuthFn(opt BasicAuthFn) TokenStringOption {
+	return func(opts *TokenStringOptions) {
+		opts.BasicAuthFn = opt
+	}
+}
+
+// WithTokenStringCookieName sets the CookieName parameter for a TokenStringOptions pointer.
+// CookieName makes the token to be read from the cookie with this name, parsing the header
+// as a Cookie header. Use it together with WithTokenStringHeaderName("Cookie"), or with
+// WithTokenStringHeaderName("grpcgateway-cookie") for the metadata forwarded by grpc-gateway to
+// oidcgrpc. TokenPrefix and ListSeparator are ignored for cookies and HeaderValuePrecedence is used if the cookie is sent more than once.
+// Default: ""
+func WithTokenStringCookieName(opt string) TokenStringOption {
+	return func(opts *TokenStringOptions) {
+		opts.CookieName = opt
+	}
+}
+
 // WithTokenStringPostExtractionFn sets the PostExtractionFn parameter for a TokenStringOptions pointer.
 // PostExtractionFn will be run if not nil after a token has been successfully extracted.
 // Default: nil